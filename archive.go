@@ -0,0 +1,294 @@
+// Package main - archive.go consolidates a config's snapshot history into one archive.
+//
+// When a project ends, its backups are still worth keeping, but not in the
+// active rotation: the config keeps running against a folder nobody changes
+// and its snapshots take up space on the backup drive. Archiving writes every
+// snapshot of a config, with its manifest and note, into a single zip (or
+// encrypted zip, see export.go) that can be moved to cold storage. Detaching
+// then disables the config and deletes its snapshots from the destination.
+//
+// Key design decisions:
+//
+// 1. Verified before anything is deleted: Every file is checked against its
+//    snapshot's manifest while it is archived, and the finished archive is
+//    read back and compared with what was written. Only a verified archive is
+//    renamed into place, and only then may detaching delete snapshots.
+//
+// 2. Self-describing: The archive contains archive.json with the config and
+//    a list of the snapshots, so it can be understood years later without
+//    the config.json it came from.
+//
+// 3. Disable, don't remove: Detaching sets "enabled": false instead of
+//    deleting the config, so the project can be picked up again by enabling
+//    it; its history stays in the archive.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveIndexName is the entry in a history archive that describes its contents
+const archiveIndexName = "archive.json"
+
+// ArchiveIndex describes the contents of a history archive.
+type ArchiveIndex struct {
+	Config    BackupConfig       `json:"config"` // Config the snapshots were taken by
+	Created   time.Time          `json:"created"`
+	Snapshots []ArchivedSnapshot `json:"snapshots"` // Oldest first
+}
+
+// ArchivedSnapshot is one snapshot in a history archive.
+type ArchivedSnapshot struct {
+	Name  string    `json:"name"` // Folder of the snapshot inside the archive
+	Time  time.Time `json:"time"`
+	Note  string    `json:"note,omitempty"`
+	Files int       `json:"files"`
+}
+
+// ArchiveResult summarizes a written history archive.
+type ArchiveResult struct {
+	Path      string // Archive file written
+	Snapshots int
+	Files     int
+	Detached  bool // The config was disabled and its snapshots deleted
+}
+
+// defaultArchivePath returns exports/<config>_history.zip in the working directory.
+func defaultArchivePath(config BackupConfig) string {
+	return filepath.Join("exports", sanitizeConfigName(config.Name)+"_history.zip")
+}
+
+// archiveHistory writes all snapshots of a config into a verified archive at
+// outPath and, with detach, then disables the config and deletes the snapshots.
+//
+// Like exportSnapshot, a non-empty passphrase encrypts the archive and
+// appends encryptedExportExtension to outPath. iface is recorded in the
+// audit log for the config change of detaching.
+func archiveHistory(config BackupConfig, outPath, passphrase string, detach bool, iface string) (ArchiveResult, error) {
+	var result ArchiveResult
+	err := backupRunner.withOperation(config, OperationMaintenance, func() error {
+		snapshots, err := listSnapshots(config)
+		if err != nil {
+			return fmt.Errorf("failed to list snapshots: %v", err)
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf("%s has no snapshots to archive", config.Name)
+		}
+
+		if passphrase != "" && !strings.HasSuffix(outPath, encryptedExportExtension) {
+			outPath += encryptedExportExtension
+		}
+		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+			return fmt.Errorf("failed to create archive directory: %v", err)
+		}
+		tempPath := outPath + ".partial"
+		index, digest, err := writeHistoryArchive(config, snapshots, tempPath, passphrase)
+		if err == nil {
+			err = verifyHistoryArchive(tempPath, passphrase, digest)
+		}
+		if err != nil {
+			os.Remove(tempPath)
+			return err
+		}
+		if err := os.Rename(tempPath, outPath); err != nil {
+			os.Remove(tempPath)
+			return fmt.Errorf("failed to finalize archive: %v", err)
+		}
+
+		result.Path = outPath
+		result.Snapshots = len(index.Snapshots)
+		for _, snapshot := range index.Snapshots {
+			result.Files += snapshot.Files
+		}
+		if !detach {
+			return nil
+		}
+		if err := detachConfig(config, snapshots, iface); err != nil {
+			return fmt.Errorf("archive %s was written, but detaching failed: %v", outPath, err)
+		}
+		result.Detached = true
+		return nil
+	})
+	return result, err
+}
+
+// writeHistoryArchive writes the snapshots, oldest first, into a (possibly
+// encrypted) zip at path.
+//
+// Returns the archive's index and the SHA-256 of the zip as written, before
+// encryption, for verifyHistoryArchive.
+func writeHistoryArchive(config BackupConfig, snapshots []Snapshot, path, passphrase string) (ArchiveIndex, []byte, error) {
+	index := ArchiveIndex{Config: config, Created: time.Now()}
+	out, err := os.Create(path)
+	if err != nil {
+		return index, nil, fmt.Errorf("failed to create archive file: %v", err)
+	}
+	defer out.Close()
+
+	var w io.Writer = out
+	var encrypter *encryptingWriter
+	if passphrase != "" {
+		encrypter, err = newEncryptingWriter(out, passphrase)
+		if err != nil {
+			return index, nil, fmt.Errorf("failed to initialize encryption: %v", err)
+		}
+		w = encrypter
+	}
+	digest := sha256.New()
+	zw := zip.NewWriter(io.MultiWriter(w, digest))
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		archived, err := addSnapshotToArchive(zw, config, snapshot)
+		if err != nil {
+			return index, nil, fmt.Errorf("failed to archive %s: %v", snapshot.Name, err)
+		}
+		index.Snapshots = append(index.Snapshots, archived)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return index, nil, err
+	}
+	entry, err := zw.Create(archiveIndexName)
+	if err == nil {
+		_, err = entry.Write(data)
+	}
+	if err != nil {
+		return index, nil, fmt.Errorf("failed to write %s: %v", archiveIndexName, err)
+	}
+	if err := zw.Close(); err != nil {
+		return index, nil, fmt.Errorf("failed to finish archive: %v", err)
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return index, nil, fmt.Errorf("failed to finish encryption: %v", err)
+		}
+	}
+	return index, digest.Sum(nil), out.Close()
+}
+
+// addSnapshotToArchive adds a snapshot and its manifest and note to zw.
+//
+// Files are compared with the manifest as they are added, so damage the
+// snapshot took on the backup drive stops the archive instead of being
+// preserved in it. Snapshots without a manifest are archived unchecked.
+func addSnapshotToArchive(zw *zip.Writer, config BackupConfig, snapshot Snapshot) (ArchivedSnapshot, error) {
+	archived := ArchivedSnapshot{Name: snapshot.Name, Time: snapshot.Time, Note: snapshot.Note}
+	manifest, err := loadManifest(config.Destination, snapshot.Name)
+	if err != nil && !os.IsNotExist(err) {
+		return archived, err
+	}
+
+	err = zipDirectory(zw, snapshot.Path, snapshot.Name+"/", func(relPath, sha string) error {
+		archived.Files++
+		if manifest == nil {
+			return nil
+		}
+		if expected, listed := manifest.Files[relPath]; listed && expected.SHA256 != sha {
+			return fmt.Errorf("%s doesn't match the snapshot manifest", relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return archived, err
+	}
+
+	// Manifest and note keep their place next to the snapshots
+	for _, path := range []string{manifestPath(config.Destination, snapshot.Name), snapshotNotePath(config.Destination, snapshot.Name)} {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return archived, err
+		}
+		entry, err := zw.Create(manifestDir + "/" + filepath.Base(path))
+		if err != nil {
+			return archived, err
+		}
+		if _, err := entry.Write(data); err != nil {
+			return archived, err
+		}
+	}
+	return archived, nil
+}
+
+// verifyHistoryArchive reads back the archive at path, decrypting it if
+// passphrase is set, and checks it against the digest of what was written.
+// Plain archives are also opened as zips and every entry is read, which
+// checks the entries' checksums.
+func verifyHistoryArchive(path, passphrase string, digest []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	readBack := sha256.New()
+	if passphrase != "" {
+		err = decryptStream(readBack, file, passphrase)
+	} else {
+		_, err = io.Copy(readBack, file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify archive: %v", err)
+	}
+	if !bytes.Equal(readBack.Sum(nil), digest) {
+		return errors.New("failed to verify archive: the written file differs from the archived data")
+	}
+	if passphrase != "" {
+		return nil
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify archive: %v", err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to verify archive: %v", err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to verify archive: %s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// detachConfig takes an archived config out of the active rotation: it is
+// disabled in config.json, then its snapshots are deleted.
+//
+// The config is disabled first, so a running instance stops scheduling it
+// once it reloads the configuration.
+func detachConfig(config BackupConfig, snapshots []Snapshot, iface string) error {
+	if err := setConfigEnabled(config.Name, false, iface); err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		if err := os.RemoveAll(snapshot.Path); err != nil {
+			return err
+		}
+		if err := removeManifest(config.Destination, snapshot.Name); err != nil {
+			return err
+		}
+		if err := removeSnapshotNote(config.Destination, snapshot.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+// Package main - audit.go implements the append-only audit log.
+//
+// Operational logs answer "what did the backup engine do"; the audit log answers
+// "who changed what, and through which interface". Every configuration change
+// and every user-initiated action is appended as one JSON line to logs/audit.log.
+//
+// Key design decisions:
+//
+// 1. Append-only JSON lines: Each entry is written with O_APPEND and never
+//    rewritten, so the file can be tailed, grepped, or parsed by scripts.
+//
+// 2. Interface attribution: Entries record which interface initiated the change
+//    (tray, config file, system) and the OS user, which matters on machines
+//    administered by more than one person.
+//
+// 3. Config edits made outside the app are still captured: the last-seen
+//    configuration is kept in audit_config.json and diffed on every load, so a
+//    hand-edited config.json shows up as a "config-file" change on next start.
+//
+// 4. Secrets are never written: values of credential-like keys are masked in
+//    diffs using the same key pattern as the diagnostics bundle.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Interfaces that can initiate audited changes
+const (
+	AuditInterfaceTray         = "tray"         // System tray menu actions
+	AuditInterfaceConfigFile   = "config-file"  // Edits made directly to config.json
+	AuditInterfaceSystem       = "system"       // Actions taken automatically by the engine
+	AuditInterfaceCLI          = "cli"          // Command-line subcommands
+	AuditInterfaceExplorer     = "explorer"     // Windows Explorer context-menu entries
+	AuditInterfaceHotkey       = "hotkey"       // Global keyboard shortcuts
+	AuditInterfaceNotification = "notification" // Buttons of desktop notifications
+	AuditInterfaceDashboard    = "dashboard"    // Buttons of the web dashboard
+)
+
+// AuditEntry is a single line in the audit log.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`             // When the change happened
+	Interface string    `json:"interface"`        // Which interface initiated it
+	User      string    `json:"user,omitempty"`   // OS user running the application
+	Action    string    `json:"action"`           // What happened, e.g. "config-change", "exit"
+	Config    string    `json:"config,omitempty"` // Affected backup config, if any
+	Detail    string    `json:"detail,omitempty"` // Human-readable description
+}
+
+// AuditLog serializes appends to the audit log file.
+type AuditLog struct {
+	mu           sync.Mutex // Serializes appends so lines never interleave
+	path         string     // Audit log location
+	snapshotPath string     // Last-seen configuration used for diffing
+}
+
+// Global singleton instance shared by all interfaces
+var auditLog = &AuditLog{
+	path:         filepath.Join("logs", "audit.log"),
+	snapshotPath: "audit_config.json",
+}
+
+// record appends an entry to the audit log.
+//
+// Failures are reported to the system log but never returned: auditing must
+// not be able to block the action being audited.
+func (al *AuditLog) record(iface, action, configName, detail string) {
+	entry := AuditEntry{
+		Time:      time.Now(),
+		Interface: iface,
+		User:      currentUserName(),
+		Action:    action,
+		Config:    configName,
+		Detail:    detail,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode audit entry: %v", err)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(al.path), 0755); err != nil {
+		log.Printf("Failed to create audit log directory: %v", err)
+		return
+	}
+	f, err := os.OpenFile(al.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Failed to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write audit entry: %v", err)
+	}
+}
+
+// recordConfigChanges diffs two configurations and records one entry per change.
+//
+// A nil oldConfig records nothing: the first time a configuration is seen there
+// is nothing to compare against, and logging every field as "added" is noise.
+func (al *AuditLog) recordConfigChanges(iface string, oldConfig, newConfig *Config) {
+	if oldConfig == nil || newConfig == nil {
+		return
+	}
+	for _, change := range diffConfigs(oldConfig, newConfig) {
+		al.record(iface, "config-change", change.config, change.detail)
+	}
+}
+
+// recordLoadedConfig audits edits made to config.json since the last load.
+//
+// The previously seen configuration is read from the snapshot file, diffed
+// against the freshly loaded one and then replaced, so each external edit is
+// recorded exactly once.
+func (al *AuditLog) recordLoadedConfig(config *Config) {
+	var previous *Config
+	if data, err := os.ReadFile(al.snapshotPath); err == nil {
+		var snapshot Config
+		if err := json.Unmarshal(data, &snapshot); err == nil {
+			previous = &snapshot
+		}
+	}
+
+	al.recordConfigChanges(AuditInterfaceConfigFile, previous, config)
+	al.saveConfigSnapshot(config)
+}
+
+// recordConfigUpdate audits a change the application itself wrote to config.json.
+//
+// The snapshot is updated too, so the next load doesn't report the same change
+// again as a hand edit.
+func (al *AuditLog) recordConfigUpdate(iface string, oldConfig, newConfig *Config) {
+	al.recordConfigChanges(iface, oldConfig, newConfig)
+	al.saveConfigSnapshot(newConfig)
+}
+
+// saveConfigSnapshot stores the configuration that later loads are diffed against.
+func (al *AuditLog) saveConfigSnapshot(config *Config) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode audit config snapshot: %v", err)
+		return
+	}
+	if err := os.WriteFile(al.snapshotPath, data, 0600); err != nil {
+		log.Printf("Failed to write audit config snapshot: %v", err)
+	}
+}
+
+// configChange describes one difference between two configurations.
+type configChange struct {
+	config string // Backup config name, empty for global settings
+	detail string // e.g. `enabled: true -> false`
+}
+
+// diffConfigs compares two configurations field by field.
+//
+// Backup configs are matched by name; fields are compared through their JSON
+// representation so the output uses the same names users see in config.json.
+func diffConfigs(oldConfig, newConfig *Config) []configChange {
+	var changes []configChange
+
+	oldBackups := make(map[string]BackupConfig)
+	for _, backup := range oldConfig.Backups {
+		oldBackups[backup.Name] = backup
+	}
+	newBackups := make(map[string]BackupConfig)
+	for _, backup := range newConfig.Backups {
+		newBackups[backup.Name] = backup
+	}
+
+	for _, backup := range newConfig.Backups {
+		previous, existed := oldBackups[backup.Name]
+		if !existed {
+			changes = append(changes, configChange{config: backup.Name, detail: "backup config added"})
+			continue
+		}
+		for _, detail := range diffJSONFields(previous, backup) {
+			changes = append(changes, configChange{config: backup.Name, detail: detail})
+		}
+	}
+	for _, backup := range oldConfig.Backups {
+		if _, stillExists := newBackups[backup.Name]; !stillExists {
+			changes = append(changes, configChange{config: backup.Name, detail: "backup config removed"})
+		}
+	}
+
+	for _, detail := range diffJSONFields(oldConfig.Settings, newConfig.Settings) {
+		changes = append(changes, configChange{detail: "settings." + detail})
+	}
+	return changes
+}
+
+// diffJSONFields returns "field: old -> new" descriptions for differing top-level JSON fields.
+func diffJSONFields(oldValue, newValue interface{}) []string {
+	oldFields := jsonFields(oldValue)
+	newFields := jsonFields(newValue)
+
+	keys := make(map[string]bool)
+	for key := range oldFields {
+		keys[key] = true
+	}
+	for key := range newFields {
+		keys[key] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	var details []string
+	for _, key := range sortedKeys {
+		before, after := oldFields[key], newFields[key]
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if secretKeyPattern.MatchString(key) {
+			details = append(details, fmt.Sprintf("%s: changed", key))
+			continue
+		}
+		details = append(details, fmt.Sprintf("%s: %s -> %s", key, describeJSONValue(before), describeJSONValue(after)))
+	}
+	return details
+}
+
+// jsonFields decodes a value's JSON encoding into a generic field map.
+func jsonFields(value interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// describeJSONValue renders a decoded JSON value compactly for audit details.
+func describeJSONValue(value interface{}) string {
+	if value == nil {
+		return "(default)"
+	}
+	// Nested objects such as smtp may contain secrets of their own
+	data, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// currentUserName returns the OS account name for audit attribution.
+func currentUserName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USERNAME")
+}
@@ -0,0 +1,65 @@
+// Package main - autostart.go starts the application when the user logs in.
+//
+// A backup tool that only runs after being launched by hand misses most of
+// the time it should be protecting data, and the first sign is usually a
+// restore that finds nothing recent. "start_at_login" in the settings, or
+// the "Start with Windows" checkbox in the tray, registers the executable to
+// start with the user's session: the Run registry key on Windows, a launch
+// agent on macOS and an XDG autostart entry on Linux (autostart_windows.go,
+// autostart_other.go).
+//
+// The setting is applied at startup and whenever config.json is reloaded, so
+// the registration follows the file. Without the setting the registration is
+// left as it is, so an entry made by an installer isn't removed. The entry
+// runs the executable by absolute path, so it should be enabled from where
+// the executable will stay; a data folder other than the default is passed
+// with --config-dir.
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// applyAutostart registers or unregisters the application according to
+// settings.start_at_login. Failures are logged; they don't stop startup.
+func applyAutostart(settings Settings) {
+	if settings.StartAtLogin == nil || *settings.StartAtLogin == autostartEnabled() {
+		return
+	}
+	if err := setAutostart(*settings.StartAtLogin); err != nil {
+		log.Printf("Failed to apply start_at_login: %v", err)
+	}
+}
+
+// setAutostart registers or unregisters the application for the current user.
+func setAutostart(enabled bool) error {
+	if enabled {
+		if err := enableAutostart(); err != nil {
+			return fmt.Errorf("failed to register the application to start at login: %v", err)
+		}
+		log.Printf("Registered the application to start at login")
+		return nil
+	}
+	if err := disableAutostart(); err != nil {
+		return fmt.Errorf("failed to stop the application from starting at login: %v", err)
+	}
+	log.Printf("The application no longer starts at login")
+	return nil
+}
+
+// setStartAtLogin saves settings.start_at_login to config.json and applies it
+// right away, so the tray checkbox reflects it before the file is reloaded.
+func setStartAtLogin(enabled bool, iface string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	previous := *config
+	config.Settings.StartAtLogin = &enabled
+	if err := saveConfig(config); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	auditLog.recordConfigUpdate(iface, &previous, config)
+	return setAutostart(enabled)
+}
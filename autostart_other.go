@@ -0,0 +1,116 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// autostartTitle is the tray checkbox that toggles start_at_login
+const autostartTitle = "Start at login"
+
+// autostartLabel names the launch agent on macOS
+const autostartLabel = "com.simplefolderbackup"
+
+// autostartPath returns the file that starts the application at login: a
+// launch agent on macOS, an XDG autostart entry elsewhere.
+func autostartPath() (string, error) {
+	if runtime.GOOS == "darwin" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "LaunchAgents", autostartLabel+".plist"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "autostart", "SimpleFolderBackup.desktop"), nil
+}
+
+// autostartEnabled reports whether the autostart file exists.
+func autostartEnabled() bool {
+	path, err := autostartPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// enableAutostart writes the autostart file for this executable.
+func enableAutostart() error {
+	path, err := autostartPath()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return err
+	}
+
+	// The login session doesn't inherit this start's options or environment,
+	// so a data folder other than the default is passed on
+	args := append([]string{exe}, dataDirArgs()...)
+	var content string
+	if runtime.GOOS == "darwin" {
+		var programArguments strings.Builder
+		for _, arg := range args {
+			fmt.Fprintf(&programArguments, "\t\t<string>%s</string>\n", xmlEscape(arg))
+		}
+		content = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+</dict>
+</plist>
+`, autostartLabel, programArguments.String())
+	} else {
+		// Exec needs quotes around paths with spaces, and escapes inside them
+		escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`)
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = `"` + escape.Replace(arg) + `"`
+		}
+		content = fmt.Sprintf("[Desktop Entry]\nType=Application\nName=SimpleFolderBackup\nComment=Back up folders on a schedule\nExec=%s\nTerminal=false\nX-GNOME-Autostart-enabled=true\n", strings.Join(quoted, " "))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// disableAutostart removes the autostart file; a missing file is not an error.
+func disableAutostart() error {
+	path, err := autostartPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// xmlEscape escapes text for an XML element.
+func xmlEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// autostartTitle is the tray checkbox that toggles start_at_login
+const autostartTitle = "Start with Windows"
+
+// Run key of the current user; HKCU needs no administrator rights
+const (
+	autostartRunKey    = `Software\Microsoft\Windows\CurrentVersion\Run`
+	autostartValueName = "SimpleFolderBackup"
+)
+
+// autostartEnabled reports whether the Run key starts the application.
+func autostartEnabled() bool {
+	_, found := regGetString(HKEY_CURRENT_USER, autostartRunKey, autostartValueName)
+	return found
+}
+
+// enableAutostart adds this executable to the Run key of the current user.
+func enableAutostart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return err
+	}
+	return regSetString(HKEY_CURRENT_USER, autostartRunKey, autostartValueName, `"`+exe+`"`+registeredDataDirArgs())
+}
+
+// disableAutostart removes the application from the Run key of the current user.
+func disableAutostart() error {
+	return regDeleteValue(HKEY_CURRENT_USER, autostartRunKey, autostartValueName)
+}
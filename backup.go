@@ -3,6 +3,9 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -10,9 +13,29 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 )
 
+// Outcomes of a backup run that did not fail
+const (
+	ResultBackup  = "backup"  // A new snapshot was created
+	ResultPartial = "partial" // A snapshot was created but rotation cleanup failed
+	ResultSkipped = "skipped" // Content was unchanged, no snapshot needed
+	ResultWaiting = "waiting" // The source or a network destination is missing and the config waits for it
+	ResultChanged = "changed" // Content changed, but verify_only kept the run from writing a snapshot
+)
+
+// BackupResult describes what a backup run did.
+type BackupResult struct {
+	Outcome  string    // One of the Result* constants
+	Snapshot string    // Path of the created snapshot, if any
+	Warning  string    // Why the run was partial
+	Bytes    int64     // Size of the files saved, or of the uploaded archive
+	Files    int       // Files in the snapshot; 0 for archives
+	Code     ErrorCode // Why a ResultWaiting run didn't back up
+}
+
 // executeBackup is the main entry point for backup operations, implementing intelligent
 // hash-based skipping to avoid unnecessary I/O when source content hasn't changed.
 //
@@ -24,34 +47,64 @@ import (
 //
 // Error handling strategy: Hash check failures fall back to performing backup
 // to ensure data protection is prioritized over performance optimization.
-func executeBackup(config BackupConfig, logger *log.Logger) error {
+func executeBackup(config BackupConfig, logger *log.Logger) (BackupResult, error) {
+	// Phase 0: Apply the missing source policy before touching anything
+	err := checkSourceAvailable(config, logger)
+	if errors.Is(err, errSourceWaiting) {
+		backupStatus.updateNextBackup(config.Name, config.ScheduleMinutes)
+		return BackupResult{Outcome: ResultWaiting, Code: CodeSourceMissing}, nil
+	} else if err != nil {
+		return BackupResult{}, err
+	}
+	
+	// A sleeping NAS gets a moment to wake up; one that stays offline defers the run.
+	// Verify-only runs don't touch the destination, which may be mid-migration.
+	if !config.VerifyOnly && !awaitNetworkDestination(config, logger) {
+		return BackupResult{Outcome: ResultWaiting, Code: CodeDestUnreachable}, nil
+	}
+	
+	// Hooks may write into the source (e.g. a database dump), so they run first
+	if err := runPreBackupHook(config, logger); err != nil {
+		return BackupResult{}, err
+	}
+	if config.VerifyOnly {
+		return runVerifyOnly(config, logger)
+	}
+	
 	// Phase 1: Hash-based change detection check (if enabled)
 	if config.IsHashCheckEnabled() {
-		shouldSkip, err := hashManager.shouldSkipBackup(config.Name, config.Source)
+		shouldSkip, err := hashManager.shouldSkipBackup(config)
 		if err != nil {
 			// Hash check failure - proceed with backup for data safety
 			logger.Printf("Hash check failed for %s, proceeding with backup: %v", config.Name, err)
 		} else if shouldSkip {
 			// Content unchanged - record skip action and update scheduling status
 			logger.Printf("Contents identical, backup skipped for %s", config.Name)
-			err = hashManager.recordAction(config.Name, config.Source, "skipped")
+			err = hashManager.recordAction(config, "skipped")
 			if err != nil {
 				logger.Printf("Failed to record skip action for %s: %v", config.Name, err)
 			}
 			// Update status as if backup completed (for scheduling purposes)
 			backupStatus.updateBackupCompleted(config.Name, config.ScheduleMinutes)
 			
+			// Unchanged content for too long may mean the upstream pipeline broke
+			checkStaleSource(config, logger)
+			
 			// Trigger immediate UI update
-			select {
-			case statusUpdateChan <- struct{}{}:
-			default:
-			}
-			return nil
+			requestStatusUpdate()
+			return BackupResult{Outcome: ResultSkipped}, nil
 		}
 	}
 
 	// Phase 2: Perform actual backup (either hash disabled or content changed)
-	return performBackup(config, logger)
+	result, err := performBackup(config, logger)
+	if err != nil && destinationWentOffline(config, logger) {
+		return BackupResult{Outcome: ResultWaiting, Code: CodeDestUnreachable}, nil
+	}
+	if err == nil {
+		runPostBackupHook(config, result, logger)
+	}
+	return result, err
 }
 
 // performBackup executes the actual file copying and cleanup operations.
@@ -61,75 +114,132 @@ func executeBackup(config BackupConfig, logger *log.Logger) error {
 // backup process fails midway, existing backups remain intact and recoverable.
 //
 // The operation sequence is critical:
-// 1. Create backup directory with timestamp-based name
-// 2. Copy all source files to backup directory
+// 1. Create a staging directory: the timestamp-based name plus ".partial"
+// 2. Copy all source files into it, then rename it to the final name
 // 3. Clean up old backups based on rotation count
 // 4. Update status tracking for UI display
 // 5. Record backup action in hash manager for future change detection
 //
-// Error handling: Any failure in steps 1-3 will prevent status updates,
-// ensuring the backup scheduler will retry on the next interval.
-func performBackup(config BackupConfig, logger *log.Logger) error {
+// Error handling: Any failure in steps 1-2 will prevent status updates,
+// ensuring the backup scheduler will retry on the next interval. The staging
+// directory is removed again, and one left behind by a crash doesn't match
+// the snapshot names, so rotation and restores never take it for a finished
+// snapshot (removeStagedSnapshots deletes it when the scheduler starts). A cleanup
+// failure in step 3 doesn't invalidate the new snapshot, so the run is
+// reported as partial instead of being retried.
+func performBackup(config BackupConfig, logger *log.Logger) (BackupResult, error) {
+	if writesArchiveSnapshots(config) {
+		return performRemoteBackup(config, logger)
+	}
+	result := BackupResult{Outcome: ResultBackup}
 	timestamp := time.Now()
 	backupDirName := generateBackupDirName(config.Source, timestamp)
 	backupDir := filepath.Join(config.Destination, backupDirName)
 	
-	// Step 1: Create backup directory structure
-	err := os.MkdirAll(backupDir, 0755)
+	// Unchanged files are linked from the newest snapshot, so find it before adding one
+	base := findLinkBase(config, logger)
+	
+	// A full disk mid-copy leaves a half-written snapshot, so check the space first
+	size, err := checkSpaceForBackup(config, base, logger)
 	if err != nil {
-		return fmt.Errorf("failed to create backup directory: %v", err)
+		return BackupResult{}, err
+	}
+	progress := newCopyProgress(config, logger)
+	progress.start(size.Files, size.Copied)
+	defer progress.finish()
+	
+	// Step 1: Create the staging directory the snapshot is written into
+	stagingDir := filepath.Join(config.Destination, stagingDirName(backupDirName))
+	err = os.MkdirAll(stagingDir, 0755)
+	if err != nil {
+		return BackupResult{}, fmt.Errorf("failed to create backup directory: %v", err)
 	}
 	
 	// Step 2: Copy source directory tree to backup location
-	err = copyDir(config.Source, backupDir)
+	if depth := config.GetMaxDepth(); depth > 0 {
+		logger.Printf("Copying %s up to %d directory levels deep (max_depth)", config.Name, depth)
+	}
+	if len(config.ExcludePresets) > 0 {
+		logger.Printf("Excluding files matched by presets %s", strings.Join(config.ExcludePresets, ", "))
+	}
+	manifest := newManifestBuilder(stagingDir)
+	err = copyDir(config.Source, stagingDir, config, walkOptionsFor(config), manifest, newBandwidthLimiter(config), base, progress)
 	if err != nil {
-		return fmt.Errorf("failed to copy files: %v", err)
+		removeStagingDir(stagingDir, logger)
+		return BackupResult{}, fmt.Errorf("failed to copy files: %v", err)
+	}
+	
+	// Only a complete (and, with verify_copies, verified) copy gets a snapshot name
+	if err := os.Rename(stagingDir, backupDir); err != nil {
+		removeStagingDir(stagingDir, logger)
+		return BackupResult{}, fmt.Errorf("failed to finish snapshot %s: %v", backupDirName, err)
+	}
+	manifest.moved(backupDir)
+	if base != nil {
+		logger.Print(base.summary())
+	}
+	result.Snapshot = backupDir
+	result.Bytes = manifest.totalSize()
+	result.Files = manifest.fileCount()
+	
+	// The snapshot is complete without its manifest, so a failure here is only logged
+	if err := manifest.save(config.Destination); err != nil {
+		logger.Printf("Failed to save manifest for %s: %v", backupDirName, err)
 	}
 	
 	// Step 3: Remove old backups beyond rotation limit
-	err = cleanupOldBackups(config)
+	err = cleanupOldBackups(config, logger)
 	if err != nil {
-		return fmt.Errorf("failed to cleanup old backups: %v", err)
+		logger.Printf("Failed to cleanup old backups for %s: %v", config.Name, err)
+		result.Outcome = ResultPartial
+		result.Warning = fmt.Sprintf("failed to cleanup old backups: %v", err)
 	}
 	
 	// Step 4: Update status tracking for UI display (only after successful backup)
 	backupStatus.updateBackupCompleted(config.Name, config.ScheduleMinutes)
 	
 	// Trigger immediate UI update
-	select {
-	case statusUpdateChan <- struct{}{}:
-	default:
-	}
+	requestStatusUpdate()
 	
 	// Step 5: Record successful backup in hash manager for future skip decisions
 	if config.IsHashCheckEnabled() {
-		err = hashManager.recordAction(config.Name, config.Source, "backup")
+		err = hashManager.recordAction(config, "backup")
 		if err != nil {
 			// Non-critical error - backup succeeded, just hash tracking failed
 			logger.Printf("Failed to record backup action for %s: %v", config.Name, err)
 		}
+		clearStaleSource(config, logger)
 	}
 	
-	return nil
+	return result, nil
 }
 
 // copyDir recursively copies an entire directory tree from src to dst.
 //
-// Uses filepath.WalkDir for efficient traversal with minimal memory footprint.
+// Uses walkTree (filepath.WalkDir unless opts bound the traversal) for
+// efficient traversal with minimal memory footprint.
 // This approach is preferred over alternatives because:
 // 1. Handles arbitrary directory depths without stack overflow risk
 // 2. Preserves directory permissions during copy operation
 // 3. Processes files in filesystem order for better disk I/O patterns
 // 4. Single-pass operation minimizes filesystem metadata lookups
 //
+// The config's copy strategy decides how individual files are copied; with the
+// sqlite strategy, databases are copied as consistent sets (see sqlite.go) and
+// their companion files are skipped when the walk reaches them on their own.
+//
+// Each copied file's hash is recorded in manifest (nil to skip); with
+// verify_copies every copy is read back and checked against it. Reads of the
+// source are paced by limiter (nil for full speed). Files unchanged since the
+// snapshot base are linked from it instead of copied (nil copies everything).
+// Each file is counted in progress, which also reports the copies of large files.
+//
 // Error handling: Any file copy failure immediately stops the entire operation,
 // ensuring partial backups are not considered successful.
-func copyDir(src, dst string) error {
-	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		
+func copyDir(src, dst string, config BackupConfig, opts walkOptions, manifest *manifestBuilder, limiter *bandwidthLimiter, base *linkBase, progress *copyProgress) error {
+	sqliteAware := config.GetCopyStrategy() == CopyStrategySQLite
+	
+	return walkTree(src, opts, func(path string, d fs.DirEntry) error {
 		// Calculate relative path for preserving directory structure
 		relPath, err := filepath.Rel(src, path)
 		if err != nil {
@@ -143,8 +253,46 @@ func copyDir(src, dst string) error {
 			return os.MkdirAll(dstPath, d.Type().Perm())
 		}
 		
+		if sqliteAware {
+			if isSQLiteCompanion(path) {
+				return nil // Copied together with its main database file
+			}
+			if isSQLiteDatabase(path) {
+				if err := copySQLiteDatabase(path, dstPath); err != nil {
+					return err
+				}
+				if info, err := d.Info(); err == nil {
+					progress.fileDone(info.Size())
+				}
+				// Retries may have copied the set several times; hash the copy that was kept
+				return addSQLiteSetToManifest(manifest, dstPath)
+			}
+		}
+		
+		// Followed links arrive as directories, so this one wasn't followed
+		if unfollowedLink(path, d.Type()) {
+			backupRunner.loggerFor(config.Name).Printf("Skipped %s: a link to a folder that isn't followed, or to nothing (see follow_links)", path)
+			return nil
+		}
+		
+		// Stat before copying, so a change during the copy moves the recorded mtime
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if linked, ok := base.link(relPath, info, dstPath); ok {
+			progress.fileDone(0)
+			return manifest.add(dstPath, linked)
+		}
+		
 		// Copy individual file with permission preservation
-		return copyFile(path, dstPath)
+		entry := sourceEntry(info)
+		entry.SHA256, entry.Size, err = copyFileHashed(path, dstPath, config.VerifyCopies, limiter, progress)
+		if err != nil {
+			return err
+		}
+		progress.fileDone(entry.Size)
+		return manifest.add(dstPath, entry)
 	})
 }
 
@@ -159,37 +307,101 @@ func copyDir(src, dst string) error {
 // This approach is essential for files which may have specific permission
 // requirements or be quite large (especially data files).
 func copyFile(src, dst string) error {
+	_, _, err := copyFileHashed(src, dst, false, nil, nil)
+	return err
+}
+
+// copyFileHashed copies a file like copyFile and returns the SHA-256 of the
+// content and its size.
+//
+// The hash is computed from the bytes as they are copied, so it costs no
+// extra read of the source. With verify, the copy is read back afterwards and
+// must hash to the same value; this catches writes the destination silently
+// got wrong (flaky USB drives, network shares) at the cost of one read of
+// the destination. A non-nil limiter paces the copy, and a non-nil progress
+// reports it if the file is large.
+func copyFileHashed(src, dst string, verify bool, limiter *bandwidthLimiter, progress *copyProgress) (string, int64, error) {
 	srcFile, err := os.Open(src)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	defer srcFile.Close()
 	
 	// Ensure destination directory exists
 	err = os.MkdirAll(filepath.Dir(dst), 0755)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	
 	dstFile, err := os.Create(dst)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	defer dstFile.Close()
 	
-	// Efficient buffered copy without loading entire file into memory
-	_, err = io.Copy(dstFile, srcFile)
+	// Efficient buffered copy without loading entire file into memory,
+	// hashing the same bytes on the way through
+	hasher := sha256.New()
+	reader := limiter.reader(srcFile)
+	if info, err := srcFile.Stat(); err == nil {
+		reader = progress.reader(reader, src, info.Size())
+	}
+	defer progress.done()
+	size, err := io.Copy(io.MultiWriter(dstFile, hasher), reader)
 	if err != nil {
-		return err
+		return "", 0, err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	
+	// Close explicitly: a failed flush is a failed copy, and read-back must see the final content
+	if err := dstFile.Close(); err != nil {
+		return "", 0, err
+	}
+	
+	if verify {
+		copied, copiedSize, err := hashFile(dst)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to verify %s: %v", dst, err)
+		}
+		if copied != hash || copiedSize != size {
+			return "", 0, fmt.Errorf("verification failed for %s: the copy differs from the source", dst)
+		}
 	}
 	
 	// Preserve source file permissions (important for executable files, etc.)
 	srcInfo, err := os.Stat(src)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	
-	return os.Chmod(dst, srcInfo.Mode())
+	return hash, size, os.Chmod(dst, srcInfo.Mode())
+}
+
+// removeStagingDir deletes the staging directory of a snapshot that wasn't finished.
+func removeStagingDir(dir string, logger *log.Logger) {
+	if err := os.RemoveAll(dir); err != nil {
+		logger.Printf("Failed to remove incomplete snapshot %s: %v", filepath.Base(dir), err)
+	}
+}
+
+// removeStagedSnapshots deletes the staging directories that backups of
+// config interrupted by a crash, power loss or unplugged drive left behind.
+func removeStagedSnapshots(config BackupConfig, logger *log.Logger) {
+	entries, err := os.ReadDir(config.Destination)
+	if err != nil {
+		return // Not created yet or unavailable; the next backup reports that
+	}
+	sourceFolderName := getSourceFolderName(config.Source)
+	for _, entry := range entries {
+		if !entry.IsDir() || !isStagingDirectory(entry.Name(), sourceFolderName) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(config.Destination, entry.Name())); err != nil {
+			logger.Printf("Failed to remove incomplete snapshot %s: %v", entry.Name(), err)
+		} else {
+			logger.Printf("Removed incomplete snapshot %s left by an interrupted backup", entry.Name())
+		}
+	}
 }
 
 // cleanupOldBackups removes backup directories beyond the configured rotation count.
@@ -207,12 +419,50 @@ func copyFile(src, dst string) error {
 //
 // Design choice: ModTime-based sorting rather than timestamp parsing handles edge
 // cases like manual backup directory manipulation or clock adjustments gracefully.
-func cleanupOldBackups(config BackupConfig) error {
-	entries, err := os.ReadDir(config.Destination)
+//
+// Snapshots protected by retention_exceptions are never deleted here; they
+// don't take a slot from the rotation count either, so the newest
+// rotation_count snapshots are always kept as before. With thinning or GFS
+// retention, the same holds for the snapshots thinningKeeps or gfsKeeps
+// select, so rotation_count becomes the minimum number of snapshots kept.
+//
+// With min_free_space_gb, more of the oldest snapshots are then deleted
+// while the destination has less free space than that (see keepMinFreeSpace).
+func cleanupOldBackups(config BackupConfig, logger *log.Logger) error {
+	snapshots, err := rotatedSnapshots(config)
 	if err != nil {
 		return err
 	}
 	
+	// Delete oldest backups beyond rotation count
+	for _, name := range rotationDeletions(config, snapshots, systemClock.Now()) {
+		if err := deleteSnapshot(config, name); err != nil {
+			return err // Fail fast - don't leave partial cleanup state
+		}
+	}
+	
+	return keepMinFreeSpace(config, 0, logger)
+}
+
+// deleteSnapshot removes a snapshot folder together with its manifest and note.
+func deleteSnapshot(config BackupConfig, name string) error {
+	if err := os.RemoveAll(filepath.Join(config.Destination, name)); err != nil {
+		return err
+	}
+	if err := removeManifest(config.Destination, name); err != nil {
+		return err
+	}
+	return removeSnapshotNote(config.Destination, name)
+}
+
+// rotatedSnapshots returns the snapshot folders of a config in the order
+// rotation considers them, oldest first.
+func rotatedSnapshots(config BackupConfig) ([]retainedSnapshot, error) {
+	entries, err := os.ReadDir(config.Destination)
+	if err != nil {
+		return nil, err
+	}
+	
 	// Filter to only backup directories for this specific source
 	var backupDirs []os.DirEntry
 	sourceFolderName := getSourceFolderName(config.Source)
@@ -222,11 +472,6 @@ func cleanupOldBackups(config BackupConfig) error {
 		}
 	}
 	
-	// No cleanup needed if within rotation limit
-	if len(backupDirs) <= config.RotationCount {
-		return nil
-	}
-	
 	// Get modification times for sorting (most reliable for chronological order)
 	type dirInfo struct {
 		entry   os.DirEntry
@@ -247,15 +492,37 @@ func cleanupOldBackups(config BackupConfig) error {
 		return dirInfos[i].modTime.Before(dirInfos[j].modTime)
 	})
 	
-	// Delete oldest backups beyond rotation count
-	toDelete := len(dirInfos) - config.RotationCount
-	for i := 0; i < toDelete; i++ {
-		dirPath := filepath.Join(config.Destination, dirInfos[i].entry.Name())
-		err := os.RemoveAll(dirPath)
-		if err != nil {
-			return err // Fail fast - don't leave partial cleanup state
+	// Weekday exceptions go by the time in the folder name, which is when the
+	// snapshot was taken; modification time can lag for long copies
+	var snapshots []retainedSnapshot
+	for _, info := range dirInfos {
+		taken, err := parseBackupTimestamp(info.entry.Name(), sourceFolderName)
+		if err != nil || taken.IsZero() {
+			taken = info.modTime
 		}
+		snapshots = append(snapshots, retainedSnapshot{name: info.entry.Name(), taken: taken})
 	}
-	
-	return nil
+	if config.GetMaxTotalSize() > 0 {
+		if err := measureSnapshotUsage(config, snapshots); err != nil {
+			return nil, err
+		}
+	}
+	return snapshots, nil
+}
+
+// rotationDeletions returns the names rotation deletes from snapshots, which
+// are ordered oldest first: the oldest beyond the rotation count that no
+// retention rule keeps, then any more that max_total_size_gb deletes.
+func rotationDeletions(config BackupConfig, snapshots []retainedSnapshot, now time.Time) []string {
+	var deleted []string
+	// No cleanup needed if within rotation limit
+	if len(snapshots) > config.RotationCount {
+		keep := retentionKeeps(config, snapshots, now)
+		for _, snapshot := range snapshots[:len(snapshots)-config.RotationCount] {
+			if !keep[snapshot.name] {
+				deleted = append(deleted, snapshot.name)
+			}
+		}
+	}
+	return append(deleted, sizeCapDeletions(config, snapshots, deleted)...)
 }
\ No newline at end of file
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyDirSkipsUnfollowedDirectoryLink(t *testing.T) {
+	dir := t.TempDir()
+	config := BackupConfig{Name: "Data", Source: filepath.Join(dir, "data")}
+	writeTestFiles(t, config.Source, map[string]string{"notes.txt": "kept"})
+	writeTestFiles(t, filepath.Join(dir, "shared"), map[string]string{"list.txt": "outside the source"})
+	if err := os.Symlink(filepath.Join(dir, "shared"), filepath.Join(config.Source, "shared")); err != nil {
+		t.Skipf("can't create symlinks here: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts walkOptions
+	}{
+		{"unbounded", walkOptions{}},
+		{"bounded", walkOptions{maxDepth: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "snapshot")
+			if err := copyDir(config.Source, dst, config, tt.opts, nil, nil, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := readTestFile(t, dst, "notes.txt"); got != "kept" {
+				t.Errorf("notes.txt = %q, want %q", got, "kept")
+			}
+			if _, err := os.Lstat(filepath.Join(dst, "shared")); !os.IsNotExist(err) {
+				t.Errorf("link to a folder was copied (%v)", err)
+			}
+		})
+	}
+
+	t.Run("followed", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "snapshot")
+		if err := copyDir(config.Source, dst, config, walkOptions{followLinks: true}, nil, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestFile(t, dst, "shared/list.txt"); got != "outside the source" {
+			t.Errorf("shared/list.txt = %q, want the linked folder's file", got)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+// Package main - bandwidth.go limits copy throughput.
+//
+// A backup to a NAS or synced folder competes with everything else on the
+// network, and a multi-GB copy to a spinning disk makes the whole machine
+// sluggish. max_mb_per_second caps a job's copies at all times. Since a
+// single static limit either slows overnight runs needlessly or still
+// saturates the link during the day, limits can also be given per time
+// window, e.g. 10 MB/s from 08:00 to 18:00; a matching window takes
+// precedence over max_mb_per_second.
+//
+// Key design decisions:
+//
+// 1. Checked while copying: The limit is looked up as data flows, so a long
+//    backup that runs into (or out of) a window changes speed on the spot
+//    instead of keeping the limit it started with.
+//
+// 2. Per run, not per file: One limiter paces all files of a backup run, so
+//    many small files can't exceed the limit by each starting a fresh budget.
+//
+// 3. Snapshot copies only: SQLite sets (sqlite.go) are copied at full speed,
+//    since a slow copy makes a consistent set less likely. Restores are not
+//    limited either; they usually happen while someone is waiting.
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthWindow limits copy throughput during a time of day.
+//
+// A window whose From is later than its To runs past midnight. Outside all
+// windows the config's max_mb_per_second applies; where windows overlap, the
+// first one applies.
+type BandwidthWindow struct {
+	From        string  `json:"from"`          // Start time, "HH:MM" local time
+	To          string  `json:"to"`            // End time (exclusive), "HH:MM"
+	MBPerSecond float64 `json:"mb_per_second"` // Limit in megabytes per second, 0=unlimited
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes since midnight.
+func parseTimeOfDay(value string) (int, error) {
+	hours, minutes, found := strings.Cut(strings.TrimSpace(value), ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !found || errH != nil || errM != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// validateBandwidthWindows checks the times and limits of a config's windows.
+func validateBandwidthWindows(windows []BandwidthWindow) error {
+	for _, window := range windows {
+		if _, err := parseTimeOfDay(window.From); err != nil {
+			return fmt.Errorf("bandwidth window: %v", err)
+		}
+		if _, err := parseTimeOfDay(window.To); err != nil {
+			return fmt.Errorf("bandwidth window: %v", err)
+		}
+		if window.MBPerSecond < 0 {
+			return fmt.Errorf("bandwidth window %s-%s: mb_per_second must not be negative", window.From, window.To)
+		}
+	}
+	return nil
+}
+
+// bandwidthLimitAt returns the limit in bytes per second at t, or 0 if unlimited.
+//
+// fallback is the limit in megabytes per second outside all windows.
+func bandwidthLimitAt(windows []BandwidthWindow, fallback float64, t time.Time) int64 {
+	now := t.Hour()*60 + t.Minute()
+	for _, window := range windows {
+		from, errFrom := parseTimeOfDay(window.From)
+		to, errTo := parseTimeOfDay(window.To)
+		if errFrom != nil || errTo != nil {
+			continue // Rejected by validatePaths; never reached for loaded configs
+		}
+		inside := now >= from && now < to
+		if from > to {
+			inside = now >= from || now < to
+		}
+		if inside {
+			return megabytesPerSecond(window.MBPerSecond)
+		}
+	}
+	return megabytesPerSecond(fallback)
+}
+
+// megabytesPerSecond converts a limit in MB/s to bytes per second.
+func megabytesPerSecond(limit float64) int64 {
+	return int64(limit * 1024 * 1024)
+}
+
+// bandwidthLimiter paces the copies of one backup run.
+type bandwidthLimiter struct {
+	windows  []BandwidthWindow
+	fallback float64 // MB/s outside the windows, 0=unlimited
+
+	mu    sync.Mutex
+	limit int64     // Limit the current budget was started with
+	start time.Time // Start of the current budget
+	bytes int64     // Bytes copied since start
+}
+
+// newBandwidthLimiter returns a limiter for a config, or nil if it has no limits.
+func newBandwidthLimiter(config BackupConfig) *bandwidthLimiter {
+	if len(config.BandwidthLimits) == 0 && config.MaxMBPerSecond == 0 {
+		return nil
+	}
+	return &bandwidthLimiter{windows: config.BandwidthLimits, fallback: config.MaxMBPerSecond}
+}
+
+// wait blocks until n more bytes may be copied. A nil limiter never blocks.
+func (bl *bandwidthLimiter) wait(n int) {
+	if bl == nil {
+		return
+	}
+	bl.mu.Lock()
+	now := time.Now()
+	limit := bandwidthLimitAt(bl.windows, bl.fallback, now)
+	// Restart the budget when the limit changes, and after idle periods
+	// (between files, hashing) so they don't turn into a burst
+	if limit != bl.limit || now.Sub(bl.start) > 2*time.Second {
+		bl.limit = limit
+		bl.start = now
+		bl.bytes = 0
+	}
+	if limit == 0 {
+		bl.mu.Unlock()
+		return
+	}
+	bl.bytes += int64(n)
+	due := bl.start.Add(time.Duration(float64(bl.bytes) / float64(limit) * float64(time.Second)))
+	bl.mu.Unlock()
+
+	if delay := time.Until(due); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// reader wraps r so reads are paced by the limiter.
+func (bl *bandwidthLimiter) reader(r io.Reader) io.Reader {
+	if bl == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: bl}
+}
+
+// throttledReader is an io.Reader paced by a bandwidthLimiter
+type throttledReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+// throttledChunk caps single reads so pacing stays smooth at low limits
+const throttledChunk = 64 * 1024
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttledChunk {
+		p = p[:throttledChunk]
+	}
+	n, err := tr.r.Read(p)
+	tr.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,1028 @@
+// Package main - cli.go implements the command-line interface.
+//
+// Without arguments the program starts as a tray application. With a
+// subcommand it performs that one operation against config.json and exits,
+// which makes inspection tasks scriptable and usable over remote sessions.
+//
+// Key design decisions:
+//
+// 1. No single-instance lock: Subcommands run alongside the tray instance.
+//    Commands that only read snapshots are safe to run concurrently; commands
+//    that modify state must take the per-config lock like the tray does.
+//
+// 2. Console attachment: Release builds are GUI executables without a
+//    console, so output is routed to the console of the calling shell.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// passphraseEnvVar supplies the passphrase for encryption commands non-interactively
+const passphraseEnvVar = "SFB_PASSPHRASE"
+
+// cliCommand is one subcommand of the command-line interface.
+type cliCommand struct {
+	usage       string                  // Argument synopsis, e.g. "<config> <from> <to>"
+	description string                  // One-line help text
+	run         func(args []string) int // Executes the command and returns the exit code
+}
+
+// cliCommands lists the available subcommands by name
+var cliCommands = map[string]cliCommand{
+	"diff": {
+		usage:       "<config> <from> <to>",
+		description: "List files added, removed and changed between two snapshots",
+		run:         runDiffCommand,
+	},
+	"export": {
+		usage:       "[--encrypt] <config> <snapshot> [output.zip]",
+		description: "Package a snapshot into a zip file, optionally encrypted with a passphrase",
+		run:         runExportCommand,
+	},
+	"archive": {
+		usage:       "[--encrypt] [--detach] <config> [output.zip]",
+		description: "Pack all snapshots of a config into one verified zip; --detach then disables the config and deletes the snapshots",
+		run:         runArchiveCommand,
+	},
+	"migrate": {
+		usage:       "[--dry-run] [--keep] <config> <previous destination>",
+		description: "Move a config's snapshots from a previous destination into its current destination and format",
+		run:         runMigrateCommand,
+	},
+	"restore-files": {
+		usage:       "[--to <folder>] <config> <snapshot> <pattern>...",
+		description: "Restore only the snapshot files matching glob patterns such as \"**/*.docx\", leaving other files alone",
+		run:         runRestoreFilesCommand,
+	},
+	"snapshots": {
+		usage:       "<config>",
+		description: "List the snapshots of a config, newest first, with their notes",
+		run:         runSnapshotsCommand,
+	},
+	"note": {
+		usage:       "[--clear] <config> <snapshot> [text...]",
+		description: "Show, set or remove the note of a snapshot, e.g. \"before mod install\"",
+		run:         runNoteCommand,
+	},
+	"report": {
+		usage:       "[config]",
+		description: "Print success rates, storage use, weekly growth and a fill-up forecast per config",
+		run:         runReportCommand,
+	},
+	"simulate": {
+		usage:       "[--runs N] [--at \"YYYY-MM-DD HH:MM\"] [config]",
+		description: "Print the next planned backup runs per config without running anything",
+		run:         runSimulateCommand,
+	},
+	"decrypt": {
+		usage:       "<input.zip.enc> [output.zip]",
+		description: "Decrypt an encrypted export or snapshot back into a plain zip file",
+		run:         runDecryptCommand,
+	},
+	"backup-folder": {
+		usage:       "<folder>",
+		description: "Ask the running instance to back up every config whose source is the folder",
+		run:         runBackupFolderCommand,
+	},
+	"add-folder": {
+		usage:       "<folder>",
+		description: "Ask the running instance to add a backup config for the folder",
+		run:         runAddFolderCommand,
+	},
+	"toast-action": {
+		usage:       toastURIScheme + ":<action>?config=<name>",
+		description: "Ask the running instance to carry out a notification button (started by Windows)",
+		run:         runToastActionCommand,
+	},
+	"import": {
+		usage:       "[--add] filehistory|robocopy|syncback <file>",
+		description: "Translate another tool's settings into backup configs; --add appends them to config.json disabled",
+		run:         runImportCommand,
+	},
+	"exclude-presets": {
+		usage:       "[config]",
+		description: "List the exclusion presets, or how much each would leave out of a config's source",
+		run:         runExcludePresetsCommand,
+	},
+	"selftest": {
+		usage:       "[--verbose] [--keep]",
+		description: "Back up, skip, rotate, verify and restore temporary folders and report each stage",
+		run:         runSelfTestCommand,
+	},
+	StateTaskResetHash: {
+		usage:       "<config>...|--all",
+		description: "Forget the change-detection hash and warm cache, so the next run backs up",
+		run:         stateTaskCommand(StateTaskResetHash),
+	},
+	StateTaskClearHistory: {
+		usage:       "<config>...|--all",
+		description: "Delete the run counters, run records and storage samples shown in reports",
+		run:         stateTaskCommand(StateTaskClearHistory),
+	},
+	StateTaskRebuildCatalog: {
+		usage:       "<config>...|--all",
+		description: "Write missing snapshot manifests and delete those of snapshots removed by hand",
+		run:         stateTaskCommand(StateTaskRebuildCatalog),
+	},
+	StateTaskRebaseline: {
+		usage:       "<config>...|--all",
+		description: "Rebuild the catalog and reset change detection after editing the destination by hand",
+		run:         stateTaskCommand(StateTaskRebaseline),
+	},
+	"backup": {
+		usage:       "[--dry-run [--list]] <config>...",
+		description: "Back up configs now, in the running instance if there is one, otherwise here; --dry-run only reports what would be copied and deleted",
+		run:         runBackupCommand,
+	},
+	"status": {
+		usage:       "[--json] [config]",
+		description: "Print the state, last and next run and recent results of each config",
+		run:         runStatusCommand,
+	},
+	"pause": {
+		usage:       "",
+		description: "Pause all scheduled backups of the running instance until resumed",
+		run:         pauseAllCommand("pause", IPCActionPauseAll),
+	},
+	"resume": {
+		usage:       "",
+		description: "Resume all backups of the running instance",
+		run:         pauseAllCommand("resume", IPCActionResumeAll),
+	},
+	"history": {
+		usage:       "[--json] [--limit N] <config>",
+		description: "Print the recorded runs of a config, newest first, with their result, size and reason",
+		run:         runHistoryCommand,
+	},
+	"list": {
+		usage:       "",
+		description: "List the backup configs with their source, destination and schedule",
+		run:         runListCommand,
+	},
+	"validate-config": {
+		usage:       "",
+		description: "Check config.json for errors and likely mistakes without starting anything",
+		run:         runValidateConfigCommand,
+	},
+	"context-menu": {
+		usage:       "install|uninstall",
+		description: "Add or remove the Explorer folder context-menu entries (Windows)",
+		run:         runContextMenuCommand,
+	},
+}
+
+// runCLI executes a subcommand and returns the process exit code.
+func runCLI(args []string) int {
+	attachConsole()
+	if migration := describeMigration(); migration != "" {
+		fmt.Fprintln(os.Stderr, migration)
+	}
+
+	// Commands may also be written as flags, e.g. "--simulate"
+	name := strings.TrimPrefix(args[0], "--")
+	if name == "help" || name == "-h" {
+		printUsage()
+		return 0
+	}
+
+	command, exists := cliCommands[name]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+		printUsage()
+		return 2
+	}
+	return command.run(args[1:])
+}
+
+// printUsage lists all subcommands.
+func printUsage() {
+	fmt.Println("Usage: SimpleFolderBackup [" + configDirFlag + " <folder>] [command] [arguments]")
+	fmt.Println()
+	fmt.Println("Without a command, starts the system tray application; with " + headlessFlag + ",")
+	fmt.Println("runs the backup schedulers without a tray.")
+	fmt.Println()
+	fmt.Println("Configuration and state are kept per user; start with " + configDirFlag + " <folder> before")
+	fmt.Println("the command, or set " + dataDirEnvVar + ", to use another folder.")
+	fmt.Println()
+	fmt.Println("Commands:")
+
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		command := cliCommands[name]
+		fmt.Printf("  %s %s\n      %s\n", name, command.usage, command.description)
+	}
+}
+
+// loadCLIConfigs loads config.json and applies its settings.
+func loadCLIConfigs() ([]BackupConfig, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+	setActiveSettings(config.Settings)
+	if err := validatePaths(config); err != nil {
+		return nil, fmt.Errorf("invalid paths in config: %v", err)
+	}
+	registerSourceOverlaps(config)
+	return config.Backups, nil
+}
+
+// loadStateStores loads the state files for a command that changes them.
+//
+// Unlike read-only commands, which only warn, a failed load is an error:
+// saving a store that failed to load would wipe the other configs' entries.
+func loadStateStores() error {
+	if err := hashManager.loadFromFile(); err != nil {
+		return fmt.Errorf("failed to load hashes: %v", err)
+	}
+	if err := storageHistory.load(); err != nil {
+		return fmt.Errorf("failed to load storage history: %v", err)
+	}
+	if err := runStats.load(); err != nil {
+		return fmt.Errorf("failed to load run statistics: %v", err)
+	}
+	if err := purgeForecasts.load(); err != nil {
+		return fmt.Errorf("failed to load purge forecasts: %v", err)
+	}
+	return nil
+}
+
+// loadCLIConfig loads config.json and finds a backup configuration by name.
+func loadCLIConfig(name string) (BackupConfig, error) {
+	configs, err := loadCLIConfigs()
+	if err != nil {
+		return BackupConfig{}, err
+	}
+	return findConfig(configs, name)
+}
+
+// findConfig returns the backup configuration with the given name.
+func findConfig(configs []BackupConfig, name string) (BackupConfig, error) {
+	for _, backup := range configs {
+		if backup.Name == name {
+			return backup, nil
+		}
+	}
+	return BackupConfig{}, fmt.Errorf("no backup config named %q", name)
+}
+
+// readPassphrase returns the passphrase from the environment or asks for it on the console.
+func readPassphrase() (string, error) {
+	if passphrase := os.Getenv(passphraseEnvVar); passphrase != "" {
+		return passphrase, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %v", err)
+		}
+		return "", fmt.Errorf("passphrase must not be empty")
+	}
+	return passphrase, nil
+}
+
+// runDiffCommand prints the differences between two snapshots of a config.
+//
+// Snapshots are given as "latest", "previous", a snapshot directory name or a
+// prefix of one (e.g. a date). The older snapshot is always treated as the base.
+func runDiffCommand(args []string) int {
+	if len(args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup diff <config> <from> <to>")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list snapshots: %v\n", err)
+		return 1
+	}
+
+	from, err := findSnapshot(snapshots, args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	to, err := findSnapshot(snapshots, args[2])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if from.Time.After(to.Time) {
+		from, to = to, from
+	}
+
+	diff, err := diffSnapshots(from, to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(formatDiff(diff, 0))
+	return 0
+}
+
+// runExportCommand packages one snapshot of a config into a zip file.
+//
+// The output defaults to exports/<config>_<snapshot>.zip. With --encrypt the
+// passphrase is read from SFB_PASSPHRASE or prompted for.
+func runExportCommand(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	encrypt := flags.Bool("encrypt", false, "encrypt the archive with a passphrase")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup export [--encrypt] <config> <snapshot> [output.zip]")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list snapshots: %v\n", err)
+		return 1
+	}
+	snapshot, err := findSnapshot(snapshots, args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	outPath := defaultExportPath(config, snapshot)
+	if len(args) == 3 {
+		outPath = launchPath(args[2])
+	}
+	var passphrase string
+	if *encrypt {
+		passphrase, err = readPassphrase()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	written, err := exportSnapshot(snapshot, outPath, passphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	auditLog.record(AuditInterfaceCLI, "export", config.Name, fmt.Sprintf("%s -> %s (encrypted: %t)", snapshot.Name, written, *encrypt))
+	fmt.Println(displayPath(written))
+	return 0
+}
+
+// runArchiveCommand packs the snapshot history of a config into one archive.
+//
+// The output defaults to exports/<config>_history.zip. With --detach the
+// config is disabled and its snapshots deleted once the archive is verified.
+func runArchiveCommand(args []string) int {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	encrypt := flags.Bool("encrypt", false, "encrypt the archive with a passphrase")
+	detach := flags.Bool("detach", false, "disable the config and delete its snapshots after archiving")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup archive [--encrypt] [--detach] <config> [output.zip]")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *detach {
+		if err := ensureWritable(AuditInterfaceCLI, "archive", config.Name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	outPath := defaultArchivePath(config)
+	if len(args) == 2 {
+		outPath = launchPath(args[1])
+	}
+	var passphrase string
+	if *encrypt {
+		passphrase, err = readPassphrase()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	result, err := archiveHistory(config, outPath, passphrase, *detach, AuditInterfaceCLI)
+	if result.Path != "" {
+		auditLog.record(AuditInterfaceCLI, "archive", config.Name, fmt.Sprintf("%d snapshot(s) -> %s (encrypted: %t, detached: %t)", result.Snapshots, result.Path, *encrypt, result.Detached))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Archived %d snapshot(s) with %d file(s) to %s\n", result.Snapshots, result.Files, displayPath(result.Path))
+	if result.Detached {
+		fmt.Printf("%s is disabled and its snapshots were deleted from %s\n", config.Name, config.Destination)
+	}
+	return 0
+}
+
+// runMigrateCommand moves the snapshots of a config left in a previous
+// destination into the destination and format it uses now.
+func runMigrateCommand(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list what would be migrated")
+	keep := flags.Bool("keep", false, "leave the snapshots in the previous destination")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup migrate [--dry-run] [--keep] <config> <previous destination>")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !*dryRun {
+		if err := ensureWritable(AuditInterfaceCLI, "migrate", config.Name); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	from := launchPath(args[1])
+	result, err := migrateDestination(config, from, *keep, *dryRun, func(step string) { fmt.Println(step) })
+	if !*dryRun && result.Migrated > 0 {
+		auditLog.record(AuditInterfaceCLI, "migrate", config.Name, fmt.Sprintf("%d snapshot(s) from %s to %s (kept: %t)", result.Migrated, from, config.Destination, *keep))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	verb := "Migrated"
+	if *dryRun {
+		verb = "Would migrate"
+	}
+	fmt.Printf("%s %d snapshot(s) to %s; %d already there or skipped\n", verb, result.Migrated, displayPath(config.Destination), result.Skipped)
+	return 0
+}
+
+// runRestoreFilesCommand restores the files of a snapshot matching patterns.
+//
+// Patterns are matched against paths relative to the snapshot root; a
+// pattern naming a folder restores everything below it.
+func runRestoreFilesCommand(args []string) int {
+	flags := flag.NewFlagSet("restore-files", flag.ContinueOnError)
+	target := flags.String("to", "", "restore into this folder instead of the source")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup restore-files [--to <folder>] <config> <snapshot> <pattern>...")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := ensureWritable(AuditInterfaceCLI, "restore-files", config.Name); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list snapshots: %v\n", err)
+		return 1
+	}
+	snapshot, err := findSnapshot(snapshots, args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	patterns := args[2:]
+	auditLog.record(AuditInterfaceCLI, "restore-files", config.Name, fmt.Sprintf("%s: %s", snapshot.Name, strings.Join(patterns, " ")))
+	result, err := restoreFiles(config, snapshot, patterns, launchPath(*target))
+	if result.SafetyPath != "" {
+		fmt.Printf("Replaced files were saved to %s\n", result.SafetyPath)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Restored %d file(s), %s\n", result.Files, formatSize(result.Size))
+	return 0
+}
+
+// runSnapshotsCommand lists the snapshots of a configuration.
+func runSnapshotsCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup snapshots <config>")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list snapshots: %v\n", err)
+		return 1
+	}
+	for _, snapshot := range snapshots {
+		line := fmt.Sprintf("%s  %s (%s)", snapshot.Name, formatDisplayTime(snapshot.Time), formatAge(time.Since(snapshot.Time)))
+		if snapshot.Note != "" {
+			line += "  " + snapshot.Note
+		}
+		fmt.Println(line)
+	}
+	return 0
+}
+
+// runNoteCommand shows, replaces or removes the note of a snapshot.
+//
+// Without text the current note is printed; the words of text are joined
+// into the new note.
+func runNoteCommand(args []string) int {
+	flags := flag.NewFlagSet("note", flag.ContinueOnError)
+	remove := flags.Bool("clear", false, "remove the note")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) < 2 || (*remove && len(args) > 2) {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup note [--clear] <config> <snapshot> [text...]")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list snapshots: %v\n", err)
+		return 1
+	}
+	snapshot, err := findSnapshot(snapshots, args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(args) == 2 && !*remove {
+		if snapshot.Note != "" {
+			fmt.Println(snapshot.Note)
+		}
+		return 0
+	}
+
+	note := strings.Join(args[2:], " ")
+	if err := saveSnapshotNote(config.Destination, snapshot.Name, note); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save note: %v\n", err)
+		return 1
+	}
+	auditLog.record(AuditInterfaceCLI, "note", config.Name, fmt.Sprintf("%s: %q", snapshot.Name, normalizeSnapshotNote(note)))
+	return 0
+}
+
+// runDecryptCommand turns an encrypted export back into a plain zip file.
+func runDecryptCommand(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup decrypt <input.zip.enc> [output.zip]")
+		return 2
+	}
+
+	inPath := launchPath(args[0])
+	outPath := strings.TrimSuffix(inPath, encryptedExportExtension)
+	if len(args) == 2 {
+		outPath = launchPath(args[1])
+	}
+	if outPath == inPath {
+		fmt.Fprintln(os.Stderr, "Output path must differ from the input path")
+		return 2
+	}
+
+	passphrase, err := readPassphrase()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := decryptExport(inPath, outPath, passphrase); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(outPath)
+	return 0
+}
+
+// runReportCommand prints the report for one or all configurations.
+func runReportCommand(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup report [config]")
+		return 2
+	}
+
+	configs, err := loadCLIConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(args) == 1 {
+		config, err := findConfig(configs, args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		configs = []BackupConfig{config}
+	}
+	if err := storageHistory.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load storage history: %v\n", err)
+	}
+	if err := runStats.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load run statistics: %v\n", err)
+	}
+	if err := purgeForecasts.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load purge forecasts: %v\n", err)
+	}
+
+	fmt.Print(formatReport(configs))
+	return 0
+}
+
+// runSimulateCommand prints when each enabled configuration would run next.
+//
+// Uses the live scheduling rules (existing snapshots, hash history, first
+// backup policy) against a simulated clock, optionally starting at --at to
+// check behavior around a particular moment such as a DST change.
+func runSimulateCommand(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	runs := flags.Int("runs", 5, "number of runs to list per config")
+	at := flags.String("at", "", "simulate starting at this local time instead of now")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup simulate [--runs N] [--at \"YYYY-MM-DD HH:MM\"] [config]")
+		return 2
+	}
+
+	start := time.Now()
+	if *at != "" {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", *at, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --at time %q, expected YYYY-MM-DD HH:MM\n", *at)
+			return 2
+		}
+		start = parsed
+	}
+
+	configs, err := loadCLIConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if flags.NArg() == 1 {
+		config, err := findConfig(configs, flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		configs = []BackupConfig{config}
+	}
+	if err := hashManager.loadFromFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load hash file: %v\n", err)
+	}
+
+	for _, config := range configs {
+		if !config.IsEnabled() {
+			fmt.Printf("%s: disabled\n\n", config.Name)
+			continue
+		}
+
+		plan, planned := simulateSchedule(config, newSimulatedClock(start), *runs)
+		fmt.Printf("%s (every %d minutes)\n  %s\n", config.Name, config.ScheduleMinutes, plan.reason)
+		if plan.awaitConfirmation {
+			fmt.Println("  Runs start once the first backup is confirmed from the tray")
+		}
+		for _, run := range planned {
+			fmt.Printf("  %s\n", formatDisplayTime(run))
+		}
+		fmt.Println()
+	}
+	return 0
+}
+
+// runBackupFolderCommand forwards a "back up now" request to the tray instance.
+func runBackupFolderCommand(args []string) int {
+	return runFolderRequest(IPCActionBackupFolder, args)
+}
+
+// runAddFolderCommand forwards an "add folder" request to the tray instance.
+func runAddFolderCommand(args []string) int {
+	return runFolderRequest(IPCActionAddFolder, args)
+}
+
+// runFolderRequest sends a folder request over IPC and reports the answer.
+//
+// These commands are mostly started from Explorer without a console, so
+// failures are also shown in a message box.
+func runFolderRequest(action string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: SimpleFolderBackup %s <folder>\n", action)
+		return 2
+	}
+	folder, err := filepath.Abs(launchPath(args[0]))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	resp, err := sendIPCRequest(ipcRequest{Action: action, Path: folder})
+	if err == nil && !resp.OK {
+		err = errors.New(resp.Message)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		showMessageBox("SimpleFolderBackup", err.Error())
+		return 1
+	}
+	fmt.Println(resp.Message)
+	return 0
+}
+
+// stateTaskCommand returns the CLI command running a state task.
+//
+// The task is forwarded to the tray instance when it is running, since it
+// holds the state in memory; otherwise it runs here on the state files.
+func stateTaskCommand(task string) func(args []string) int {
+	return func(args []string) int {
+		all := len(args) == 1 && args[0] == "--all"
+		if len(args) == 0 || (!all && slices.Contains(args, "--all")) {
+			fmt.Fprintf(os.Stderr, "Usage: SimpleFolderBackup %s <config>...|--all\n", task)
+			return 2
+		}
+		var names []string
+		if !all {
+			names = args
+		}
+
+		resp, err := sendIPCRequest(ipcRequest{Action: IPCActionStateTask, Task: task, Configs: names})
+		if err == nil {
+			if !resp.OK {
+				fmt.Fprintln(os.Stderr, resp.Message)
+				return 1
+			}
+			fmt.Println(resp.Message)
+			return 0
+		}
+		if !errors.Is(err, errInstanceNotRunning) {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if _, err := loadCLIConfigs(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		configs, err := resolveStateTaskConfigs(names)
+		if err == nil {
+			err = checkStateTask(task, configs, AuditInterfaceCLI)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := loadStateStores(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		message, err := runStateTask(task, configs, AuditInterfaceCLI)
+		if message != "" {
+			fmt.Println(message)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+}
+
+// runContextMenuCommand installs or removes the Explorer folder verbs.
+func runContextMenuCommand(args []string) int {
+	if len(args) != 1 || (args[0] != "install" && args[0] != "uninstall") {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup context-menu install|uninstall")
+		return 2
+	}
+
+	install := args[0] == "install"
+	var err error
+	if install {
+		err = installContextMenu()
+	} else {
+		err = uninstallContextMenu()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s the context menu: %v\n", args[0], err)
+		return 1
+	}
+	auditLog.record(AuditInterfaceCLI, "context-menu-"+args[0], "", "")
+	if install {
+		fmt.Println("Right-click a folder in Explorer to back it up or add it.")
+	} else {
+		fmt.Println("Context-menu entries removed.")
+	}
+	return 0
+}
+
+// runImportCommand converts another tool's settings into backup configs.
+//
+// Without --add the configs are only printed as JSON for pasting into
+// config.json. With --add they are appended, disabled, under unique names.
+func runImportCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	add := flags.Bool("add", false, "append the imported configs to config.json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup import [--add] filehistory|robocopy|syncback <file>")
+		return 2
+	}
+
+	result, err := importConfigs(args[0], launchPath(args[1]))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	if !*add {
+		data, err := json.MarshalIndent(result.Configs, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	setActiveSettings(config.Settings)
+	if err := ensureWritable(AuditInterfaceCLI, "import", ""); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	previous := *config
+	previous.Backups = append([]BackupConfig(nil), config.Backups...)
+	for _, imported := range result.Configs {
+		imported.Name = uniqueConfigName(config, imported.Name)
+		config.Backups = append(config.Backups, imported)
+		fmt.Printf("Added %q: %s -> %s (disabled)\n", imported.Name, imported.Source, imported.Destination)
+	}
+	if err := saveConfig(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save config: %v\n", err)
+		return 1
+	}
+	auditLog.recordConfigUpdate(AuditInterfaceCLI, &previous, config)
+	fmt.Println("Review the new configs in config.json, set \"enabled\" to true and restart the application.")
+	return 0
+}
+
+// runExcludePresetsCommand lists the exclusion presets.
+//
+// With a config name, each preset is measured against that config's source,
+// which shows what switching it on would save before any snapshot is taken.
+func runExcludePresetsCommand(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup exclude-presets [config]")
+		return 2
+	}
+
+	if len(args) == 0 {
+		for _, name := range excludePresetNames() {
+			fmt.Printf("%s\n    %s\n    %s\n", name, excludePresetDescriptions[name], strings.Join(excludePresets[name], " "))
+		}
+		return 0
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enabled := make(map[string]bool)
+	for _, preset := range config.ExcludePresets {
+		enabled[strings.ToLower(preset)] = true
+	}
+	for _, name := range excludePresetNames() {
+		files, size, err := presetUsage(config, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to scan %s: %v\n", config.Source, err)
+			return 1
+		}
+		state := "off"
+		if enabled[name] {
+			state = "on"
+		}
+		fmt.Printf("%-16s %-3s %6d files %10s  %s\n", name, state, files, formatSize(size), excludePresetDescriptions[name])
+	}
+	return 0
+}
+
+// runSelfTestCommand runs the self-test stages and prints one line per stage.
+//
+// Exits with 1 if any stage failed. --verbose shows the backup log of the
+// stages, --keep leaves the temporary folder for inspection.
+func runSelfTestCommand(args []string) int {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	verbose := flags.Bool("verbose", false, "show the backup log of each stage")
+	keep := flags.Bool("keep", false, "keep the temporary source and destination")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup selftest [--verbose] [--keep]")
+		return 2
+	}
+
+	dir, err := os.MkdirTemp("", "sfb-selftest-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create a temporary folder: %v\n", err)
+		return 1
+	}
+	if *keep {
+		fmt.Printf("Testing in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	logOutput := io.Discard
+	if *verbose {
+		logOutput = os.Stderr
+	}
+
+	failures := 0
+	for _, result := range runSelfTest(dir, logOutput) {
+		status := "PASS"
+		switch {
+		case !result.Ran:
+			status, result.Detail = "----", "not run"
+		case !result.Passed:
+			status = "FAIL"
+			failures++
+		}
+		fmt.Printf("%s  %-8s %s\n", status, result.Stage, result.Detail)
+	}
+	if failures > 0 {
+		fmt.Println("Self-test failed")
+		return 1
+	}
+	fmt.Println("All stages passed")
+	return 0
+}
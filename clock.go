@@ -0,0 +1,158 @@
+// Package main - clock.go abstracts time for the scheduler and status tracking.
+//
+// Scheduling decisions (overdue math, hash-aware startup, countdowns) depend
+// on the current time and on timers firing. Routing them through the Clock
+// interface lets that logic run against a simulated clock, both for the
+// "simulate" command and for exercising the timing rules without waiting in
+// real time.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single-shot timer created by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is a repeating timer created by a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the wall clock used outside of simulations
+var systemClock Clock = realClock{}
+
+// realClock implements Clock with the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (rt realTimer) C() <-chan time.Time { return rt.t.C }
+
+func (rt realTimer) Stop() bool { return rt.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (rt realTicker) C() <-chan time.Time { return rt.t.C }
+
+func (rt realTicker) Stop() { rt.t.Stop() }
+
+// simulatedClock is a Clock whose time only moves when advanced.
+//
+// Timers and tickers fire during advance as their deadlines pass, in order,
+// which lets scheduling logic be replayed over days of simulated time
+// instantly. Channels are buffered with one slot, like the time package's.
+type simulatedClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*simulatedTimer
+}
+
+// newSimulatedClock returns a simulated clock starting at now.
+func newSimulatedClock(now time.Time) *simulatedClock {
+	return &simulatedClock{now: now}
+}
+
+func (sc *simulatedClock) Now() time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.now
+}
+
+func (sc *simulatedClock) NewTimer(d time.Duration) Timer {
+	return sc.addWaiter(d, 0)
+}
+
+func (sc *simulatedClock) NewTicker(d time.Duration) Ticker {
+	return simulatedTicker{sc.addWaiter(d, d)}
+}
+
+func (sc *simulatedClock) addWaiter(d, period time.Duration) *simulatedTimer {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st := &simulatedTimer{clock: sc, deadline: sc.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	sc.waiters = append(sc.waiters, st)
+	return st
+}
+
+// advance moves the clock forward by d, firing every timer that comes due.
+func (sc *simulatedClock) advance(d time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	end := sc.now.Add(d)
+	for {
+		var next *simulatedTimer
+		for _, st := range sc.waiters {
+			if !st.deadline.After(end) && (next == nil || st.deadline.Before(next.deadline)) {
+				next = st
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		sc.now = next.deadline
+		select {
+		case next.c <- sc.now:
+		default: // Receiver is behind; drop the tick like time.Ticker does
+		}
+		if next.period > 0 {
+			next.deadline = next.deadline.Add(next.period)
+		} else {
+			sc.removeLocked(next)
+		}
+	}
+	sc.now = end
+}
+
+func (sc *simulatedClock) removeLocked(st *simulatedTimer) bool {
+	for i, waiter := range sc.waiters {
+		if waiter == st {
+			sc.waiters = append(sc.waiters[:i], sc.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// simulatedTimer is a timer or ticker of a simulatedClock.
+type simulatedTimer struct {
+	clock    *simulatedClock
+	deadline time.Time
+	period   time.Duration // 0 for single-shot timers
+	c        chan time.Time
+}
+
+func (st *simulatedTimer) C() <-chan time.Time { return st.c }
+
+func (st *simulatedTimer) Stop() bool {
+	st.clock.mu.Lock()
+	defer st.clock.mu.Unlock()
+	return st.clock.removeLocked(st)
+}
+
+// simulatedTicker adapts simulatedTimer to the Ticker interface.
+type simulatedTicker struct{ st *simulatedTimer }
+
+func (t simulatedTicker) C() <-chan time.Time { return t.st.c }
+
+func (t simulatedTicker) Stop() { t.st.Stop() }
@@ -0,0 +1,226 @@
+// Package main - compare.go implements comparison of two snapshots.
+//
+// "What changed between Friday and Monday" is answered by diffing the two
+// snapshot directories: files only in the newer snapshot were added, files
+// only in the older one were removed, and files in both with different
+// content were changed.
+//
+// Snapshot copies don't preserve modification times, so timestamps can't be
+// used to detect changes. Files of equal size are compared byte by byte,
+// which stops at the first difference and never reads unchanged files twice.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of change reported by diffSnapshots
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// FileChange describes one file that differs between two snapshots.
+type FileChange struct {
+	Path    string // Slash-separated path relative to the snapshot root
+	Kind    string // ChangeAdded, ChangeRemoved or ChangeChanged
+	OldSize int64  // Size in the older snapshot (0 when added)
+	NewSize int64  // Size in the newer snapshot (0 when removed)
+}
+
+// SnapshotDiff is the result of comparing two snapshots.
+type SnapshotDiff struct {
+	From    Snapshot
+	To      Snapshot
+	Changes []FileChange // Sorted by path
+}
+
+// counts returns the number of added, removed and changed files.
+func (d *SnapshotDiff) counts() (added, removed, changed int) {
+	for _, change := range d.Changes {
+		switch change.Kind {
+		case ChangeAdded:
+			added++
+		case ChangeRemoved:
+			removed++
+		case ChangeChanged:
+			changed++
+		}
+	}
+	return added, removed, changed
+}
+
+// summary renders a one-line description such as "3 added, 1 removed, 5 changed".
+func (d *SnapshotDiff) summary() string {
+	added, removed, changed := d.counts()
+	return fmt.Sprintf("%d added, %d removed, %d changed", added, removed, changed)
+}
+
+// diffSnapshots compares two snapshots, treating from as the older one.
+func diffSnapshots(from, to Snapshot) (*SnapshotDiff, error) {
+	oldFiles, err := snapshotFileSizes(from.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %v", from.Name, err)
+	}
+	newFiles, err := snapshotFileSizes(to.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %v", to.Name, err)
+	}
+
+	diff := &SnapshotDiff{From: from, To: to}
+	for path, newSize := range newFiles {
+		oldSize, existed := oldFiles[path]
+		if !existed {
+			diff.Changes = append(diff.Changes, FileChange{Path: path, Kind: ChangeAdded, NewSize: newSize})
+			continue
+		}
+		same := oldSize == newSize
+		if same {
+			same, err = sameFileContent(filepath.Join(from.Path, filepath.FromSlash(path)), filepath.Join(to.Path, filepath.FromSlash(path)))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !same {
+			diff.Changes = append(diff.Changes, FileChange{Path: path, Kind: ChangeChanged, OldSize: oldSize, NewSize: newSize})
+		}
+	}
+	for path, oldSize := range oldFiles {
+		if _, stillExists := newFiles[path]; !stillExists {
+			diff.Changes = append(diff.Changes, FileChange{Path: path, Kind: ChangeRemoved, OldSize: oldSize})
+		}
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		return diff.Changes[i].Path < diff.Changes[j].Path
+	})
+	return diff, nil
+}
+
+// snapshotFileSizes maps each regular file in a snapshot to its size.
+func snapshotFileSizes(root string) (map[string]int64, error) {
+	files := make(map[string]int64)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relPath)] = info.Size()
+		return nil
+	})
+	return files, err
+}
+
+// sameFileContent reports whether two files have identical content.
+func sameFileContent(pathA, pathB string) (bool, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		nA, errA := io.ReadFull(fileA, bufA)
+		nB, errB := io.ReadFull(fileB, bufB)
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// findSnapshot resolves a user-supplied snapshot reference.
+//
+// Accepts "latest", "previous", a full snapshot directory name, or a prefix of
+// one such as "13-10-2026" (the newest matching snapshot wins, so a date picks
+// the last snapshot taken that day).
+func findSnapshot(snapshots []Snapshot, ref string) (Snapshot, error) {
+	switch ref {
+	case "latest":
+		if len(snapshots) > 0 {
+			return snapshots[0], nil
+		}
+	case "previous":
+		if len(snapshots) > 1 {
+			return snapshots[1], nil
+		}
+	default:
+		for _, snapshot := range snapshots {
+			if strings.HasPrefix(snapshot.Name, ref) {
+				return snapshot, nil
+			}
+		}
+	}
+	return Snapshot{}, fmt.Errorf("no snapshot matches %q", ref)
+}
+
+// formatDiff renders a diff as text, listing at most limit changes (0 = all).
+func formatDiff(diff *SnapshotDiff, limit int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s -> %s\n", formatDisplayTime(diff.From.Time), formatDisplayTime(diff.To.Time))
+	fmt.Fprintf(&b, "%s\n", diff.summary())
+
+	for i, change := range diff.Changes {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(&b, "... and %d more\n", len(diff.Changes)-limit)
+			break
+		}
+		switch change.Kind {
+		case ChangeAdded:
+			fmt.Fprintf(&b, "+ %s (%s)\n", change.Path, formatSize(change.NewSize))
+		case ChangeRemoved:
+			fmt.Fprintf(&b, "- %s (%s)\n", change.Path, formatSize(change.OldSize))
+		case ChangeChanged:
+			fmt.Fprintf(&b, "~ %s (%s -> %s)\n", change.Path, formatSize(change.OldSize), formatSize(change.NewSize))
+		}
+	}
+	return b.String()
+}
+
+// formatSize renders a byte count with a binary unit, e.g. "1.5 MB".
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -22,8 +22,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 )
 
 // BackupConfig defines the configuration for a single backup operation.
@@ -37,19 +40,151 @@ import (
 // This structure supports multiple backup configurations in a single application
 // instance, allowing users to backup different sources simultaneously.
 type BackupConfig struct {
-	Name             string `json:"name"`              // Display name for UI and logging
-	Source           string `json:"source"`            // Path to directory to backup
-	Destination      string `json:"destination"`       // Path where backups are stored
-	ScheduleMinutes  int    `json:"schedule_minutes"`  // Backup interval in minutes
-	RotationCount    int    `json:"rotation_count"`    // Number of backups to retain
-	Enabled          *bool  `json:"enabled,omitempty"` // nil=enabled, pointer to distinguish from false
-	HashCheck        *bool  `json:"hash_check,omitempty"`       // nil=enabled, optimizes unchanged content
-	LogRetentionDays *int   `json:"log_retention_days,omitempty"` // nil=7 days, per-backup log cleanup
+	Name                 string               `json:"name"`                             // Display name for UI and logging
+	Source               string               `json:"source"`                           // Path to directory to backup
+	Destination          string               `json:"destination"`                      // Path where backups are stored, s3://bucket/prefix or sftp://user@host/folder
+	ScheduleMinutes      int                  `json:"schedule_minutes"`                 // Backup interval in minutes
+	RotationCount        int                  `json:"rotation_count"`                   // Number of backups to retain
+	Enabled              *bool                `json:"enabled,omitempty"`                // nil=enabled, pointer to distinguish from false
+	HashCheck            *bool                `json:"hash_check,omitempty"`             // nil=enabled, optimizes unchanged content
+	LogRetentionDays     *int                 `json:"log_retention_days,omitempty"`     // nil=7 days, per-backup log cleanup
+	CopyStrategy         string               `json:"copy_strategy,omitempty"`          // ""/"standard" or "sqlite" for live databases
+	RunBeforeShutdown    bool                 `json:"run_before_shutdown,omitempty"`    // Windows: back up on pending restart and session end
+	MissingSource        string               `json:"missing_source,omitempty"`         // "fail" (default), "wait" or "disable"
+	MissingSourceLimit   *int                 `json:"missing_source_limit,omitempty"`   // nil=3 misses before "disable" stops the config
+	StaleAlertDays       *int                 `json:"stale_alert_days,omitempty"`       // nil=off, warn when content unchanged this many days
+	Notify               map[string][]string  `json:"notify,omitempty"`                 // Event -> notification channels, nil=defaults
+	FirstBackup          string               `json:"first_backup,omitempty"`           // "immediate" (default), "scheduled" or "confirm" for configs without snapshots
+	WarmCache            string               `json:"warm_cache,omitempty"`             // "off" (default), "memory" or "persist": reuse the source listing between runs
+	MaxDepth             *int                 `json:"max_depth,omitempty"`              // nil=unlimited, directory levels below the source to walk
+	FollowLinks          bool                 `json:"follow_links,omitempty"`           // Descend into symlinked directories and junctions
+	Hooks                *HookSettings        `json:"hooks,omitempty"`                  // Commands run before and after backups
+	Retention            string               `json:"retention,omitempty"`              // "count" (default), "thinning" or "gfs": keep old snapshots ever more sparsely
+	GFS                  *GFSRetention        `json:"gfs,omitempty"`                    // Periods kept by "retention": "gfs"
+	RetentionExceptions  []RetentionException `json:"retention_exceptions,omitempty"`   // Weekday snapshots kept beyond rotation_count
+	PauseAfterFailures   *int                 `json:"pause_after_failures,omitempty"`   // nil=off, pause after this many identical failures in a row
+	Exclude              []string             `json:"exclude,omitempty"`                // Patterns of files and folders left out of snapshots
+	ExcludePresets       []string             `json:"exclude_presets,omitempty"`        // Named pattern groups from exclude.go, e.g. "caches"
+	ExcludeNestedSources bool                 `json:"exclude_nested_sources,omitempty"` // Leave out sources of other configs nested inside this one
+	VerifyCopies         bool                 `json:"verify_copies,omitempty"`          // Read back every copied file and compare it with the source hash
+	BandwidthLimits      []BandwidthWindow    `json:"bandwidth_limits,omitempty"`       // Copy speed limits by time of day, max_mb_per_second outside them
+	MaxMBPerSecond       float64              `json:"max_mb_per_second,omitempty"`      // Copy speed limit in megabytes per second whenever no bandwidth window applies, 0=unlimited
+	Incremental          bool                 `json:"incremental,omitempty"`            // Hardlink files unchanged since the previous snapshot instead of copying them
+	LogToDestination     bool                 `json:"log_to_destination,omitempty"`     // Also write the backup log to a logs folder at the destination
+	Replica              *ReplicaSettings     `json:"replica,omitempty"`                // Second destination the snapshots are copied to on their own schedule
+	S3                   *S3Settings          `json:"s3,omitempty"`                     // Endpoint and credentials for s3:// destinations
+	SFTP                 *SFTPSettings        `json:"sftp,omitempty"`                   // SSH key and client for sftp:// destinations
+	Encryption           *EncryptionSettings  `json:"encryption,omitempty"`             // Write snapshots as archives encrypted with a passphrase
+	VerifyOnly           bool                 `json:"verify_only,omitempty"`            // Only check the source for changes, write no snapshots (e.g. during a destination migration)
+	Trigger              string               `json:"trigger,omitempty"`                // What starts backups: "schedule" (default) or "watch" (also after changes in the source settle)
+	CriticalFiles        []string             `json:"critical_files,omitempty"`         // Files (relative to source) that alert within minutes when deleted or truncated
+	WatchDelayMinutes    *int                 `json:"watch_delay_minutes,omitempty"`    // With "trigger": "watch", minutes without changes before a backup starts (default 5)
+	AlertAfterFailures   *int                 `json:"alert_after_failures,omitempty"`   // nil=3, raise repeated_failure after this many failures in a row; 0=never
+	FreeSpaceHeadroomMB  *int                 `json:"free_space_headroom_mb,omitempty"` // nil=1024, megabytes that must stay free at the destination after a backup
+	MinFreeSpaceGB       *int                 `json:"min_free_space_gb,omitempty"`      // Gigabytes kept free at the destination by deleting the oldest snapshots; nil or 0=off
+	MaxTotalSizeGB       *int                 `json:"max_total_size_gb,omitempty"`      // Gigabytes all snapshots of the config may use together; nil or 0=no cap
+}
+
+// Settings holds application-wide options that apply across all backup configurations.
+//
+// Like BackupConfig, every field is optional and an empty value means "use the
+// default", so existing config files without a settings section keep working.
+type Settings struct {
+	DateFormat           string           `json:"date_format,omitempty"`            // Display format: "system" (default), "iso", "us", "eu" or a Go layout
+	ObfuscatePaths       bool             `json:"obfuscate_paths,omitempty"`        // Replace configured paths in logs with stable hashes
+	WebhookURL           string           `json:"webhook_url,omitempty"`            // Endpoint for the "webhook" notification channel
+	SMTP                 *SMTPSettings    `json:"smtp,omitempty"`                   // Mail server for the "email" notification channel
+	DefaultDestination   string           `json:"default_destination,omitempty"`    // Parent folder for configs added from the Explorer context menu
+	Hotkeys              []HotkeySettings `json:"hotkeys,omitempty"`                // Global keyboard shortcuts that start backups
+	ReadOnly             bool             `json:"read_only,omitempty"`              // Show status only; refuse manual runs, restores and config edits
+	ShutdownGraceMinutes *int             `json:"shutdown_grace_minutes,omitempty"` // nil=10, how long Exit waits for running backups; 0 exits at once
+	MaintenanceConflicts string           `json:"maintenance_conflicts,omitempty"`  // "log" (default), "shift" or "ignore": scheduled backups starting in OS maintenance windows
+	ConfirmManualBackups bool             `json:"confirm_manual_backups,omitempty"` // Show a summary (size, free space, last snapshot) and ask before "Backup now" from the tray
+	DashboardPort        int              `json:"dashboard_port,omitempty"`         // 0=off, serve a status dashboard at http://127.0.0.1:<port>
+	MaxConcurrent        int              `json:"max_concurrent,omitempty"`         // 0=unlimited, backups (and startup hash checks) of all configs running at once
+	StartAtLogin         *bool            `json:"start_at_login,omitempty"`         // nil=leave as is, register (true) or unregister (false) the app to start with the user's session
+}
+
+// HookSettings configures the commands a backup config runs around its backups.
+type HookSettings struct {
+	PreBackup  string            `json:"pre_backup,omitempty"`  // Runs before change detection; failure fails the run
+	PostBackup string            `json:"post_backup,omitempty"` // Runs after each new snapshot
+	Env        map[string]string `json:"env,omitempty"`         // Extra variables; "credential:<name>" reads the credential store
+	Dir        string            `json:"dir,omitempty"`         // Working directory, empty=source folder
+}
+
+// HotkeySettings binds a global keyboard shortcut to a manual backup.
+type HotkeySettings struct {
+	Keys   string `json:"keys"`             // e.g. "Ctrl+Alt+B"
+	Config string `json:"config,omitempty"` // Backup config to run, empty=all configs
+}
+
+// SMTPSettings configures the mail server used for email notifications.
+type SMTPSettings struct {
+	Host     string   `json:"host"`               // Mail server host name
+	Port     int      `json:"port,omitempty"`     // 0=587; 465 uses implicit TLS, others STARTTLS when offered
+	Username string   `json:"username,omitempty"` // Empty for servers without authentication
+	Password string   `json:"password,omitempty"` // Masked in logs, audit entries and diagnostics
+	From     string   `json:"from"`               // Sender address
+	To       []string `json:"to"`                 // Recipient addresses
 }
 
 // Config is the root configuration structure containing all backup configurations.
 type Config struct {
-	Backups []BackupConfig `json:"backups"`
+	Backups  []BackupConfig `json:"backups"`
+	Settings Settings       `json:"settings,omitzero"`
+}
+
+// activeSettings holds the settings of the currently loaded configuration.
+//
+// Settings are read from many goroutines (loggers, status display) but only
+// replaced when configuration is loaded, so a RWMutex keeps reads cheap.
+var (
+	settingsMu     sync.RWMutex
+	activeSettings Settings
+)
+
+// setActiveSettings replaces the application-wide settings after a config load.
+func setActiveSettings(settings Settings) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	activeSettings = settings
+}
+
+// GetShutdownGraceMinutes returns how long Exit waits for running backups and restores.
+//
+// Returns 10 if not specified, and 0 (exit immediately) for negative values.
+func (s *Settings) GetShutdownGraceMinutes() int {
+	if s.ShutdownGraceMinutes == nil {
+		return 10
+	}
+	return max(*s.ShutdownGraceMinutes, 0)
+}
+
+// GetMaxConcurrent returns how many backups may run at once across all
+// configurations; 0 means no limit, as do negative values.
+func (s *Settings) GetMaxConcurrent() int {
+	return max(s.MaxConcurrent, 0)
+}
+
+// GetMaintenanceConflicts returns how scheduled backups starting in an OS
+// maintenance window are handled.
+//
+// Returns MaintenanceConflictLog if not specified or unrecognized.
+func (s *Settings) GetMaintenanceConflicts() string {
+	switch s.MaintenanceConflicts {
+	case MaintenanceConflictShift, MaintenanceConflictIgnore:
+		return s.MaintenanceConflicts
+	default:
+		return MaintenanceConflictLog
+	}
+}
+
+// currentSettings returns a copy of the application-wide settings.
+func currentSettings() Settings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return activeSettings
 }
 
 // loadConfig loads the backup configuration from config.json, creating a default if none exists.
@@ -157,6 +292,176 @@ func (bc *BackupConfig) GetLogRetentionDays() int {
 	return *bc.LogRetentionDays
 }
 
+// GetCopyStrategy returns the file copy strategy for this backup configuration.
+//
+// The "sqlite" strategy copies live SQLite databases together with their
+// -wal/-shm/-journal files and validates the result (see sqlite.go). It costs
+// an extra header read per file, so it's opt-in for sources known to contain
+// application databases such as browser profiles.
+//
+// Returns CopyStrategyStandard if not specified or unrecognized.
+func (bc *BackupConfig) GetCopyStrategy() string {
+	if bc.CopyStrategy == CopyStrategySQLite {
+		return CopyStrategySQLite
+	}
+	return CopyStrategyStandard
+}
+
+// GetMissingSourcePolicy returns how this configuration reacts to a missing source.
+//
+// Returns MissingSourceFail if not specified or unrecognized, since failing
+// loudly is the safest behavior for a backup tool.
+func (bc *BackupConfig) GetMissingSourcePolicy() string {
+	switch bc.MissingSource {
+	case MissingSourceWait, MissingSourceDisable:
+		return bc.MissingSource
+	default:
+		return MissingSourceFail
+	}
+}
+
+// GetMissingSourceLimit returns how many consecutive misses disable a configuration.
+//
+// Only used by the "disable" policy. Three misses tolerates a brief unmount
+// (e.g. a drive being swapped) without disabling the config.
+func (bc *BackupConfig) GetMissingSourceLimit() int {
+	if bc.MissingSourceLimit == nil || *bc.MissingSourceLimit < 1 {
+		return 3
+	}
+	return *bc.MissingSourceLimit
+}
+
+// GetStaleAlertDays returns after how many days of unchanged content to warn.
+//
+// For folders that are supposed to keep receiving data (camera imports,
+// exports), unchanged content usually means the upstream pipeline broke.
+// Requires hash checking, since the content hash is what detects change.
+//
+// Returns 0 (disabled) if not specified.
+func (bc *BackupConfig) GetStaleAlertDays() int {
+	if bc.StaleAlertDays == nil || *bc.StaleAlertDays < 0 {
+		return 0
+	}
+	return *bc.StaleAlertDays
+}
+
+// GetFirstBackupPolicy returns when a configuration without snapshots first runs.
+//
+// Returns FirstBackupImmediate if not specified or unrecognized, which keeps
+// the behavior of existing configurations.
+func (bc *BackupConfig) GetFirstBackupPolicy() string {
+	switch bc.FirstBackup {
+	case FirstBackupScheduled, FirstBackupConfirm:
+		return bc.FirstBackup
+	default:
+		return FirstBackupImmediate
+	}
+}
+
+// GetWarmCacheMode returns how the source listing is cached between hash checks.
+//
+// Returns WarmCacheOff if not specified or unrecognized.
+func (bc *BackupConfig) GetWarmCacheMode() string {
+	switch bc.WarmCache {
+	case WarmCacheMemory, WarmCachePersist:
+		return bc.WarmCache
+	default:
+		return WarmCacheOff
+	}
+}
+
+// GetRetentionMode returns how old snapshots are chosen for deletion.
+//
+// Returns RetentionCount if not specified or unrecognized.
+func (bc *BackupConfig) GetRetentionMode() string {
+	switch bc.Retention {
+	case RetentionThinning, RetentionGFS:
+		return bc.Retention
+	default:
+		return RetentionCount
+	}
+}
+
+// GetMaxDepth returns how many directory levels below the source are walked.
+//
+// Returns 0 (unlimited) if not specified or not positive.
+func (bc *BackupConfig) GetMaxDepth() int {
+	if bc.MaxDepth == nil || *bc.MaxDepth < 1 {
+		return 0
+	}
+	return *bc.MaxDepth
+}
+
+// GetPauseAfterFailures returns how many identical failures in a row pause the config.
+//
+// Returns 0 (never pause) if not specified or not positive.
+func (bc *BackupConfig) GetPauseAfterFailures() int {
+	if bc.PauseAfterFailures == nil || *bc.PauseAfterFailures < 1 {
+		return 0
+	}
+	return *bc.PauseAfterFailures
+}
+
+// GetAlertAfterFailures returns how many failures in a row raise a repeated_failure event.
+//
+// Returns 3 if not specified and 0 (never) if set to 0 or less.
+func (bc *BackupConfig) GetAlertAfterFailures() int {
+	if bc.AlertAfterFailures == nil {
+		return defaultAlertAfterFailures
+	}
+	return max(*bc.AlertAfterFailures, 0)
+}
+
+// GetFreeSpaceHeadroom returns how many bytes must remain free at the
+// destination once a backup has been written.
+//
+// Returns 1 GB if not specified, so the destination volume isn't filled to
+// the last byte; 0 or less only requires the backup itself to fit.
+func (bc *BackupConfig) GetFreeSpaceHeadroom() int64 {
+	if bc.FreeSpaceHeadroomMB == nil {
+		return 1024 * 1024 * 1024
+	}
+	return int64(max(*bc.FreeSpaceHeadroomMB, 0)) * 1024 * 1024
+}
+
+// GetMinFreeSpace returns how many bytes old snapshots are deleted to keep
+// free at the destination.
+//
+// Returns 0 (no minimum) if not specified or set to 0 or less.
+func (bc *BackupConfig) GetMinFreeSpace() int64 {
+	if bc.MinFreeSpaceGB == nil {
+		return 0
+	}
+	return int64(max(*bc.MinFreeSpaceGB, 0)) * 1024 * 1024 * 1024
+}
+
+// GetMaxTotalSize returns how many bytes all snapshots of the config may use
+// together before rotation deletes the oldest.
+//
+// Returns 0 (no cap) if not specified or set to 0 or less.
+func (bc *BackupConfig) GetMaxTotalSize() int64 {
+	if bc.MaxTotalSizeGB == nil {
+		return 0
+	}
+	return int64(max(*bc.MaxTotalSizeGB, 0)) * 1024 * 1024 * 1024
+}
+
+// GetNotifyChannels returns the notification channels for an event.
+//
+// An event listed in notify uses exactly the channels given, so an empty list
+// silences it. Unlisted events fall back to defaultNotifyChannels: problems
+// raise a toast, routine outcomes (success, skip) stay quiet. A repeated
+// failure is also e-mailed by default once a mail server is configured.
+func (bc *BackupConfig) GetNotifyChannels(event string) []string {
+	if channels, exists := bc.Notify[event]; exists {
+		return channels
+	}
+	if event == EventRepeatedFailure && currentSettings().SMTP != nil {
+		return append(slices.Clone(defaultNotifyChannels[event]), ChannelEmail)
+	}
+	return defaultNotifyChannels[event]
+}
+
 // saveConfig writes the configuration structure to config.json with pretty formatting.
 //
 // Uses JSON indentation for human readability since users will likely need to
@@ -171,12 +476,42 @@ func saveConfig(config *Config) error {
 	return os.WriteFile("config.json", data, 0644)
 }
 
+// setConfigEnabled turns a configuration on or off in config.json.
+//
+// A running instance starts or stops the config once its watcher reloads
+// the file (see reload.go).
+func setConfigEnabled(name string, enabled bool, iface string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	previous := *config
+	previous.Backups = append([]BackupConfig(nil), config.Backups...)
+	found := false
+	for i := range config.Backups {
+		if config.Backups[i].Name == name {
+			config.Backups[i].Enabled = &enabled
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no backup configuration named %q", name)
+	}
+	if err := saveConfig(config); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	auditLog.recordConfigUpdate(iface, &previous, config)
+	return nil
+}
+
 // validatePaths normalizes and validates all configured file paths.
 //
 // This preprocessing step is critical for preventing common user configuration errors:
 // 1. Converts relative paths to absolute paths for consistent operation
 // 2. Normalizes path separators and removes redundant elements (., ..)
 // 3. Ensures path resolution happens at startup, not during backup operations
+// 4. Rejects unknown exclude presets, malformed exclude patterns and
+//    bandwidth windows with unreadable times
 //
 // The validation runs before any backup schedulers start to fail fast on
 // configuration errors rather than discovering them during backup attempts.
@@ -193,11 +528,59 @@ func validatePaths(config *Config) error {
 		config.Backups[i].Source = filepath.Clean(absSource)
 		
 		// Convert destination path to absolute and normalize  
-		absDestination, err := filepath.Abs(backup.Destination)
-		if err != nil {
-			return err
+		if isRemoteDestination(backup.Destination) {
+			if err := validateRemoteDestination(backup); err != nil {
+				return fmt.Errorf("%s: %v", backup.Name, err)
+			}
+		} else {
+			absDestination, err := filepath.Abs(backup.Destination)
+			if err != nil {
+				return err
+			}
+			config.Backups[i].Destination = filepath.Clean(absDestination)
+		}
+		if backup.IsEncrypted() {
+			if err := validateEncryption(backup); err != nil {
+				return fmt.Errorf("%s: %v", backup.Name, err)
+			}
+		}
+		
+		// Exclusions are path patterns too; a typo would otherwise silently back up everything
+		if _, err := excludeMatcherFor(backup); err != nil {
+			return fmt.Errorf("%s: %v", backup.Name, err)
+		}
+		if err := validateBandwidthWindows(backup.BandwidthLimits); err != nil {
+			return fmt.Errorf("%s: %v", backup.Name, err)
+		}
+		if backup.MaxMBPerSecond < 0 {
+			return fmt.Errorf("%s: max_mb_per_second must not be negative", backup.Name)
+		}
+		if err := validateGFSRetention(backup); err != nil {
+			return fmt.Errorf("%s: %v", backup.Name, err)
+		}
+		if err := validateVerifyOnly(backup); err != nil {
+			return fmt.Errorf("%s: %v", backup.Name, err)
+		}
+		if err := validateTrigger(backup); err != nil {
+			return fmt.Errorf("%s: %v", backup.Name, err)
+		}
+		if err := validateCriticalFiles(backup); err != nil {
+			return fmt.Errorf("%s: %v", backup.Name, err)
+		}
+		
+		// The replica must be somewhere else, or it protects against nothing
+		if backup.Replica != nil {
+			absReplica, err := filepath.Abs(backup.Replica.Destination)
+			if err != nil {
+				return err
+			}
+			replica := *backup.Replica
+			replica.Destination = filepath.Clean(absReplica)
+			if backup.Replica.Destination == "" || pathsOverlap(replica.Destination, config.Backups[i].Destination) || pathsOverlap(replica.Destination, config.Backups[i].Source) {
+				return fmt.Errorf("%s: the replica destination must be a separate folder outside the source and destination", backup.Name)
+			}
+			config.Backups[i].Replica = &replica
 		}
-		config.Backups[i].Destination = filepath.Clean(absDestination)
 	}
 	return nil
 }
\ No newline at end of file
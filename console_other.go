@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// attachConsole is a no-op: command-line programs always inherit the terminal.
+func attachConsole() {}
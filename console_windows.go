@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// ATTACH_PARENT_PROCESS attaches to the console of the process that started us
+const ATTACH_PARENT_PROCESS = ^uintptr(0) // (DWORD)-1
+
+var procAttachConsole = kernel32.NewProc("AttachConsole")
+
+// attachConsole routes standard output to the console of the calling shell.
+//
+// The release build is linked as a GUI application (-H=windowsgui), so it
+// starts without a console and anything printed is lost. Attaching to the
+// parent console makes subcommand output appear in cmd.exe or PowerShell.
+// When started without a parent console (e.g. from Explorer) this is a no-op.
+func attachConsole() {
+	ret, _, _ := procAttachConsole.Call(ATTACH_PARENT_PROCESS)
+	if ret == 0 {
+		return
+	}
+
+	if out, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0); err == nil {
+		os.Stdout = out
+		os.Stderr = out
+	}
+}
@@ -0,0 +1,212 @@
+// Package main - contextmenu.go handles folder requests from the Explorer context menu.
+//
+// Right-clicking a folder offers two entries (registered by the
+// "context-menu install" command, see contextmenu_windows.go):
+//
+// - "Back up now with SimpleFolderBackup" runs every config whose source is
+//    that folder
+// - "Add to SimpleFolderBackup" creates a config for the folder and starts
+//    its scheduler without restarting the application
+//
+// Each entry starts a short-lived copy of the executable that forwards the
+// request to the tray instance over IPC (see ipc.go); the work happens here,
+// in the instance that owns the schedulers and config.json.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Schedule of configs added from the context menu or by import, matching the example config
+const (
+	addedScheduleMinutes = 30
+	addedRotationCount   = 5
+)
+
+// contextMenuEntry describes one Explorer context-menu verb.
+type contextMenuEntry struct {
+	key     string // Registry verb name
+	title   string // Menu text
+	command string // Subcommand the verb runs with the folder path
+}
+
+// contextMenuEntries lists the verbs added to folders' context menus
+var contextMenuEntries = []contextMenuEntry{
+	{key: "SimpleFolderBackup.BackupNow", title: "Back up now with SimpleFolderBackup", command: IPCActionBackupFolder},
+	{key: "SimpleFolderBackup.Add", title: "Add to SimpleFolderBackup", command: IPCActionAddFolder},
+}
+
+// folderRequestHandler answers context-menu requests in the tray instance.
+type folderRequestHandler struct {
+	mu       sync.Mutex                // Serializes edits of config.json
+	activate func(config BackupConfig) // Starts the scheduler and menu of a new config
+}
+
+// newFolderRequestHandler returns the IPC handler of the tray instance.
+func newFolderRequestHandler(activate func(config BackupConfig)) ipcHandler {
+	h := &folderRequestHandler{activate: activate}
+	return h.handle
+}
+
+// handle dispatches one request.
+func (h *folderRequestHandler) handle(req ipcRequest) ipcResponse {
+	switch req.Action {
+	case IPCActionStateTask:
+		return handleStateTaskRequest(req)
+	case IPCActionBackup:
+		return handleBackupRequest(req)
+	case IPCActionStatus:
+		return ipcResponse{OK: true, Statuses: backupStatus.configStatuses(), Engine: headless,
+			Paused: allBackupsPaused(), PausedUntil: allPausedUntil()}
+	case IPCActionToastAction:
+		return handleToastActionRequest(req)
+	case IPCActionPauseAll, IPCActionResumeAll:
+		return handlePauseAllRequest(req)
+	}
+	if !filepath.IsAbs(req.Path) {
+		return ipcResponse{Message: fmt.Sprintf("Not an absolute folder path: %q", req.Path)}
+	}
+	if err := ensureWritable(AuditInterfaceExplorer, req.Action, ""); err != nil {
+		return ipcResponse{Message: err.Error()}
+	}
+	switch req.Action {
+	case IPCActionBackupFolder:
+		return h.backupFolder(filepath.Clean(req.Path))
+	case IPCActionAddFolder:
+		return h.addFolder(filepath.Clean(req.Path))
+	default:
+		return ipcResponse{Message: fmt.Sprintf("Unknown request %q", req.Action)}
+	}
+}
+
+// backupFolder starts a backup of every active config whose source is folder.
+func (h *folderRequestHandler) backupFolder(folder string) ipcResponse {
+	var started []string
+	for _, config := range backupRunner.registeredConfigs() {
+		if !sameFolder(config.Source, folder) {
+			continue
+		}
+		auditLog.record(AuditInterfaceExplorer, "backup-now", config.Name, "")
+		go backupRunner.runByName(config.Name)
+		started = append(started, config.Name)
+	}
+
+	if len(started) == 0 {
+		return ipcResponse{Message: fmt.Sprintf("No backup is set up for %s.\n\nUse \"Add to SimpleFolderBackup\" to create one.", folder)}
+	}
+	message := fmt.Sprintf("Backing up %s", joinNames(started))
+	notifyUser("Backup started", message)
+	return ipcResponse{OK: true, Message: message}
+}
+
+// addFolder creates a config for folder, saves it and starts it.
+func (h *folderRequestHandler) addFolder(folder string) ipcResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+		return ipcResponse{Message: fmt.Sprintf("%s is not a folder", folder)}
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return ipcResponse{Message: fmt.Sprintf("Failed to load config.json: %v", err)}
+	}
+	for _, existing := range config.Backups {
+		if sameFolder(existing.Source, folder) {
+			return ipcResponse{Message: fmt.Sprintf("%s is already backed up by %q", folder, existing.Name)}
+		}
+	}
+
+	parent := defaultDestinationParent(config)
+	if parent == "" {
+		return ipcResponse{Message: "No destination for new backups is known yet.\n\nSet \"default_destination\" in the settings section of config.json."}
+	}
+
+	name := uniqueConfigName(config, filepath.Base(folder))
+	added := BackupConfig{
+		Name:            name,
+		Source:          folder,
+		Destination:     filepath.Join(parent, sanitizeConfigName(name)),
+		ScheduleMinutes: addedScheduleMinutes,
+		RotationCount:   addedRotationCount,
+	}
+
+	previous := *config
+	previous.Backups = append([]BackupConfig(nil), config.Backups...)
+	config.Backups = append(config.Backups, added)
+	if err := saveConfig(config); err != nil {
+		return ipcResponse{Message: fmt.Sprintf("Failed to save config.json: %v", err)}
+	}
+	auditLog.recordConfigUpdate(AuditInterfaceExplorer, &previous, config)
+
+	if err := validatePaths(config); err != nil {
+		return ipcResponse{Message: fmt.Sprintf("Saved, but the paths are invalid: %v", err)}
+	}
+	registerLogPaths(config)
+	warnings := registerSourceOverlaps(config)
+	h.activate(config.Backups[len(config.Backups)-1])
+
+	message := fmt.Sprintf("Added %q: backed up every %d minutes to %s", name, added.ScheduleMinutes, added.Destination)
+	if len(warnings) > 0 {
+		message += "\n\nWarning: " + strings.Join(warnings, "\nWarning: ")
+	}
+	notifyUser("Backup added", message)
+	return ipcResponse{OK: true, Message: message}
+}
+
+// defaultDestinationParent returns the folder new configs' destinations are created in.
+//
+// Falls back to the parent of the first config's destination, which is where
+// most users keep all of their backups.
+func defaultDestinationParent(config *Config) string {
+	if config.Settings.DefaultDestination != "" {
+		return config.Settings.DefaultDestination
+	}
+	if len(config.Backups) > 0 && config.Backups[0].Destination != "" {
+		return filepath.Dir(filepath.Clean(config.Backups[0].Destination))
+	}
+	return ""
+}
+
+// uniqueConfigName returns base, or base with a number appended if the name is taken.
+func uniqueConfigName(config *Config, base string) string {
+	taken := make(map[string]bool)
+	for _, backup := range config.Backups {
+		taken[backup.Name] = true
+	}
+	name := base
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s %d", base, i)
+	}
+	return name
+}
+
+// sameFolder reports whether two paths refer to the same directory.
+//
+// Compared by file identity, so differences in case or short names on
+// Windows don't matter.
+func sameFolder(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// joinNames lists config names for messages.
+func joinNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// errContextMenuUnsupported is returned on platforms without an Explorer integration
+var errContextMenuUnsupported = errors.New("the folder context menu is only available on Windows")
+
+// installContextMenu is only supported on Windows.
+func installContextMenu() error {
+	return errContextMenuUnsupported
+}
+
+// uninstallContextMenu is only supported on Windows.
+func uninstallContextMenu() error {
+	return errContextMenuUnsupported
+}
@@ -0,0 +1,53 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// contextMenuShellKey holds the per-user folder verbs; HKCU needs no administrator rights
+const contextMenuShellKey = `Software\Classes\Directory\shell\`
+
+// installContextMenu adds the folder verbs for the current user.
+//
+// The verbs run this executable by absolute path, so it should be installed
+// from where it will stay.
+func installContextMenu() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range contextMenuEntries {
+		key := contextMenuShellKey + entry.key
+		if err := regSetString(HKEY_CURRENT_USER, key, "", entry.title); err != nil {
+			return err
+		}
+		if err := regSetString(HKEY_CURRENT_USER, key, "Icon", exe+",0"); err != nil {
+			return err
+		}
+		// %V is the folder that was right-clicked
+		command := fmt.Sprintf(`"%s"%s %s "%%V"`, exe, registeredDataDirArgs(), entry.command)
+		if err := regSetString(HKEY_CURRENT_USER, key+`\command`, "", command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uninstallContextMenu removes the folder verbs of the current user.
+func uninstallContextMenu() error {
+	for _, entry := range contextMenuEntries {
+		if err := regDeleteTree(HKEY_CURRENT_USER, contextMenuShellKey+entry.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
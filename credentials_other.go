@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// readCredential returns a secret from the desktop keyring via secret-tool.
+//
+// Secrets are stored with e.g. `secret-tool store --label=MyDatabase service SimpleFolderBackup name MyDatabase`.
+func readCredential(target string) (string, error) {
+	output, err := exec.Command("secret-tool", "lookup", "service", "SimpleFolderBackup", "name", target).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret-tool lookup failed: %v", err)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
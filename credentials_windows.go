@@ -0,0 +1,56 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// CRED_TYPE_GENERIC identifies credentials stored with "cmdkey /generic:" or Credential Manager
+const CRED_TYPE_GENERIC = 1
+
+var (
+	procCredReadW = advapi32.NewProc("CredReadW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+// credential mirrors the leading fields of the Win32 CREDENTIALW structure
+type credential struct {
+	flags              uint32
+	credType           uint32
+	targetName         *uint16
+	comment            *uint16
+	lastWritten        syscall.Filetime
+	credentialBlobSize uint32
+	credentialBlob     *byte
+}
+
+// readCredential returns the secret of a generic credential from Windows Credential Manager.
+//
+// Credentials are created with e.g. `cmdkey /generic:MyDatabase /user:backup /pass`.
+// The password is stored as UTF-16 by cmdkey and the Credential Manager UI.
+func readCredential(target string) (string, error) {
+	targetPtr, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(targetPtr)), CRED_TYPE_GENERIC, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		return "", fmt.Errorf("not found in Credential Manager: %v", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.credentialBlob, cred.credentialBlobSize)
+	if len(blob)%2 != 0 {
+		return string(blob), nil // Written by a tool that stores plain bytes
+	}
+	chars := make([]uint16, len(blob)/2)
+	for i := range chars {
+		chars[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return string(utf16.Decode(chars)), nil
+}
@@ -0,0 +1,167 @@
+// Package main - criticalfiles.go alerts within minutes when a key file disappears.
+//
+// An accidentally deleted or truncated file is usually discovered when it is
+// needed, by which time rotation may have deleted every snapshot that still
+// had it. Files listed in "critical_files" are checked every
+// criticalCheckInterval, independently of the backup schedule, and a file
+// that went missing or shrank below criticalShrinkRatio of its size raises a
+// critical_file notification and a tray alert right away.
+//
+// A missing file alerts until it is back. A shrunk file alerts until it grows
+// back or the next snapshot is taken: a snapshot means the job has backed up
+// the smaller file, so its size becomes the new reference, while the
+// snapshots before it still hold the larger version.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// criticalCheckInterval is how often critical files are checked
+const criticalCheckInterval = time.Minute
+
+// criticalShrinkRatio is the share of its previous size below which a critical file counts as shrunk
+const criticalShrinkRatio = 0.5
+
+// criticalFileState is what the last check found for one critical file
+type criticalFileState struct {
+	size    int64 // Reference size; kept from before a shrink until it's accepted
+	missing bool
+	shrunk  bool
+}
+
+// criticalFiles holds the state of each config's critical files by relative path
+var (
+	criticalFilesMu sync.Mutex
+	criticalFiles   = make(map[string]map[string]*criticalFileState)
+)
+
+// validateCriticalFiles checks that critical files are given relative to the source.
+func validateCriticalFiles(config BackupConfig) error {
+	for _, rel := range config.CriticalFiles {
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return fmt.Errorf("critical file %q must be a path inside the source folder", rel)
+		}
+	}
+	return nil
+}
+
+// checkCriticalFiles checks a config's critical files and updates its alert.
+func checkCriticalFiles(config BackupConfig, logger *log.Logger) {
+	if len(config.CriticalFiles) == 0 {
+		return
+	}
+	if _, err := os.Stat(config.Source); err != nil {
+		return // A missing source is reported by checkSourceAvailable, not once per file
+	}
+
+	criticalFilesMu.Lock()
+	states := criticalFiles[config.Name]
+	if states == nil {
+		states = make(map[string]*criticalFileState)
+		criticalFiles[config.Name] = states
+	}
+	previous := criticalProblems(states)
+	var found []string
+	for _, rel := range config.CriticalFiles {
+		state := states[rel]
+		if state == nil {
+			state = &criticalFileState{}
+			states[rel] = state
+		}
+		info, err := os.Stat(filepath.Join(config.Source, filepath.FromSlash(rel)))
+		switch {
+		case err != nil:
+			if !state.missing {
+				found = append(found, fmt.Sprintf("%s is missing", rel))
+			}
+			state.missing, state.shrunk = true, false
+		case state.size > 0 && float64(info.Size()) < float64(state.size)*criticalShrinkRatio:
+			state.missing = false
+			if !state.shrunk {
+				found = append(found, fmt.Sprintf("%s shrank from %s to %s", rel, formatSize(state.size), formatSize(info.Size())))
+			}
+			state.shrunk = true
+		default:
+			state.size, state.missing, state.shrunk = info.Size(), false, false
+		}
+	}
+	problems := criticalProblems(states)
+	criticalFilesMu.Unlock()
+
+	for _, problem := range found {
+		logger.Printf("Critical file of %s: %s", config.Name, problem)
+		notifyEvent(config, EventCriticalFile, "Critical file changed: "+config.Name,
+			fmt.Sprintf("%s in %s. Earlier snapshots still hold the previous version.", problem, config.Source))
+	}
+	if problems != previous {
+		updateCriticalAlert(config, problems, logger)
+	}
+}
+
+// updateCriticalAlert shows the critical file problems of a config in the tray.
+func updateCriticalAlert(config BackupConfig, problems string, logger *log.Logger) {
+	if problems == "" {
+		logger.Printf("Critical files of %s are back to normal", config.Name)
+		backupStatus.clearAlert(config.Name)
+	} else {
+		backupStatus.setAlert(config.Name, problems)
+	}
+	requestStatusUpdate()
+}
+
+// criticalProblems renders the alert for a config's critical files, "" if all are fine.
+func criticalProblems(states map[string]*criticalFileState) string {
+	var missing, shrunk []string
+	for rel, state := range states {
+		if state.missing {
+			missing = append(missing, rel)
+		} else if state.shrunk {
+			shrunk = append(shrunk, rel)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(shrunk)
+	var parts []string
+	if len(missing) > 0 {
+		parts = append(parts, "critical file missing: "+strings.Join(missing, ", "))
+	}
+	if len(shrunk) > 0 {
+		parts = append(parts, "critical file shrank: "+strings.Join(shrunk, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// acceptCriticalFiles makes the current sizes of a config's shrunk critical
+// files their new reference, after a snapshot has backed them up.
+func acceptCriticalFiles(config BackupConfig, logger *log.Logger) {
+	criticalFilesMu.Lock()
+	states := criticalFiles[config.Name]
+	accepted := false
+	for _, state := range states {
+		if state.shrunk {
+			state.size, state.shrunk = 0, false
+			accepted = true
+		}
+	}
+	problems := criticalProblems(states)
+	criticalFilesMu.Unlock()
+	if accepted {
+		updateCriticalAlert(config, problems, logger)
+		checkCriticalFiles(config, logger) // Records the new reference sizes
+	}
+}
+
+// forgetCriticalFiles drops the critical file state of a config stopped by a reload.
+func forgetCriticalFiles(name string) {
+	criticalFilesMu.Lock()
+	defer criticalFilesMu.Unlock()
+	delete(criticalFiles, name)
+}
@@ -0,0 +1,438 @@
+// Package main - dashboard.go serves a status dashboard in the browser.
+//
+// The tray has room for two status lines and one submenu per config, which
+// doesn't scale to a dozen jobs. With "dashboard_port" set, the tray
+// application serves a page on http://127.0.0.1:<port> that shows every job
+// at once: state, last and next backup, alerts, recent runs and snapshots and
+// the end of today's log, with buttons to back up now, pause and resume.
+// Snapshots can be browsed and restored from (see snapshotbrowser.go).
+//
+// Key design decisions:
+//
+// 1. Localhost only: The server listens on the loopback interface, and
+//    requests whose Host header isn't localhost are refused, so a web page
+//    can't reach it through DNS rebinding.
+//
+// 2. Pages need the user's key: Every account on the machine can reach the
+//    loopback interface, so each start generates a key and writes it, with a
+//    login page that posts it, into the data folder, readable only by the
+//    user. "Open dashboard" in the tray opens that page, which exchanges the
+//    key for a cookie; requests without the cookie (or the key as a bearer
+//    token, for scripts) are refused. The key never appears in a URL or a
+//    command line, where other users could see it.
+//
+// 3. Actions need a token: Buttons post a random token issued with the page,
+//    so another site open in the same browser can't start or pause backups
+//    with a cross-site form.
+//
+// 4. The same rules as the tray: Actions are recorded in the audit log, and
+//    "Backup now" is refused in read-only mode.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How much history and log the dashboard shows per config
+const (
+	dashboardSnapshots = 5
+	dashboardRuns      = 10
+	dashboardLogLines  = 30
+)
+
+// dashboardLogTailBytes bounds how much of a log file is read for its last lines
+const dashboardLogTailBytes = 64 * 1024
+
+// Actions the dashboard's buttons post
+const (
+	DashboardActionBackup = "backup" // Back up now
+	DashboardActionPause  = "pause"  // Pause until resumed
+	DashboardActionResume = "resume" // Resume a paused config
+)
+
+// Files in the data folder that let the current user open the dashboard
+const (
+	dashboardKeyPath   = "dashboard.key"  // Key for scripts, sent as "Authorization: Bearer <key>"
+	dashboardLoginPath = "dashboard.html" // Page that logs the browser in; opened by "Open dashboard"
+)
+
+// dashboardCookie carries the key once the browser has logged in
+const dashboardCookie = "sfb_dashboard"
+
+// dashboard serves the status page of the running application.
+type dashboard struct {
+	port  int
+	key   string // Must accompany every request, as cookie or bearer token
+	token string // Must accompany every action
+}
+
+// dashboardConfig is everything the page shows about one config.
+type dashboardConfig struct {
+	Status     ConfigStatus
+	Last30Days string // Share of successful runs, e.g. "97% ok (29 of 30 runs)"
+	Paused     bool
+	Snapshots  []Snapshot
+	Runs       []RunRecord // Newest first
+	Log        string      // End of today's log
+}
+
+// startDashboard serves the dashboard on the loopback interface until ctx ends.
+func startDashboard(ctx context.Context, port int) {
+	key, err := randomHex(32)
+	if err != nil {
+		log.Printf("Dashboard not started: %v", err)
+		return
+	}
+	token, err := randomHex(16)
+	if err != nil {
+		log.Printf("Dashboard not started: %v", err)
+		return
+	}
+	d := &dashboard{port: port, key: key, token: token}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		log.Printf("Dashboard not started: %v", err)
+		return
+	}
+	if err := d.writeLoginFiles(); err != nil {
+		listener.Close()
+		log.Printf("Dashboard not started: %v", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", d.servePage)
+	mux.HandleFunc("GET /status.json", d.serveStatus)
+	mux.HandleFunc("POST /action", d.serveAction)
+	mux.HandleFunc("GET /browse", d.serveBrowse)
+	mux.HandleFunc("GET /file", d.serveFile)
+	mux.HandleFunc("POST /restore", d.serveRestore)
+	root := http.NewServeMux()
+	root.HandleFunc("POST /login", d.serveLogin)
+	root.Handle("/", d.loggedIn(mux))
+	server := &http.Server{Handler: d.localOnly(root), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+		os.Remove(dashboardLoginPath)
+		os.Remove(dashboardKeyPath)
+	}()
+	go func() {
+		log.Printf("Dashboard at http://127.0.0.1:%d", port)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Dashboard stopped: %v", err)
+		}
+	}()
+}
+
+// openDashboardFromTray opens the login page of the running dashboard, of
+// this process or of the engine a tray client is attached to, in the browser.
+func openDashboardFromTray() {
+	path, err := filepath.Abs(dashboardLoginPath)
+	if err == nil {
+		_, err = os.Stat(path)
+	}
+	if err != nil {
+		showMessageBox("SimpleFolderBackup", "The dashboard is not running.\n\nSet \"dashboard_port\" in the settings of config.json and restart the application.")
+		return
+	}
+	if err := openDocument(path); err != nil {
+		log.Printf("Failed to open the dashboard: %v", err)
+	}
+}
+
+// localOnly refuses requests addressed to any host but this machine.
+func (d *dashboard) localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, port, err := net.SplitHostPort(r.Host)
+		if err != nil || port != strconv.Itoa(d.port) || (host != "127.0.0.1" && host != "localhost") {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeLoginFiles writes the key and the login page into the data folder,
+// readable only by the current user.
+func (d *dashboard) writeLoginFiles() error {
+	var page bytes.Buffer
+	err := dashboardLoginTemplate.Execute(&page, map[string]any{
+		"Login": fmt.Sprintf("http://127.0.0.1:%d/login", d.port),
+		"Key":   d.key,
+	})
+	if err != nil {
+		return err
+	}
+	for path, data := range map[string][]byte{dashboardKeyPath: []byte(d.key + "\n"), dashboardLoginPath: page.Bytes()} {
+		// Removed first, so a file another user created can't keep its permissions
+		os.Remove(path)
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loggedIn refuses requests that carry neither the login cookie nor the key
+// as a bearer token.
+func (d *dashboard) loggedIn(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if cookie, err := r.Cookie(dashboardCookie); err == nil {
+			presented = cookie.Value
+		}
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(d.key)) != 1 {
+			http.Error(w, "Not logged in. Open the dashboard with \"Open dashboard\" in the tray menu, or open "+dashboardLoginPath+" in the data folder.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveLogin exchanges the key posted by the login page for a cookie.
+//
+// The answer moves on to the dashboard with a page rather than a redirect:
+// the login page is a local file, and a strict cookie isn't sent along a
+// redirect that started on another site.
+func (d *dashboard) serveLogin(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("key")), []byte(d.key)) != 1 {
+		http.Error(w, "Invalid key. Open the dashboard again from the tray menu.", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     dashboardCookie,
+		Value:    d.key,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(`<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0; url=/"></head><body><a href="/">Dashboard</a></body></html>`))
+}
+
+// servePage renders the dashboard.
+func (d *dashboard) servePage(w http.ResponseWriter, r *http.Request) {
+	registered := make(map[string]BackupConfig)
+	for _, config := range backupRunner.registeredConfigs() {
+		registered[config.Name] = config
+	}
+
+	var configs []dashboardConfig
+	for _, status := range backupStatus.configStatuses() {
+		entry := dashboardConfig{Status: status, Last30Days: status.Last30Days.describe(), Paused: isPaused(status.Name)}
+		if config, active := registered[status.Name]; active {
+			if snapshots, err := listSnapshots(config); err == nil {
+				entry.Snapshots = snapshots[:min(len(snapshots), dashboardSnapshots)]
+			}
+		}
+		if runs, err := loadRunHistory(status.Name); err == nil {
+			slices.Reverse(runs)
+			entry.Runs = runs[:min(len(runs), dashboardRuns)]
+		}
+		entry.Log = tailLogFile(getTodayLogPath(filepath.Join("logs", sanitizeConfigName(status.Name)), "backup"), dashboardLogLines)
+		configs = append(configs, entry)
+	}
+
+	var page bytes.Buffer
+	err := dashboardTemplate.Execute(&page, map[string]any{
+		"Configs":   configs,
+		"Token":     d.token,
+		"ReadOnly":  currentSettings().ReadOnly,
+		"AllPaused": allBackupsPaused(),
+		"Next":      backupStatus.getNextBackupStatus(),
+		"Now":       time.Now(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
+
+// serveStatus returns the status of every config as JSON, like "status --json".
+func (d *dashboard) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statusReport{Running: true, Configs: backupStatus.configStatuses()})
+}
+
+// serveAction carries out a button of the dashboard and returns to the page.
+func (d *dashboard) serveAction(w http.ResponseWriter, r *http.Request) {
+	if !d.validToken(w, r) {
+		return
+	}
+	name := r.FormValue("config")
+	switch r.FormValue("action") {
+	case DashboardActionBackup:
+		if err := ensureWritable(AuditInterfaceDashboard, "backup-now", name); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		auditLog.record(AuditInterfaceDashboard, "backup-now", name, "")
+		go func() {
+			if err := backupRunner.runByName(name); err != nil {
+				log.Printf("Backup now %s: %v", name, err)
+			}
+		}()
+	case DashboardActionPause:
+		if err := ensureWritable(AuditInterfaceDashboard, "pause", name); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if pauseConfig(name) {
+			auditLog.record(AuditInterfaceDashboard, "pause", name, "")
+		}
+	case DashboardActionResume:
+		if err := ensureWritable(AuditInterfaceDashboard, "resume", name); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if resumeConfig(name) {
+			auditLog.record(AuditInterfaceDashboard, "resume", name, "")
+		}
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// validToken checks the token posted with an action, refusing the request
+// if it is missing or wrong.
+func (d *dashboard) validToken(w http.ResponseWriter, r *http.Request) bool {
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(d.token)) != 1 {
+		http.Error(w, "invalid or expired page, reload the dashboard", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// tailLogFile returns the last lines of a log file, "" if it doesn't exist.
+func tailLogFile(path string, lines int) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() > dashboardLogTailBytes {
+		file.Seek(info.Size()-dashboardLogTailBytes, io.SeekStart)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return ""
+	}
+	all := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	return strings.Join(all[max(len(all)-lines, 0):], "\n")
+}
+
+// dashboardLoginTemplate is the page in the data folder that posts the key to
+// the dashboard, logging the browser in.
+var dashboardLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>SimpleFolderBackup</title>
+</head>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.Login}}">
+<input type="hidden" name="key" value="{{.Key}}">
+<noscript><button>Open the dashboard</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// dashboardTemplate is the dashboard page; it reloads itself every 30 seconds.
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"time": formatDisplayTime,
+	"age":  func(t, now time.Time) string { return formatAge(now.Sub(t)) },
+	"size": formatSize,
+	"took": func(d time.Duration) time.Duration { return d.Round(time.Second) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>SimpleFolderBackup</title>
+{{template "style"}}
+</head>
+{{define "style"}}<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+section { border: 1px solid #ccc; border-radius: 6px; padding: 0.5em 1em; margin-bottom: 1em; }
+h2 { margin: 0.3em 0; font-size: 1.2em; }
+.state { font-weight: normal; color: #666; }
+.alert { color: #b00; }
+table { border-collapse: collapse; }
+td { padding: 0.1em 1em 0.1em 0; vertical-align: top; }
+pre { background: #f6f6f6; padding: 0.5em; max-height: 20em; overflow: auto; font-size: 0.85em; }
+form { display: inline; }
+.done { color: #060; }
+</style>{{end}}
+<body>
+<h1>SimpleFolderBackup</h1>
+<p>{{.Next}}{{if .AllPaused}} (all backups paused){{end}}{{if .ReadOnly}} - read-only mode{{end}}</p>
+{{$token := .Token}}{{$readOnly := .ReadOnly}}{{$now := .Now}}
+{{range .Configs}}{{$name := .Status.Name}}
+<section>
+<h2>{{$name}} <span class="state">{{.Status.State}}{{if not .Status.PausedUntil.IsZero}} until {{time .Status.PausedUntil}}{{end}}</span></h2>
+{{with .Status.Alert}}<p class="alert">&#9888; {{.}}</p>{{end}}
+<table>
+<tr><td>Last run</td><td>{{if .Status.LastRun.IsZero}}never{{else}}{{time .Status.LastRun}} ({{age .Status.LastRun $now}}), {{.Status.LastResult}}{{end}}</td></tr>
+{{if .Status.LastError}}<tr><td>Last error</td><td>[{{.Status.LastErrorCode}}] {{.Status.LastError}}</td></tr>{{end}}
+<tr><td>Next run</td><td>{{if .Status.NextRun.IsZero}}-{{else}}{{time .Status.NextRun}}{{end}}</td></tr>
+<tr><td>Last 30 days</td><td>{{.Last30Days}}</td></tr>
+{{if .Snapshots}}<tr><td>Snapshots</td><td>{{range .Snapshots}}<a href="/browse?config={{$name}}&amp;snapshot={{.Name}}">{{time .Time}}</a>{{with .Note}} - {{.}}{{end}}<br>{{end}}<a href="/browse?config={{$name}}">All snapshots...</a></td></tr>{{end}}
+</table>
+<p>
+{{if not $readOnly}}<form method="post" action="/action"><input type="hidden" name="token" value="{{$token}}"><input type="hidden" name="config" value="{{$name}}"><button name="action" value="backup">Backup now</button></form>{{end}}
+{{if $readOnly}}{{else if .Paused}}<form method="post" action="/action"><input type="hidden" name="token" value="{{$token}}"><input type="hidden" name="config" value="{{$name}}"><button name="action" value="resume">Resume</button></form>
+{{else}}<form method="post" action="/action"><input type="hidden" name="token" value="{{$token}}"><input type="hidden" name="config" value="{{$name}}"><button name="action" value="pause">Pause</button></form>{{end}}
+</p>
+{{with .Runs}}<details><summary>Recent runs</summary><table>
+{{range .}}<tr><td>{{time .Start}}</td><td>{{.Result}}</td><td>{{took .Duration}}</td><td>{{if .Bytes}}{{size .Bytes}}{{if .Files}} in {{.Files}} files{{end}}{{end}}</td><td>{{if .Error}}<span class="alert">{{.Error}}</span>{{else}}{{.Reason}}{{end}}</td></tr>
+{{end}}</table></details>{{end}}
+{{with .Log}}<details><summary>Today's log</summary><pre>{{.}}</pre></details>{{end}}
+</section>
+{{else}}
+<p>No backup configurations are running.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -43,14 +43,16 @@ import (
 // - LastHash: Cryptographic hash of directory content for change detection
 // - LastActionType: "backup" or "skipped" to distinguish action types
 // - LastActionTime: When the action occurred for scheduling calculations
+// - LastChangeTime: When the content hash last differed from the previous one
 //
 // The action type distinction is crucial because it enables the scheduler to
 // make intelligent decisions about timing based on when content was last checked
 // rather than just when backups were last performed.
 type HashStatus struct {
-	LastHash       string    `json:"lastHash"`       // Directory content hash
-	LastActionType string    `json:"lastActionType"` // "backup" or "skipped"
-	LastActionTime time.Time `json:"lastActionTime"` // When action occurred
+	LastHash       string    `json:"lastHash"`                 // Directory content hash
+	LastActionType string    `json:"lastActionType"`           // "backup" or "skipped"
+	LastActionTime time.Time `json:"lastActionTime"`           // When action occurred
+	LastChangeTime time.Time `json:"lastChangeTime,omitempty"` // When content last changed
 }
 
 // HashManager provides thread-safe management of hash-based backup state.
@@ -133,6 +135,41 @@ func (hm *HashManager) calculateDirectoryHash(dirPath string) (string, error) {
 	return dirhash.HashDir(dirPath, "", dirhash.Hash1)
 }
 
+// sourceHash computes the content hash of a config's source.
+//
+// Configs with warm_cache enabled reuse the previous cycle's listing and file
+// hashes; the result is identical to calculateDirectoryHash either way. Configs
+// with traversal limits or exclusions are hashed with the same walk used for
+// copying.
+func (hm *HashManager) sourceHash(config BackupConfig) (string, error) {
+	if config.GetWarmCacheMode() != WarmCacheOff {
+		return hashSourceWithCache(config)
+	}
+	if opts := walkOptionsFor(config); opts.bounded() {
+		_, hash, err := scanWithCache(config.Source, &treeCache{}, opts)
+		return hash, err
+	}
+	return hm.calculateDirectoryHash(config.Source)
+}
+
+// fileHashes returns the per-file content hashes of a config's source as of
+// its last warm cache scan, keyed by slash-separated relative path.
+//
+// Returns nil for configs without warm_cache. An entry is only current while
+// the file's size and mtime still match it; incremental backups use these to
+// link files whose mtime moved but whose content didn't.
+func (hm *HashManager) fileHashes(config BackupConfig) map[string]cachedFile {
+	if config.GetWarmCacheMode() == WarmCacheOff {
+		return nil
+	}
+	warmCachesMu.Lock()
+	defer warmCachesMu.Unlock()
+	if cache := warmCaches[config.Name]; cache != nil {
+		return cache.Files // Scans replace the cache rather than modify it, so this stays stable
+	}
+	return nil
+}
+
 // shouldSkipBackup determines if a backup should be skipped based on content hash comparison.
 //
 // This is the core intelligence of the backup optimization system. The decision process:
@@ -145,14 +182,14 @@ func (hm *HashManager) calculateDirectoryHash(dirPath string) (string, error) {
 // data protection over performance optimization.
 //
 // Thread safety: Uses read lock for hash lookup since we only need to read state.
-func (hm *HashManager) shouldSkipBackup(configName, sourcePath string) (bool, error) {
-	currentHash, err := hm.calculateDirectoryHash(sourcePath)
+func (hm *HashManager) shouldSkipBackup(config BackupConfig) (bool, error) {
+	currentHash, err := hm.sourceHash(config)
 	if err != nil {
 		return false, err
 	}
 
 	hm.mu.RLock()
-	lastStatus, exists := hm.hashes[configName]
+	lastStatus, exists := hm.hashes[config.Name]
 	hm.mu.RUnlock()
 
 	if !exists {
@@ -173,17 +210,28 @@ func (hm *HashManager) shouldSkipBackup(configName, sourcePath string) (bool, er
 //
 // Thread safety: Uses write lock since this modifies hash state, then persists
 // to disk for recovery across application restarts.
-func (hm *HashManager) recordAction(configName, sourcePath, actionType string) error {
-	currentHash, err := hm.calculateDirectoryHash(sourcePath)
+func (hm *HashManager) recordAction(config BackupConfig, actionType string) error {
+	currentHash, err := hm.sourceHash(config)
 	if err != nil {
 		return err
 	}
 
+	now := time.Now()
+	
 	hm.mu.Lock()
-	hm.hashes[configName] = HashStatus{
+	previous, exists := hm.hashes[config.Name]
+	lastChangeTime := previous.LastChangeTime
+	if !exists || previous.LastHash != currentHash {
+		lastChangeTime = now // Content differs from the last recorded state
+	} else if lastChangeTime.IsZero() {
+		// State from before change tracking: the last action is the best known bound
+		lastChangeTime = previous.LastActionTime
+	}
+	hm.hashes[config.Name] = HashStatus{
 		LastHash:       currentHash,
 		LastActionType: actionType,
-		LastActionTime: time.Now(),
+		LastActionTime: now,
+		LastChangeTime: lastChangeTime,
 	}
 	hm.mu.Unlock()
 
@@ -225,6 +273,45 @@ func (hm *HashManager) getLastActionTime(configName string) time.Time {
 	return time.Time{}
 }
 
+// getLastHash returns the content hash recorded by the last backup or skip of
+// a configuration, and whether there is one.
+func (hm *HashManager) getLastHash(configName string) (string, bool) {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
+	status, exists := hm.hashes[configName]
+	return status.LastHash, exists
+}
+
+// getLastChangeTime returns when the content of a backup configuration last changed.
+//
+// Used to detect sources that stopped receiving new data. Returns zero time if
+// no hash state exists yet.
+// Thread safety: Uses read lock for concurrent access.
+func (hm *HashManager) getLastChangeTime(configName string) time.Time {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
+	if status, exists := hm.hashes[configName]; exists {
+		return status.LastChangeTime
+	}
+	return time.Time{}
+}
+
+// reset forgets the change-detection state of a configuration, so its next
+// run backs up as if it had never run, and returns whether there was any.
+func (hm *HashManager) reset(configName string) (bool, error) {
+	hm.mu.Lock()
+	_, exists := hm.hashes[configName]
+	delete(hm.hashes, configName)
+	hm.mu.Unlock()
+
+	if !exists {
+		return false, nil
+	}
+	return true, hm.saveToFile()
+}
+
 // initHashManager initializes the global hash manager from persistent storage.
 //
 // Called once during application startup to restore hash state from previous sessions.
@@ -0,0 +1,101 @@
+// Package main - destprobe.go checks that backup destinations are writable.
+//
+// A destination that became read-only, lost its network credentials or
+// filled up is otherwise only noticed when a backup fails partway through,
+// possibly hours after the problem started. Each scheduler writes and
+// deletes a tiny probe file when it starts and every destinationProbeInterval,
+// and shows a failed probe as an alert on the config.
+//
+// A failed probe doesn't stop backups: the next backup still runs and may
+// well succeed once the drive or share is back.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// destinationProbeInterval is how often a scheduler probes its destination between backups
+const destinationProbeInterval = 15 * time.Minute
+
+// probeMinFreeBytes is the free space below which a destination counts as full
+const probeMinFreeBytes = 1024 * 1024
+
+// errDestinationFull is returned by probeDestination for a destination without free space
+var errDestinationFull = errors.New("destination is full")
+
+// probeFailures tracks configs whose last probe failed, so recovery is
+// logged and the alert cleared only once
+var (
+	probeFailuresMu sync.Mutex
+	probeFailures   = make(map[string]bool)
+)
+
+// probeDestination writes, syncs and removes a small file in dir.
+func probeDestination(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create destination: %v", err)
+	}
+	if free, total, err := diskUsage(dir); err == nil && total > 0 && free < probeMinFreeBytes {
+		return errDestinationFull
+	}
+
+	file, err := os.CreateTemp(dir, ".write-test-*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot write to destination: %v", err)
+	}
+	path := file.Name()
+	_, err = file.WriteString("SimpleFolderBackup write test\n")
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	removeErr := os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("cannot write to destination: %v", err)
+	}
+	if removeErr != nil {
+		return fmt.Errorf("cannot delete from destination: %v", removeErr)
+	}
+	return nil
+}
+
+// checkDestinationWritable probes a config's destination and updates its alert.
+func checkDestinationWritable(config BackupConfig, logger *log.Logger) {
+	if isRemoteDestination(config.Destination) {
+		return // Object storage is written by uploads only; a failed upload fails the run
+	}
+	if config.VerifyOnly {
+		return // Nothing is written, and the destination may be in the middle of a migration
+	}
+	err := probeDestination(config.Destination)
+
+	probeFailuresMu.Lock()
+	wasFailing := probeFailures[config.Name]
+	probeFailures[config.Name] = err != nil
+	probeFailuresMu.Unlock()
+
+	if err != nil {
+		if !wasFailing {
+			logger.Printf("Destination of %s failed the write test: %v", config.Name, err)
+		}
+		alert := "destination not writable"
+		if errors.Is(err, errDestinationFull) {
+			alert = "destination full"
+		}
+		backupStatus.setAlert(config.Name, alert)
+		requestStatusUpdate()
+		return
+	}
+	if wasFailing {
+		logger.Printf("Destination of %s passes the write test again", config.Name)
+		backupStatus.clearAlert(config.Name)
+		requestStatusUpdate()
+	}
+}
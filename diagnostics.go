@@ -0,0 +1,182 @@
+// Package main - diagnostics.go implements the "Collect diagnostics" bundle.
+//
+// Troubleshooting a remote user's installation usually requires their logs,
+// configuration and persisted state. Rather than walking users through finding
+// those files, the tray offers a single action that zips everything relevant
+// into one file they can attach to a bug report.
+//
+// Key design decisions:
+//
+// 1. Secrets never leave the machine: config.json is re-serialized with any
+//    credential-looking values replaced before it is added to the bundle.
+//
+// 2. Logs are filtered through the same redaction layer as live logging, so
+//    entries written before obfuscate_paths was enabled are still protected.
+//
+// 3. Recent logs only: per-backup logs older than diagnosticsLogDays are left
+//    out to keep bundles small enough to e-mail.
+//
+// 4. Best effort: unreadable files are noted in the bundle's manifest instead
+//    of aborting, since a partial bundle is still far better than none.
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// diagnosticsLogDays limits which log files are included in a bundle
+const diagnosticsLogDays = 7
+
+// secretKeyPattern matches JSON keys whose values must be redacted from diagnostics
+var secretKeyPattern = regexp.MustCompile(`(?i)(password|passphrase|secret|token|credential|api_?key|access_?key|private_?key|webhook_?url)`)
+
+// createDiagnosticsBundle writes a zip of recent logs, redacted config and state files.
+//
+// The bundle is written to the diagnostics/ folder with a timestamped name and
+// the path is returned so the caller can reveal it to the user.
+func createDiagnosticsBundle() (string, error) {
+	err := os.MkdirAll("diagnostics", 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create diagnostics directory: %v", err)
+	}
+
+	bundlePath := filepath.Join("diagnostics", fmt.Sprintf("diagnostics_%s.zip", time.Now().Format(BackupTimestampFormat)))
+	bundleFile, err := os.Create(bundlePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create diagnostics bundle: %v", err)
+	}
+	defer bundleFile.Close()
+
+	zw := zip.NewWriter(bundleFile)
+	var notes []string
+
+	// Build and environment information first so it's visible at the top of the archive
+	info := buildInfo() + fmt.Sprintf("\nCollected: %s\n", formatDisplayTime(time.Now()))
+	if err := addBytesToZip(zw, "info.txt", []byte(info)); err != nil {
+		return "", err
+	}
+
+	// Configuration with secrets removed
+	if data, err := os.ReadFile("config.json"); err == nil {
+		redacted, err := redactConfigJSON(data)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("config.json: could not parse for redaction (%v), omitted", err))
+		} else if err := addBytesToZip(zw, "config.json", redacted); err != nil {
+			return "", err
+		}
+	} else {
+		notes = append(notes, fmt.Sprintf("config.json: %v", err))
+	}
+
+	// Persisted state files
+	for _, stateFile := range []string{hashManager.filePath} {
+		if err := addFileToZip(zw, stateFile, stateFile); err != nil {
+			notes = append(notes, fmt.Sprintf("%s: %v", stateFile, err))
+		}
+	}
+
+	// Recent log files
+	cutoff := time.Now().AddDate(0, 0, -diagnosticsLogDays)
+	walkErr := filepath.WalkDir("logs", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("%s: %v", path, err))
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().Before(cutoff) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("%s: %v", path, err))
+			return nil
+		}
+		return addBytesToZip(zw, filepath.ToSlash(path), []byte(redactLogText(string(data))))
+	})
+	if walkErr != nil {
+		notes = append(notes, fmt.Sprintf("logs: %v", walkErr))
+	}
+
+	if len(notes) > 0 {
+		if err := addBytesToZip(zw, "notes.txt", []byte(strings.Join(notes, "\n")+"\n")); err != nil {
+			return "", err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to finalize diagnostics bundle: %v", err)
+	}
+
+	log.Printf("Diagnostics bundle written to %s", bundlePath)
+	return bundlePath, nil
+}
+
+// redactConfigJSON replaces values of credential-like keys anywhere in a JSON document.
+func redactConfigJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(redactValue(doc), "", "  ")
+}
+
+// redactValue walks decoded JSON and masks values stored under secret-looking keys.
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if secretKeyPattern.MatchString(key) {
+				v[key] = "[REDACTED]"
+			} else {
+				v[key] = redactValue(child)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// addBytesToZip stores an in-memory file in the archive.
+func addBytesToZip(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// addFileToZip streams a file from disk into the archive under the given name.
+func addFileToZip(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
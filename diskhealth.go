@@ -0,0 +1,127 @@
+// Package main - diskhealth.go warns when a destination disk is failing.
+//
+// A second internal drive is the most common destination, and a backup onto
+// a dying disk gives false security: the snapshots look fine until the day
+// they are needed. Disks keep S.M.A.R.T. counters that usually rise long
+// before they fail, so the destination's disk is queried after each snapshot
+// and a disk_health event is raised when it predicts a failure or reports
+// damaged sectors.
+//
+// Key design decisions:
+//
+// 1. Local disks only: Network shares and object storage hide the disks
+//    behind them, and their servers watch them. Disks that can't report
+//    health (USB bridges, virtual or spanned volumes, too few privileges) are
+//    skipped without a warning, since nothing can be done about them.
+//
+// 2. Warn on change: The warning is repeated only when the counters change,
+//    so a disk with a few long-stable reallocated sectors doesn't notify after
+//    every backup, but one that keeps degrading does.
+//
+// 3. Sector counters as well as the verdict: A disk's own failure prediction
+//    trips late, typically when a threshold set by the vendor is crossed.
+//    Reallocated, pending and uncorrectable sectors are warned about from the
+//    first one.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// S.M.A.R.T. attributes counting damaged sectors
+const (
+	smartReallocatedSectors   = 5   // Sectors replaced by spares after failing
+	smartPendingSectors       = 197 // Unreadable sectors waiting to be reallocated
+	smartUncorrectableSectors = 198 // Sectors that couldn't be read or corrected
+)
+
+// errDiskHealthUnavailable is returned by queryDiskHealth for disks that can't report their health
+var errDiskHealthUnavailable = errors.New("disk health is not available for this destination")
+
+// DiskHealth is what the disk holding a destination reports about itself.
+type DiskHealth struct {
+	PredictsFailure bool  // The disk's own verdict that it will fail soon
+	Reallocated     int64 // Sectors replaced by spares, -1 if not reported
+	Pending         int64 // Sectors waiting to be reallocated, -1 if not reported
+	Uncorrectable   int64 // Sectors that couldn't be read, -1 if not reported
+}
+
+// failing reports whether the disk should no longer be trusted with backups.
+func (h DiskHealth) failing() bool {
+	return h.PredictsFailure || h.Reallocated > 0 || h.Pending > 0 || h.Uncorrectable > 0
+}
+
+// describe summarizes the problems of a failing disk, e.g. "predicts its own
+// failure, 8 reallocated sectors".
+func (h DiskHealth) describe() string {
+	if !h.failing() {
+		return "healthy"
+	}
+	var problems []string
+	if h.PredictsFailure {
+		problems = append(problems, "predicts its own failure")
+	}
+	for _, counter := range []struct {
+		count int64
+		kind  string
+	}{{h.Reallocated, "reallocated"}, {h.Pending, "pending"}, {h.Uncorrectable, "uncorrectable"}} {
+		if counter.count > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s sectors", counter.count, counter.kind))
+		}
+	}
+	return strings.Join(problems, ", ")
+}
+
+// destinationDiskHealth queries the disk holding a config's destination.
+func destinationDiskHealth(config BackupConfig) (DiskHealth, error) {
+	if isRemoteDestination(config.Destination) {
+		return DiskHealth{}, errDiskHealthUnavailable
+	}
+	if _, onShare := destinationShareRoot(config); onShare {
+		return DiskHealth{}, errDiskHealthUnavailable
+	}
+	return queryDiskHealth(config.Destination)
+}
+
+// diskHealthWarned holds the last health problems warned about per config, so
+// the warning is repeated only when they change
+var (
+	diskHealthWarnedMu sync.Mutex
+	diskHealthWarned   = make(map[string]string)
+)
+
+// checkDestinationHealth raises a disk_health event and a tray alert when the
+// destination disk is failing.
+func checkDestinationHealth(config BackupConfig, logger *log.Logger) {
+	health, err := destinationDiskHealth(config)
+	if err != nil {
+		return // Not every disk can report its health; see errDiskHealthUnavailable
+	}
+	problems := ""
+	if health.failing() {
+		problems = health.describe()
+	}
+
+	diskHealthWarnedMu.Lock()
+	warned := diskHealthWarned[config.Name]
+	diskHealthWarned[config.Name] = problems
+	diskHealthWarnedMu.Unlock()
+
+	switch {
+	case problems == warned:
+	case problems == "":
+		logger.Printf("Destination disk of %s no longer reports problems", config.Name)
+		backupStatus.clearAlert(config.Name)
+		requestStatusUpdate()
+	default:
+		logger.Printf("Destination disk of %s is failing: %s", config.Name, problems)
+		backupStatus.setAlert(config.Name, "destination disk failing")
+		requestStatusUpdate()
+		notifyEvent(config, EventDiskHealth, "Destination disk failing: "+config.Name,
+			fmt.Sprintf("The disk holding %s %s. Move the backups to another disk before it fails.", config.Destination, problems))
+	}
+}
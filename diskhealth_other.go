@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+// queryDiskHealth reports the health of the disk holding path.
+//
+// Reading S.M.A.R.T. data needs raw device access outside Windows, which an
+// application running as a normal user doesn't have; tools like smartd
+// already watch disks there.
+func queryDiskHealth(path string) (DiskHealth, error) {
+	return DiskHealth{}, errDiskHealthUnavailable
+}
@@ -0,0 +1,145 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetVolumePathNameW                = kernel32.NewProc("GetVolumePathNameW")
+	procGetVolumeNameForVolumeMountPointW = kernel32.NewProc("GetVolumeNameForVolumeMountPointW")
+)
+
+// Storage IOCTLs answered for handles opened without read or write access
+const (
+	ioctlStorageGetDeviceNumber = 0x2D1080 // Physical disk number of a volume
+	ioctlStoragePredictFailure  = 0x2D1100 // Failure prediction and ATA S.M.A.R.T. data
+	ioctlStorageQueryProperty   = 0x2D1400 // Device descriptor, including the bus type
+)
+
+// Bus types whose disks fill VendorSpecific with ATA S.M.A.R.T. attributes;
+// others (NVMe, SCSI) only give the failure prediction
+const (
+	busTypeAta  = 3
+	busTypeSata = 11
+)
+
+// Layout of the ATA S.M.A.R.T. data in STORAGE_PREDICT_FAILURE.VendorSpecific:
+// a 2-byte revision, then 30 attributes of 12 bytes each
+const (
+	smartAttributesOffset = 2
+	smartAttributeSize    = 12
+	smartAttributeCount   = 30
+	smartRawOffset        = 5 // Raw value (6 bytes, little endian) within an attribute
+)
+
+// queryDiskHealth reports the health of the disk holding path.
+//
+// The path is mapped to its volume and the volume to its physical disk,
+// which is then asked for its failure prediction. Volumes spanning several
+// disks and disks whose driver doesn't answer (most USB enclosures) return
+// errDiskHealthUnavailable.
+func queryDiskHealth(path string) (DiskHealth, error) {
+	volume, err := volumeDevicePath(path)
+	if err != nil {
+		return DiskHealth{}, errDiskHealthUnavailable
+	}
+	var number struct {
+		DeviceType      uint32
+		DeviceNumber    uint32
+		PartitionNumber uint32
+	}
+	if err := storageIoctl(volume, ioctlStorageGetDeviceNumber, nil, 0, unsafe.Pointer(&number), uint32(unsafe.Sizeof(number))); err != nil {
+		return DiskHealth{}, errDiskHealthUnavailable
+	}
+
+	var predict struct {
+		PredictFailure uint32
+		VendorSpecific [512]byte
+	}
+	disk := fmt.Sprintf(`\\.\PhysicalDrive%d`, number.DeviceNumber)
+	if err := storageIoctl(disk, ioctlStoragePredictFailure, nil, 0, unsafe.Pointer(&predict), uint32(unsafe.Sizeof(predict))); err != nil {
+		return DiskHealth{}, errDiskHealthUnavailable
+	}
+
+	health := DiskHealth{PredictsFailure: predict.PredictFailure != 0, Reallocated: -1, Pending: -1, Uncorrectable: -1}
+	if bus := diskBusType(disk); bus != busTypeAta && bus != busTypeSata {
+		return health, nil
+	}
+	data := predict.VendorSpecific[:]
+	for i := 0; i < smartAttributeCount; i++ {
+		attribute := data[smartAttributesOffset+i*smartAttributeSize:][:smartAttributeSize]
+		raw := make([]byte, 8)
+		copy(raw, attribute[smartRawOffset:smartRawOffset+6])
+		value := int64(binary.LittleEndian.Uint64(raw))
+		switch attribute[0] {
+		case smartReallocatedSectors:
+			health.Reallocated = value & 0xFFFF // Vendors put other counters in the upper bytes
+		case smartPendingSectors:
+			health.Pending = value & 0xFFFF
+		case smartUncorrectableSectors:
+			health.Uncorrectable = value & 0xFFFF
+		}
+	}
+	return health, nil
+}
+
+// volumeDevicePath returns the device path of the volume holding path, e.g.
+// \\?\Volume{...}, which also works for volumes mounted in a folder.
+func volumeDevicePath(path string) (string, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return "", err
+	}
+	mountPoint := make([]uint16, syscall.MAX_PATH+1)
+	ret, _, callErr := procGetVolumePathNameW.Call(uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&mountPoint[0])), uintptr(len(mountPoint)))
+	if ret == 0 {
+		return "", callErr
+	}
+	volume := make([]uint16, 50) // "\\?\Volume{GUID}\" plus terminator
+	ret, _, callErr = procGetVolumeNameForVolumeMountPointW.Call(uintptr(unsafe.Pointer(&mountPoint[0])),
+		uintptr(unsafe.Pointer(&volume[0])), uintptr(len(volume)))
+	if ret == 0 {
+		return "", callErr
+	}
+	// The trailing backslash would open the volume's root folder instead of the volume
+	return strings.TrimSuffix(syscall.UTF16ToString(volume), `\`), nil
+}
+
+// diskBusType returns the STORAGE_BUS_TYPE of a disk, 0 if unknown.
+func diskBusType(disk string) uint32 {
+	query := struct {
+		PropertyId uint32 // StorageDeviceProperty
+		QueryType  uint32 // PropertyStandardQuery
+		Additional [4]byte
+	}{}
+	var descriptor [1024]byte // STORAGE_DEVICE_DESCRIPTOR followed by its strings
+	if err := storageIoctl(disk, ioctlStorageQueryProperty, unsafe.Pointer(&query), uint32(unsafe.Sizeof(query)),
+		unsafe.Pointer(&descriptor[0]), uint32(len(descriptor))); err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(descriptor[28:]) // BusType
+}
+
+// storageIoctl sends a storage IOCTL to a device.
+func storageIoctl(device string, code uint32, in unsafe.Pointer, inSize uint32, out unsafe.Pointer, outSize uint32) error {
+	devicePtr, err := syscall.UTF16PtrFromString(device)
+	if err != nil {
+		return err
+	}
+	// No access rights are needed for these queries, so no administrator either
+	handle, err := syscall.CreateFile(devicePtr, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE,
+		nil, syscall.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+	var returned uint32
+	return syscall.DeviceIoControl(handle, code, (*byte)(in), inSize, (*byte)(out), outSize, &returned, nil)
+}
@@ -0,0 +1,160 @@
+// Package main - diskspace.go watches free space on backup destinations.
+//
+// A full destination makes every following backup fail, usually long after
+// the space ran low. Free space is checked after each snapshot and a
+// low_space event is raised once when it drops below lowSpacePercent, so
+// there is time to clean up or add storage.
+//
+// Before a backup copies anything, the size of the source (without the files
+// an incremental snapshot links) is measured, for the run's progress, and
+// compared with the free space, keeping
+// free_space_headroom_mb spare. A backup that doesn't fit fails at once with
+// E_DISK_FULL instead of filling the disk and leaving a half-written snapshot.
+// Old snapshots are only rotated out after the new one is complete, so the
+// space they would free doesn't count. Should the disk fill up anyway, the
+// incomplete snapshot is removed again.
+//
+// A destination shared with other data can be kept from filling up with
+// min_free_space_gb: while less than that is free, the oldest snapshots are
+// deleted, beyond rotation_count, both after each backup and before copying
+// the next one. A backup that would still leave less free fails with
+// E_DISK_FULL.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// lowSpacePercent is the free space share of the destination volume below which a warning is raised
+const lowSpacePercent = 10
+
+// lowSpaceAlerted tracks configs already warned about, so the warning is raised
+// once per low-space period instead of after every backup
+var (
+	lowSpaceAlertedMu sync.Mutex
+	lowSpaceAlerted   = make(map[string]bool)
+)
+
+// checkDestinationSpace raises a low_space event when the destination is nearly full.
+func checkDestinationSpace(config BackupConfig, logger *log.Logger) {
+	free, total, err := diskUsage(config.Destination)
+	if err != nil || total == 0 {
+		return // Space can't be determined (e.g. some network shares)
+	}
+	low := free*100 < total*lowSpacePercent
+
+	lowSpaceAlertedMu.Lock()
+	wasAlerted := lowSpaceAlerted[config.Name]
+	lowSpaceAlerted[config.Name] = low
+	lowSpaceAlertedMu.Unlock()
+
+	if low && !wasAlerted {
+		logger.Printf("Destination of %s is low on space: %s free of %s", config.Name, formatSize(int64(free)), formatSize(int64(total)))
+		notifyEvent(config, EventLowSpace, "Low disk space: "+config.Name,
+			fmt.Sprintf("Only %s of %s is free at %s.", formatSize(int64(free)), formatSize(int64(total)), config.Destination))
+	}
+}
+
+// errNotEnoughSpace is returned when the destination can't hold the next backup
+var errNotEnoughSpace = errors.New("not enough free space at the destination")
+
+// checkSpaceForBackup measures what a backup of config will write and fails
+// with errNotEnoughSpace if the destination can't hold it plus the configured
+// headroom. Files that base links instead of copying take no space (nil
+// copies everything). A destination whose free space can't be determined
+// passes. The measurement is returned for the run's progress; it is zero if
+// the source couldn't be measured.
+func checkSpaceForBackup(config BackupConfig, base *linkBase, logger *log.Logger) (backupSize, error) {
+	size, err := estimateBackupSize(config, base)
+	if err != nil {
+		// The copy walks the same tree and reports the problem properly
+		logger.Printf("Could not estimate the size of %s: %v", config.Name, err)
+		return backupSize{}, nil
+	}
+	if err := keepMinFreeSpace(config, size.Copied, logger); err != nil {
+		return size, fmt.Errorf("failed to delete old snapshots for min_free_space_gb: %v", err)
+	}
+	free, total, err := diskUsage(config.Destination)
+	if err != nil || total == 0 {
+		return size, nil
+	}
+	headroom := max(config.GetFreeSpaceHeadroom(), config.GetMinFreeSpace())
+	if size.Copied+headroom <= int64(free) {
+		return size, nil
+	}
+	return size, fmt.Errorf("%w: the backup needs about %s plus %s headroom, but only %s is free at %s",
+		errNotEnoughSpace, formatSize(size.Copied), formatSize(headroom), formatSize(int64(free)), config.Destination)
+}
+
+// keepMinFreeSpace deletes the oldest snapshots of config, beyond
+// rotation_count and retention, while the destination would have less than
+// min_free_space_gb free once pending more bytes are written. The newest
+// snapshot is always kept, as the base of the next incremental backup and
+// the last copy of the data. Does nothing without min_free_space_gb, for
+// archive snapshots, or if the free space can't be determined.
+func keepMinFreeSpace(config BackupConfig, pending int64, logger *log.Logger) error {
+	minimum := config.GetMinFreeSpace()
+	if minimum == 0 || writesArchiveSnapshots(config) {
+		return nil
+	}
+	snapshots, err := rotatedSnapshots(config)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		free, total, err := diskUsage(config.Destination)
+		if err != nil || total == 0 || int64(free)-pending >= minimum {
+			return nil
+		}
+		if i >= len(snapshots)-1 {
+			logger.Printf("Destination of %s has %s free, less than min_free_space_gb (%s), with only the newest snapshot left",
+				config.Name, formatSize(int64(free)), formatSize(minimum))
+			return nil
+		}
+		logger.Printf("Deleting snapshot %s to keep %s free at the destination (min_free_space_gb); %s is free",
+			snapshots[i].name, formatSize(minimum), formatSize(int64(free)))
+		if err := deleteSnapshot(config, snapshots[i].name); err != nil {
+			return err
+		}
+	}
+}
+
+// backupSize is what a backup of a config is about to write.
+type backupSize struct {
+	Files  int   // Files the snapshot will hold
+	Copied int64 // Size of the files copied rather than linked
+}
+
+// estimateBackupSize counts the files a backup of config would contain and
+// the size of those it would copy rather than link from base.
+func estimateBackupSize(config BackupConfig, base *linkBase) (backupSize, error) {
+	var size backupSize
+	err := walkTree(config.Source, walkOptionsFor(config), func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(config.Source, path)
+		if err != nil {
+			return err
+		}
+		size.Files++
+		if previous, exists := base.previous(rel); exists {
+			if _, linked := base.unchanged(rel, info, previous); linked {
+				return nil
+			}
+		}
+		size.Copied += info.Size()
+		return nil
+	})
+	return size, err
+}
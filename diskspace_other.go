@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// diskUsage returns the bytes available to unprivileged users and the total size
+// of the volume containing path.
+func diskUsage(path string) (free, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = kernel32.NewProc("GetDiskFreeSpaceExW")
+
+// diskUsage returns the bytes available to the current user and the total size
+// of the volume containing path.
+func diskUsage(path string) (free, total uint64, err error) {
+	pathPtr, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var freeToCaller, totalBytes, totalFree uint64
+	ret, _, callErr := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&freeToCaller)),
+		uintptr(unsafe.Pointer(&totalBytes)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if ret == 0 {
+		return 0, 0, callErr
+	}
+	return freeToCaller, totalBytes, nil
+}
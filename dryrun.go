@@ -0,0 +1,217 @@
+// Package main - dryrun.go previews what a backup of a config would do.
+//
+// Whether the exclusions, max_depth and retention of a new config do what
+// was meant only showed once a snapshot had been written and old ones
+// deleted. "backup --dry-run" walks the source exactly as a backup would and
+// reports what it would decide and copy, what it would leave out and which
+// snapshots rotation would delete afterwards, without writing anything: no
+// snapshot, no change-detection state, no warm cache and no log file.
+//
+// The decision uses the change-detection hash recorded by the last real run,
+// so it is what the next scheduled run would decide if nothing changes in
+// between. The pre_backup hook is not run, since it may change the source.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DryRunReport describes what a backup of a config would do now.
+type DryRunReport struct {
+	Outcome       string   // ResultBackup, ResultSkipped, ResultChanged or ResultWaiting
+	Reason        string   // Why, e.g. "contents changed since the last backup"
+	Files         int      // Files a snapshot would contain
+	Bytes         int64    // Their size
+	Linked        int      // Files of them incremental would link rather than copy
+	LinkedBytes   int64    // Size of the linked files
+	Excluded      int      // Files left out by the exclusions
+	ExcludedBytes int64    // Size of the excluded files
+	Deletions     []string // Snapshots rotation would delete after the new one, oldest first
+	Notes         []string // Things the preview could not take into account
+}
+
+// dryRunBackup works out what a backup of config would do now. list, if not
+// nil, is called with the relative path of every file a snapshot would
+// contain and whether it would be linked.
+func dryRunBackup(config BackupConfig, list func(rel string, size int64, linked bool)) (DryRunReport, error) {
+	var report DryRunReport
+	if info, err := os.Stat(config.Source); err != nil || !info.IsDir() {
+		report.Outcome = ResultWaiting
+		report.Reason = fmt.Sprintf("source folder %s is unavailable; missing_source %q decides what happens", config.Source, config.GetMissingSourcePolicy())
+		return report, nil
+	}
+	if config.Hooks != nil && config.Hooks.PreBackup != "" {
+		report.Notes = append(report.Notes, "the pre_backup hook was not run; it may change the source before a real backup")
+	}
+
+	report.Outcome, report.Reason = ResultBackup, "change detection is off"
+	if config.IsHashCheckEnabled() {
+		changed, reason, err := dryRunContentChanged(config)
+		if err != nil {
+			return report, fmt.Errorf("failed to hash %s: %v", config.Source, err)
+		}
+		report.Reason = reason
+		if !changed {
+			report.Outcome = ResultSkipped
+		}
+	}
+	if config.VerifyOnly {
+		report.Reason += "; verify_only is on, so no snapshot would be written"
+		if report.Outcome == ResultBackup {
+			report.Outcome = ResultChanged
+		}
+	}
+
+	// Incremental copies link from the newest snapshot; its log output is of no interest here
+	var base *linkBase
+	if report.Outcome == ResultBackup {
+		base = findLinkBase(config, log.New(io.Discard, "", 0))
+	}
+	opts := walkOptionsFor(config)
+	err := walkTree(config.Source, opts, func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(config.Source, path)
+		if err != nil {
+			return err
+		}
+		report.Files++
+		report.Bytes += info.Size()
+		linked := false
+		if previous, exists := base.previous(rel); exists {
+			_, linked = base.unchanged(rel, info, previous)
+		}
+		if linked {
+			report.Linked++
+			report.LinkedBytes += info.Size()
+		}
+		if list != nil {
+			list(rel, info.Size(), linked)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to walk %s: %v", config.Source, err)
+	}
+
+	if opts.exclude != nil {
+		// Whatever a walk without the exclusions finds on top was excluded
+		all := opts
+		all.exclude = nil
+		err := walkTree(config.Source, all, func(path string, d fs.DirEntry) error {
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				report.Excluded++
+				report.ExcludedBytes += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to walk %s: %v", config.Source, err)
+		}
+		report.Excluded -= report.Files
+		report.ExcludedBytes -= report.Bytes
+	}
+
+	if report.Outcome == ResultBackup {
+		if report.Deletions, err = dryRunDeletions(config, time.Now()); err != nil {
+			return report, fmt.Errorf("failed to list the snapshots in %s: %v", config.Destination, err)
+		}
+	}
+	return report, nil
+}
+
+// dryRunContentChanged compares the source with the hash recorded by the last
+// backup or skip, without recording anything or updating the warm cache.
+func dryRunContentChanged(config BackupConfig) (bool, string, error) {
+	var hash string
+	var err error
+	if config.GetWarmCacheMode() == WarmCacheOff {
+		hash, err = hashManager.sourceHash(config)
+	} else {
+		cache := loadWarmCache(config)
+		if cache == nil {
+			cache = &treeCache{}
+		}
+		_, hash, err = scanWithCache(config.Source, cache, walkOptionsFor(config))
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	last, exists := hashManager.getLastHash(config.Name)
+	switch {
+	case !exists:
+		return true, "no backup recorded yet", nil
+	case hash != last:
+		return true, "contents changed since the last backup", nil
+	}
+	return false, "contents unchanged since the last backup", nil
+}
+
+// dryRunDeletions returns the snapshots rotation would delete once a new one
+// taken at now is added.
+func dryRunDeletions(config BackupConfig, now time.Time) ([]string, error) {
+	name := generateBackupDirName(config.Source, now)
+	var snapshots []retainedSnapshot
+	var err error
+	if writesArchiveSnapshots(config) {
+		name += remoteArchiveExt
+		var store remoteStore
+		if store, err = openRemoteStore(config); err != nil {
+			return nil, err
+		}
+		defer store.close()
+		snapshots, err = remoteSnapshots(config, store)
+	} else {
+		snapshots, err = rotatedSnapshots(config)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil // The first backup creates the destination
+	} else if err != nil {
+		return nil, err
+	}
+	return rotationDeletions(config, append(snapshots, retainedSnapshot{name: name, taken: now}), now), nil
+}
+
+// formatDryRun renders a dry run of a config for the backup command.
+func formatDryRun(name string, report DryRunReport) string {
+	var out strings.Builder
+	switch report.Outcome {
+	case ResultBackup:
+		fmt.Fprintf(&out, "%s: would back up %d file(s) (%s)\n", name, report.Files, formatSize(report.Bytes))
+	case ResultSkipped:
+		fmt.Fprintf(&out, "%s: would skip, %d file(s) (%s) are unchanged\n", name, report.Files, formatSize(report.Bytes))
+	case ResultChanged:
+		fmt.Fprintf(&out, "%s: would only check, %d file(s) (%s)\n", name, report.Files, formatSize(report.Bytes))
+	default:
+		fmt.Fprintf(&out, "%s: would not back up\n", name)
+	}
+	lines := []string{report.Reason}
+	if report.Linked > 0 {
+		lines = append(lines, fmt.Sprintf("%d file(s) (%s) are unchanged since the newest snapshot and would be linked, not copied", report.Linked, formatSize(report.LinkedBytes)))
+	}
+	if report.Excluded > 0 {
+		lines = append(lines, fmt.Sprintf("%d file(s) (%s) are excluded", report.Excluded, formatSize(report.ExcludedBytes)))
+	}
+	if len(report.Deletions) > 0 {
+		lines = append(lines, fmt.Sprintf("would then delete %d old snapshot(s): %s", len(report.Deletions), strings.Join(report.Deletions, ", ")))
+	} else if report.Outcome == ResultBackup {
+		lines = append(lines, "would delete no old snapshots")
+	}
+	for _, line := range append(lines, report.Notes...) {
+		fmt.Fprintf(&out, "  %s\n", line)
+	}
+	return out.String()
+}
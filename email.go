@@ -0,0 +1,105 @@
+// Package main - email.go implements the e-mail notification channel.
+//
+// Desktop notifications only help when someone is sitting at the machine.
+// E-mail reaches the owner of an unattended or remote machine, which is where
+// a silently failing backup does the most damage.
+//
+// Only the standard library SMTP client is used. Port 465 connects with
+// implicit TLS; any other port starts in plaintext and upgrades with STARTTLS
+// when the server offers it. Credentials are only sent over TLS (or to
+// localhost), as enforced by net/smtp.
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default SMTP submission port
+const defaultSMTPPort = 587
+
+// sendEmailNotification e-mails a notification to the configured recipients.
+func sendEmailNotification(n Notification) error {
+	settings := currentSettings().SMTP
+	if settings == nil || settings.Host == "" || settings.From == "" || len(settings.To) == 0 {
+		return fmt.Errorf("email channel used but settings.smtp is incomplete")
+	}
+
+	body := fmt.Sprintf("%s\r\n\r\nConfiguration: %s\r\nEvent: %s\r\nTime: %s\r\n",
+		n.Message, n.Config, n.Event, formatDisplayTime(n.Time))
+	if n.Code != "" {
+		body += fmt.Sprintf("Error code: %s\r\n", n.Code)
+	}
+	return sendEmail(settings, "[SimpleFolderBackup] "+n.Title, body)
+}
+
+// sendEmail delivers a plain-text message through the configured SMTP server.
+func sendEmail(settings *SMTPSettings, subject, body string) error {
+	port := settings.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	addr := net.JoinHostPort(settings.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if settings.Username != "" {
+		auth = smtp.PlainAuth("", settings.Username, settings.Password, settings.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + settings.From,
+		"To: " + strings.Join(settings.To, ", "),
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if port != 465 {
+		// smtp.SendMail upgrades to STARTTLS whenever the server supports it
+		return smtp.SendMail(addr, auth, settings.From, settings.To, []byte(message))
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: settings.Host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, settings.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(settings.From); err != nil {
+		return err
+	}
+	for _, recipient := range settings.To {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
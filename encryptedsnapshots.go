@@ -0,0 +1,167 @@
+// Package main - encryptedsnapshots.go writes snapshots as encrypted archives.
+//
+// Snapshot folders can be read by anyone with access to the destination. For
+// a shared drive, a NAS used by others or a cloud bucket that is not good
+// enough, so a config with an "encryption" section writes every snapshot as
+// one zip archive sealed with the passphrase-based format of encryption.go,
+// e.g. 15-01-2024_14-30-00_Documents.zip.enc. Without the passphrase neither
+// file contents nor file names can be read; the "decrypt" command turns an
+// archive back into a plain zip.
+//
+// Key design decisions:
+//
+// 1. The archive path of remote destinations: Encrypted snapshots are
+//    written, named and rotated exactly like the archives of remote.go. A
+//    local folder becomes one more remoteStore (folderStore), and remote
+//    destinations simply receive the encrypted archive instead of the plain
+//    one.
+//
+// 2. Passphrase kept out of the config: The passphrase should be referenced
+//    from the credential store with the credential: prefix, as for hook
+//    environment values, so config.json can be shared or backed up without
+//    giving the snapshots away. A literal passphrase is accepted, and masked
+//    in logs.
+//
+// 3. Nothing readable next to the archives: No manifests or notes are
+//    written, and options that would leave file names or contents
+//    unencrypted at the destination (log_to_destination, incremental,
+//    replica) are rejected.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errEncryptedSnapshots is returned where snapshots of an encrypted config would have to be folders
+var errEncryptedSnapshots = errors.New("snapshots of this config are encrypted archives; unpack one with the decrypt command to restore it")
+
+// EncryptionSettings enables encrypted snapshots for a config.
+type EncryptionSettings struct {
+	Passphrase string `json:"passphrase"` // credential:<target> to read it from the credential store
+}
+
+// IsEncrypted reports whether snapshots are written as encrypted archives.
+func (bc *BackupConfig) IsEncrypted() bool {
+	return bc.Encryption != nil
+}
+
+// writesArchiveSnapshots reports whether a config's snapshots are archives
+// in a remoteStore rather than folders.
+func writesArchiveSnapshots(config BackupConfig) bool {
+	return isRemoteDestination(config.Destination) || config.IsEncrypted()
+}
+
+// validateEncryption checks the encryption settings of a config.
+func validateEncryption(config BackupConfig) error {
+	switch {
+	case config.Encryption.Passphrase == "":
+		return fmt.Errorf("encryption needs a passphrase")
+	case config.LogToDestination:
+		return fmt.Errorf("log_to_destination would store file names unencrypted next to encrypted snapshots")
+	}
+	return validateArchiveSnapshots(config)
+}
+
+// snapshotPassphrase returns the passphrase snapshots of a config are encrypted with.
+func snapshotPassphrase(config BackupConfig) (string, error) {
+	passphrase := config.Encryption.Passphrase
+	if target, isCredential := strings.CutPrefix(passphrase, credentialPrefix); isCredential {
+		secret, err := readCredential(target)
+		if err != nil {
+			return "", fmt.Errorf("encryption credential %q: %v", target, err)
+		}
+		registerSecret(secret)
+		passphrase = secret
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("encryption passphrase is empty")
+	}
+	return passphrase, nil
+}
+
+// writeSnapshotStream writes the source archive of a config to w, sealed
+// with passphrase unless it is empty.
+func writeSnapshotStream(w io.Writer, config BackupConfig, passphrase string, limiter *bandwidthLimiter, progress *copyProgress) error {
+	if passphrase == "" {
+		return writeSourceArchive(w, config, limiter, progress)
+	}
+	encrypter, err := newEncryptingWriter(w, passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to initialize encryption: %v", err)
+	}
+	if err := writeSourceArchive(encrypter, config, limiter, progress); err != nil {
+		return err
+	}
+	return encrypter.Close()
+}
+
+// folderStore keeps snapshot archives as files in a local or network folder.
+type folderStore struct {
+	dir string
+}
+
+// upload writes body to the file name, under a .partial name until it is complete.
+func (f folderStore) upload(name string, body io.Reader) error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(f.dir, name)
+	tempPath := path + stagingSuffix
+	out, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, body)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+// list returns the files of the folder; archives still being written are left out.
+func (f folderStore) list() ([]remoteObject, error) {
+	entries, err := os.ReadDir(f.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var objects []remoteObject
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".partial") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Deleted since the listing
+		}
+		objects = append(objects, remoteObject{Name: entry.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func (f folderStore) remove(name string) error {
+	return os.Remove(filepath.Join(f.dir, name))
+}
+
+func (f folderStore) describe() string {
+	return f.dir
+}
+
+func (f folderStore) close() error {
+	return nil
+}
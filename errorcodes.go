@@ -0,0 +1,135 @@
+// Package main - errorcodes.go gives backup failures stable error codes.
+//
+// Error messages come from the operating system, the SFTP server or the S3
+// endpoint, differ between Windows and Unix and change with the locale, so
+// neither a monitoring script nor a support request can rely on their text.
+// Every failure is therefore classified into one of a few codes, such as
+// E_DEST_UNREACHABLE or E_DISK_FULL, shown next to the message in logs, the
+// status, notifications and webhooks, and used as the exit code of the
+// backup command.
+//
+// Key design decisions:
+//
+// 1. Codes where the cause is known, classification everywhere else: The
+//    engine marks the failures it recognizes itself with withCode. Most copy
+//    errors are formatted with %v and no longer wrap the system error, so the
+//    common causes are also recognized by their message, as before for
+//    pause_after_failures.
+//
+// 2. Stable strings: Codes are part of the scripting interface. Existing
+//    codes never change meaning; new causes get new codes.
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// ErrorCode identifies the cause of a failure independently of its message.
+type ErrorCode string
+
+// Error codes of failed and deferred runs
+const (
+	CodeSourceMissing   ErrorCode = "E_SOURCE_MISSING"   // The source folder doesn't exist
+	CodeDestUnreachable ErrorCode = "E_DEST_UNREACHABLE" // The destination share, server or bucket doesn't answer
+	CodeAccessDenied    ErrorCode = "E_ACCESS_DENIED"    // A file or folder can't be read or written
+	CodeDiskFull        ErrorCode = "E_DISK_FULL"        // The destination has no space left
+	CodeNotFound        ErrorCode = "E_NOT_FOUND"        // A file or folder disappeared while being backed up
+	CodeHookFailed      ErrorCode = "E_HOOK_FAILED"      // The pre_backup hook failed
+	CodeWrongPassphrase ErrorCode = "E_WRONG_PASSPHRASE" // An encrypted snapshot couldn't be decrypted
+	CodeReadOnly        ErrorCode = "E_READ_ONLY"        // The action is refused in read-only mode
+	CodeUnknown         ErrorCode = "E_UNKNOWN"          // Anything else; the message tells more
+)
+
+// errorCodeInfo describes each code for people and scripts
+var errorCodeInfo = map[ErrorCode]struct {
+	description string // Short cause, e.g. in "paused after 3 failures (disk full)"
+	exitCode    int    // Exit code of the backup command when every failed job failed this way
+}{
+	CodeSourceMissing:   {"source missing", 10},
+	CodeDestUnreachable: {"destination unreachable", 11},
+	CodeAccessDenied:    {"access denied", 12},
+	CodeDiskFull:        {"disk full", 13},
+	CodeNotFound:        {"not found", 14},
+	CodeHookFailed:      {"hook failed", 15},
+	CodeWrongPassphrase: {"wrong passphrase", 16},
+	CodeReadOnly:        {"read-only mode", 17},
+	CodeUnknown:         {"unknown error", exitFailure},
+}
+
+// codedError attaches an error code to an error
+type codedError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode marks err as caused by code. A nil err stays nil.
+func withCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// errorCodeOf classifies an error.
+func errorCodeOf(err error) ErrorCode {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+
+	message := strings.ToLower(err.Error())
+	containsAny := func(fragments ...string) bool {
+		for _, fragment := range fragments {
+			if strings.Contains(message, fragment) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case errors.Is(err, errSourceMissing) || errors.Is(err, errSourceMissingDisabled):
+		return CodeSourceMissing
+	case errors.Is(err, errDestinationFull) || errors.Is(err, errNotEnoughSpace):
+		return CodeDiskFull
+	case errors.Is(err, errWrongPassphrase):
+		return CodeWrongPassphrase
+	case errors.Is(err, errReadOnly):
+		return CodeReadOnly
+	case containsAny("network path", "network name", "network is unreachable", "host is down",
+		"connection refused", "no such host", "i/o timeout"):
+		return CodeDestUnreachable
+	case errors.Is(err, fs.ErrPermission) || containsAny("permission denied", "access is denied"):
+		return CodeAccessDenied
+	case errors.Is(err, fs.ErrNotExist) || containsAny("no such file or directory", "cannot find the path", "cannot find the file"):
+		return CodeNotFound
+	case containsAny("no space left", "not enough space", "disk is full"):
+		return CodeDiskFull
+	default:
+		return CodeUnknown
+	}
+}
+
+// describe returns the short cause of a code, e.g. "disk full".
+func (c ErrorCode) describe() string {
+	return errorCodeInfo[c].description
+}
+
+// exitCode returns the exit code of the backup command for failures with this code.
+func (c ErrorCode) exitCode() int {
+	if info, known := errorCodeInfo[c]; known {
+		return info.exitCode
+	}
+	return exitFailure
+}
+
+// formatCodedError renders an error with its code for logs and summaries,
+// e.g. "[E_DISK_FULL] failed to copy ...: no space left on device".
+func formatCodedError(err error) string {
+	return "[" + string(errorCodeOf(err)) + "] " + err.Error()
+}
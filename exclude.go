@@ -0,0 +1,243 @@
+// Package main - exclude.go implements exclusion patterns and presets.
+//
+// Caches, build output and VM images are often the largest and least
+// valuable parts of a source folder, but which names to exclude is only
+// obvious after the first oversized snapshot. Besides its own exclude
+// patterns, a config can switch on named presets covering the common cases.
+//
+// Patterns use path.Match syntax and are matched case-insensitively:
+//
+// - A pattern without a slash matches an entry's name at any depth ("*.tmp")
+// - A pattern containing a slash matches the path relative to the source,
+//    written with forward slashes ("docs/drafts"); a leading slash anchors a
+//    single name to the top of the source ("/build/")
+// - A trailing slash restricts a pattern to directories ("node_modules/")
+// - "**" as a whole path element matches any number of folders, so
+//    "node_modules/**" and "**/cache/" work as they do in .gitignore files
+//
+// Excluded directories are not walked at all, so they cost nothing to hash
+// or copy.
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// excludePresets are the pattern groups selectable through exclude_presets
+var excludePresets = map[string][]string{
+	"build-artifacts": {
+		"node_modules/", "bower_components/", "__pycache__/", ".venv/", ".gradle/", ".next/",
+		".nuxt/", ".tox/", "target/", "obj/", "*.o", "*.obj", "*.pyc", "*.pyo", "*.class",
+		"*.pdb", "*.ilk", "*.tlog",
+	},
+	"caches": {
+		".cache/", "cache/", "caches/", "cache2/", ".thumbnails/", "*.tmp", "*.temp",
+		"~$*", "*.swp", "thumbs.db", ".ds_store",
+	},
+	"vm-images": {
+		"*.vhd", "*.vhdx", "*.avhd", "*.avhdx", "*.vmdk", "*.vdi", "*.qcow2", "*.vmem",
+		"*.vmsn", "*.vmss", "*.vsv", "*.iso",
+	},
+	"media-scratch": {
+		"adobe premiere pro video previews/", "adobe premiere pro audio previews/",
+		"adobe premiere pro auto-save/", "media cache/", "media cache files/", "*.cfa",
+		"*.pek", "*previews.lrdata/", "*smart previews.lrdata/", "cacheclip/", "render files/",
+	},
+}
+
+// excludePresetDescriptions explains each preset in listings
+var excludePresetDescriptions = map[string]string{
+	"build-artifacts": "Dependency folders and compiler output that a build recreates",
+	"caches":          "Application caches, temporary files and thumbnail databases",
+	"vm-images":       "Virtual machine disks, memory snapshots and ISO images",
+	"media-scratch":   "Preview renders and media caches of video and photo editors",
+}
+
+// excludePresetNames returns the preset names in sorted order.
+func excludePresetNames() []string {
+	names := make([]string, 0, len(excludePresets))
+	for name := range excludePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// excludePattern is one normalized exclusion pattern
+type excludePattern struct {
+	segments []string // Lowercase path.Match patterns per path element; one for unanchored patterns
+	anchored bool     // Matched against the relative path rather than the name
+	dirOnly  bool     // Only matches directories
+}
+
+// excludeMatcher decides which entries of a source tree are left out.
+type excludeMatcher struct {
+	patterns []excludePattern
+	dirs     []string // Lowercase relative paths of folders excluded literally (see overlap.go)
+}
+
+// newExcludeMatcher compiles patterns and the patterns of the named presets.
+//
+// Returns nil when there is nothing to exclude, so callers can keep the
+// unfiltered walk. Unknown preset names are an error.
+func newExcludeMatcher(patterns, presets []string) (*excludeMatcher, error) {
+	all := append([]string(nil), patterns...)
+	for _, preset := range presets {
+		presetPatterns, exists := excludePresets[strings.ToLower(preset)]
+		if !exists {
+			return nil, fmt.Errorf("unknown exclude preset %q (available: %s)", preset, strings.Join(excludePresetNames(), ", "))
+		}
+		all = append(all, presetPatterns...)
+	}
+
+	matcher := &excludeMatcher{}
+	for _, raw := range all {
+		glob := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(raw), "\\", "/"))
+		pattern := excludePattern{
+			anchored: strings.HasPrefix(glob, "/"),
+			dirOnly:  strings.HasSuffix(glob, "/"),
+		}
+		glob = strings.Trim(glob, "/")
+		if glob == "" {
+			continue
+		}
+		pattern.anchored = pattern.anchored || strings.Contains(glob, "/")
+		pattern.segments = []string{glob}
+		if pattern.anchored {
+			pattern.segments = strings.Split(glob, "/")
+		}
+		for _, segment := range pattern.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid exclude pattern %q: %v", raw, err)
+			}
+		}
+		matcher.patterns = append(matcher.patterns, pattern)
+	}
+	if len(matcher.patterns) == 0 {
+		return nil, nil
+	}
+	return matcher, nil
+}
+
+// excludeMatcherFor returns the matcher for a config's exclusions.
+//
+// Nested sources excluded through exclude_nested_sources are matched as
+// literal paths, since folder names may contain pattern characters.
+func excludeMatcherFor(config BackupConfig) (*excludeMatcher, error) {
+	matcher, err := newExcludeMatcher(config.Exclude, config.ExcludePresets)
+	if err != nil {
+		return nil, err
+	}
+	if nested := nestedSourcesOf(config.Name); len(nested) > 0 {
+		if matcher == nil {
+			matcher = &excludeMatcher{}
+		}
+		matcher.dirs = append(matcher.dirs, nested...)
+	}
+	return matcher, nil
+}
+
+// excludes reports whether the entry at rel (slash-separated, relative to the
+// source root) is left out.
+func (m *excludeMatcher) excludes(rel string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	rel = strings.ToLower(rel)
+	if isDir {
+		for _, dir := range m.dirs {
+			if rel == dir {
+				return true
+			}
+		}
+	}
+	name := path.Base(rel)
+	names := strings.Split(rel, "/")
+	for _, pattern := range m.patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if pattern.anchored {
+			if matchSegments(pattern.segments, names) {
+				return true
+			}
+		} else if matched, _ := path.Match(pattern.segments[0], name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// stands for zero or more whole segments.
+func matchSegments(pattern, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(names); skip++ {
+				if matchSegments(pattern[1:], names[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], names[0]); !matched {
+			return false
+		}
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// presetUsage measures what a preset would leave out of a config's source.
+//
+// The walk uses the config's other traversal options but none of its
+// exclusions, so presets that are already enabled are measured too.
+func presetUsage(config BackupConfig, preset string) (files int, size int64, err error) {
+	matcher, err := newExcludeMatcher(nil, []string{preset})
+	if err != nil {
+		return 0, 0, err
+	}
+	opts := walkOptionsFor(config)
+	opts.exclude = nil
+
+	count := func(d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		size += info.Size()
+		return nil
+	}
+	err = walkTree(config.Source, opts, func(entryPath string, d fs.DirEntry) error {
+		rel, err := filepath.Rel(config.Source, entryPath)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !matcher.excludes(filepath.ToSlash(rel), d.IsDir()) {
+			return nil
+		}
+		if !d.IsDir() {
+			return count(d)
+		}
+		err = filepath.WalkDir(entryPath, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			return count(d)
+		})
+		if err != nil {
+			return err
+		}
+		return filepath.SkipDir
+	})
+	return files, size, err
+}
@@ -0,0 +1,247 @@
+// Package main - fileprogress.go reports how far a running backup and the copy
+// of a very large file are.
+//
+// A long backup that only says "backing up" can't be told apart from a hung
+// one. Each run therefore counts the files and bytes it has copied against
+// the totals measured before the copy started (see checkSpaceForBackup) and
+// publishes them, e.g. "412 of 5000 files, 1.2 GB of 8.0 GB, 15%", in the
+// config's tray entry, the tooltip and the status command.
+//
+// Within a run, a single 60 GB virtual disk or video can take an hour to
+// copy without the file count moving. Files of at least
+// largeFileThreshold are therefore read through a counting reader that
+// publishes the bytes copied and an estimate of the time left. The progress
+// is shown in the config's tray entry, the tooltip and the status command,
+// and logged about once a minute.
+//
+// Key design decisions:
+//
+// 1. Large files only: For small files an update would cost more than the
+//    copy itself, and the next file follows within moments anyway.
+//
+// 2. Throttled updates: The reader sees a chunk every few milliseconds, but
+//    the status is only updated every largeFileUpdateInterval, so the tray
+//    isn't redrawn thousands of times per file.
+//
+// 3. Estimate from the file's own rate: Copy speed depends on the drives,
+//    the network and bandwidth_limits, so the time left is extrapolated from
+//    how fast this file has been copied so far.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// largeFileThreshold is the size from which a file's copy progress is reported (1 GB)
+const largeFileThreshold = 1 << 30
+
+// Intervals between progress updates of the status and of the log; run
+// progress uses the status interval too
+const (
+	largeFileUpdateInterval = 2 * time.Second
+	largeFileLogInterval    = time.Minute
+)
+
+// largeFileEstimateDelay is how long a copy runs before its rate is trusted for an estimate
+const largeFileEstimateDelay = 10 * time.Second
+
+// FileProgress is the progress of copying one large file.
+type FileProgress struct {
+	Path    string    `json:"path"` // Source file being copied
+	Size    int64     `json:"size"`
+	Copied  int64     `json:"copied"`
+	Started time.Time `json:"started"`
+	ETA     time.Time `json:"eta,omitzero"` // Expected end of the copy; zero until the rate is known
+}
+
+// describe renders the progress as e.g. "Copying disk.vhdx: 45% of 60.0 GB, about 12 minutes left".
+func (fp FileProgress) describe(now time.Time) string {
+	text := fmt.Sprintf("Copying %s: %d%% of %s", filepath.Base(fp.Path), fp.Copied*100/max(fp.Size, 1), formatSize(fp.Size))
+	if !fp.ETA.IsZero() {
+		text += ", about " + formatUntil(fp.ETA.Sub(now)) + " left"
+	}
+	return text
+}
+
+// RunProgress is how far a running backup is.
+type RunProgress struct {
+	Files      int   `json:"files"`                // Files copied or linked so far
+	TotalFiles int   `json:"totalFiles,omitempty"` // Files the snapshot will hold; 0 if they weren't counted
+	Bytes      int64 `json:"bytes"`                // Bytes copied so far
+	TotalBytes int64 `json:"totalBytes,omitempty"` // Bytes to copy; linked files don't count
+}
+
+// describe renders the progress as e.g. "412 of 5000 files, 1.2 GB of 8.0 GB, 15%".
+func (rp RunProgress) describe() string {
+	if rp.TotalFiles == 0 {
+		return fmt.Sprintf("%d files, %s copied", rp.Files, formatSize(rp.Bytes))
+	}
+	if rp.TotalBytes == 0 {
+		// Nothing to copy: every file is linked from the previous snapshot
+		return fmt.Sprintf("%d of %d files, %d%%", rp.Files, rp.TotalFiles, min(rp.Files*100/rp.TotalFiles, 100))
+	}
+	// Linked files take no time to speak of, so the bytes tell how far the work is
+	percent := min(rp.Bytes*100/rp.TotalBytes, 100)
+	return fmt.Sprintf("%d of %d files, %s of %s, %d%%", rp.Files, rp.TotalFiles, formatSize(rp.Bytes), formatSize(rp.TotalBytes), percent)
+}
+
+// fileProgress holds the large file each config is copying, and runProgress
+// how far each running backup is, by config name
+var (
+	fileProgressMu sync.Mutex
+	fileProgress   = make(map[string]FileProgress)
+	runProgress    = make(map[string]RunProgress)
+)
+
+// fileProgressFor returns the large file a config is copying, if any.
+func fileProgressFor(name string) (FileProgress, bool) {
+	fileProgressMu.Lock()
+	defer fileProgressMu.Unlock()
+	progress, exists := fileProgress[name]
+	return progress, exists
+}
+
+// runProgressFor returns how far a config's running backup is, if one runs.
+func runProgressFor(name string) (RunProgress, bool) {
+	fileProgressMu.Lock()
+	defer fileProgressMu.Unlock()
+	progress, exists := runProgress[name]
+	return progress, exists
+}
+
+// copyProgress publishes the progress of one backup run and of the large
+// files it copies.
+//
+// Files of a run are copied one at a time, so it tracks at most one file.
+// A nil copyProgress tracks nothing.
+type copyProgress struct {
+	config    string
+	logger    *log.Logger
+	tracking  bool // A large file is being copied
+	run       RunProgress
+	published time.Time // Last status update of run
+}
+
+// newCopyProgress returns the progress tracker of a backup run of config.
+func newCopyProgress(config BackupConfig, logger *log.Logger) *copyProgress {
+	return &copyProgress{config: config.Name, logger: logger}
+}
+
+// reader wraps r, the content of the file at path, so its progress is
+// published while it is read. Files below largeFileThreshold are not tracked.
+func (cp *copyProgress) reader(r io.Reader, path string, size int64) io.Reader {
+	if cp == nil || size < largeFileThreshold {
+		return r
+	}
+	now := time.Now()
+	cp.tracking = true
+	cp.logger.Printf("Copying large file %s (%s)", path, formatSize(size))
+	pr := &progressReader{
+		r:         r,
+		cp:        cp,
+		progress:  FileProgress{Path: path, Size: size, Started: now},
+		published: now,
+		logged:    now,
+	}
+	pr.publish()
+	return pr
+}
+
+// start publishes the totals of the run: the files the snapshot will hold and
+// the bytes that have to be copied. Runs that don't measure them first count
+// without totals.
+func (cp *copyProgress) start(files int, bytes int64) {
+	if cp == nil {
+		return
+	}
+	cp.run.TotalFiles, cp.run.TotalBytes = files, bytes
+	cp.publishRun(time.Now())
+}
+
+// fileDone counts a file of the run, copied bytes long (0 for a linked file).
+func (cp *copyProgress) fileDone(copied int64) {
+	if cp == nil {
+		return
+	}
+	cp.run.Files++
+	cp.run.Bytes += copied
+	if now := time.Now(); now.Sub(cp.published) >= largeFileUpdateInterval {
+		cp.publishRun(now)
+	}
+}
+
+// finish removes the run's progress from the status once it has ended.
+func (cp *copyProgress) finish() {
+	if cp == nil {
+		return
+	}
+	fileProgressMu.Lock()
+	delete(runProgress, cp.config)
+	fileProgressMu.Unlock()
+	requestStatusUpdate()
+}
+
+// publishRun makes the run's progress visible to the status display.
+func (cp *copyProgress) publishRun(now time.Time) {
+	cp.published = now
+	fileProgressMu.Lock()
+	runProgress[cp.config] = cp.run
+	fileProgressMu.Unlock()
+	statusEvents.publish(StatusEvent{Kind: StatusProgress, Config: cp.config, Progress: cp.run})
+}
+
+// done ends tracking of the file copied last, whether or not it succeeded.
+func (cp *copyProgress) done() {
+	if cp == nil || !cp.tracking {
+		return
+	}
+	cp.tracking = false
+	fileProgressMu.Lock()
+	delete(fileProgress, cp.config)
+	fileProgressMu.Unlock()
+	requestStatusUpdate()
+}
+
+// progressReader counts the bytes read from a large file and publishes them.
+type progressReader struct {
+	r         io.Reader
+	cp        *copyProgress
+	progress  FileProgress
+	published time.Time // Last status update
+	logged    time.Time // Last log line
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.progress.Copied += int64(n)
+
+	now := time.Now()
+	if now.Sub(pr.published) < largeFileUpdateInterval {
+		return n, err
+	}
+	pr.published = now
+	if elapsed := now.Sub(pr.progress.Started); elapsed >= largeFileEstimateDelay && pr.progress.Copied > 0 {
+		remaining := float64(pr.progress.Size-pr.progress.Copied) / float64(pr.progress.Copied) * float64(elapsed)
+		pr.progress.ETA = now.Add(time.Duration(remaining))
+	}
+	pr.publish()
+
+	if now.Sub(pr.logged) >= largeFileLogInterval {
+		pr.logged = now
+		pr.cp.logger.Print(pr.progress.describe(now))
+	}
+	return n, err
+}
+
+// publish makes the current progress visible to the status display.
+func (pr *progressReader) publish() {
+	fileProgressMu.Lock()
+	fileProgress[pr.cp.config] = pr.progress
+	fileProgressMu.Unlock()
+	requestStatusUpdate()
+}
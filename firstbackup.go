@@ -0,0 +1,89 @@
+// Package main - firstbackup.go controls when a newly added configuration first runs.
+//
+// A config with no existing snapshots normally backs up as soon as the
+// scheduler starts. For a large source that means an immediate full copy at
+// whatever moment the config happened to be added, so the first_backup
+// option can postpone it to the next scheduled slot or hold it until the
+// user confirms it from the tray.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// First backup policies
+const (
+	FirstBackupImmediate = "immediate" // Run as soon as the scheduler starts (default)
+	FirstBackupScheduled = "scheduled" // Run after one schedule interval
+	FirstBackupConfirm   = "confirm"   // Wait until confirmed from the tray
+)
+
+// pendingFirstBackups holds a channel per config waiting for confirmation
+var (
+	pendingFirstBackupsMu sync.Mutex
+	pendingFirstBackups   = make(map[string]chan struct{})
+)
+
+// isNewConfig reports whether a configuration has never been backed up or checked.
+func isNewConfig(config BackupConfig) bool {
+	return backupStatus.findLastBackupTime(config).IsZero() && hashManager.getLastActionTime(config.Name).IsZero()
+}
+
+// awaitFirstBackupConfirmation blocks until the first backup is confirmed or ctx ends.
+//
+// Returns false if ctx was cancelled. While waiting the config is shown as an
+// alert and has no next backup time.
+func awaitFirstBackupConfirmation(ctx context.Context, config BackupConfig, logger *log.Logger) bool {
+	confirmed := make(chan struct{})
+	pendingFirstBackupsMu.Lock()
+	pendingFirstBackups[config.Name] = confirmed
+	pendingFirstBackupsMu.Unlock()
+
+	defer func() {
+		pendingFirstBackupsMu.Lock()
+		delete(pendingFirstBackups, config.Name)
+		pendingFirstBackupsMu.Unlock()
+		backupStatus.clearAlert(config.Name)
+	}()
+
+	logger.Printf("First backup of %s is waiting for confirmation from the tray", config.Name)
+	backupStatus.removeSchedule(config.Name)
+	backupStatus.setAlert(config.Name, "first backup awaiting confirmation")
+	notifyUser("New backup waiting: "+config.Name,
+		fmt.Sprintf("The first backup of %s will start once you confirm it from the tray menu.", config.Source))
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-confirmed:
+		logger.Printf("First backup of %s confirmed", config.Name)
+		return true
+	}
+}
+
+// hasPendingFirstBackup reports whether a config is waiting for first backup confirmation.
+func hasPendingFirstBackup(name string) bool {
+	pendingFirstBackupsMu.Lock()
+	defer pendingFirstBackupsMu.Unlock()
+	_, pending := pendingFirstBackups[name]
+	return pending
+}
+
+// confirmFirstBackup releases a config waiting for confirmation.
+//
+// Returns false if the config was not waiting (e.g. already confirmed).
+func confirmFirstBackup(name string) bool {
+	pendingFirstBackupsMu.Lock()
+	defer pendingFirstBackupsMu.Unlock()
+
+	confirmed, pending := pendingFirstBackups[name]
+	if !pending {
+		return false
+	}
+	close(confirmed)
+	delete(pendingFirstBackups, name)
+	return true
+}
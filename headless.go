@@ -0,0 +1,57 @@
+// Package main - headless.go runs the backup schedulers without a system tray.
+//
+// Servers, WSL and Linux machines without a desktop have no tray to show the
+// icon in, and systray fails to start there. Started with --headless, the
+// application runs everything except the tray: schedulers, replication,
+// config reloading and the IPC server the CLI talks to. It runs in the
+// foreground, logs to stderr as well as logs/system.log, and exits on
+// Ctrl+C or SIGTERM, so a service manager such as systemd can run it.
+// Starting the tray application while it runs opens a tray client showing
+// its status instead of a second engine (see trayclient.go).
+//
+// Key design decisions:
+//
+// 1. A flag, not a build tag: One binary serves both desktops and servers,
+//    and the same machine can run either way.
+//
+// 2. No confirmation prompts: first_backup "confirm" is confirmed from the
+//    tray, so headless mode defers those first backups to the next
+//    scheduled slot instead of waiting forever.
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// headlessFlag starts the application without a system tray
+const headlessFlag = "--headless"
+
+// headless is set when running without a system tray
+var headless bool
+
+// runHeadless starts all schedulers and blocks until a signal asks it to exit.
+//
+// Returns an error if the configuration could not be loaded.
+func runHeadless() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := startApplication(ctx, nil); err != nil {
+		return err
+	}
+	log.Printf("Running headless; press Ctrl+C or send SIGTERM to exit")
+
+	signalExit, forceExit := watchSignals()
+	<-signalExit
+
+	// Same shutdown as the tray's Exit item: stop the schedulers, then let
+	// running backups finish within the grace period
+	cancel()
+	backupRunner.beginShutdown()
+	settings := currentSettings()
+	waitForOperations(time.Duration(settings.GetShutdownGraceMinutes())*time.Minute, nil, forceExit)
+	log.Printf("Application exiting...")
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package main - hooks.go runs per-config commands before and after backups.
+//
+// Some sources need preparing before they can be copied consistently, most
+// commonly a database that must be dumped to a file inside the source folder.
+// A config's hooks section names a command to run before each backup and one
+// to run after each new snapshot.
+//
+// Key design decisions:
+//
+// 1. Own environment and working directory: Hook processes inherit the
+//    application's environment plus the variables given in hooks.env, and run
+//    in hooks.dir (the source folder by default). Connection settings can live
+//    there instead of inline in the command string.
+//
+// 2. Secrets come from the credential store: An env value of the form
+//    "credential:<name>" is looked up in the operating system's credential
+//    store when the hook runs, so config.json never holds the secret. Resolved
+//    values are registered for log redaction before the hook starts.
+//
+// 3. The pre-backup hook gates the backup: It runs before change detection
+//    (it may write into the source), and if it fails the run fails rather than
+//    snapshotting a half-written dump. A failing post-backup hook is only
+//    logged, since the snapshot already exists.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// credentialPrefix marks hook env values that are looked up in the credential store
+const credentialPrefix = "credential:"
+
+// hookTimeout stops hooks that hang so the config's schedule isn't blocked forever
+const hookTimeout = time.Hour
+
+// runPreBackupHook runs a config's pre-backup command, if any.
+func runPreBackupHook(config BackupConfig, logger *log.Logger) error {
+	if config.Hooks == nil || config.Hooks.PreBackup == "" {
+		return nil
+	}
+	if err := runHook(config, "pre_backup", config.Hooks.PreBackup, nil, logger); err != nil {
+		return withCode(CodeHookFailed, fmt.Errorf("pre_backup hook failed: %w", err))
+	}
+	return nil
+}
+
+// runPostBackupHook runs a config's post-backup command after a new snapshot.
+func runPostBackupHook(config BackupConfig, result BackupResult, logger *log.Logger) {
+	if config.Hooks == nil || config.Hooks.PostBackup == "" || result.Snapshot == "" {
+		return
+	}
+	extra := []string{"SFB_SNAPSHOT=" + result.Snapshot, "SFB_OUTCOME=" + result.Outcome}
+	if err := runHook(config, "post_backup", config.Hooks.PostBackup, extra, logger); err != nil {
+		logger.Printf("post_backup hook failed for %s: %v", config.Name, err)
+	}
+}
+
+// runHook executes one hook command through the platform shell and logs its output.
+func runHook(config BackupConfig, stage, command string, extraEnv []string, logger *log.Logger) error {
+	env, err := hookEnvironment(config)
+	if err != nil {
+		return err
+	}
+	env = append(env, extraEnv...)
+
+	dir := config.Hooks.Dir
+	if dir == "" {
+		dir = config.Source
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Dir = dir
+	cmd.Env = env
+	hideHookWindow(cmd)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	logger.Printf("Running %s hook for %s", stage, config.Name)
+	started := time.Now()
+	err = cmd.Run()
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\r\n"), "\n") {
+		if line != "" {
+			logger.Printf("[%s] %s", stage, strings.TrimRight(line, "\r"))
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", hookTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Printf("%s hook for %s finished in %v", stage, config.Name, time.Since(started).Round(time.Second))
+	return nil
+}
+
+// hookEnvironment builds the environment of a config's hook processes.
+//
+// Besides hooks.env, hooks see SFB_CONFIG, SFB_SOURCE and SFB_DESTINATION so a
+// single script can serve several configs.
+func hookEnvironment(config BackupConfig) ([]string, error) {
+	env := append(os.Environ(),
+		"SFB_CONFIG="+config.Name,
+		"SFB_SOURCE="+config.Source,
+		"SFB_DESTINATION="+config.Destination,
+	)
+
+	names := make([]string, 0, len(config.Hooks.Env))
+	for name := range config.Hooks.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := config.Hooks.Env[name]
+		if target, isCredential := strings.CutPrefix(value, credentialPrefix); isCredential {
+			secret, err := readCredential(target)
+			if err != nil {
+				return nil, fmt.Errorf("env %s: failed to read credential %q: %v", name, target, err)
+			}
+			registerSecret(secret)
+			value = secret
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
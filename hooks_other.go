@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+import "os/exec"
+
+// hideHookWindow is a no-op: hooks run without a window on other platforms.
+func hideHookWindow(cmd *exec.Cmd) {}
@@ -0,0 +1,97 @@
+// Package main - hotkey.go implements global keyboard shortcuts for manual backups.
+//
+// Before a risky operation (a bulk rename, a git rebase, an installer) users
+// want a backup right now, without hunting for the tray icon. Each entry of
+// the hotkeys setting binds a key combination to "back up now" for one
+// config or for all of them.
+//
+// Registration is platform-specific (see hotkey_windows.go); this file holds
+// parsing and the action itself.
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// hotkey is a parsed key combination.
+type hotkey struct {
+	ctrl, alt, shift, win bool
+	key                   string // Upper-case key name: "A"-"Z", "0"-"9" or "F1"-"F24"
+}
+
+// parseHotkey parses a combination such as "Ctrl+Alt+B".
+//
+// At least one modifier is required: a bare letter registered globally would
+// swallow that key in every other application.
+func parseHotkey(keys string) (hotkey, error) {
+	var hk hotkey
+	parts := strings.Split(keys, "+")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if i < len(parts)-1 {
+			switch strings.ToLower(part) {
+			case "ctrl", "control":
+				hk.ctrl = true
+			case "alt":
+				hk.alt = true
+			case "shift":
+				hk.shift = true
+			case "win", "windows", "super":
+				hk.win = true
+			default:
+				return hotkey{}, fmt.Errorf("unknown modifier %q in hotkey %q", part, keys)
+			}
+			continue
+		}
+
+		hk.key = strings.ToUpper(part)
+		if !isHotkeyKey(hk.key) {
+			return hotkey{}, fmt.Errorf("unsupported key %q in hotkey %q (use A-Z, 0-9 or F1-F24)", part, keys)
+		}
+	}
+	if !hk.ctrl && !hk.alt && !hk.shift && !hk.win {
+		return hotkey{}, fmt.Errorf("hotkey %q needs at least one of Ctrl, Alt, Shift or Win", keys)
+	}
+	return hk, nil
+}
+
+// isHotkeyKey reports whether a key name can be bound.
+func isHotkeyKey(key string) bool {
+	if len(key) == 1 {
+		return (key[0] >= 'A' && key[0] <= 'Z') || (key[0] >= '0' && key[0] <= '9')
+	}
+	var n int
+	if _, err := fmt.Sscanf(key, "F%d", &n); err == nil && key == fmt.Sprintf("F%d", n) {
+		return n >= 1 && n <= 24
+	}
+	return false
+}
+
+// triggerHotkey starts the backups bound to a hotkey.
+//
+// Configs are looked up when the key is pressed, so "all configs" includes
+// configs added while the application is running.
+func triggerHotkey(binding HotkeySettings) {
+	if err := ensureWritable(AuditInterfaceHotkey, "backup-now", binding.Config); err != nil {
+		notifyUser("Backup not started", err.Error())
+		return
+	}
+	var names []string
+	for _, config := range backupRunner.registeredConfigs() {
+		if binding.Config == "" || config.Name == binding.Config {
+			names = append(names, config.Name)
+		}
+	}
+	if len(names) == 0 {
+		log.Printf("Hotkey %s: no active backup configuration named %q", binding.Keys, binding.Config)
+		return
+	}
+
+	for _, name := range names {
+		auditLog.record(AuditInterfaceHotkey, "backup-now", name, binding.Keys)
+		go backupRunner.runByName(name)
+	}
+	notifyUser("Backup started", "Backing up "+joinNames(names))
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import "log"
+
+// startHotkeys reports that global hotkeys aren't available on this platform.
+func startHotkeys(bindings []HotkeySettings) {
+	if len(bindings) > 0 {
+		log.Printf("Global hotkeys are only supported on Windows; ignoring %d configured hotkeys", len(bindings))
+	}
+}
@@ -0,0 +1,94 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"syscall"
+)
+
+// Hotkey window message and modifier flags for RegisterHotKey
+const (
+	WM_HOTKEY = 0x0312
+
+	MOD_ALT      = 0x0001
+	MOD_CONTROL  = 0x0002
+	MOD_SHIFT    = 0x0004
+	MOD_WIN      = 0x0008
+	MOD_NOREPEAT = 0x4000 // Holding the keys down doesn't start repeated backups
+)
+
+var procRegisterHotKey = user32.NewProc("RegisterHotKey")
+
+// startHotkeys registers the configured hotkeys on a hidden message window.
+//
+// A combination already taken by another application can't be registered;
+// that is logged and the remaining hotkeys still work.
+func startHotkeys(bindings []HotkeySettings) {
+	if len(bindings) == 0 {
+		return
+	}
+
+	handler := func(hwnd syscall.Handle, msg uint32, wParam, lParam uintptr) (uintptr, bool) {
+		if msg != WM_HOTKEY {
+			return 0, false
+		}
+		// Hotkey IDs are binding indexes plus one
+		if id := int(wParam); id >= 1 && id <= len(bindings) {
+			go triggerHotkey(bindings[id-1])
+		}
+		return 0, true
+	}
+
+	ready := func(hwnd syscall.Handle) {
+		for i, binding := range bindings {
+			hk, err := parseHotkey(binding.Keys)
+			if err != nil {
+				log.Printf("Ignoring hotkey: %v", err)
+				continue
+			}
+			ret, _, err := procRegisterHotKey.Call(uintptr(hwnd), uintptr(i+1), uintptr(hotkeyModifiers(hk)), uintptr(hotkeyVirtualKey(hk)))
+			if ret == 0 {
+				log.Printf("Failed to register hotkey %s (already used by another application?): %v", binding.Keys, err)
+				continue
+			}
+			log.Printf("Registered hotkey %s", binding.Keys)
+		}
+	}
+
+	if err := startMessageWindow("SimpleFolderBackupHotkeys", handler, ready); err != nil {
+		log.Printf("Failed to set up hotkeys: %v", err)
+	}
+}
+
+// hotkeyModifiers converts a parsed hotkey into RegisterHotKey modifier flags.
+func hotkeyModifiers(hk hotkey) uint32 {
+	mods := uint32(MOD_NOREPEAT)
+	if hk.ctrl {
+		mods |= MOD_CONTROL
+	}
+	if hk.alt {
+		mods |= MOD_ALT
+	}
+	if hk.shift {
+		mods |= MOD_SHIFT
+	}
+	if hk.win {
+		mods |= MOD_WIN
+	}
+	return mods
+}
+
+// hotkeyVirtualKey returns the Windows virtual-key code of a parsed hotkey.
+//
+// Letters and digits use their ASCII codes; VK_F1 is 0x70.
+func hotkeyVirtualKey(hk hotkey) uint32 {
+	if len(hk.key) == 1 {
+		return uint32(hk.key[0])
+	}
+	var n uint32
+	for _, c := range hk.key[1:] {
+		n = n*10 + uint32(c-'0')
+	}
+	return 0x70 + n - 1
+}
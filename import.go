@@ -0,0 +1,312 @@
+// Package main - import.go translates other backup tools' settings into backup configs.
+//
+// Users migrating from another tool would otherwise re-enter every folder by
+// hand. The importers read what they can and produce BackupConfig entries as a
+// starting point; options with no equivalent here are reported as warnings
+// rather than silently dropped.
+//
+// Supported formats:
+// - filehistory: Windows File History configuration (Config1.xml), one config
+//    per protected folder
+// - robocopy: Batch or PowerShell scripts, one config per robocopy command
+// - syncback: SyncBack profiles exported as plain-text INI files
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Import formats accepted by the import command
+const (
+	ImportFileHistory = "filehistory"
+	ImportRobocopy    = "robocopy"
+	ImportSyncBack    = "syncback"
+)
+
+// importResult holds the configs read from another tool's settings.
+type importResult struct {
+	Configs  []BackupConfig
+	Warnings []string // Settings that could not be carried over
+}
+
+// importConfigs reads a settings file in the given format.
+func importConfigs(format, path string) (importResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return importResult{}, err
+	}
+	defer f.Close()
+
+	switch format {
+	case ImportFileHistory:
+		return importFileHistory(f)
+	case ImportRobocopy:
+		return importRobocopy(f)
+	case ImportSyncBack:
+		return importSyncBack(f)
+	default:
+		return importResult{}, fmt.Errorf("unknown import format %q (use %s, %s or %s)", format, ImportFileHistory, ImportRobocopy, ImportSyncBack)
+	}
+}
+
+// newImportedConfig creates a config with the defaults used for imports.
+//
+// Imported configs start disabled so nothing runs before the user reviewed them.
+func newImportedConfig(source, destination string) BackupConfig {
+	enabled := false
+	return BackupConfig{
+		Name:            importPathBase(source),
+		Source:          source,
+		Destination:     destination,
+		ScheduleMinutes: addedScheduleMinutes,
+		RotationCount:   addedRotationCount,
+		Enabled:         &enabled,
+	}
+}
+
+// importFileHistory reads a File History Config1.xml.
+//
+// Protected folders are listed as Library/Folder and UserFolder elements, the
+// backup drive as Target/TargetUrl and the interval in seconds as DPFrequency.
+// Each folder gets its own subfolder on the target drive.
+func importFileHistory(r io.Reader) (importResult, error) {
+	var result importResult
+	var folders, excluded []string
+	var target string
+	frequency := 0
+
+	decoder := xml.NewDecoder(r)
+	var element string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return importResult{}, fmt.Errorf("invalid File History configuration: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			element = t.Name.Local
+		case xml.EndElement:
+			element = ""
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" {
+				continue
+			}
+			switch element {
+			case "Folder", "UserFolder":
+				folders = append(folders, text)
+			case "FolderExclude":
+				excluded = append(excluded, text)
+			case "TargetUrl":
+				target = text
+			case "DPFrequency":
+				if seconds, err := strconv.Atoi(text); err == nil && seconds > 0 {
+					frequency = seconds
+				}
+			}
+		}
+	}
+
+	if len(folders) == 0 {
+		return importResult{}, fmt.Errorf("no protected folders found in File History configuration")
+	}
+	if target == "" {
+		result.Warnings = append(result.Warnings, "No File History drive found; set each destination by hand")
+	}
+	for _, folder := range folders {
+		destination := ""
+		if target != "" {
+			destination = importPathJoin(target, "SimpleFolderBackup", importPathBase(folder))
+		}
+		config := newImportedConfig(folder, destination)
+		if frequency > 0 {
+			config.ScheduleMinutes = max(frequency/60, 1)
+		}
+		result.Configs = append(result.Configs, config)
+	}
+	for _, folder := range excluded {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("Excluded folder not carried over: %s", folder))
+	}
+	return result, nil
+}
+
+// importRobocopy reads robocopy commands from a batch or PowerShell script.
+//
+// Each "robocopy <source> <destination> [files] [options]" line becomes a
+// config. Snapshots replace mirroring, so copy options are dropped; options
+// that narrow what is copied are reported since they have no equivalent.
+func importRobocopy(r io.Reader) (importResult, error) {
+	var result importResult
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		args := splitCommandLine(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		command := strings.ToLower(filepath.Base(strings.ReplaceAll(args[0], `\`, "/")))
+		if command != "robocopy" && command != "robocopy.exe" {
+			continue
+		}
+		if len(args) < 3 || strings.HasPrefix(args[1], "/") || strings.HasPrefix(args[2], "/") || strings.ContainsAny(args[2], "*?") {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Line %d: robocopy without source and destination skipped", lineNumber))
+			continue
+		}
+
+		config := newImportedConfig(args[1], args[2])
+		result.Configs = append(result.Configs, config)
+		inExclusion := false // /XD and /XF take the following arguments as values
+		for _, arg := range args[3:] {
+			option := strings.ToUpper(strings.SplitN(arg, ":", 2)[0])
+			switch {
+			case !strings.HasPrefix(arg, "/"):
+				if !inExclusion {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("%s: file filter %q not carried over, all files are backed up", config.Name, arg))
+				}
+			case option == "/XD" || option == "/XF":
+				inExclusion = true
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s: exclusions (%s) not carried over", config.Name, option))
+			case option == "/LEV" || option == "/MAXAGE" || option == "/MINAGE" || option == "/MAX" || option == "/MIN":
+				inExclusion = false
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s: option %s not carried over", config.Name, option))
+			default:
+				inExclusion = false
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return importResult{}, err
+	}
+	if len(result.Configs) == 0 {
+		return importResult{}, fmt.Errorf("no robocopy commands found")
+	}
+	return result, nil
+}
+
+// splitCommandLine splits a script line into arguments, honoring double quotes.
+//
+// Comment lines (REM, ::, #) yield no arguments.
+func splitCommandLine(line string) []string {
+	trimmed := strings.TrimSpace(line)
+	upper := strings.ToUpper(trimmed)
+	if trimmed == "" || strings.HasPrefix(trimmed, "::") || strings.HasPrefix(trimmed, "#") || upper == "REM" || strings.HasPrefix(upper, "REM ") {
+		return nil
+	}
+
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+	for _, r := range trimmed {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// importSyncBack reads SyncBack profiles exported as INI text.
+//
+// Each section is a profile; its Source and Destination keys (or the Src/Dest
+// and Target spellings of older versions) become a config named after the
+// section. Binary or encrypted profile files must be exported as text first.
+func importSyncBack(r io.Reader) (importResult, error) {
+	var result importResult
+	var name string
+	values := make(map[string]string)
+
+	flush := func() {
+		if name == "" {
+			return
+		}
+		source := firstValue(values, "source", "src")
+		destination := firstValue(values, "destination", "dest", "target")
+		if source == "" || destination == "" {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Profile %q has no source or destination and was skipped", name))
+			return
+		}
+		config := newImportedConfig(source, destination)
+		config.Name = name
+		result.Configs = append(result.Configs, config)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			flush()
+			name = strings.TrimSpace(line[1 : len(line)-1])
+			values = make(map[string]string)
+		default:
+			if key, value, found := strings.Cut(line, "="); found {
+				values[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return importResult{}, err
+	}
+	flush()
+
+	if len(result.Configs) == 0 {
+		return importResult{}, fmt.Errorf("no SyncBack profiles with a source and destination found")
+	}
+	return result, nil
+}
+
+// importPathBase returns the last element of a path from another tool's settings.
+//
+// Those settings are written on Windows, so backslashes separate elements
+// regardless of the platform running the import.
+func importPathBase(path string) string {
+	path = strings.TrimRight(path, `\/`)
+	if i := strings.LastIndexAny(path, `\/`); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+// importPathJoin joins path elements using the separator style of the first one.
+func importPathJoin(first string, elems ...string) string {
+	if !strings.Contains(first, `\`) {
+		return filepath.Join(append([]string{first}, elems...)...)
+	}
+	return strings.TrimRight(first, `\`) + `\` + strings.Join(elems, `\`)
+}
+
+// firstValue returns the first non-empty value among the given keys.
+func firstValue(values map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := values[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,202 @@
+// Package main - incremental.go links unchanged files into new snapshots.
+//
+// Every snapshot is a complete copy of the source, which is what makes it
+// browsable and restorable on its own, but for a mostly static folder it also
+// means storing the same files again on every run. With incremental enabled,
+// a file that hasn't changed since the previous snapshot is hardlinked from
+// that snapshot instead of copied, like rsync --link-dest: each snapshot stays
+// a complete tree while unchanged files take no extra space.
+//
+// Key design decisions:
+//
+// 1. Unchanged means same size, mtime and mode: The previous snapshot's
+//    manifest records each file's source mtime, so unchanged files are found
+//    without reading them. Files modified within racyWindow of being copied
+//    get no recorded mtime and are copied again next time.
+//
+// 2. Or same content: With warm_cache, the change check has just hashed every
+//    changed file, so a file whose mtime moved but whose hash still matches
+//    the manifest (touched, or rewritten identically by a sync client) is
+//    linked as well, without reading it again.
+//
+// 3. Newest snapshot only: Files are linked from the newest snapshot that has
+//    a manifest. Older snapshots are already linked to it for unchanged files.
+//
+// 4. Hardlink, then reflink, then copy: Where a hardlink fails (FAT drives,
+//    too many links to one file), a reflink is tried on filesystems that
+//    support it (Btrfs, XFS on Linux), and otherwise the file is copied as
+//    usual. A failed link never fails the backup.
+//
+// 5. Linked files are shared: Snapshots are never modified after they are
+//    written, so sharing the file between them is safe. Deleting a snapshot
+//    keeps the file for the snapshots still linking to it.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileID identifies a file on disk regardless of how many names it has
+type fileID struct {
+	volume uint64
+	index  uint64
+}
+
+// linkBase is the previous snapshot that unchanged files are linked from.
+type linkBase struct {
+	dir     string                  // Snapshot directory
+	files   map[string]ManifestFile // Its manifest entries
+	current map[string]cachedFile   // Per-file hashes of the source from the change check, nil if unknown
+	source  string                  // Source folder, for hashing moved files
+
+	// Set after a restructure (see restructure.go), nil otherwise
+	byHash map[string]string // Manifest path by content hash
+	sizes  map[int64]bool    // Sizes of the files in byHash
+
+	mu        sync.Mutex
+	linked    int   // Files linked so far
+	byContent int   // Of those, files whose mtime changed but content didn't
+	moved     int   // Of those, files found by content at a new path
+	bytes     int64 // Their total size
+	failed    int   // Unchanged files that could not be linked and were copied
+}
+
+// findLinkBase returns the newest snapshot of config with a manifest, or nil
+// if incremental is off or there is no such snapshot.
+func findLinkBase(config BackupConfig, logger *log.Logger) *linkBase {
+	if !config.Incremental {
+		return nil
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		return nil
+	}
+	for _, snapshot := range snapshots {
+		manifest, err := loadManifest(config.Destination, snapshot.Name)
+		if err == nil {
+			base := &linkBase{dir: snapshot.Path, files: manifest.Files, current: hashManager.fileHashes(config), source: config.Source}
+			base.detectRestructure(config, logger)
+			return base
+		}
+		if !os.IsNotExist(err) {
+			logger.Printf("Not linking from snapshot %s: %v", snapshot.Name, err)
+		}
+	}
+	return nil
+}
+
+// sourceEntry returns the manifest entry of a source file as it is about to
+// be copied. The mtime is left out for files changed too recently to be sure
+// a later change would move it.
+func sourceEntry(info os.FileInfo) ManifestFile {
+	entry := ManifestFile{Size: info.Size()}
+	if time.Since(info.ModTime()) > racyWindow {
+		entry.ModTime = info.ModTime().UnixNano()
+	}
+	return entry
+}
+
+// link places the previous snapshot's copy of rel at dst if the source file
+// (described by info) is unchanged since then.
+//
+// Returns the manifest entry of the linked file and true on success; false
+// means the file has to be copied. A nil base never links.
+func (lb *linkBase) link(rel string, info os.FileInfo, dst string) (ManifestFile, bool) {
+	if lb == nil {
+		return ManifestFile{}, false
+	}
+	previous, exists := lb.files[filepath.ToSlash(rel)]
+	if !exists {
+		return lb.linkMoved(rel, info, dst)
+	}
+	sameTime, unchanged := lb.unchanged(rel, info, previous)
+	if !unchanged {
+		return ManifestFile{}, false
+	}
+	if !lb.place(filepath.Join(lb.dir, rel), dst, info) {
+		return ManifestFile{}, false
+	}
+
+	lb.mu.Lock()
+	lb.linked++
+	if !sameTime {
+		lb.byContent++
+	}
+	lb.bytes += previous.Size
+	lb.mu.Unlock()
+	previous.ModTime = sourceEntry(info).ModTime
+	return previous, true
+}
+
+// previous returns the previous snapshot's manifest entry of rel, if it has
+// one. A nil base has none.
+func (lb *linkBase) previous(rel string) (ManifestFile, bool) {
+	if lb == nil {
+		return ManifestFile{}, false
+	}
+	previous, exists := lb.files[filepath.ToSlash(rel)]
+	return previous, exists
+}
+
+// unchanged reports whether the source file at rel, described by info, is
+// unchanged since it was saved as previous, and whether that was decided by
+// its mtime rather than its content hash.
+func (lb *linkBase) unchanged(rel string, info os.FileInfo, previous ManifestFile) (sameTime, unchanged bool) {
+	current := sourceEntry(info)
+	if previous.Size != current.Size {
+		return false, false
+	}
+	sameTime = previous.ModTime != 0 && previous.ModTime == current.ModTime
+	return sameTime, sameTime || lb.sameContent(rel, info, previous)
+}
+
+// place links or clones the previous snapshot's file src to dst, if it
+// still has the size and mode of the source file described by info.
+func (lb *linkBase) place(src, dst string, info os.FileInfo) bool {
+	stored, err := os.Stat(src)
+	if err != nil || stored.Size() != info.Size() || stored.Mode() != info.Mode() {
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return false
+	}
+	if err := os.Link(src, dst); err != nil {
+		if err := cloneFile(src, dst); err != nil {
+			lb.mu.Lock()
+			lb.failed++
+			lb.mu.Unlock()
+			return false
+		}
+	}
+	return true
+}
+
+// sameContent reports whether the change check hashed the source file at rel,
+// as it is now, to the content of the previous snapshot's copy.
+func (lb *linkBase) sameContent(rel string, info os.FileInfo, previous ManifestFile) bool {
+	cached, known := lb.current[filepath.ToSlash(rel)]
+	return known && !cached.Racy && previous.SHA256 != "" &&
+		cached.Size == info.Size() && cached.ModTime == info.ModTime().UnixNano() && cached.Hash == previous.SHA256
+}
+
+// summary describes the files linked so far, for the backup log.
+func (lb *linkBase) summary() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	summary := fmt.Sprintf("Linked %d unchanged file(s) (%s) from snapshot %s", lb.linked, formatSize(lb.bytes), filepath.Base(lb.dir))
+	if lb.byContent > 0 {
+		summary += fmt.Sprintf(", %d of them found unchanged by content hash", lb.byContent)
+	}
+	if lb.moved > 0 {
+		summary += fmt.Sprintf(", %d of them moved or renamed", lb.moved)
+	}
+	if lb.failed > 0 {
+		summary += fmt.Sprintf("; %d could not be linked and were copied", lb.failed)
+	}
+	return summary
+}
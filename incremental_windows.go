@@ -0,0 +1,35 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// cloneFile would create dst as a block clone of src. ReFS block cloning is
+// not implemented, so on Windows unchanged files are hardlinked or copied.
+func cloneFile(src, dst string) error {
+	return errors.ErrUnsupported
+}
+
+// fileIdentity returns the identity of a file with more than one hardlink,
+// so a file linked into several snapshots can be counted once.
+//
+// FileInfo from a directory listing carries no link count on Windows, so the
+// file is opened to ask for it.
+func fileIdentity(path string, info fs.FileInfo) (fileID, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	defer file.Close()
+
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), &data); err != nil || data.NumberOfLinks < 2 {
+		return fileID{}, false
+	}
+	return fileID{volume: uint64(data.VolumeSerialNumber), index: uint64(data.FileIndexHigh)<<32 | uint64(data.FileIndexLow)}, true
+}
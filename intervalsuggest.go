@@ -0,0 +1,123 @@
+// Package main - intervalsuggest.go suggests better schedule intervals from run history.
+//
+// schedule_minutes is usually a guess made on the first day. Guessed too
+// short, the folder is hashed every few minutes to find nothing new; guessed
+// too long, a day of work sits between snapshots. With hash checking on,
+// the run statistics already tell which it is: the share of checks that found
+// changed content. The report turns that into a suggestion, e.g. "changed in
+// only 4% of 120 checks; consider every 4 hours".
+//
+// Suggestions are advice only: the schedule is never changed automatically,
+// and a config without enough history gets no suggestion at all.
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Run history a suggestion is based on
+const (
+	suggestionDays      = 30 // Days of run statistics considered
+	suggestionMinDays   = 7  // Days with runs needed before suggesting anything
+	suggestionMinChecks = 20 // Successful checks needed before suggesting anything
+)
+
+// Change ratios outside which an interval is considered badly chosen
+const (
+	rareChangeRatio     = 0.10 // Longer intervals are suggested below this
+	frequentChangeRatio = 0.95 // Shorter intervals are suggested from this
+)
+
+// frequentChangeFloorMinutes is the shortest interval suggested for content
+// that changes all the time; below it hashing and copying dominate
+const frequentChangeFloorMinutes = 60
+
+// suggestedIntervals are the intervals suggestions are rounded to, in minutes
+var suggestedIntervals = []int{15, 30, 60, 120, 240, 480, 720, 1440}
+
+// IntervalSuggestion is a recommended schedule interval for a config.
+type IntervalSuggestion struct {
+	Minutes int    // Suggested schedule_minutes
+	Reason  string // What the run history showed
+}
+
+// suggestInterval suggests a schedule interval from the config's recent runs.
+//
+// Returns false if the current interval fits or there is not enough history.
+func suggestInterval(config BackupConfig) (IntervalSuggestion, bool) {
+	// Without hash checking every run copies, so unchanged content can't be told apart
+	if !config.IsHashCheckEnabled() || config.ScheduleMinutes <= 0 {
+		return IntervalSuggestion{}, false
+	}
+	counts := runStats.summary(config.Name, suggestionDays)
+	checks := counts.total() - counts.Failure
+	if checks < suggestionMinChecks || runStats.activeDays(config.Name, suggestionDays) < suggestionMinDays {
+		return IntervalSuggestion{}, false
+	}
+
+	changed := counts.Success + counts.Partial
+	ratio := float64(changed) / float64(checks)
+	current := config.ScheduleMinutes
+	switch {
+	case ratio < rareChangeRatio:
+		// About two checks per change still back up each change soon after it happens
+		perChange := float64(current) * float64(checks) / float64(max(changed, 1))
+		minutes := roundDownInterval(perChange / 2)
+		if minutes < 2*current {
+			return IntervalSuggestion{}, false
+		}
+		return IntervalSuggestion{
+			Minutes: minutes,
+			Reason:  fmt.Sprintf("content changed in only %s of %d checks over the last %d days", formatPercent(ratio), checks, suggestionDays),
+		}, true
+	case ratio >= frequentChangeRatio && current > frequentChangeFloorMinutes:
+		// Every check finds changes, so each snapshot bundles more work than needed
+		minutes := max(roundDownInterval(float64(current)/2), frequentChangeFloorMinutes)
+		return IntervalSuggestion{
+			Minutes: minutes,
+			Reason: fmt.Sprintf("content changed in %s of %d checks over the last %d days, so changes wait up to %s for a snapshot",
+				formatPercent(ratio), checks, suggestionDays, formatInterval(current)),
+		}, true
+	}
+	return IntervalSuggestion{}, false
+}
+
+// roundDownInterval returns the longest of suggestedIntervals not above minutes.
+func roundDownInterval(minutes float64) int {
+	rounded := suggestedIntervals[0]
+	for _, interval := range suggestedIntervals {
+		if float64(interval) <= minutes {
+			rounded = interval
+		}
+	}
+	return rounded
+}
+
+// formatPercent renders a ratio as a whole percentage; tiny non-zero ratios show as "<1%".
+func formatPercent(ratio float64) string {
+	if ratio > 0 && ratio < 0.005 {
+		return "<1%"
+	}
+	return fmt.Sprintf("%.0f%%", math.Round(ratio*100))
+}
+
+// formatInterval renders a schedule interval, e.g. "30 minutes", "4 hours" or "1 day".
+func formatInterval(minutes int) string {
+	switch {
+	case minutes%1440 == 0:
+		return pluralize(minutes/1440, "day")
+	case minutes%60 == 0:
+		return pluralize(minutes/60, "hour")
+	default:
+		return pluralize(minutes, "minute")
+	}
+}
+
+// pluralize renders a count with its unit, e.g. "1 hour" or "4 hours".
+func pluralize(count int, unit string) string {
+	if count == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
@@ -0,0 +1,126 @@
+// Package main - ipc.go lets short-lived processes send requests to the running tray instance.
+//
+// Explorer context-menu entries (and the matching subcommands) start a second
+// copy of the executable. That copy must not run backups or edit config.json
+// itself: the tray instance owns the schedulers, the per-config locks and the
+// menu. Instead it forwards one request over a local channel and exits.
+//
+// Key design decisions:
+//
+// 1. Local-only transport: A named pipe on Windows and a Unix socket next to
+//    the lock file elsewhere. Neither is reachable from the network.
+//
+// 2. One JSON request and one JSON response per connection: Requests are rare
+//    and tiny, so there is no framing or session state to get wrong.
+//
+// 3. Requests are acknowledged, not awaited: A backup can take hours, so the
+//    instance replies once the work is started and reports the outcome
+//    through its usual notifications. The backup command is the exception:
+//    it sets Wait and gets each config's outcome once the backups are done,
+//    so scripts can branch on it.
+//
+// 4. One protocol for every client: The tray client that attaches to a
+//    headless engine (see trayclient.go) polls IPCActionStatus and sends
+//    the same backup and pause requests as the CLI.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// IPC request actions
+const (
+	IPCActionBackupFolder = "backup-folder" // Back up every config whose source is the folder
+	IPCActionAddFolder    = "add-folder"    // Add a backup config for the folder
+	IPCActionStateTask    = "state-task"    // Run a state task (see statetasks.go) on configs
+	IPCActionBackup       = "backup"        // Back up configs by name
+	IPCActionStatus       = "status"        // Report the status of every running config
+	IPCActionToastAction  = "toast-action"  // Carry out a button of a failure notification
+	IPCActionPauseAll     = "pause-all"     // Pause all scheduled backups until resumed
+	IPCActionResumeAll    = "resume-all"    // Resume all backups
+)
+
+// ipcTimeout bounds how long either side waits on a connection
+const ipcTimeout = 10 * time.Second
+
+// errInstanceNotRunning is returned when no tray instance is listening
+var errInstanceNotRunning = errors.New("SimpleFolderBackup is not running")
+
+// ipcRequest is sent by a client process to the tray instance.
+type ipcRequest struct {
+	Action    string   `json:"action"`              // One of the IPCAction* constants
+	Path      string   `json:"path"`                // Absolute folder path the action applies to
+	Task      string   `json:"task,omitempty"`      // State task name, for IPCActionStateTask
+	Configs   []string `json:"configs,omitempty"`   // Config names the request applies to; state tasks treat empty as all
+	Button    string   `json:"button,omitempty"`    // ToastAction* constant, for IPCActionToastAction
+	Interface string   `json:"interface,omitempty"` // AuditInterface* the request came from; the CLI when empty
+	Wait      bool     `json:"wait,omitempty"`      // For IPCActionBackup, answer once the backups are done, with their outcomes
+}
+
+// ipcResponse is the tray instance's reply.
+type ipcResponse struct {
+	OK          bool               `json:"ok"`
+	Message     string             `json:"message"`              // What was done, or why it wasn't
+	Statuses    []ConfigStatus     `json:"statuses,omitempty"`   // For IPCActionStatus
+	Engine      bool               `json:"engine,omitempty"`     // The instance runs headless, without its own tray
+	Paused      bool               `json:"paused,omitempty"`     // All backups are paused, for IPCActionStatus
+	PausedUntil time.Time          `json:"pausedUntil,omitzero"` // When the pause of all backups ends, if timed
+	Backups     []backupSummaryRow `json:"backups,omitempty"`    // Outcome of each config, for IPCActionBackup with Wait
+}
+
+// ipcHandler processes one request in the tray instance.
+type ipcHandler func(req ipcRequest) ipcResponse
+
+// startIPCServer accepts requests until ctx is cancelled.
+//
+// Reading the request and writing the response each get ipcTimeout; abort
+// cuts off a client that connects and then stalls, so it can't hold a
+// connection forever. The handler itself isn't bounded.
+func startIPCServer(ctx context.Context, handler ipcHandler) {
+	err := listenIPC(ctx, func(conn io.ReadWriter, abort func()) {
+		var req ipcRequest
+		deadline := time.AfterFunc(ipcTimeout, abort)
+		err := json.NewDecoder(conn).Decode(&req)
+		deadline.Stop()
+		if err != nil {
+			log.Printf("Ignoring malformed IPC request: %v", err)
+			return
+		}
+		resp := handler(req)
+		deadline = time.AfterFunc(ipcTimeout, abort)
+		defer deadline.Stop()
+		if err := json.NewEncoder(conn).Encode(resp); err != nil {
+			log.Printf("Failed to answer IPC request: %v", err)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to start IPC server, context-menu requests are unavailable: %v", err)
+	}
+}
+
+// sendIPCRequest delivers a request to the tray instance and returns its reply.
+func sendIPCRequest(req ipcRequest) (ipcResponse, error) {
+	conn, err := dialIPC()
+	if err != nil {
+		return ipcResponse{}, errInstanceNotRunning
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return ipcResponse{}, fmt.Errorf("failed to send request: %v", err)
+	}
+	if deadline, ok := conn.(interface{ SetDeadline(time.Time) error }); ok && req.Wait {
+		deadline.SetDeadline(time.Time{}) // The answer comes when the backups are done
+	}
+	var resp ipcResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return ipcResponse{}, fmt.Errorf("no answer from SimpleFolderBackup: %v", err)
+	}
+	return resp, nil
+}
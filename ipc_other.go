@@ -0,0 +1,60 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ipcSocketPath sits next to the lock file; only the instance holding the lock listens on it
+const ipcSocketPath = "SimpleFolderBackup.sock"
+
+// listenIPC serves connections on a Unix socket until ctx is cancelled.
+func listenIPC(ctx context.Context, serve func(conn io.ReadWriter, abort func())) error {
+	// A socket left behind by a crashed instance would make Listen fail; the
+	// instance lock guarantees nobody else is using it
+	os.Remove(ipcSocketPath)
+	// The socket is created accessible to the user only; a chmod after Listen
+	// would leave a moment in which other users could connect
+	previous := syscall.Umask(0177)
+	listener, err := net.Listen("unix", ipcSocketPath)
+	syscall.Umask(previous)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		os.Remove(ipcSocketPath)
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // Listener closed on shutdown
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn, func() { conn.Close() })
+			}()
+		}
+	}()
+	return nil
+}
+
+// dialIPC connects to the running instance.
+func dialIPC() (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("unix", ipcSocketPath, ipcTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(ipcTimeout))
+	return conn, nil
+}
@@ -0,0 +1,124 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ipcPipeName is the named pipe the tray instance listens on; pipe names are
+// machine-wide, so it carries the user and session like the instance mutex
+var ipcPipeName = `\\.\pipe\` + instanceName()
+
+// Named pipe constants for CreateNamedPipeW
+const (
+	PIPE_ACCESS_DUPLEX            = 0x00000003
+	FILE_FLAG_FIRST_PIPE_INSTANCE = 0x00080000
+	PIPE_TYPE_BYTE                = 0x00000000
+	PIPE_WAIT                     = 0x00000000
+	PIPE_REJECT_REMOTE_CLIENTS    = 0x00000008
+	PIPE_UNLIMITED_INSTANCES      = 255
+
+	ERROR_PIPE_CONNECTED = 535
+)
+
+var (
+	procCreateNamedPipeW    = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = kernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = kernel32.NewProc("DisconnectNamedPipe")
+	procFlushFileBuffers    = kernel32.NewProc("FlushFileBuffers")
+)
+
+// createPipeInstance creates one server end of the pipe.
+//
+// The first instance is created with FILE_FLAG_FIRST_PIPE_INSTANCE so another
+// process can't squat on the name and receive requests meant for the tray.
+func createPipeInstance(first bool) (syscall.Handle, error) {
+	namePtr, err := syscall.UTF16PtrFromString(ipcPipeName)
+	if err != nil {
+		return 0, err
+	}
+	openMode := uintptr(PIPE_ACCESS_DUPLEX)
+	if first {
+		openMode |= FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	handle, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		openMode,
+		PIPE_TYPE_BYTE|PIPE_WAIT|PIPE_REJECT_REMOTE_CLIENTS,
+		PIPE_UNLIMITED_INSTANCES,
+		4096, // Output buffer size
+		4096, // Input buffer size
+		0,    // Default timeout
+		0,    // Default security: only the owner, SYSTEM and administrators may write
+	)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		return 0, err
+	}
+	return syscall.Handle(handle), nil
+}
+
+// listenIPC serves connections on the named pipe until ctx is cancelled.
+func listenIPC(ctx context.Context, serve func(conn io.ReadWriter, abort func())) error {
+	handle, err := createPipeInstance(true)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			// Blocks until a client connects; a client that connected between
+			// creation and this call is reported as ERROR_PIPE_CONNECTED
+			ret, _, err := procConnectNamedPipe.Call(uintptr(handle), 0)
+			connected := ret != 0 || err == syscall.Errno(ERROR_PIPE_CONNECTED)
+
+			if ctx.Err() != nil {
+				syscall.CloseHandle(handle)
+				return
+			}
+			if connected {
+				go func(h syscall.Handle) {
+					// The pipe isn't overlapped, so it has no deadlines; disconnecting
+					// it fails a read or write stuck on a client that stalls
+					abort := func() { procDisconnectNamedPipe.Call(uintptr(h)) }
+					pipe := os.NewFile(uintptr(h), ipcPipeName)
+					serve(pipe, abort)
+					// Waits until the client has read the response
+					deadline := time.AfterFunc(ipcTimeout, abort)
+					procFlushFileBuffers.Call(uintptr(h))
+					deadline.Stop()
+					procDisconnectNamedPipe.Call(uintptr(h))
+					pipe.Close()
+				}(handle)
+			} else {
+				syscall.CloseHandle(handle)
+			}
+
+			handle, err = createPipeInstance(false)
+			if err != nil {
+				log.Printf("IPC server stopped: %v", err)
+				return
+			}
+		}
+	}()
+
+	// ConnectNamedPipe can't be cancelled, so shutdown connects once to wake it
+	go func() {
+		<-ctx.Done()
+		if pipe, err := os.OpenFile(ipcPipeName, os.O_RDWR, 0); err == nil {
+			pipe.Close()
+		}
+	}()
+	return nil
+}
+
+// dialIPC connects to the running instance.
+func dialIPC() (io.ReadWriteCloser, error) {
+	return os.OpenFile(ipcPipeName, os.O_RDWR, 0)
+}
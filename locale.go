@@ -0,0 +1,95 @@
+// Package main - locale.go implements user-facing date/time formatting.
+//
+// Timestamps shown to users (tray status, log lines, reports) are formatted
+// separately from the timestamps embedded in backup directory and log file
+// names. The storage formats in naming.go must never change because retention
+// and scheduling parse them back; the display format is purely cosmetic and
+// can follow the user's preference or the operating system locale.
+//
+// Supported date_format values:
+// - "system" (default): derive the layout from the OS regional settings
+// - "iso":  2006-01-02 15:04:05
+// - "us":   01/02/2006 03:04:05 PM
+// - "eu":   02/01/2006 15:04:05
+// - any other value containing a Go reference-time component is used as-is
+package main
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// Display layout presets selectable through the date_format setting.
+const (
+	DisplayFormatISO = "2006-01-02 15:04:05"
+	DisplayFormatUS  = "01/02/2006 03:04:05 PM"
+	DisplayFormatEU  = "02/01/2006 15:04:05"
+)
+
+// displayLayout resolves the configured date_format setting into a Go time layout.
+//
+// Unknown values that don't look like a Go layout fall back to the system
+// locale rather than producing garbage output in every log line.
+func displayLayout() string {
+	format := strings.TrimSpace(currentSettings().DateFormat)
+	switch strings.ToLower(format) {
+	case "", "system":
+		return systemDateLayout()
+	case "iso":
+		return DisplayFormatISO
+	case "us":
+		return DisplayFormatUS
+	case "eu":
+		return DisplayFormatEU
+	}
+
+	if strings.Contains(format, "2006") || strings.Contains(format, "15") || strings.Contains(format, "01") {
+		return format
+	}
+	return systemDateLayout()
+}
+
+// formatDisplayTime formats a timestamp for display to the user.
+func formatDisplayTime(t time.Time) string {
+	if t.IsZero() {
+		return "Never"
+	}
+	return t.Format(displayLayout())
+}
+
+// localeDateLayout maps a POSIX locale name (e.g. "en_US.UTF-8") to a display layout.
+//
+// Used on platforms without a native regional settings API. Only the common
+// conventions are distinguished; everything else gets the unambiguous ISO layout.
+func localeDateLayout(locale string) string {
+	locale = strings.SplitN(locale, ".", 2)[0]
+	switch {
+	case locale == "en_US":
+		return DisplayFormatUS
+	case strings.HasPrefix(locale, "de_"), strings.HasPrefix(locale, "ru_"), strings.HasPrefix(locale, "pl_"):
+		return "02.01.2006 15:04:05"
+	case strings.HasPrefix(locale, "en_"), strings.HasPrefix(locale, "fr_"),
+		strings.HasPrefix(locale, "es_"), strings.HasPrefix(locale, "it_"):
+		return DisplayFormatEU
+	default:
+		return DisplayFormatISO
+	}
+}
+
+// timestampWriter prefixes each log line with the current time in the display format.
+//
+// The standard library logger only supports a fixed date layout, so loggers are
+// created without log.Ldate/log.Ltime and this writer adds the timestamp instead.
+// The log package issues exactly one Write per entry, which makes prefixing safe.
+type timestampWriter struct {
+	w io.Writer
+}
+
+func (tw *timestampWriter) Write(p []byte) (int, error) {
+	prefix := time.Now().Format(displayLayout()) + " "
+	if _, err := io.WriteString(tw.w, prefix+string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// systemDateLayout derives a display layout from the POSIX locale environment.
+//
+// LC_ALL overrides LC_TIME which overrides LANG, matching how libc resolves
+// the time category.
+func systemDateLayout() string {
+	for _, name := range []string{"LC_ALL", "LC_TIME", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return localeDateLayout(value)
+		}
+	}
+	return DisplayFormatISO
+}
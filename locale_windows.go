@@ -0,0 +1,99 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Windows locale API constants for GetLocaleInfoEx
+const (
+	LOCALE_SSHORTDATE  = 0x0000001F // Short date pattern, e.g. "M/d/yyyy"
+	LOCALE_STIMEFORMAT = 0x00001003 // Time pattern, e.g. "h:mm:ss tt"
+)
+
+var (
+	procGetLocaleInfoEx = kernel32.NewProc("GetLocaleInfoEx")
+
+	systemLayoutOnce sync.Once
+	systemLayout     string
+)
+
+// systemDateLayout derives a display layout from the user's Windows regional settings.
+//
+// The lookup is cached for the lifetime of the process because it is consulted
+// for every log line and regional settings changes are rare.
+func systemDateLayout() string {
+	systemLayoutOnce.Do(func() {
+		datePattern := getLocaleInfo(LOCALE_SSHORTDATE)
+		timePattern := getLocaleInfo(LOCALE_STIMEFORMAT)
+		if datePattern == "" || timePattern == "" {
+			systemLayout = DisplayFormatISO
+			return
+		}
+		systemLayout = windowsPatternToLayout(datePattern) + " " + windowsPatternToLayout(timePattern)
+	})
+	return systemLayout
+}
+
+// getLocaleInfo queries a single value from the user default locale.
+func getLocaleInfo(lcType uint32) string {
+	buf := make([]uint16, 128)
+	n, _, _ := procGetLocaleInfoEx.Call(
+		0, // LOCALE_NAME_USER_DEFAULT
+		uintptr(lcType),
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(len(buf)),
+	)
+	if n == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(buf)
+}
+
+// windowsPatternToLayout converts a Windows date/time picture string into a Go layout.
+//
+// Windows uses repeated letters ("dd", "MMM", "yyyy", "HH", "tt") and single
+// quotes for literals; each run of identical letters maps to one Go component.
+func windowsPatternToLayout(pattern string) string {
+	tokens := map[string]string{
+		"d": "2", "dd": "02", "ddd": "Mon", "dddd": "Monday",
+		"M": "1", "MM": "01", "MMM": "Jan", "MMMM": "January",
+		"y": "06", "yy": "06", "yyyy": "2006", "yyyyy": "2006",
+		"h": "3", "hh": "03", "H": "15", "HH": "15",
+		"m": "4", "mm": "04", "s": "5", "ss": "05",
+		"t": "PM", "tt": "PM",
+	}
+
+	var layout strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if r == '\'' {
+			// Quoted literal text runs until the next quote
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			layout.WriteString(string(runes[i+1 : end]))
+			i = end + 1
+			continue
+		}
+
+		j := i
+		for j < len(runes) && runes[j] == r {
+			j++
+		}
+		run := string(runes[i:j])
+		if replacement, ok := tokens[run]; ok {
+			layout.WriteString(replacement)
+		} else {
+			layout.WriteString(run)
+		}
+		i = j
+	}
+	return layout.String()
+}
@@ -25,33 +25,47 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
-// LogDateFormat is defined in utils.go for consistency across modules
+// LogDateFormat and the log file naming rules are defined in naming.go
 
 // LoggerConfig defines the configuration for creating a logger instance.
 //
 // This structure supports different logging behaviors:
 // - Name: Descriptive name for error messages and debugging
 // - Path: File system path where logs should be written
-// - ClearOnStartup: Whether to truncate existing log file (for system.log)
+// - ClearOnStartup: Whether to start a fresh log file (for system.log)
+// - KeepSessions: How many previous session logs to keep when clearing
 // - RetentionDays: How many days of logs to keep (nil = no retention)
+// - Mirror: A second copy of the log, e.g. at the backup destination
 //
-// The flexible design supports both system logging (cleared on startup,
-// no retention) and per-backup logging (appended, with retention).
+// The flexible design supports both system logging (fresh file per session,
+// previous sessions rotated) and per-backup logging (appended, with retention).
 type LoggerConfig struct {
-	Name           string // Descriptive name for error reporting
-	Path           string // File path for log output
-	ClearOnStartup bool   // Whether to clear existing log on startup
-	RetentionDays  *int   // Days to retain logs (nil = no cleanup)
+	Name           string    // Descriptive name for error reporting
+	Path           string    // File path for log output
+	ClearOnStartup bool      // Whether to start a fresh log on startup
+	KeepSessions   int       // Previous sessions kept as path.1..path.N (0 = discard)
+	RetentionDays  *int      // Days to retain logs (nil = no cleanup)
+	Mirror         io.Writer // Also receives every entry (nil = none)
 }
 
+// systemLogSessions is how many previous system.log sessions are preserved.
+//
+// Keeping several sessions means a crash followed by one or two relaunches
+// still leaves the log of the session that actually failed.
+const systemLogSessions = 5
+
+// systemLogPath is the log of application-level events
+var systemLogPath = filepath.Join("logs", "system.log")
+
 // createLogger creates a configured logger instance with directory setup and retention management.
 //
 // This is the core logger factory that handles all the complexity of setting up
@@ -65,7 +79,8 @@ type LoggerConfig struct {
 // but don't prevent logger creation, ensuring backup operations can continue
 // even if log maintenance fails.
 //
-// The logger format includes date, time, and source file for debugging.
+// The logger format includes a timestamp in the user's display format (see
+// locale.go) and the source file for debugging.
 func createLogger(config LoggerConfig) (*log.Logger, error) {
 	// Create directory structure if needed
 	if dir := filepath.Dir(config.Path); dir != "." {
@@ -84,10 +99,19 @@ func createLogger(config LoggerConfig) (*log.Logger, error) {
 		}
 	}
 	
+	// Preserve previous sessions before the current log is replaced
+	if config.ClearOnStartup && config.KeepSessions > 0 {
+		err := rotateSessionLogs(config.Path, config.KeepSessions)
+		if err != nil {
+			// Non-fatal - losing old sessions is better than not logging at all
+			fmt.Printf("Warning: Failed to rotate previous logs for %s: %v\n", config.Name, err)
+		}
+	}
+	
 	// Configure file opening behavior based on logger type
 	openFlags := os.O_CREATE | os.O_WRONLY
 	if config.ClearOnStartup {
-		openFlags |= os.O_TRUNC // Clear previous session (system.log)
+		openFlags |= os.O_TRUNC // Fresh file for this session (system.log)
 	} else {
 		openFlags |= os.O_APPEND // Append to existing (per-backup logs)
 	}
@@ -97,8 +121,42 @@ func createLogger(config LoggerConfig) (*log.Logger, error) {
 		return nil, err
 	}
 	
-	// Create logger with consistent formatting: date, time, source file
-	return log.New(logFile, "", log.Ldate|log.Ltime|log.Lshortfile), nil
+	var output io.Writer = logFile
+	if config.Mirror != nil {
+		output = io.MultiWriter(logFile, config.Mirror)
+	}
+	
+	// Create logger with consistent formatting: display-format timestamp, source file
+	// Output passes through the redaction layer so credentials never reach disk
+	return log.New(&timestampWriter{w: &redactingWriter{w: output}}, "", log.Lshortfile), nil
+}
+
+// rotateSessionLogs shifts session logs so the current file becomes path.1.
+//
+// Existing rotations move up by one (path.1 -> path.2, ...) and the oldest
+// beyond keep is deleted. Missing files in the chain are simply skipped, so
+// rotation works the same on first run and after manual deletions.
+func rotateSessionLogs(path string, keep int) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil // Nothing to preserve on first run
+	}
+	
+	// Drop the oldest session that would fall off the end
+	oldest := fmt.Sprintf("%s.%d", path, keep)
+	if err := os.Remove(oldest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	
+	// Shift remaining sessions up by one, newest last to avoid overwriting
+	for i := keep - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", path, i)
+		to := fmt.Sprintf("%s.%d", path, i+1)
+		if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	
+	return os.Rename(path, path+".1")
 }
 
 // getTodayLogPath generates a log file path based on current date.
@@ -107,11 +165,10 @@ func createLogger(config LoggerConfig) (*log.Logger, error) {
 // the specified base directory. This daily rotation makes it easy to find
 // logs for specific dates and enables date-based retention cleanup.
 //
-// The date format matches the LogDateFormat constant defined in utils.go
-// for consistency across the application.
+// File names come from logFileName in naming.go, which also parses them
+// back for retention.
 func getTodayLogPath(baseDir, prefix string) string {
-	fileName := fmt.Sprintf("%s_%s.log", prefix, time.Now().Format(LogDateFormat))
-	return filepath.Join(baseDir, fileName)
+	return filepath.Join(baseDir, logFileName(prefix, time.Now()))
 }
 
 // cleanupOldLogs removes log files older than the specified retention period.
@@ -122,7 +179,7 @@ func getTodayLogPath(baseDir, prefix string) string {
 //
 // The cleanup process:
 // 1. Scans directory for .log files
-// 2. Extracts dates from filenames using extractDateFromLogName
+// 2. Extracts dates from filenames using parseLogFileDate
 // 3. Removes files older than retention period
 // 4. Logs warnings for deletion failures but continues processing
 //
@@ -143,59 +200,19 @@ func cleanupOldLogs(logDir string, retentionDays int) error {
 		}
 		
 		// Extract date from filename pattern (backup_DD-MM-YYYY.log)
-		if dateStr := extractDateFromLogName(entry.Name()); dateStr != "" {
-			if logDate, err := time.Parse(LogDateFormat, dateStr); err == nil {
-				if logDate.Before(cutoffDate) {
-					// Log file is older than retention period - delete it
-					logPath := filepath.Join(logDir, entry.Name())
-					err := os.Remove(logPath)
-					if err != nil {
-						// Log failure but continue with other files
-						fmt.Printf("Warning: Failed to delete old log file %s: %v\n", logPath, err)
-					}
-				}
+		if logDate, ok := parseLogFileDate(entry.Name()); ok && logDate.Before(cutoffDate) {
+			// Log file is older than retention period - delete it
+			logPath := filepath.Join(logDir, entry.Name())
+			err := os.Remove(logPath)
+			if err != nil {
+				// Log failure but continue with other files
+				fmt.Printf("Warning: Failed to delete old log file %s: %v\n", logPath, err)
 			}
 		}
 	}
 	return nil
 }
 
-// extractDateFromLogName extracts the date portion from a log filename.
-//
-// Parses filenames following the pattern "prefix_DD-MM-YYYY.log" to extract
-// the date string for retention processing. The regex matches the specific
-// date format used by this application's log naming convention.
-//
-// Returns empty string if the filename doesn't match the expected pattern,
-// which causes the file to be skipped during retention cleanup.
-func extractDateFromLogName(filename string) string {
-	// Extract "10-08-2025" from "backup_10-08-2025.log"
-	re := regexp.MustCompile(`(\d{2}-\d{2}-\d{4})\.log$`)
-	if matches := re.FindStringSubmatch(filename); len(matches) > 1 {
-		return matches[1]
-	}
-	return "" // No matching date pattern found
-}
-
-// sanitizeConfigName converts a backup configuration name into a safe directory name.
-//
-// Transforms user-provided configuration names into filesystem-safe directory names
-// by:
-// 1. Converting to lowercase for consistency
-// 2. Replacing spaces with hyphens for readability
-// 3. Removing any characters that aren't alphanumeric or hyphens
-//
-// This ensures log directories can be created on any filesystem without issues
-// while maintaining some readability of the original configuration name.
-//
-// Example: "Application Server" becomes "application-server"
-func sanitizeConfigName(name string) string {
-	name = strings.ToLower(name)
-	name = strings.ReplaceAll(name, " ", "-")
-	// Remove any characters that aren't safe for directory names
-	return regexp.MustCompile(`[^a-z0-9\-]`).ReplaceAllString(name, "")
-}
-
 // initSystemLogger creates the system-level logger for application events.
 //
 // The system logger captures application-level events like startup, configuration
@@ -203,8 +220,9 @@ func sanitizeConfigName(name string) string {
 // operational logs to make troubleshooting easier.
 //
 // Key characteristics:
-// - Clears on each application startup for fresh session logs
-// - No retention management (cleared each start, so no accumulation)
+// - Starts a fresh file on each application startup for clean session logs
+// - Previous sessions are rotated to system.log.1..N instead of being destroyed,
+//   so the evidence of a crash survives the restart that follows it
 // - Single shared log for all system-level events
 //
 // This logger is used for Go's default log output, capturing events that
@@ -212,9 +230,14 @@ func sanitizeConfigName(name string) string {
 func initSystemLogger() (*log.Logger, error) {
 	config := LoggerConfig{
 		Name:           "system",
-		Path:           "logs/system.log",
-		ClearOnStartup: true, // Fresh log each session
-		RetentionDays:  nil,  // No retention needed (clears on startup)
+		Path:           systemLogPath,
+		ClearOnStartup: true,              // Fresh log each session
+		KeepSessions:   systemLogSessions, // Previous sessions rotated, not truncated
+		RetentionDays:  nil,               // No retention needed (bounded by KeepSessions)
+	}
+	// Without a tray the console (or the service manager's journal) is where people look
+	if headless {
+		config.Mirror = os.Stderr
 	}
 	return createLogger(config)
 }
@@ -231,7 +254,8 @@ func initSystemLogger() (*log.Logger, error) {
 // with specific backup configurations without sifting through logs from other
 // backups or system events.
 //
-// Log structure: logs/{sanitized-config-name}/backup_DD-MM-YYYY.log
+// Log structure: logs/{sanitized-config-name}/backup_DD-MM-YYYY.log, and with
+// log_to_destination also {destination}/logs/backup_DD-MM-YYYY.log
 func initBackupLogger(backupConfig BackupConfig) (*log.Logger, error) {
 	// Create config-specific directory
 	configDir := filepath.Join("logs", sanitizeConfigName(backupConfig.Name))
@@ -244,6 +268,61 @@ func initBackupLogger(backupConfig BackupConfig) (*log.Logger, error) {
 		ClearOnStartup: false,        // Append to preserve history
 		RetentionDays:  &retentionDays, // User-configurable retention
 	}
+	if backupConfig.LogToDestination {
+		mirrorDir := filepath.Join(backupConfig.Destination, destinationLogDir)
+		// The destination may be offline right now; its logs are cleaned up next start
+		cleanupOldLogs(mirrorDir, retentionDays)
+		config.Mirror = &destinationLogWriter{dir: mirrorDir, prefix: "backup"}
+	}
 	return createLogger(config)
 }
 
+// configLogToOpen returns the log a user looking into a configuration wants
+// to see: today's log file, or the config's log folder if nothing was logged
+// today. The path is absolute, so file managers don't resolve it against
+// their own working directory.
+func configLogToOpen(name string) string {
+	path := getTodayLogPath(filepath.Join("logs", sanitizeConfigName(name)), "backup")
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Dir(path)
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	return path
+}
+
+// destinationLogDir is the folder at a backup destination that log_to_destination writes to
+const destinationLogDir = "logs"
+
+// destinationLogWriter appends log entries to daily log files at a backup destination.
+//
+// The destination is often a removable drive or a network share, so the file
+// is opened for every entry instead of being held open: entries written while
+// the destination is unavailable are lost there (they are still in the local
+// log), and writing resumes as soon as it is back. Write never fails, so the
+// local log is never held up by the destination.
+type destinationLogWriter struct {
+	dir    string
+	prefix string
+
+	mu sync.Mutex // Keeps concurrent entries whole
+}
+
+func (dw *destinationLogWriter) Write(p []byte) (int, error) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	
+	// Mkdir rather than MkdirAll: an unmounted destination must not be recreated as an empty folder
+	if err := os.Mkdir(dw.dir, 0755); err != nil && !os.IsExist(err) {
+		return len(p), nil
+	}
+	file, err := os.OpenFile(getTodayLogPath(dw.dir, dw.prefix), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return len(p), nil
+	}
+	file.Write(p)
+	file.Close()
+	return len(p), nil
+}
+
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// longPath returns path unchanged: only Windows limits path lengths to
+// MAX_PATH without an extended-length prefix.
+func longPath(path string) string {
+	return path
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPathLimit is the length from which Win32 calls need the \\?\ prefix;
+// a directory path must leave room for an 8.3 file name below MAX_PATH
+const longPathLimit = 248
+
+// longPath returns the extended-length form of path (\\?\C:\... or
+// \\?\UNC\server\share\...) if it is too long for the plain Win32 API, and
+// path itself otherwise.
+//
+// Everything that goes through the os package - copying, hashing, walking,
+// linking and deleting snapshots - gets this prefix from the Go runtime, so
+// deeply nested trees such as node_modules back up as they are. Only the
+// calls made directly through syscall, which pass paths to Windows as given,
+// need it here.
+func longPath(path string) string {
+	if len(path) < longPathLimit || strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\??\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if server, isUNC := strings.CutPrefix(abs, `\\`); isUNC {
+		return `\\?\UNC\` + server
+	}
+	return `\\?\` + abs
+}
@@ -4,7 +4,9 @@
 // hash-based change detection to avoid unnecessary backups when content hasn't changed.
 // Key architectural decisions:
 //
-// 1. System tray application vs service: Chosen for user visibility and easier management
+// 1. System tray application vs service: Chosen for user visibility and easier management;
+//    --headless runs the same schedulers without a tray for servers or as a service,
+//    and a tray started alongside attaches to it as a client (see headless.go, trayclient.go)
 // 2. Single instance enforcement: Prevents conflicts and resource contention
 // 3. Hash-based change detection: Dramatically reduces I/O and storage overhead
 // 4. Per-backup logging: Enables debugging specific backup configurations
@@ -17,15 +19,14 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/getlantern/systray"
 )
 
-// statusUpdateChan signals when system tray menu should update immediately
-var statusUpdateChan = make(chan struct{}, 1)
-
 // main initializes the backup tool with single instance enforcement and system tray integration.
 //
 // Single instance enforcement is critical for this application because:
@@ -34,15 +35,42 @@ var statusUpdateChan = make(chan struct{}, 1)
 // 3. System resources would be wasted on duplicate backup operations
 // 4. Log files could become corrupted with concurrent writes
 func main() {
+	// Config, state and lock are kept per OS user (see userdata.go)
+	args, err := takeConfigDirFlag(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := enterUserDataDir(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open the data folder: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Subcommands run once and exit; they don't need the tray or the instance lock
+	if len(args) == 1 && args[0] == headlessFlag {
+		headless = true
+	} else if len(args) > 0 {
+		os.Exit(runCLI(args))
+	}
+
 	// Enforce single instance before any other initialization to prevent race conditions
 	mutex, err := acquireMutex()
 	if err != nil {
+		if headless {
+			fmt.Fprintln(os.Stderr, "Another instance is already running.")
+			os.Exit(1)
+		}
+		// A headless engine has no tray of its own; attach to it as its tray (see trayclient.go)
+		if engineIsRunning() {
+			runTrayClient()
+			return
+		}
 		showMessageBox("SimpleFolderBackup", "Another instance is already running.\n\nPlease close the existing instance before starting a new one.")
 		os.Exit(1)
 	}
 	defer mutex.release()
 
-	// Initialize system logger first (clears previous session log for fresh start)
+	// Initialize system logger first (rotates previous session logs for a fresh start)
 	// System logger captures application-level events vs per-backup operational logs
 	systemLogger, err := initSystemLogger()
 	if err != nil {
@@ -52,9 +80,22 @@ func main() {
 	
 	// Redirect Go's default logger to our system logger for consistent logging
 	log.SetOutput(systemLogger.Writer())
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	// Timestamps are added by the logger's writer in the configured display format
+	log.SetFlags(log.Lshortfile)
 	
 	log.Printf("Application starting...")
+	if migration := describeMigration(); migration != "" {
+		log.Print(migration)
+	}
+	
+	if headless {
+		if err := runHeadless(); err != nil {
+			log.Print(err)
+			mutex.release()
+			os.Exit(1)
+		}
+		return
+	}
 	
 	// systray.Run blocks until application exit - all initialization happens in onReady
 	systray.Run(onReady, onExit)
@@ -81,60 +122,101 @@ func onReady() {
 	mNextBackup := systray.AddMenuItem("Next backup: Unknown", "Next backup time")
 	mNextBackup.Disable()
 	
-	systray.AddSeparator()
+	// Alert line is only visible while a backup needs attention
+	mAlert := systray.AddMenuItem("", "Backups needing attention")
+	mAlert.Disable()
+	mAlert.Hide()
 	
-	mQuit := systray.AddMenuItem("Exit", "Exit the application")
+	// Per-config submenus are filled in once the configuration is loaded
+	mBackups := systray.AddMenuItem("Backups", "Snapshots of each backup configuration")
 	
-	// Load and validate configuration before starting any backup operations
-	config, err := loadConfig()
-	if err != nil {
-		log.Printf("Error loading config: %v", err)
-		return
-	}
+	// Turns configurations on and off, saved to config.json
+	mEnabled := systray.AddMenuItem("Enabled backups", "Turn each backup configuration on or off")
 	
-	err = validatePaths(config)
-	if err != nil {
-		log.Printf("Error validating paths: %v", err)
-		return
-	}
+	// Suspends every scheduler until clicked again, e.g. while gaming or on battery
+	mPauseAll := systray.AddMenuItemCheckbox("Pause all backups", "Stop scheduled backups until resumed", false)
+	pauseAllUntilMenu := newPauseUntilMenu(systray.AddMenuItem("Pause all until", "Stop scheduled backups for a while; they resume by themselves"))
 	
-	// Initialize hash manager for content-based backup skipping
-	// This must be done before any backup schedulers start to avoid race conditions
-	initHashManager()
+	// Registers the application to start with the user's session (start_at_login)
+	mAutostart := systray.AddMenuItemCheckbox(autostartTitle, "Start SimpleFolderBackup when you log in, so backups run without launching it", autostartEnabled())
+	
+	systray.AddSeparator()
+	
+	mAbout := systray.AddMenuItem("About", "Version and build information")
+	mDashboard := systray.AddMenuItem("Open dashboard", "Show every backup's status, history and snapshots in the browser")
+	mLogs := systray.AddMenuItem("Open logs", "Show system.log, with a folder of logs per backup next to it, in the file manager")
+	mDiagnostics := systray.AddMenuItem("Collect diagnostics", "Zip logs, redacted config and state for bug reports")
+	
+	systray.AddSeparator()
+	
+	mQuit := systray.AddMenuItem("Exit", "Exit the application")
 	
 	// Create cancellable context for coordinated shutdown of all schedulers
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	
-	// Start a scheduler goroutine for each enabled backup configuration
-	// Each runs independently to prevent one backup failure from affecting others
-	for _, backup := range config.Backups {
-		if backup.IsEnabled() {
-			// Create dedicated logger for this backup to isolate log entries
-			backupLogger, err := initBackupLogger(backup)
-			if err != nil {
-				log.Printf("Failed to create logger for %s: %v", backup.Name, err)
-				continue
-			}
-			go startBackupScheduler(ctx, backup, backupLogger)
-		} else {
-			log.Printf("Skipping disabled backup config: %s", backup.Name)
-		}
+	// Each running config gets a submenu under Backups, shown once the first exists
+	mBackups.Hide()
+	mEnabled.Hide()
+	enabled := newEnableMenu(ctx, mEnabled)
+	configs, err := startApplication(ctx, func(ctx context.Context, backup BackupConfig) *configMenu {
+		cm := newConfigMenu(mBackups, backup)
+		mBackups.Show()
+		go cm.handleClicks(ctx)
+		return cm
+	})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if currentSettings().DashboardPort == 0 {
+		mDashboard.Hide()
 	}
 	
 	// updateMenuStatus updates both menu items with current status
 	updateMenuStatus := func() {
 		mLastBackup.SetTitle(backupStatus.getLastBackupStatus())
 		mNextBackup.SetTitle(backupStatus.getNextBackupStatus())
+		systray.SetTooltip(backupStatus.getTooltipStatus())
+		if allBackupsPaused() {
+			mPauseAll.Check()
+		} else {
+			mPauseAll.Uncheck()
+		}
+		// Pausing changes when backups run, so read-only mode doesn't offer it
+		if currentSettings().ReadOnly {
+			mPauseAll.Disable()
+			pauseAllUntilMenu.root.Hide()
+		} else {
+			mPauseAll.Enable()
+			pauseAllUntilMenu.root.Show()
+		}
+		if autostartEnabled() {
+			mAutostart.Check()
+		} else {
+			mAutostart.Uncheck()
+		}
+		if alert := backupStatus.getAlertStatus(); alert != "" {
+			mAlert.SetTitle(alert)
+			mAlert.Show()
+		} else {
+			mAlert.Hide()
+		}
+		for _, cm := range configs.menus() {
+			cm.refresh()
+		}
+		enabled.refresh(configs.configs())
 	}
 	
 	// Brief delay to allow schedulers to initialize before displaying status
 	time.Sleep(100 * time.Millisecond)
 	updateMenuStatus()
 	
-	// Start status update goroutine with 30-second refresh interval
-	// Also listens for immediate updates when backup actions complete
+	// Start status update goroutine with 30-second refresh interval, which
+	// keeps relative times current; status events refresh it immediately
 	go func() {
+		events, unsubscribe := statusEvents.subscribe(1)
+		defer unsubscribe()
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
 		for {
@@ -143,32 +225,218 @@ func onReady() {
 				return
 			case <-ticker.C:
 				updateMenuStatus()
-			case <-statusUpdateChan:
+			case <-events:
 				updateMenuStatus()
 			}
 		}
 	}()
 	
-	// Handle OS signals for graceful shutdown (Ctrl+C, service stop, etc.)
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
-		cancel() // Signal all backup schedulers to stop
+	go pauseAllUntilMenu.handleClicks(ctx, "Pause all backups", func(until time.Time) {
+		if err := ensureWritable(AuditInterfaceTray, "pause-all", ""); err != nil {
+			showMessageBox("Pause all backups", err.Error())
+			return
+		}
+		auditLog.record(AuditInterfaceTray, "pause-all", "", "until "+formatDisplayTime(until))
+		pauseAllUntil(until)
+	})
+	
+	signalExit, forceExit := watchSignals()
+	
+	// exit stops the schedulers, lets running backups finish within the grace
+	// period and then quits the tray
+	exit := func() {
+		cancel() // Signal all backup schedulers to stop cleanly
+		backupRunner.beginShutdown()
+		settings := currentSettings()
+		waitForOperations(time.Duration(settings.GetShutdownGraceMinutes())*time.Minute, mQuit, forceExit)
 		systray.Quit()
-	}()
+	}
 	
 	// Main event loop - blocks until quit is selected or application is terminated
 	for {
 		select {
+		case <-mAbout.ClickedCh:
+			// Message boxes are modal - show from a goroutine so the menu stays responsive
+			go showMessageBox("About SimpleFolderBackup", buildInfo())
+		case <-mPauseAll.ClickedCh:
+			if err := ensureWritable(AuditInterfaceTray, "pause-all", ""); err != nil {
+				go showMessageBox("Pause all backups", err.Error())
+			} else if allBackupsPaused() {
+				auditLog.record(AuditInterfaceTray, "resume-all", "", "")
+				resumeAllBackups()
+			} else {
+				auditLog.record(AuditInterfaceTray, "pause-all", "", "")
+				pauseAllBackups()
+			}
+		case <-mAutostart.ClickedCh:
+			go toggleAutostartFromTray(mAutostart)
+		case <-mDashboard.ClickedCh:
+			go openDashboardFromTray()
+		case <-mLogs.ClickedCh:
+			path := systemLogPath
+			if absPath, err := filepath.Abs(path); err == nil {
+				path = absPath
+			}
+			openPathOrLog(path)
+		case <-mDiagnostics.ClickedCh:
+			auditLog.record(AuditInterfaceTray, "collect-diagnostics", "", "")
+			go func() {
+				bundlePath, err := createDiagnosticsBundle()
+				if err != nil {
+					log.Printf("Failed to collect diagnostics: %v", err)
+					showMessageBox("SimpleFolderBackup", fmt.Sprintf("Failed to collect diagnostics:\n\n%v", err))
+					return
+				}
+				if err := openInFileManager(bundlePath); err != nil {
+					showMessageBox("SimpleFolderBackup", fmt.Sprintf("Diagnostics saved to:\n\n%s", bundlePath))
+				}
+			}()
 		case <-mQuit.ClickedCh:
-			cancel() // Signal all backup schedulers to stop cleanly
-			systray.Quit()
+			auditLog.record(AuditInterfaceTray, "exit", "", "")
+			exit()
+			return
+		case <-signalExit:
+			exit()
 			return
 		}
 	}
 }
 
+// startApplication loads the configuration and state and starts everything
+// that runs in the background: the schedulers, the config watcher, the IPC
+// server and the system hooks. Both the tray and headless mode start here.
+//
+// newMenu builds the tray submenu of each config as it starts; it is nil in
+// headless mode. All background work stops when ctx ends.
+func startApplication(ctx context.Context, newMenu func(ctx context.Context, backup BackupConfig) *configMenu) (*configSet, error) {
+	// Load and validate configuration before starting any backup operations
+	config, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Error loading config: %v", err)
+	}
+	
+	// Apply global settings (display date format) before anything is logged or shown
+	setActiveSettings(config.Settings)
+	applyAutostart(config.Settings)
+	
+	// Audit any edits made to config.json since the last time it was loaded
+	auditLog.recordLoadedConfig(config)
+	
+	err = validatePaths(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error validating paths: %v", err)
+	}
+	
+	// Register normalized paths and configured credentials for log redaction
+	registerLogPaths(config)
+	registerConfigSecrets(config)
+	
+	// A source inside another source would otherwise be copied twice
+	if warnings := registerSourceOverlaps(config); len(warnings) > 0 {
+		notifyUser("Folders backed up twice", strings.Join(warnings, "\n")+
+			"\n\nSet \"exclude_nested_sources\" on the outer backup to back up each folder once.")
+	}
+	
+	// Initialize hash manager for content-based backup skipping
+	// This must be done before any backup schedulers start to avoid race conditions
+	initHashManager()
+	if err := storageHistory.load(); err != nil {
+		log.Printf("Warning: Could not load storage history: %v", err)
+	}
+	if err := runStats.load(); err != nil {
+		log.Printf("Warning: Could not load run statistics: %v", err)
+	}
+	if err := purgeForecasts.load(); err != nil {
+		log.Printf("Warning: Could not load purge forecasts: %v", err)
+	}
+	if err := loadTimedPauses(); err != nil {
+		log.Printf("Warning: Could not load timed pauses: %v", err)
+	}
+	
+	// Buttons on failure notifications are wired back here over IPC; set up
+	// before any scheduler can fail
+	enableToastActions()
+	
+	// startConfig starts a configuration's schedulers and, with a tray, its submenu
+	// Each scheduler runs independently to prevent one backup failure from affecting others
+	startConfig := func(ctx context.Context, backup BackupConfig) (*configMenu, bool) {
+		// Create dedicated logger for this backup to isolate log entries
+		backupLogger, err := initBackupLogger(backup)
+		if err != nil {
+			log.Printf("Failed to create logger for %s: %v", backup.Name, err)
+			return nil, false
+		}
+		backupRunner.register(backup, backupLogger)
+		restoreTimedPause(backup.Name)
+		go startBackupScheduler(ctx, backup, backupLogger)
+		go startReplicaScheduler(ctx, backup, backupLogger)
+	
+		if newMenu == nil {
+			return nil, true
+		}
+		return newMenu(ctx, backup), true
+	}
+	
+	// Configs are also started, stopped and restarted while running: when
+	// config.json is edited, and when a folder is added from Explorer
+	configs := newConfigSet(ctx, startConfig)
+	for _, backup := range config.Backups {
+		if !backup.IsEnabled() {
+			log.Printf("Skipping disabled backup config: %s", backup.Name)
+		}
+	}
+	configs.apply(config.Backups)
+	startConfigWatcher(ctx, configs)
+	
+	// Accept "back up now" and "add folder" requests from the Explorer context menu
+	startIPCServer(ctx, newFolderRequestHandler(func(backup BackupConfig) {
+		configs.activate(backup)
+		requestStatusUpdate()
+	}))
+	
+	// Compact run history and remove leftovers of deleted configs, daily
+	startMetadataMaintenance(ctx, configs.configs)
+	
+	// Resume pauses from "Pause until" when their time comes
+	startTimedPauseWatcher(ctx)
+	
+	// Global keyboard shortcuts for "back up now"
+	startHotkeys(config.Settings.Hotkeys)
+	
+	// Status page in the browser for users with more configs than the tray shows well
+	if config.Settings.DashboardPort > 0 {
+		startDashboard(ctx, config.Settings.DashboardPort)
+	}
+	
+	// Run flagged configs before OS-initiated restarts (Windows Update, logoff)
+	startSessionEndWatcher(ctx)
+	
+	return configs, nil
+}
+
+// watchSignals handles OS signals for graceful shutdown (Ctrl+C, service stop, etc.)
+//
+// The first signal is delivered on exit, to exit like the Exit item; any
+// further one on force, to skip waiting for running backups.
+func watchSignals() (exit, force <-chan struct{}) {
+	signalExit := make(chan struct{}, 1)
+	forceExit := make(chan struct{}, 1)
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigChan
+		auditLog.record(AuditInterfaceSystem, "exit", "", fmt.Sprintf("received signal %v", sig))
+		signalExit <- struct{}{}
+		for range sigChan {
+			select {
+			case forceExit <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return signalExit, forceExit
+}
+
 // onExit is called when the system tray application is shutting down.
 // The systray library handles most cleanup automatically, but this provides
 // a hook for any final cleanup operations if needed in the future.
@@ -0,0 +1,86 @@
+// Package main - maintenance.go keeps the application's own metadata bounded.
+//
+// Logs already have a retention period, but the history files grow with
+// every run and every config that ever existed. Once a day (and at startup)
+// a maintenance pass compacts them:
+//
+// - Run counters older than runStatsDays are folded into monthly totals
+// - Run records older than runHistoryDays are dropped
+// - Storage samples are thinned to weekly, then monthly (see compactSamples)
+// - Warm caches and purge forecasts of configs that no longer exist are deleted
+//
+// The audit log is deliberately left alone: it is the record of who changed
+// what, and only grows with user actions.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maintenanceInterval is how often the maintenance pass runs
+const maintenanceInterval = 24 * time.Hour
+
+// startMetadataMaintenance runs the maintenance pass now and then daily until ctx ends.
+//
+// configs returns the configs of config.json as last loaded; configs added
+// while running are known to the runner.
+func startMetadataMaintenance(ctx context.Context, configs func() []BackupConfig) {
+	go func() {
+		ticker := time.NewTicker(maintenanceInterval)
+		defer ticker.Stop()
+		for {
+			runMetadataMaintenance(append(configs(), backupRunner.registeredConfigs()...))
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// runMetadataMaintenance compacts the history files and removes leftovers of
+// configs not in configs.
+func runMetadataMaintenance(configs []BackupConfig) {
+	now := time.Now()
+	known := make(map[string]bool)
+	cacheFiles := make(map[string]bool)
+	for _, config := range configs {
+		known[config.Name] = true
+		cacheFiles[filepath.Base(warmCachePath(config))] = true
+	}
+
+	folded := runStats.compact(now)
+	thinned, err := storageHistory.compact(now)
+	if err != nil {
+		log.Printf("Failed to save compacted storage history: %v", err)
+	}
+	forecasts := purgeForecasts.prune(known)
+	runs, err := compactRunHistory(now)
+	if err != nil {
+		log.Printf("Failed to compact run history: %v", err)
+	}
+
+	caches := 0
+	if entries, err := os.ReadDir(warmCacheDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() || cacheFiles[entry.Name()] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(warmCacheDir, entry.Name())); err != nil {
+				log.Printf("Failed to remove stale warm cache %s: %v", entry.Name(), err)
+				continue
+			}
+			caches++
+		}
+	}
+
+	if folded+thinned+runs+forecasts+caches > 0 {
+		log.Printf("Metadata maintenance: folded %d days of run counters into monthly totals, thinned %d storage samples, dropped %d old run records, removed %d stale forecasts and %d stale warm caches",
+			folded, thinned, runs, forecasts, caches)
+	}
+}
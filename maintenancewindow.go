@@ -0,0 +1,112 @@
+// Package main - maintenancewindow.go keeps backups out of OS maintenance windows.
+//
+// Windows runs Automatic Maintenance (disk optimization, updates, Defender
+// scans) at 02:00 by default - exactly when many people schedule backups.
+// Both are I/O heavy, and running them together makes the machine crawl
+// and both take longer. The configured maintenance windows are read from the
+// system, and a scheduled backup that would start inside one is logged or,
+// with the maintenance_conflicts setting set to "shift", postponed until the
+// window ends.
+//
+// Key design decisions:
+//
+// 1. Scheduled runs only: "Backup now", hotkeys and shutdown backups start
+//    when asked; someone is waiting for those.
+//
+// 2. Start times only: A backup already running when a window opens keeps
+//    running. Stopping it would only make the next run copy more.
+//
+// 3. Read from the system each time: Windows keeps the windows in the
+//    registry, where Group Policy or the Security Center may change them at
+//    any time, so they are read again for every check.
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Values of the maintenance_conflicts setting
+const (
+	MaintenanceConflictLog    = "log"    // Log backups starting in a maintenance window (default)
+	MaintenanceConflictShift  = "shift"  // Postpone them until the window ends
+	MaintenanceConflictIgnore = "ignore" // Don't check
+)
+
+// maintenanceWindowLength is how long a maintenance window is assumed to
+// last; Windows stops Automatic Maintenance after an hour
+const maintenanceWindowLength = time.Hour
+
+// maintenanceWindow is a recurring period of OS maintenance.
+type maintenanceWindow struct {
+	name     string        // Shown in logs, e.g. "Windows Automatic Maintenance"
+	start    int           // Minutes after local midnight
+	length   time.Duration // How long it lasts
+	everyDay bool          // Runs daily; otherwise weekly on weekday
+	weekday  time.Weekday
+}
+
+// endAt returns when the occurrence of the window containing t ends, and
+// false if t is outside the window.
+func (mw maintenanceWindow) endAt(t time.Time) (time.Time, bool) {
+	// The occurrence containing t started today or, for windows past
+	// midnight, yesterday
+	for daysBack := 0; daysBack <= 1; daysBack++ {
+		day := t.AddDate(0, 0, -daysBack)
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, mw.start, 0, 0, t.Location())
+		if !mw.everyDay && start.Weekday() != mw.weekday {
+			continue
+		}
+		end := start.Add(mw.length)
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// activeMaintenanceWindow returns the first of windows that contains t and
+// when it ends.
+func activeMaintenanceWindow(windows []maintenanceWindow, t time.Time) (maintenanceWindow, time.Time, bool) {
+	for _, window := range windows {
+		if end, inside := window.endAt(t); inside {
+			return window, end, true
+		}
+	}
+	return maintenanceWindow{}, time.Time{}, false
+}
+
+// awaitMaintenanceWindow checks a scheduled backup's start against the
+// system's maintenance windows and, in shift mode, waits until the window
+// has ended.
+//
+// Returns false if ctx ended while waiting.
+func awaitMaintenanceWindow(ctx context.Context, config BackupConfig, logger *log.Logger, clock Clock) bool {
+	settings := currentSettings()
+	mode := settings.GetMaintenanceConflicts()
+	if mode == MaintenanceConflictIgnore {
+		return true
+	}
+	window, end, inside := activeMaintenanceWindow(systemMaintenanceWindows(), clock.Now())
+	if !inside {
+		return true
+	}
+	if mode != MaintenanceConflictShift {
+		logger.Printf("Backup of %s starts during %s (until %s); set \"maintenance_conflicts\": \"shift\" to postpone it",
+			config.Name, window.name, end.Format("15:04"))
+		return true
+	}
+
+	logger.Printf("Postponing backup of %s until %s to avoid %s", config.Name, end.Format("15:04"), window.name)
+	backupStatus.postponeNextBackup(config.Name, end)
+	requestStatusUpdate()
+	timer := clock.NewTimer(end.Sub(clock.Now()))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
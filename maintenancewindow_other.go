@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+// systemMaintenanceWindows returns no windows outside Windows.
+//
+// Other systems spread their maintenance over timers that differ between
+// distributions and rarely cluster at one hour, so there is nothing
+// dependable to read.
+func systemMaintenanceWindows() []maintenanceWindow {
+	return nil
+}
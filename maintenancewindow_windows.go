@@ -0,0 +1,58 @@
+//go:build windows
+
+package main
+
+import "time"
+
+// Registry locations of the Automatic Maintenance schedule and the Defender scan policy
+const (
+	automaticMaintenanceKey = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\Schedule\Maintenance`
+	defenderScanPolicyKey   = `SOFTWARE\Policies\Microsoft\Windows Defender\Scan`
+)
+
+// defaultMaintenanceStart is when Automatic Maintenance runs unless configured otherwise (02:00)
+const defaultMaintenanceStart = 2 * 60
+
+// systemMaintenanceWindows returns Windows' Automatic Maintenance window and,
+// when set by policy, the Defender scheduled scan.
+//
+// Without a policy, Defender's scheduled scan runs as part of Automatic
+// Maintenance and is covered by its window.
+func systemMaintenanceWindows() []maintenanceWindow {
+	var windows []maintenanceWindow
+
+	if disabled, _ := regGetDWORD(HKEY_LOCAL_MACHINE, automaticMaintenanceKey, "MaintenanceDisabled"); disabled != 1 {
+		start := defaultMaintenanceStart
+		// Stored as a full timestamp of which only the time of day matters, e.g. "2000-01-01T02:00:00"
+		if boundary, found := regGetString(HKEY_LOCAL_MACHINE, automaticMaintenanceKey, "Activation Boundary"); found {
+			if t, err := time.Parse("2006-01-02T15:04:05", boundary); err == nil {
+				start = t.Hour()*60 + t.Minute()
+			}
+		}
+		windows = append(windows, maintenanceWindow{
+			name:     "Windows Automatic Maintenance",
+			start:    start,
+			length:   maintenanceWindowLength,
+			everyDay: true,
+		})
+	}
+
+	// ScheduleDay: 0 = every day, 1-7 = Sunday-Saturday, 8 = never
+	if day, found := regGetDWORD(HKEY_LOCAL_MACHINE, defenderScanPolicyKey, "ScheduleDay"); found && day <= 7 {
+		start := defaultMaintenanceStart
+		if minutes, found := regGetDWORD(HKEY_LOCAL_MACHINE, defenderScanPolicyKey, "ScheduleTime"); found && minutes < 24*60 {
+			start = int(minutes)
+		}
+		window := maintenanceWindow{
+			name:     "Microsoft Defender scheduled scan",
+			start:    start,
+			length:   maintenanceWindowLength,
+			everyDay: day == 0,
+		}
+		if day > 0 {
+			window.weekday = time.Weekday(day - 1)
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
@@ -0,0 +1,166 @@
+// Package main - manifest.go records what each snapshot contains.
+//
+// Every file copied into a snapshot is hashed while it is copied, so the
+// content hash costs no extra read of the source. The hashes are saved as a
+// manifest next to the snapshot (in manifestDir, so the snapshot folder
+// itself stays an exact copy of the source). With verify_copies, each copy is
+// also read back and compared against the hash taken in flight - one read of
+// the source and one of the copy, instead of reading both in full afterwards.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestDir holds the manifests inside a destination; like safety
+// snapshots, it is ignored by rotation and snapshot listings
+const manifestDir = ".manifests"
+
+// ManifestFile is one file in a snapshot.
+type ManifestFile struct {
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`          // Hex-encoded content hash
+	ModTime int64  `json:"mtime,omitempty"` // Source mtime (UnixNano) when copied; lets incremental backups link the file
+}
+
+// Manifest lists the files of a snapshot by slash-separated relative path.
+type Manifest struct {
+	Snapshot string                  `json:"snapshot"` // Snapshot directory name
+	Created  time.Time               `json:"created"`
+	Files    map[string]ManifestFile `json:"files"`
+}
+
+// manifestBuilder collects file hashes while a snapshot is copied.
+type manifestBuilder struct {
+	mu    sync.Mutex
+	root  string // Snapshot directory the recorded paths are relative to
+	files map[string]ManifestFile
+}
+
+// newManifestBuilder starts a manifest for the snapshot being written to root.
+func newManifestBuilder(root string) *manifestBuilder {
+	return &manifestBuilder{root: root, files: make(map[string]ManifestFile)}
+}
+
+// add records a file copied or linked to dstPath. A nil builder records nothing.
+func (mb *manifestBuilder) add(dstPath string, file ManifestFile) error {
+	if mb == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(mb.root, dstPath)
+	if err != nil {
+		return err
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.files[filepath.ToSlash(rel)] = file
+	return nil
+}
+
+// totalSize returns the combined size of the recorded files.
+func (mb *manifestBuilder) totalSize() int64 {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	var total int64
+	for _, file := range mb.files {
+		total += file.Size
+	}
+	return total
+}
+
+// fileCount returns the number of recorded files.
+func (mb *manifestBuilder) fileCount() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.files)
+}
+
+// addCopied hashes a file that was copied without in-flight hashing.
+func (mb *manifestBuilder) addCopied(dstPath string) error {
+	if mb == nil {
+		return nil
+	}
+	hash, size, err := hashFile(dstPath)
+	if err != nil {
+		return err
+	}
+	return mb.add(dstPath, ManifestFile{Size: size, SHA256: hash})
+}
+
+// moved records that the snapshot was renamed to root, e.g. from its staging directory.
+func (mb *manifestBuilder) moved(root string) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.root = root
+}
+
+// manifestPath returns where the manifest of a snapshot in destination is stored.
+func manifestPath(destination, snapshotName string) string {
+	return filepath.Join(destination, manifestDir, snapshotName+".json")
+}
+
+// save writes the manifest of the snapshot at root into destination.
+func (mb *manifestBuilder) save(destination string) error {
+	mb.mu.Lock()
+	manifest := Manifest{Snapshot: filepath.Base(mb.root), Created: time.Now(), Files: mb.files}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	mb.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path := manifestPath(destination, manifest.Snapshot)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// loadManifest reads the manifest of a snapshot.
+//
+// Snapshots taken before manifests existed have none; the error then
+// satisfies os.IsNotExist.
+func loadManifest(destination, snapshotName string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(destination, snapshotName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest for %s: %v", snapshotName, err)
+	}
+	return &manifest, nil
+}
+
+// removeManifest deletes the manifest of a snapshot removed by rotation.
+func removeManifest(destination, snapshotName string) error {
+	err := os.Remove(manifestPath(destination, snapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// hashFile returns the hex SHA-256 and size of a file.
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
@@ -27,7 +27,11 @@
 // users who prefer alternative platforms.
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
 
 // showMessageBox displays an error message via console output on non-Windows platforms.
 //
@@ -44,4 +48,48 @@ import "fmt"
 // is more common.
 func showMessageBox(title, message string) {
 	fmt.Printf("%s: %s\n", title, message)
-}
\ No newline at end of file
+}
+
+// askConfirmation asks a Yes/No question and reports whether the user agreed.
+//
+// Uses zenity when available so desktop users get a real dialog. Without it
+// there is no way to ask (the tray has no console), so the question is printed
+// and treated as declined - destructive actions must never proceed unconfirmed.
+func askConfirmation(title, message string) bool {
+	if _, err := exec.LookPath("zenity"); err == nil {
+		return exec.Command("zenity", "--question", "--title="+title, "--text="+message).Run() == nil
+	}
+	fmt.Printf("%s: %s (no dialog available, not confirmed)\n", title, message)
+	return false
+}
+
+// chooseFolder asks the user to pick a folder and returns it.
+//
+// Like askConfirmation, this needs zenity; without it no folder is chosen.
+func chooseFolder(title string) (string, bool) {
+	if _, err := exec.LookPath("zenity"); err != nil {
+		fmt.Printf("%s (no dialog available)\n", title)
+		return "", false
+	}
+	output, err := exec.Command("zenity", "--file-selection", "--directory", "--title="+title).Output()
+	folder := strings.TrimSpace(string(output))
+	if err != nil || folder == "" {
+		return "", false
+	}
+	return folder, true
+}
+
+// askText asks the user for a line of text, prefilled with initial.
+//
+// Like askConfirmation, this needs zenity; without it nothing is entered.
+func askText(title, prompt, initial string) (string, bool) {
+	if _, err := exec.LookPath("zenity"); err != nil {
+		fmt.Printf("%s: %s (no dialog available)\n", title, prompt)
+		return "", false
+	}
+	output, err := exec.Command("zenity", "--entry", "--title="+title, "--text="+prompt, "--entry-text="+initial).Output()
+	if err != nil {
+		return "", false // Cancelled
+	}
+	return strings.TrimSpace(string(output)), true
+}
@@ -26,6 +26,10 @@
 package main
 
 import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
 	"syscall"
 	"unsafe"
 )
@@ -33,15 +37,47 @@ import (
 // Windows API constants for MessageBoxW function
 const (
 	MB_OK          = 0x00000000 // OK button only
+	MB_YESNO       = 0x00000004 // Yes and No buttons
 	MB_ICONWARNING = 0x00000030 // Warning icon display
+	MB_DEFBUTTON2  = 0x00000100 // Second button (No) is the default
+	MB_TOPMOST     = 0x00040000 // Keep above other windows (no parent window)
+	IDYES          = 6          // Return value when Yes is clicked
+)
+
+// Windows API constants for SHBrowseForFolderW
+const (
+	BIF_RETURNONLYFSDIRS     = 0x00000001 // Only file system folders can be chosen
+	BIF_NEWDIALOGSTYLE       = 0x00000040 // Resizable dialog with a "Make New Folder" button
+	COINIT_APARTMENTTHREADED = 0x00000002 // Required by BIF_NEWDIALOGSTYLE
+	MAX_PATH                 = 260        // Path buffer length in UTF-16 units
 )
 
 // Lazy-loaded Windows API functions for runtime efficiency
 var (
 	user32          = syscall.NewLazyDLL("user32.dll")   // User interface API library
 	procMessageBoxW = user32.NewProc("MessageBoxW")      // Unicode message box function
+
+	shell32                  = syscall.NewLazyDLL("shell32.dll")
+	procSHBrowseForFolderW   = shell32.NewProc("SHBrowseForFolderW")
+	procSHGetPathFromIDListW = shell32.NewProc("SHGetPathFromIDListW")
+	ole32                    = syscall.NewLazyDLL("ole32.dll")
+	procCoInitializeEx       = ole32.NewProc("CoInitializeEx")
+	procCoUninitialize       = ole32.NewProc("CoUninitialize")
+	procCoTaskMemFree        = ole32.NewProc("CoTaskMemFree")
 )
 
+// browseInfo mirrors the Windows BROWSEINFOW structure
+type browseInfo struct {
+	owner       uintptr
+	root        uintptr
+	displayName *uint16
+	title       *uint16
+	flags       uint32
+	callback    uintptr
+	param       uintptr
+	image       int32
+}
+
 // showMessageBox displays a native Windows message box with warning icon.
 //
 // This is the Windows-specific implementation of the cross-platform message box
@@ -66,4 +102,76 @@ func showMessageBox(title, message string) {
 		uintptr(unsafe.Pointer(titlePtr)),
 		uintptr(MB_OK|MB_ICONWARNING), // OK button with warning icon
 	)
-}
\ No newline at end of file
+}
+
+// askConfirmation displays a Yes/No warning dialog and reports whether Yes was clicked.
+//
+// Used before destructive actions such as restores. "No" is the default button
+// so an accidental Enter keypress never confirms, and the dialog is topmost
+// because there is no parent window to keep it in front of.
+func askConfirmation(title, message string) bool {
+	titlePtr, _ := syscall.UTF16PtrFromString(title)
+	messagePtr, _ := syscall.UTF16PtrFromString(message)
+	
+	ret, _, _ := procMessageBoxW.Call(
+		0,
+		uintptr(unsafe.Pointer(messagePtr)),
+		uintptr(unsafe.Pointer(titlePtr)),
+		uintptr(MB_YESNO|MB_ICONWARNING|MB_DEFBUTTON2|MB_TOPMOST),
+	)
+	return ret == IDYES
+}
+
+// chooseFolder shows the Windows folder picker and returns the chosen folder.
+//
+// The dialog uses COM, which must be initialized on the thread that shows it,
+// so the goroutine is locked to its OS thread for the duration.
+func chooseFolder(title string) (string, bool) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	procCoInitializeEx.Call(0, COINIT_APARTMENTTHREADED)
+	defer procCoUninitialize.Call()
+
+	titlePtr, _ := syscall.UTF16PtrFromString(title)
+	displayName := make([]uint16, MAX_PATH)
+	info := browseInfo{
+		displayName: &displayName[0],
+		title:       titlePtr,
+		flags:       BIF_RETURNONLYFSDIRS | BIF_NEWDIALOGSTYLE,
+	}
+	pidl, _, _ := procSHBrowseForFolderW.Call(uintptr(unsafe.Pointer(&info)))
+	if pidl == 0 {
+		return "", false // Cancelled
+	}
+	defer procCoTaskMemFree.Call(pidl)
+
+	path := make([]uint16, MAX_PATH)
+	ok, _, _ := procSHGetPathFromIDListW.Call(pidl, uintptr(unsafe.Pointer(&path[0])))
+	if ok == 0 {
+		return "", false // Not a file system folder
+	}
+	return syscall.UTF16ToString(path), true
+}
+
+// inputBoxScript shows the Visual Basic input box, which Windows has no
+// plain API for. Texts are passed in environment variables so they need no
+// quoting, and the answer is written as UTF-8.
+const inputBoxScript = `Add-Type -AssemblyName Microsoft.VisualBasic
+[Console]::OutputEncoding = [Text.Encoding]::UTF8
+$answer = [Microsoft.VisualBasic.Interaction]::InputBox($env:SFB_PROMPT, $env:SFB_TITLE, $env:SFB_INITIAL)
+if ($answer -eq '') { exit 1 }
+[Console]::Out.Write($answer)`
+
+// askText asks the user for a line of text, prefilled with initial.
+//
+// The input box can't tell Cancel from an empty answer, so both report false.
+func askText(title, prompt, initial string) (string, bool) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", inputBoxScript)
+	cmd.Env = append(os.Environ(), "SFB_TITLE="+title, "SFB_PROMPT="+prompt, "SFB_INITIAL="+initial)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: CREATE_NO_WINDOW}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(output)), true
+}
@@ -0,0 +1,293 @@
+// Package main - migrate.go moves existing snapshots into a config's current layout.
+//
+// Changing where or how a config stores its snapshots - a new destination
+// folder of its own, a move to a NAS or bucket, turning on encryption -
+// only applies to new snapshots: the old ones stay behind, unrotated and
+// unknown to restore, comparison and incremental linking. The migrate
+// command brings the snapshots of a config found in a previous destination
+// into the layout the config uses now:
+//
+// 1. Folder snapshots to folders: Moved with their manifests and notes, by
+//    renaming on the same volume and by copying otherwise. Copies link files
+//    unchanged since the previous snapshot like incremental backups do, and
+//    keep the modification times of files and folders.
+//
+// 2. Folder snapshots to archives: Packed into archives named like the
+//    snapshot (e.g. 15-01-2024_14-30-00_Documents.zip.enc), encrypted if the
+//    config is, and written to its folder, bucket or server.
+//
+// 3. Archives to archives: Copied as they are. Archives can't become folders
+//    again here; the decrypt command unpacks one by hand.
+//
+// Names keep their timestamps, so the migrated snapshots rotate, thin and
+// match retention exceptions exactly as if they had been taken in the new
+// layout. Snapshots already present in the new layout are skipped, so an
+// interrupted migration can simply be run again.
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MigrationResult summarizes a migration.
+type MigrationResult struct {
+	Migrated int   // Snapshots brought into the new layout
+	Skipped  int   // Already present in the new layout
+	Bytes    int64 // Written to archives; moved folders aren't counted
+}
+
+// migrationSource is a snapshot found in the previous destination.
+type migrationSource struct {
+	Snapshot
+	archive bool // A .zip or .zip.enc file rather than a folder
+}
+
+// migrateDestination moves the snapshots of config found in from into the
+// config's current destination and layout. With keep, the originals are left
+// in place; with report, each step is described before it is carried out,
+// and nothing is changed if dryRun is set.
+func migrateDestination(config BackupConfig, from string, keep, dryRun bool, report func(string)) (MigrationResult, error) {
+	var result MigrationResult
+	from, err := filepath.Abs(from)
+	if err != nil {
+		return result, err
+	}
+	toArchives := writesArchiveSnapshots(config)
+	if !toArchives && filepath.Clean(from) == filepath.Clean(config.Destination) {
+		return result, fmt.Errorf("%s already keeps its snapshots as folders in %s", config.Name, from)
+	}
+	sources, err := migrationSources(from, config.Source)
+	if err != nil {
+		return result, fmt.Errorf("failed to list %s: %v", from, err)
+	}
+	if len(sources) == 0 {
+		return result, fmt.Errorf("no snapshots of %s found in %s", config.Name, from)
+	}
+
+	previousConfig := config
+	previousConfig.Destination = from
+	if toArchives {
+		return migrateToArchives(config, previousConfig, sources, keep, dryRun, report)
+	}
+
+	previous := "" // Last snapshot in the new destination, for linking
+	for _, source := range sources {
+		target := filepath.Join(config.Destination, source.Name)
+		switch {
+		case source.archive:
+			report(fmt.Sprintf("%s: skipped, archives can't be turned back into folders (unpack it with the decrypt command)", source.Name))
+			result.Skipped++
+			continue
+		case pathExists(target):
+			report(fmt.Sprintf("%s: already in %s", source.Name, config.Destination))
+			result.Skipped++
+			previous = source.Name
+			continue
+		}
+		report(fmt.Sprintf("%s: moving to %s", source.Name, config.Destination))
+		if !dryRun {
+			if err := moveSnapshotFolder(previousConfig, source.Snapshot, config.Destination, previous, keep); err != nil {
+				return result, fmt.Errorf("%s: %v", source.Name, err)
+			}
+		}
+		result.Migrated++
+		previous = source.Name
+	}
+	return result, nil
+}
+
+// migrateToArchives writes the snapshots of a previous destination as
+// archives into config's store.
+func migrateToArchives(config, previousConfig BackupConfig, sources []migrationSource, keep, dryRun bool, report func(string)) (MigrationResult, error) {
+	var result MigrationResult
+	var passphrase string
+	extension := remoteArchiveExt
+	if config.IsEncrypted() {
+		var err error
+		if passphrase, err = snapshotPassphrase(config); err != nil {
+			return result, err
+		}
+		extension += encryptedExportExtension
+	}
+	store, err := openRemoteStore(config)
+	if err != nil {
+		return result, err
+	}
+	defer store.close()
+	objects, err := store.list()
+	if err != nil {
+		return result, fmt.Errorf("failed to list %s: %v", store.describe(), err)
+	}
+	existing := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		existing[object.Name] = true
+	}
+
+	for _, source := range sources {
+		name := source.Name + extension
+		if source.archive {
+			name = filepath.Base(source.Path)
+		}
+		if existing[name] || existing[source.Name+remoteArchiveExt] || existing[source.Name+remoteArchiveExt+encryptedExportExtension] {
+			report(fmt.Sprintf("%s: already in %s", source.Name, store.describe()))
+			result.Skipped++
+			continue
+		}
+		report(fmt.Sprintf("%s: writing %s to %s", source.Name, name, store.describe()))
+		if dryRun {
+			result.Migrated++
+			continue
+		}
+
+		var written int64
+		if source.archive {
+			written, err = uploadFile(store, name, source.Path)
+		} else {
+			written, err = uploadSnapshotArchive(store, name, snapshotArchiveConfig(config, source.Path), passphrase, nil, nil)
+		}
+		if err != nil {
+			return result, fmt.Errorf("%s: %v", source.Name, err)
+		}
+		result.Migrated++
+		result.Bytes += written
+		if !keep {
+			if err := removeMigratedSource(previousConfig, source); err != nil {
+				return result, fmt.Errorf("%s was migrated, but the original could not be removed: %v", source.Name, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// migrationSources lists the folder snapshots and snapshot archives of
+// source in dir, oldest first.
+func migrationSources(dir, source string) ([]migrationSource, error) {
+	snapshots, err := listSnapshotsIn(dir, source)
+	if err != nil {
+		return nil, err
+	}
+	var sources []migrationSource
+	for _, snapshot := range snapshots {
+		sources = append(sources, migrationSource{Snapshot: snapshot})
+	}
+
+	archives, err := remoteSnapshots(BackupConfig{Source: source}, folderStore{dir: dir})
+	if err != nil {
+		return nil, err
+	}
+	for _, archive := range archives {
+		name := strings.TrimSuffix(strings.TrimSuffix(archive.name, encryptedExportExtension), remoteArchiveExt)
+		sources = append(sources, migrationSource{
+			Snapshot: Snapshot{Name: name, Path: filepath.Join(dir, archive.name), Time: archive.taken},
+			archive:  true,
+		})
+	}
+	slices.SortStableFunc(sources, func(a, b migrationSource) int { return a.Time.Compare(b.Time) })
+	return sources, nil
+}
+
+// moveSnapshotFolder moves a snapshot with its manifest and note from the
+// destination of previousConfig to destination.
+func moveSnapshotFolder(previousConfig BackupConfig, snapshot Snapshot, destination, previous string, keep bool) error {
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(destination, snapshot.Name)
+	if !keep && os.Rename(snapshot.Path, target) == nil {
+		// Same volume: the folder moved as it is; its manifest and note follow
+		if manifest := manifestPath(previousConfig.Destination, snapshot.Name); pathExists(manifest) {
+			if err := os.MkdirAll(filepath.Dir(manifestPath(destination, snapshot.Name)), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(manifest, manifestPath(destination, snapshot.Name)); err != nil {
+				return err
+			}
+		}
+		if err := saveSnapshotNote(destination, snapshot.Name, snapshot.Note); err != nil {
+			return err
+		}
+		return removeSnapshotNote(previousConfig.Destination, snapshot.Name)
+	}
+
+	// Another volume, or the original stays: copy like a replica does
+	if err := copySnapshotToReplica(previousConfig, snapshot, destination, previous); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(destination, replicaPartialDir)) // Only goes if empty
+	if err := keepModTimes(snapshot.Path, target); err != nil {
+		return err
+	}
+	if keep {
+		return nil
+	}
+	return removeMigratedSource(previousConfig, migrationSource{Snapshot: snapshot})
+}
+
+// keepModTimes gives the files and folders copied from src to dst the
+// modification times of the originals.
+func keepModTimes(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil || !(info.IsDir() || info.Mode().IsRegular()) {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(filepath.Join(dst, rel), info.ModTime(), info.ModTime())
+	})
+}
+
+// removeMigratedSource deletes a snapshot, its manifest and its note from the
+// destination of previousConfig once it has been migrated.
+func removeMigratedSource(previousConfig BackupConfig, source migrationSource) error {
+	if source.archive {
+		return os.Remove(source.Path)
+	}
+	if err := os.RemoveAll(source.Path); err != nil {
+		return err
+	}
+	if err := removeManifest(previousConfig.Destination, source.Name); err != nil {
+		return err
+	}
+	return removeSnapshotNote(previousConfig.Destination, source.Name)
+}
+
+// snapshotArchiveConfig returns config with a snapshot folder as its source,
+// so writeSnapshotStream packs the whole snapshot: the config's exclusions
+// were applied when it was taken.
+func snapshotArchiveConfig(config BackupConfig, snapshotPath string) BackupConfig {
+	config.Source = snapshotPath
+	config.Exclude, config.ExcludePresets, config.ExcludeNestedSources = nil, nil, false
+	config.MaxDepth, config.FollowLinks = nil, false
+	return config
+}
+
+// uploadFile writes the file at path to store as name and returns its size.
+func uploadFile(store remoteStore, name, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	counted := &countingReader{r: f}
+	if err := store.upload(name, counted); err != nil {
+		return 0, err
+	}
+	return counted.n, nil
+}
+
+// pathExists reports whether a file or folder exists at path.
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,135 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Window messages handled by hidden message windows
+const (
+	WM_QUERYENDSESSION = 0x0011
+	WM_ENDSESSION      = 0x0016
+)
+
+var (
+	procRegisterClassExW = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW  = user32.NewProc("CreateWindowExW")
+	procDefWindowProcW   = user32.NewProc("DefWindowProcW")
+	procGetMessageW      = user32.NewProc("GetMessageW")
+	procTranslateMessage = user32.NewProc("TranslateMessage")
+	procDispatchMessageW = user32.NewProc("DispatchMessageW")
+	procGetModuleHandleW = kernel32.NewProc("GetModuleHandleW")
+)
+
+// wndClassEx mirrors the Win32 WNDCLASSEXW structure
+type wndClassEx struct {
+	cbSize        uint32
+	style         uint32
+	lpfnWndProc   uintptr
+	cbClsExtra    int32
+	cbWndExtra    int32
+	hInstance     syscall.Handle
+	hIcon         syscall.Handle
+	hCursor       syscall.Handle
+	hbrBackground syscall.Handle
+	lpszMenuName  *uint16
+	lpszClassName *uint16
+	hIconSm       syscall.Handle
+}
+
+// winMsg mirrors the Win32 MSG structure
+type winMsg struct {
+	hwnd    syscall.Handle
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	ptX     int32
+	ptY     int32
+}
+
+// messageHandler processes a window message; handled=false defers to DefWindowProc
+type messageHandler func(hwnd syscall.Handle, msg uint32, wParam, lParam uintptr) (result uintptr, handled bool)
+
+// startMessageWindow creates a hidden top-level window and pumps its messages.
+//
+// Some notifications (session end, global hotkeys) are only delivered to a
+// window owned by the receiving thread, and the systray library doesn't expose
+// its own window. The window is top-level rather than message-only because
+// message-only windows don't receive broadcast messages like WM_QUERYENDSESSION.
+//
+// The ready callback runs on the window's thread after creation, which is where
+// thread-affine registrations such as RegisterHotKey must happen. The function
+// returns once the window exists; the message loop keeps running in the
+// background for the lifetime of the process.
+func startMessageWindow(className string, handler messageHandler, ready func(hwnd syscall.Handle)) error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		// Window messages are delivered to the creating thread only
+		runtime.LockOSThread()
+
+		classNamePtr, err := syscall.UTF16PtrFromString(className)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		instance, _, _ := procGetModuleHandleW.Call(0)
+
+		wndProc := syscall.NewCallback(func(hwnd syscall.Handle, msg uint32, wParam, lParam uintptr) uintptr {
+			if result, handled := handler(hwnd, msg, wParam, lParam); handled {
+				return result
+			}
+			result, _, _ := procDefWindowProcW.Call(uintptr(hwnd), uintptr(msg), wParam, lParam)
+			return result
+		})
+
+		class := wndClassEx{
+			lpfnWndProc:   wndProc,
+			hInstance:     syscall.Handle(instance),
+			lpszClassName: classNamePtr,
+		}
+		class.cbSize = uint32(unsafe.Sizeof(class))
+		if atom, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&class))); atom == 0 {
+			errCh <- fmt.Errorf("failed to register window class %s: %v", className, err)
+			return
+		}
+
+		hwnd, _, err := procCreateWindowExW.Call(
+			0, // dwExStyle
+			uintptr(unsafe.Pointer(classNamePtr)),
+			uintptr(unsafe.Pointer(classNamePtr)), // Window name (never shown)
+			0,                                     // dwStyle - not WS_VISIBLE, so the window stays hidden
+			0, 0, 0, 0,                            // Position and size
+			0, // hWndParent - top-level so broadcasts are received
+			0, // hMenu
+			instance,
+			0, // lpParam
+		)
+		if hwnd == 0 {
+			errCh <- fmt.Errorf("failed to create window %s: %v", className, err)
+			return
+		}
+
+		if ready != nil {
+			ready(syscall.Handle(hwnd))
+		}
+		errCh <- nil
+
+		var m winMsg
+		for {
+			ret, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+			if int32(ret) <= 0 {
+				return // WM_QUIT or error
+			}
+			procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+			procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+		}
+	}()
+
+	return <-errCh
+}
@@ -3,41 +3,56 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"syscall"
 )
 
+// lockFilePath holds the PID of the running instance
+const lockFilePath = "SimpleFolderBackup.lock"
+
 type Mutex struct {
 	lockFile *os.File
 }
 
-// Fallback to file-based locking on non-Windows platforms
+// Fallback to file-based locking on non-Windows platforms.
+//
+// The lock file is never removed: it is opened (or created) and the instance
+// that gets an exclusive flock on it is the one that runs. The kernel
+// releases the flock when its process dies, however it dies, so a crashed
+// instance never blocks the next start, and there is no window in which two
+// starters can each replace the other's file. The PID written into the file
+// is only for people looking at it.
 func acquireMutex() (*Mutex, error) {
-	lockFilePath := "SimpleFolderBackup.lock"
-	
-	lockFile, err := os.OpenFile(lockFilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	lockFile, err := os.OpenFile(lockFilePath, os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
-		if os.IsExist(err) {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lockFile.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
 			return nil, fmt.Errorf("another instance is already running")
 		}
-		return nil, fmt.Errorf("failed to create lock file: %v", err)
+		return nil, fmt.Errorf("failed to lock lock file: %v", err)
+	}
+
+	// Replaces the PID of whichever instance held the lock before
+	if err := lockFile.Truncate(0); err == nil {
+		_, err = lockFile.WriteAt(fmt.Appendf(nil, "%d\n", os.Getpid()), 0)
 	}
-	
-	// Write PID to lock file
-	_, err = fmt.Fprintf(lockFile, "%d\n", os.Getpid())
 	if err != nil {
-		lockFile.Close()
-		os.Remove(lockFilePath)
-		return nil, fmt.Errorf("failed to write to lock file: %v", err)
+		fmt.Fprintf(os.Stderr, "Failed to write the PID to the lock file: %v\n", err)
 	}
-	
+
 	return &Mutex{lockFile: lockFile}, nil
 }
 
+// release unlocks the lock file. The file stays, so a starter that opened it
+// just now locks the same file rather than one nobody else sees.
 func (m *Mutex) release() {
 	if m.lockFile != nil {
 		m.lockFile.Close()
-		os.Remove(m.lockFile.Name())
 		m.lockFile = nil
 	}
-}
\ No newline at end of file
+}
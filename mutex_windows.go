@@ -4,6 +4,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"syscall"
 	"unsafe"
 )
@@ -12,14 +13,25 @@ var (
 	kernel32      = syscall.NewLazyDLL("kernel32.dll")
 	procCreateMutex = kernel32.NewProc("CreateMutexW")
 	procCloseHandle = kernel32.NewProc("CloseHandle")
+	procProcessIdToSessionId = kernel32.NewProc("ProcessIdToSessionId")
 )
 
+// instanceName makes the mutex and pipe names unique per user and logon
+// session, so users of a shared machine each run their own instance.
+func instanceName() string {
+	var session uint32
+	procProcessIdToSessionId.Call(uintptr(os.Getpid()), uintptr(unsafe.Pointer(&session)))
+	return fmt.Sprintf("SimpleFolderBackup_%s_%d", userInstanceKey(), session)
+}
+
 type Mutex struct {
 	handle syscall.Handle
 }
 
+// acquireMutex creates the named mutex of this instance. Windows closes the
+// handle of a process however it ends, so a crash never leaves it behind.
 func acquireMutex() (*Mutex, error) {
-	mutexName := "Global\\SimpleFolderBackup_SingleInstance"
+	mutexName := "Local\\" + instanceName()
 	mutexNamePtr, err := syscall.UTF16PtrFromString(mutexName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert mutex name: %v", err)
@@ -0,0 +1,216 @@
+// Package main - naming.go owns how backups and logs are named on disk.
+//
+// Snapshot directories, safety snapshots, log files and per-config folders
+// are all identified by their names, and rotation, restore and status each
+// need to produce and recognize those names identically. Every rule about
+// formatting, parsing, matching and sanitizing names lives here, so features
+// don't each reimplement them with their own string slicing.
+//
+// Key design decisions:
+//
+// 1. Timestamp first: Snapshot names are "<timestamp>_<source folder>", e.g.
+//    "02-01-2006_15-04-05_data", so they sort chronologically within a source
+//    and stay readable in a file manager.
+//
+// 2. One policy value: Snapshot naming is a snapshotNaming value rather than
+//    loose constants, so a configurable naming template only has to provide
+//    another policy instead of touching every caller.
+//
+// 3. Exact matching: A name matches a source only if the separator sits where
+//    the timestamp ends. Suffix matching alone would count the snapshots of
+//    "my-data" as snapshots of "data" when both share a destination.
+//
+// 4. Lenient timestamps: A name with the right shape but an unparsable
+//    timestamp (e.g. renamed by hand) still matches; callers fall back to the
+//    directory's modification time.
+//
+// 5. Local time: Timestamps are formatted and parsed in the local timezone to
+//    match what users see in the tray and in their file manager.
+//
+// 6. Old names keep counting: Snapshots are only written with snapshotNames,
+//    but recognized in every format of legacySnapshotNames too. Changing the
+//    format (or adding naming templates) moves the previous policy there, so
+//    existing snapshots stay in rotation, retention and scheduling instead of
+//    being orphaned.
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Date format constants used throughout the application for consistency.
+//
+// BackupTimestampFormat: Used for backup directory names, includes time for uniqueness
+// LogDateFormat: Used for daily log file names, date-only for daily rotation
+//
+// Both formats use Go's reference time (Mon Jan 2 15:04:05 MST 2006) which
+// corresponds to Unix timestamp 1136239445.
+const (
+	BackupTimestampFormat = "02-01-2006_15-04-05" // DD-MM-YYYY_HH-MM-SS format
+	LogDateFormat         = "02-01-2006"          // DD-MM-YYYY format for daily logs
+)
+
+// snapshotNaming is a policy for naming the snapshot directories of a source.
+type snapshotNaming struct {
+	layout    string // Go time layout of the timestamp prefix
+	separator string // Between the timestamp and the source folder name
+}
+
+// snapshotNames is the naming policy of all snapshots
+var snapshotNames = snapshotNaming{layout: BackupTimestampFormat, separator: "_"}
+
+// legacySnapshotNames are naming policies that snapshots are recognized in
+// but no longer written in, e.g. after renaming them to sort by date or
+// importing them from another tool.
+var legacySnapshotNames = []snapshotNaming{
+	{layout: "2006-01-02_15-04-05", separator: "_"}, // YYYY-MM-DD_HH-MM-SS
+	{layout: "20060102_150405", separator: "_"},     // YYYYMMDD_HHMMSS
+}
+
+// stagingSuffix marks a snapshot directory that is still being written
+const stagingSuffix = ".partial"
+
+// format returns the name of a snapshot of sourceFolderName taken at t.
+func (sn snapshotNaming) format(sourceFolderName string, t time.Time) string {
+	return t.Format(sn.layout) + sn.separator + sourceFolderName
+}
+
+// timestampPart returns the timestamp portion of name if name belongs to sourceFolderName.
+func (sn snapshotNaming) timestampPart(name, sourceFolderName string) (string, bool) {
+	prefix, found := strings.CutSuffix(name, sn.separator+sourceFolderName)
+	if !found || len(prefix) != len(sn.layout) {
+		return "", false
+	}
+	return prefix, true
+}
+
+// matches reports whether name is a snapshot of sourceFolderName.
+func (sn snapshotNaming) matches(name, sourceFolderName string) bool {
+	_, ok := sn.timestampPart(name, sourceFolderName)
+	return ok
+}
+
+// parse returns when the snapshot called name was taken.
+//
+// Returns zero time and nil error for names that aren't snapshots of
+// sourceFolderName, and an error for snapshot names whose timestamp doesn't
+// parse.
+func (sn snapshotNaming) parse(name, sourceFolderName string) (time.Time, error) {
+	timestamp, ok := sn.timestampPart(name, sourceFolderName)
+	if !ok {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(sn.layout, timestamp, time.Local)
+}
+
+// getSourceFolderName extracts the final directory name from a source path.
+//
+// The source folder name is the suffix of every snapshot name, e.g.
+// "/path/to/data" is backed up to "02-01-2006_15-04-05_data".
+func getSourceFolderName(sourcePath string) string {
+	return filepath.Base(sourcePath)
+}
+
+// isBackupDirectory checks if a directory name is a snapshot of sourceFolderName.
+//
+// Used during backup cleanup and status checking to identify relevant backup
+// directories while ignoring other directories in the destination folder.
+func isBackupDirectory(dirName, sourceFolderName string) bool {
+	if snapshotNames.matches(dirName, sourceFolderName) {
+		return true
+	}
+	for _, naming := range legacySnapshotNames {
+		if naming.matches(dirName, sourceFolderName) {
+			return true
+		}
+	}
+	return false
+}
+
+// stagingDirName returns the name a snapshot is written under until it is complete.
+func stagingDirName(snapshotName string) string {
+	return snapshotName + stagingSuffix
+}
+
+// isStagingDirectory checks if a directory name is an unfinished snapshot of
+// sourceFolderName. The suffix keeps it from matching isBackupDirectory.
+func isStagingDirectory(dirName, sourceFolderName string) bool {
+	name, found := strings.CutSuffix(dirName, stagingSuffix)
+	return found && isBackupDirectory(name, sourceFolderName)
+}
+
+// parseBackupTimestamp extracts and parses the timestamp from a backup directory name.
+//
+// Returns zero time and nil error for directories that don't match the backup
+// pattern, allowing callers to distinguish between parsing errors and
+// non-backup directories. The current format is tried first, then each
+// legacy one; the error is that of the first format the name has the shape of.
+func parseBackupTimestamp(dirName, sourceFolderName string) (time.Time, error) {
+	var firstErr error
+	for _, naming := range append([]snapshotNaming{snapshotNames}, legacySnapshotNames...) {
+		taken, err := naming.parse(dirName, sourceFolderName)
+		if err == nil && !taken.IsZero() {
+			return taken, nil
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return time.Time{}, firstErr
+}
+
+// generateBackupDirName creates the snapshot directory name for a backup of
+// sourcePath started at timestamp.
+func generateBackupDirName(sourcePath string, timestamp time.Time) string {
+	return snapshotNames.format(getSourceFolderName(sourcePath), timestamp)
+}
+
+// logFileName returns the name of the daily log file "prefix_DD-MM-YYYY.log".
+func logFileName(prefix string, day time.Time) string {
+	return fmt.Sprintf("%s_%s.log", prefix, day.Format(LogDateFormat))
+}
+
+// parseLogFileDate returns the day a log file written by logFileName covers.
+//
+// Returns false for other files, which are then left alone by retention.
+func parseLogFileDate(filename string) (time.Time, bool) {
+	base, found := strings.CutSuffix(filename, ".log")
+	if !found || len(base) < len(LogDateFormat) {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(LogDateFormat, base[len(base)-len(LogDateFormat):])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// unsafeNameChars matches everything sanitizeConfigName removes
+var unsafeNameChars = regexp.MustCompile(`[^a-z0-9\-]`)
+
+// windowsDeviceNames matches names Windows reserves for devices, with or
+// without an extension, such as "con" and "con.jsonl"
+var windowsDeviceNames = regexp.MustCompile(`^(con|prn|aux|nul|com[1-9]|lpt[1-9])$`)
+
+// sanitizeConfigName converts a backup configuration name into a safe directory name.
+//
+// Names are lowercased, spaces become hyphens and any other character that
+// isn't alphanumeric is removed, so the result is valid on every filesystem
+// and still recognizable. Names Windows reserves for devices get a trailing
+// hyphen. Used for log folders, warm caches, exports and the destinations of
+// configs added from Explorer.
+//
+// Example: "Application Server" becomes "application-server", "AUX" "aux-"
+func sanitizeConfigName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, " ", "-")
+	name = unsafeNameChars.ReplaceAllString(name, "")
+	if windowsDeviceNames.MatchString(name) {
+		name += "-"
+	}
+	return name
+}
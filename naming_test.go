@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// allSnapshotNames is the current naming policy followed by the legacy ones
+var allSnapshotNames = append([]snapshotNaming{snapshotNames}, legacySnapshotNames...)
+
+func TestSnapshotNamingRoundTrip(t *testing.T) {
+	taken := time.Date(2024, time.January, 14, 14, 30, 5, 0, time.Local)
+	tests := []struct {
+		naming snapshotNaming
+		source string
+		want   string
+	}{
+		{snapshotNames, "data", "14-01-2024_14-30-05_data"},
+		{snapshotNames, "my_data", "14-01-2024_14-30-05_my_data"},
+		{snapshotNames, "Docs 2024", "14-01-2024_14-30-05_Docs 2024"},
+		{legacySnapshotNames[0], "data", "2024-01-14_14-30-05_data"},
+		{legacySnapshotNames[1], "data", "20240114_143005_data"},
+		{snapshotNaming{layout: "2006.01.02-150405", separator: "--"}, "data", "2024.01.14-143005--data"},
+		{snapshotNaming{layout: "20060102T150405", separator: " "}, "data", "20240114T143005 data"},
+	}
+	for _, tt := range tests {
+		name := tt.naming.format(tt.source, taken)
+		if name != tt.want {
+			t.Errorf("format(%q) with %q = %q, want %q", tt.source, tt.naming.layout, name, tt.want)
+		}
+		if !tt.naming.matches(name, tt.source) {
+			t.Errorf("%q doesn't match source %q", name, tt.source)
+		}
+		parsed, err := tt.naming.parse(name, tt.source)
+		if err != nil || !parsed.Equal(taken) {
+			t.Errorf("parse(%q) = %v, %v; want %v", name, parsed, err, taken)
+		}
+	}
+}
+
+func TestParseBackupTimestamp(t *testing.T) {
+	taken := time.Date(2023, time.December, 31, 23, 59, 59, 0, time.Local)
+	for _, naming := range allSnapshotNames {
+		name := naming.format("data", taken)
+		if !isBackupDirectory(name, "data") {
+			t.Errorf("isBackupDirectory(%q) = false, want true", name)
+		}
+		parsed, err := parseBackupTimestamp(name, "data")
+		if err != nil || !parsed.Equal(taken) {
+			t.Errorf("parseBackupTimestamp(%q) = %v, %v; want %v", name, parsed, err, taken)
+		}
+	}
+
+	if got := generateBackupDirName("/backups/source/data", taken); got != "31-12-2023_23-59-59_data" {
+		t.Errorf("generateBackupDirName = %q", got)
+	}
+
+	// The right shape with an impossible timestamp still matches, but doesn't parse
+	if !isBackupDirectory("99-99-2024_99-99-99_data", "data") {
+		t.Error("a hand-renamed snapshot with an unparsable timestamp should still match")
+	}
+	if _, err := parseBackupTimestamp("99-99-2024_99-99-99_data", "data"); err == nil {
+		t.Error("parseBackupTimestamp of an unparsable timestamp should fail")
+	}
+
+	// Names of other sources are neither errors nor snapshots
+	parsed, err := parseBackupTimestamp("14-01-2024_14-30-05_other", "data")
+	if err != nil || !parsed.IsZero() {
+		t.Errorf("parseBackupTimestamp of another source = %v, %v; want zero, nil", parsed, err)
+	}
+}
+
+func TestIsBackupDirectoryNearMisses(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   bool
+	}{
+		{"14-01-2024_14-30-05_Docs", "Docs", true},
+		{"14-01-2024_14-30-05_Docs2", "Docs", false},
+		{"14-01-2024_14-30-05_Docs", "Docs2", false},
+		{"14-01-2024_14-30-05_my-data", "data", false},
+		{"14-01-2024_14-30-05_my_data", "data", false},
+		{"14-01-2024_14-30-05_my_data", "my_data", true},
+		{"14-01-2024_14-30-05_data", "Data", false},
+		{"14-01-2024_14-30-05data", "data", false},
+		{"x14-01-2024_14-30-05_data", "data", false},
+		{"4-01-2024_14-30-05_data", "data", false},
+		{"14-01-2024_14-30-05_data.partial", "data", false},
+		{"14-01-2024_14-30-05_data.zip", "data", false},
+		{"2024-01-14_14-30-05_Docs2", "Docs", false},
+		{"20240114_143005_Docs2", "Docs", false},
+		{"_data", "data", false},
+		{"data", "data", false},
+		{"", "data", false},
+	}
+	for _, tt := range tests {
+		if got := isBackupDirectory(tt.name, tt.source); got != tt.want {
+			t.Errorf("isBackupDirectory(%q, %q) = %v, want %v", tt.name, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestStagingDirectory(t *testing.T) {
+	snapshot := generateBackupDirName("data", time.Date(2024, time.March, 1, 8, 0, 0, 0, time.Local))
+	staging := stagingDirName(snapshot)
+	if staging != snapshot+".partial" {
+		t.Errorf("stagingDirName(%q) = %q", snapshot, staging)
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		staging bool
+		backup  bool
+	}{
+		{staging, "data", true, false},
+		{snapshot, "data", false, true},
+		{staging, "Docs", false, false},
+		{"2024-03-01_08-00-00_data.partial", "data", true, false},
+		{"14-01-2024_14-30-05_data2.partial", "data", false, false},
+		{"backup.partial", "data", false, false},
+		{".partial", "data", false, false},
+		{snapshot + ".partial.partial", "data", false, false},
+	}
+	for _, tt := range tests {
+		if got := isStagingDirectory(tt.name, tt.source); got != tt.staging {
+			t.Errorf("isStagingDirectory(%q, %q) = %v, want %v", tt.name, tt.source, got, tt.staging)
+		}
+		if got := isBackupDirectory(tt.name, tt.source); got != tt.backup {
+			t.Errorf("isBackupDirectory(%q, %q) = %v, want %v", tt.name, tt.source, got, tt.backup)
+		}
+	}
+}
+
+func TestLogFileNames(t *testing.T) {
+	day := time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)
+	name := logFileName("backup", day)
+	if name != "backup_29-02-2024.log" {
+		t.Errorf("logFileName = %q", name)
+	}
+	if parsed, ok := parseLogFileDate(name); !ok || !parsed.Equal(day) {
+		t.Errorf("parseLogFileDate(%q) = %v, %v", name, parsed, ok)
+	}
+
+	for _, other := range []string{"system.log", "backup_29-02-2024.txt", "backup_31-02-2024.log", "29-02-2024", ".log"} {
+		if _, ok := parseLogFileDate(other); ok {
+			t.Errorf("parseLogFileDate(%q) recognized a file logFileName doesn't write", other)
+		}
+	}
+}
+
+func TestSanitizeConfigName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Application Server", "application-server"},
+		{"documents", "documents"},
+		{"Photos-2024", "photos-2024"},
+		{`Docs: "2024" <A|B>*?/\`, "docs-2024-ab"},
+		{"../../etc", "etc"},
+		{"my_data.v2", "mydatav2"},
+		{"Über Backup", "ber-backup"},
+		{"  padded  ", "--padded--"},
+		{"CON", "con-"},
+		{"nul", "nul-"},
+		{"Aux", "aux-"},
+		{"PRN", "prn-"},
+		{"COM1", "com1-"},
+		{"lpt9", "lpt9-"},
+		{"COM0", "com0"},
+		{"COM10", "com10"},
+		{"con.txt", "contxt"},
+		{"Console", "console"},
+		{"日本語", ""}, // Nothing safe is left
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeConfigName(tt.name); got != tt.want {
+			t.Errorf("sanitizeConfigName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package main - netdest.go keeps backups to network shares going through short outages.
+//
+// A NAS that is asleep, rebooting or briefly off the network used to fail
+// the whole run on the first I/O error, and the backup was lost until the
+// next interval - a day, for daily jobs. Destinations on a network share
+// (UNC paths and mapped drives on Windows, SMB/NFS mounts on Linux) are now
+// checked before each run. An unreachable share is retried with a growing
+// delay, long enough for a sleeping NAS to spin up. If it stays away, the run
+// is deferred rather than failed and retried after networkRetryInterval
+// instead of waiting for the next regular run.
+//
+// Key design decisions:
+//
+// 1. Deferred, not failed: An offline share says nothing about the backup
+//    itself, so deferred runs don't count as failures, don't pause the config
+//    and don't notify at once. The tray shows "destination offline" instead.
+//
+// 2. Notify long outages only: Once a share has been offline for
+//    networkOfflineNotifyAfter runs in a row, one notification is raised, so
+//    a NAS that is really gone doesn't go unnoticed.
+//
+// 3. Lost mid-copy: A share dropping out during a copy is recognized by
+//    checking reachability after the failure, and deferred like a share that
+//    was offline from the start.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// networkReachDelays are the waits between reachability checks of a network destination
+var networkReachDelays = []time.Duration{5 * time.Second, 15 * time.Second, 40 * time.Second}
+
+// networkRetryInterval is how soon a run deferred for an offline destination is retried
+const networkRetryInterval = 15 * time.Minute
+
+// networkOfflineNotifyAfter is how many deferred runs in a row raise a notification
+const networkOfflineNotifyAfter = 4
+
+// destinationOutages counts consecutive runs each config deferred because
+// its network destination was offline
+var (
+	destinationOutagesMu sync.Mutex
+	destinationOutages   = make(map[string]int)
+)
+
+// networkDestinationReachable reports whether a config's destination is
+// usable: it exists, or it doesn't exist yet but the share it will be
+// created on does.
+func networkDestinationReachable(destination, root string) bool {
+	if _, err := os.Stat(destination); err == nil {
+		return true
+	} else if !os.IsNotExist(err) {
+		return false
+	}
+	_, err := os.Stat(root)
+	return err == nil
+}
+
+// destinationShareRoot returns the network share a config's destination is
+// on. Remote destinations aren't paths and are never on a share.
+func destinationShareRoot(config BackupConfig) (string, bool) {
+	if isRemoteDestination(config.Destination) {
+		return "", false
+	}
+	return networkShareRoot(config.Destination)
+}
+
+// awaitNetworkDestination checks that a config's destination is reachable
+// before a backup, retrying with increasing delays if it is on a network
+// share that doesn't answer.
+//
+// Returns false if the share stayed unreachable; the run has then been
+// deferred (see deferForOfflineDestination). Local destinations always
+// return true.
+func awaitNetworkDestination(config BackupConfig, logger *log.Logger) bool {
+	root, network := destinationShareRoot(config)
+	if !network {
+		return true
+	}
+	for attempt := 0; ; attempt++ {
+		if networkDestinationReachable(config.Destination, root) {
+			destinationReachable(config, logger)
+			return true
+		}
+		if attempt == len(networkReachDelays) {
+			break
+		}
+		if attempt == 0 {
+			logger.Printf("Network destination %s is not reachable, retrying", root)
+		}
+		time.Sleep(networkReachDelays[attempt])
+	}
+	deferForOfflineDestination(config, root, logger)
+	return false
+}
+
+// destinationWentOffline reports whether a failed run's network destination
+// has become unreachable, and defers the run if so.
+func destinationWentOffline(config BackupConfig, logger *log.Logger) bool {
+	root, network := destinationShareRoot(config)
+	if !network || networkDestinationReachable(config.Destination, root) {
+		return false
+	}
+	logger.Printf("Network destination %s went offline during the backup of %s", root, config.Name)
+	deferForOfflineDestination(config, root, logger)
+	return true
+}
+
+// deferForOfflineDestination records a run deferred because the network
+// share at root is offline and moves the next run up to the retry.
+func deferForOfflineDestination(config BackupConfig, root string, logger *log.Logger) {
+	destinationOutagesMu.Lock()
+	destinationOutages[config.Name]++
+	outages := destinationOutages[config.Name]
+	destinationOutagesMu.Unlock()
+
+	delay, retrying := destinationRetryDelay(config)
+	if retrying {
+		logger.Printf("Deferring backup of %s: %s is offline, retrying in %s", config.Name, root, formatUntil(delay))
+		backupStatus.postponeNextBackup(config.Name, time.Now().Add(delay))
+	} else {
+		logger.Printf("Deferring backup of %s to the next scheduled run: %s is offline", config.Name, root)
+		backupStatus.updateNextBackup(config.Name, config.ScheduleMinutes)
+	}
+	backupStatus.setAlert(config.Name, "destination offline")
+	if outages == networkOfflineNotifyAfter {
+		notifyEvent(config, EventFailure, "Backup destination offline: "+config.Name,
+			fmt.Sprintf("%s has not been reachable for the last %d attempts. Is the NAS or network drive online?", root, outages))
+	}
+	requestStatusUpdate()
+}
+
+// destinationReachable ends an outage of a config's network destination.
+func destinationReachable(config BackupConfig, logger *log.Logger) {
+	destinationOutagesMu.Lock()
+	outages := destinationOutages[config.Name]
+	delete(destinationOutages, config.Name)
+	destinationOutagesMu.Unlock()
+
+	if outages > 0 {
+		logger.Printf("Network destination of %s is reachable again after %d deferred run(s)", config.Name, outages)
+		backupStatus.clearAlert(config.Name)
+	}
+}
+
+// destinationRetryDelay returns how soon the scheduler retries a config
+// whose last run was deferred for an offline destination.
+//
+// Returns false if the last run wasn't deferred, or if the regular schedule
+// comes round no later than a retry would.
+func destinationRetryDelay(config BackupConfig) (time.Duration, bool) {
+	destinationOutagesMu.Lock()
+	outages := destinationOutages[config.Name]
+	destinationOutagesMu.Unlock()
+
+	if outages == 0 || time.Duration(config.ScheduleMinutes)*time.Minute <= networkRetryInterval {
+		return 0, false
+	}
+	return networkRetryInterval, true
+}
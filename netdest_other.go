@@ -0,0 +1,69 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// networkFilesystems are the mount types of network shares
+var networkFilesystems = map[string]bool{
+	"cifs":       true,
+	"smb3":       true,
+	"smbfs":      true,
+	"nfs":        true,
+	"nfs4":       true,
+	"fuse.sshfs": true,
+}
+
+// networkShareRoot returns the mount point of the network share containing path.
+//
+// Network mounts are found in /proc/self/mounts, so only Linux detects them.
+func networkShareRoot(path string) (string, bool) {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	// The innermost mount containing path decides, e.g. a local disk mounted inside a share
+	var root string
+	var network bool
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[1])
+		if len(mountPoint) > len(root) && isWithin(path, mountPoint) {
+			root = mountPoint
+			network = networkFilesystems[fields[2]]
+		}
+	}
+	if !network {
+		return "", false
+	}
+	return filepath.Clean(root), true
+}
+
+// unescapeMountPath decodes the octal escapes (e.g. \040 for a space) of a
+// path in /proc/self/mounts.
+func unescapeMountPath(field string) string {
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if code, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
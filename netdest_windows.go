@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDriveTypeW = kernel32.NewProc("GetDriveTypeW")
+
+// driveRemote is GetDriveTypeW's result for network drives
+const driveRemote = 4
+
+// networkShareRoot returns the root of the network share containing path:
+// the \\server\share of a UNC path or the letter of a mapped network drive.
+func networkShareRoot(path string) (string, bool) {
+	volume := filepath.VolumeName(path)
+	if strings.HasPrefix(volume, `\\`) {
+		return volume + `\`, true
+	}
+	if len(volume) != 2 {
+		return "", false
+	}
+	rootPtr, err := syscall.UTF16PtrFromString(volume + `\`)
+	if err != nil {
+		return "", false
+	}
+	driveType, _, _ := procGetDriveTypeW.Call(uintptr(unsafe.Pointer(rootPtr)))
+	if driveType != driveRemote {
+		return "", false
+	}
+	return volume + `\`, true
+}
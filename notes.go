@@ -0,0 +1,66 @@
+// Package main - notes.go stores short user notes attached to snapshots.
+//
+// A timestamped folder says when a snapshot was taken but not why it matters.
+// A note such as "before mod install" is attached from the tray or the CLI
+// and shown wherever snapshots are listed.
+//
+// Notes are stored as small text files next to the snapshot's manifest (in
+// manifestDir), so the snapshot folder itself stays an exact copy of the
+// source, and they are deleted together with the snapshot by rotation.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotNoteMaxLength is the longest note kept, in characters
+const snapshotNoteMaxLength = 200
+
+// snapshotNotePath returns where the note of a snapshot in destination is stored.
+func snapshotNotePath(destination, snapshotName string) string {
+	return filepath.Join(destination, manifestDir, snapshotName+".note.txt")
+}
+
+// normalizeSnapshotNote reduces a note to one trimmed line of limited length,
+// so it fits a menu title or a listing row.
+func normalizeSnapshotNote(note string) string {
+	note = strings.Join(strings.Fields(note), " ")
+	if runes := []rune(note); len(runes) > snapshotNoteMaxLength {
+		note = string(runes[:snapshotNoteMaxLength])
+	}
+	return note
+}
+
+// loadSnapshotNote returns the note of a snapshot, or "" if it has none.
+func loadSnapshotNote(destination, snapshotName string) string {
+	data, err := os.ReadFile(snapshotNotePath(destination, snapshotName))
+	if err != nil {
+		return ""
+	}
+	return normalizeSnapshotNote(string(data))
+}
+
+// saveSnapshotNote attaches note to a snapshot, replacing any previous note.
+// An empty note removes it.
+func saveSnapshotNote(destination, snapshotName, note string) error {
+	note = normalizeSnapshotNote(note)
+	if note == "" {
+		return removeSnapshotNote(destination, snapshotName)
+	}
+	path := snapshotNotePath(destination, snapshotName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(note+"\n"), 0644)
+}
+
+// removeSnapshotNote deletes the note of a snapshot, if it has one.
+func removeSnapshotNote(destination, snapshotName string) error {
+	err := os.Remove(snapshotNotePath(destination, snapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
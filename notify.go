@@ -0,0 +1,120 @@
+// Package main - notify.go implements notifications for backup events.
+//
+// Log files are only useful once someone is already looking for a problem.
+// Backup events are additionally sent through notification channels so they
+// are noticed while they can still be fixed.
+//
+// Which events reach which channels is configured per backup config with the
+// "notify" matrix, e.g. {"failure": ["toast", "email"], "success": []}:
+// a critical config can e-mail on failure while a noisy one only toasts.
+//
+// Notifications are best effort: delivery failures are logged and never
+// affect backup operations. Every notification is also written to the system
+// log so there is a record even when no channel delivers it.
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Backup events that can trigger notifications
+const (
+	EventSuccess         = "success"          // A snapshot was created
+	EventSkip            = "skip"             // The run was skipped because content was unchanged
+	EventFailure         = "failure"          // The run failed, including a missing source
+	EventPartial         = "partial"          // A snapshot was created but a follow-up step failed
+	EventLowSpace        = "low_space"        // The destination volume is running out of space
+	EventStale           = "stale"            // Source content hasn't changed for stale_alert_days
+	EventDiskHealth      = "disk_health"      // The destination disk predicts a failure or reports damaged sectors
+	EventCriticalFile    = "critical_file"    // A file listed in critical_files went missing or shrank
+	EventRepeatedFailure = "repeated_failure" // The config failed alert_after_failures runs in a row, or recovered after that
+)
+
+// Notification channels
+const (
+	ChannelToast   = "toast"   // Desktop notification
+	ChannelEmail   = "email"   // E-mail via settings.smtp
+	ChannelWebhook = "webhook" // JSON POST to settings.webhook_url
+)
+
+// defaultNotifyChannels applies to events not listed in a config's notify matrix
+var defaultNotifyChannels = map[string][]string{
+	EventFailure:         {ChannelToast},
+	EventPartial:         {ChannelToast},
+	EventLowSpace:        {ChannelToast},
+	EventStale:           {ChannelToast},
+	EventDiskHealth:      {ChannelToast},
+	EventCriticalFile:    {ChannelToast},
+	EventRepeatedFailure: {ChannelToast}, // Plus e-mail when settings.smtp is configured, see GetNotifyChannels
+}
+
+// Notification is one message sent through the notification channels.
+type Notification struct {
+	Config  string    `json:"config"`         // Backup config the event belongs to
+	Event   string    `json:"event"`          // One of the Event* constants
+	Title   string    `json:"title"`          // Short summary line
+	Message string    `json:"message"`        // Human-readable detail
+	Code    ErrorCode `json:"code,omitempty"` // Cause of a failure, see errorcodes.go
+	Time    time.Time `json:"time"`           // When the event occurred
+}
+
+// notifyEvent sends a backup event through the channels configured for it.
+//
+// Delivery happens in the background so slow mail servers or webhooks never
+// delay a backup.
+func notifyEvent(config BackupConfig, event, title, message string) {
+	sendNotification(config, Notification{Config: config.Name, Event: event, Title: title, Message: message, Time: time.Now()})
+}
+
+// notifyFailure sends a failure event carrying the error code of err, so
+// webhooks and mail filters can tell causes apart.
+func notifyFailure(config BackupConfig, title, message string, err error) {
+	sendNotification(config, Notification{Config: config.Name, Event: EventFailure, Title: title, Message: message,
+		Code: errorCodeOf(err), Time: time.Now()})
+}
+
+// sendNotification delivers n through the channels configured for its event.
+func sendNotification(config BackupConfig, n Notification) {
+	channels := config.GetNotifyChannels(n.Event)
+	if len(channels) == 0 {
+		return
+	}
+
+	log.Printf("Notification (%s): %s - %s", n.Event, n.Title, n.Message)
+	for _, channel := range channels {
+		go func(channel string) {
+			if err := deliverNotification(channel, n); err != nil {
+				log.Printf("Failed to send %s notification: %v", channel, err)
+			}
+		}(channel)
+	}
+}
+
+// notifyUser shows a desktop notification for user-initiated actions.
+//
+// Results of actions the user just started (such as a restore) always go to
+// the desktop regardless of the notify matrix.
+func notifyUser(title, message string) {
+	log.Printf("Notification: %s - %s", title, message)
+	go func() {
+		if err := showDesktopNotification(title, message); err != nil {
+			log.Printf("Failed to show notification: %v", err)
+		}
+	}()
+}
+
+// deliverNotification sends a notification through a single channel.
+func deliverNotification(channel string, n Notification) error {
+	switch channel {
+	case ChannelToast:
+		return showNotificationWithActions(n.Title, n.Message, failureToastActions(n))
+	case ChannelEmail:
+		return sendEmailNotification(n)
+	case ChannelWebhook:
+		return sendWebhookNotification(n)
+	default:
+		return fmt.Errorf("unknown notification channel %q", channel)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// showDesktopNotification displays a notification using the platform's standard tool.
+//
+// macOS uses osascript; other platforms use notify-send (libnotify), which is
+// available on practically every Linux desktop. Headless systems without
+// either tool fall back to the system log entry written by notifyUser.
+func showDesktopNotification(title, message string) error {
+	if runtime.GOOS == "darwin" {
+		script := "display notification " + appleScriptString(message) + " with title " + appleScriptString(title)
+		return exec.Command("osascript", "-e", script).Run()
+	}
+	return exec.Command("notify-send", "--app-name=SimpleFolderBackup", title, message).Run()
+}
+
+// appleScriptString quotes text as an AppleScript string literal.
+func appleScriptString(text string) string {
+	escaped := make([]rune, 0, len(text)+2)
+	escaped = append(escaped, '"')
+	for _, r := range text {
+		if r == '"' || r == '\\' {
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, r)
+	}
+	return string(append(escaped, '"'))
+}
@@ -0,0 +1,63 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+)
+
+// powershellAppID is PowerShell's registered AppUserModelID. Using it lets a
+// portable executable show toasts without installing a Start menu shortcut.
+const powershellAppID = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+
+// CREATE_NO_WINDOW prevents a console window flashing up for helper processes
+const CREATE_NO_WINDOW = 0x08000000
+
+// showDesktopNotification displays a Windows toast notification.
+//
+// The WinRT toast API isn't reachable through plain syscalls, so the toast is
+// raised by a hidden PowerShell process. The script is passed base64-encoded
+// to avoid any quoting issues with user-provided text.
+func showDesktopNotification(title, message string) error {
+	return showNotificationWithActions(title, message, nil)
+}
+
+// runToastScript shows a toast described by the given toast XML document.
+func runToastScript(toastXML string) error {
+	script := fmt.Sprintf(`
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml('%s')
+$toast = New-Object Windows.UI.Notifications.ToastNotification $xml
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('%s').Show($toast)
+`, strings.ReplaceAll(toastXML, "'", "''"), powershellAppID)
+
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(script))
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: CREATE_NO_WINDOW}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// encodePowerShell encodes a script for powershell.exe -EncodedCommand (UTF-16LE base64).
+func encodePowerShell(script string) string {
+	units := utf16.Encode([]rune(script))
+	buf := make([]byte, len(units)*2)
+	for i, u := range units {
+		buf[i*2] = byte(u)
+		buf[i*2+1] = byte(u >> 8)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// escapeXML escapes text for inclusion in toast XML.
+func escapeXML(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;").Replace(text)
+}
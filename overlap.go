@@ -0,0 +1,90 @@
+// Package main - overlap.go detects configurations whose sources overlap.
+//
+// A source nested inside another config's source is copied twice - once on
+// its own schedule and once as part of the outer folder - which can double
+// the space used without anyone noticing. Overlaps are reported when the
+// configuration is loaded; an outer config with exclude_nested_sources
+// leaves the nested sources out instead, so each folder is backed up once.
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// sourceOverlap describes one config whose source lies inside another's
+type sourceOverlap struct {
+	outer BackupConfig
+	inner BackupConfig
+	rel   string // Slash-separated path of inner's source below outer's, "" if they are the same folder
+}
+
+// nestedSources holds the nested source paths excluded per outer config
+var (
+	nestedSourcesMu sync.Mutex
+	nestedSources   = make(map[string][]string)
+)
+
+// findSourceOverlaps returns every pair of enabled configs with overlapping sources.
+//
+// Disabled configs don't run, so they can't cause a second copy.
+func findSourceOverlaps(configs []BackupConfig) []sourceOverlap {
+	var overlaps []sourceOverlap
+	for i, outer := range configs {
+		for j, inner := range configs {
+			if i == j || !outer.IsEnabled() || !inner.IsEnabled() || !isWithin(inner.Source, outer.Source) {
+				continue
+			}
+			rel, err := filepath.Rel(outer.Source, inner.Source)
+			if err != nil {
+				continue
+			}
+			if rel == "." {
+				// Report a shared source once, not once per direction
+				if j < i {
+					continue
+				}
+				rel = ""
+			}
+			overlaps = append(overlaps, sourceOverlap{outer: outer, inner: inner, rel: filepath.ToSlash(rel)})
+		}
+	}
+	return overlaps
+}
+
+// registerSourceOverlaps records the nested sources to exclude and logs every overlap.
+//
+// Returns a description of each overlap that still results in a double
+// backup, for the caller to show.
+func registerSourceOverlaps(config *Config) []string {
+	nestedSourcesMu.Lock()
+	defer nestedSourcesMu.Unlock()
+
+	nestedSources = make(map[string][]string)
+	var warnings []string
+	for _, overlap := range findSourceOverlaps(config.Backups) {
+		switch {
+		case overlap.rel == "":
+			warnings = append(warnings, fmt.Sprintf("%q and %q back up the same folder %s", overlap.outer.Name, overlap.inner.Name, overlap.outer.Source))
+		case overlap.outer.ExcludeNestedSources:
+			nestedSources[overlap.outer.Name] = append(nestedSources[overlap.outer.Name], strings.ToLower(overlap.rel))
+			log.Printf("Excluding %s from %s; it is backed up by %s", overlap.rel, overlap.outer.Name, overlap.inner.Name)
+		default:
+			warnings = append(warnings, fmt.Sprintf("%q (%s) is inside the source of %q and is backed up twice", overlap.inner.Name, overlap.inner.Source, overlap.outer.Name))
+		}
+	}
+	for _, warning := range warnings {
+		log.Printf("Warning: %s", warning)
+	}
+	return warnings
+}
+
+// nestedSourcesOf returns the nested source paths excluded from a config.
+func nestedSourcesOf(name string) []string {
+	nestedSourcesMu.Lock()
+	defer nestedSourcesMu.Unlock()
+	return nestedSources[name]
+}
@@ -0,0 +1,250 @@
+// Package main - pause.go pauses configurations that keep failing the same way.
+//
+// A destination that is gone for good (a retired NAS, a reformatted drive)
+// made every scheduled cycle fail with the same error, each one logged and
+// notified, for as long as nobody looked. With pause_after_failures set, a
+// config whose last N runs failed with the same class of error stops
+// running, alerts once and waits until it is resumed from the tray - or a
+// manual backup succeeds, or the application restarts.
+//
+// All backups can also be paused at once from the tray, e.g. during heavy
+// disk work, a game or on battery. Runs that come due meanwhile wait and
+// start when backups are resumed; a manual backup still runs. Like the
+// per-config pause, it ends when the application restarts, unless it was
+// paused until a given time (see pauseuntil.go).
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// errConfigPaused is returned by a run that paused its configuration
+var errConfigPaused = errors.New("backups paused after repeated failures")
+
+// failureStreak counts consecutive failures of one class
+type failureStreak struct {
+	class string
+	count int
+}
+
+// failureStreaks and pausedConfigs track each config's recent failures and
+// the channel a paused config's scheduler waits on
+var (
+	pauseMu        sync.Mutex
+	failureStreaks = make(map[string]failureStreak)
+	pausedConfigs  = make(map[string]chan struct{})
+)
+
+// allResumed is closed when backups paused from the tray are resumed; nil
+// while they run
+var allResumed chan struct{}
+
+// failureClass groups errors that would fail again the same way.
+//
+// Errors with a known code (see errorcodes.go) are grouped by it; anything
+// else is its own class, compared by the full message.
+func failureClass(err error) string {
+	if code := errorCodeOf(err); code != CodeUnknown {
+		return code.describe()
+	}
+	return err.Error()
+}
+
+// recordFailure counts a failed run and pauses the config once its limit is reached.
+//
+// Returns true if this failure paused the config; the caller then reports
+// the pause instead of the individual failure.
+func recordFailure(config BackupConfig, err error, logger *log.Logger) bool {
+	limit := config.GetPauseAfterFailures()
+	if limit == 0 {
+		return false
+	}
+	class := failureClass(err)
+
+	pauseMu.Lock()
+	streak := failureStreaks[config.Name]
+	if streak.class != class {
+		streak = failureStreak{class: class}
+	}
+	streak.count++
+	failureStreaks[config.Name] = streak
+	_, alreadyPaused := pausedConfigs[config.Name]
+	pausing := streak.count >= limit && !alreadyPaused
+	if pausing {
+		pausedConfigs[config.Name] = make(chan struct{})
+	}
+	pauseMu.Unlock()
+
+	if !pausing {
+		return false
+	}
+
+	logger.Printf("Pausing backups for %s after %d consecutive failures (%s)", config.Name, streak.count, class)
+	backupStatus.setAlert(config.Name, fmt.Sprintf("paused after %d failures (%s)", streak.count, class))
+	backupStatus.markPaused(config.Name, true)
+	notifyFailure(config, "Backups paused: "+config.Name,
+		fmt.Sprintf("The last %d backups failed the same way: %v\n\nResume the backup from the tray menu once the problem is fixed.", streak.count, err), err)
+	auditLog.record(AuditInterfaceSystem, "pause", config.Name, fmt.Sprintf("%d consecutive failures: %s", streak.count, class))
+	return true
+}
+
+// pauseConfig pauses a config on request, e.g. from a failure notification.
+//
+// Returns false if the config was already paused.
+func pauseConfig(name string) bool {
+	pauseMu.Lock()
+	_, alreadyPaused := pausedConfigs[name]
+	if !alreadyPaused {
+		pausedConfigs[name] = make(chan struct{})
+	}
+	pauseMu.Unlock()
+
+	if alreadyPaused {
+		return false
+	}
+	backupStatus.setAlert(name, "paused until resumed")
+	backupStatus.markPaused(name, true)
+	requestStatusUpdate()
+	return true
+}
+
+// recordSuccess ends a failure streak; a paused config resumes, unless it
+// was paused until a given time.
+func recordSuccess(config BackupConfig, logger *log.Logger) {
+	pauseMu.Lock()
+	delete(failureStreaks, config.Name)
+	pauseMu.Unlock()
+
+	if pausedUntil(config.Name).IsZero() && resumeConfig(config.Name) {
+		logger.Printf("Backups for %s resumed after a successful run", config.Name)
+	}
+}
+
+// isPaused reports whether a config is paused.
+func isPaused(name string) bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	_, paused := pausedConfigs[name]
+	return paused
+}
+
+// resumeConfig releases a paused config so its scheduler runs again.
+//
+// Returns false if the config was not paused.
+func resumeConfig(name string) bool {
+	pauseMu.Lock()
+	resumed, paused := pausedConfigs[name]
+	if paused {
+		close(resumed)
+		delete(pausedConfigs, name)
+		delete(failureStreaks, name)
+	}
+	pauseMu.Unlock()
+	endTimedPause(name)
+
+	if paused {
+		backupStatus.markPaused(name, false)
+		backupStatus.clearAlert(name)
+		requestStatusUpdate()
+	}
+	return paused
+}
+
+// forgetFailures drops the failure streak and pause of a config stopped by a
+// reload of config.json, so an edited config starts with a clean slate.
+//
+// Unlike resumeConfig, the channel is not closed: the stopped scheduler
+// leaves through its cancelled context instead of running once more.
+func forgetFailures(name string) {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	delete(pausedConfigs, name)
+	delete(failureStreaks, name)
+}
+
+// awaitResume blocks a paused config's scheduler until it is resumed or ctx ends.
+//
+// Returns false if ctx was cancelled.
+func awaitResume(ctx context.Context, config BackupConfig) bool {
+	pauseMu.Lock()
+	resumed, paused := pausedConfigs[config.Name]
+	pauseMu.Unlock()
+	if !paused {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-resumed:
+		return true
+	}
+}
+
+// pauseAllBackups stops all schedulers from starting runs until resumeAllBackups.
+//
+// Returns false if backups were already paused.
+func pauseAllBackups() bool {
+	pauseMu.Lock()
+	pausing := allResumed == nil
+	if pausing {
+		allResumed = make(chan struct{})
+	}
+	pauseMu.Unlock()
+
+	if pausing {
+		log.Print("All backups paused")
+		requestStatusUpdate()
+	}
+	return pausing
+}
+
+// resumeAllBackups lets the schedulers run again; runs that came due while
+// paused start straight away.
+//
+// Returns false if backups were not paused.
+func resumeAllBackups() bool {
+	pauseMu.Lock()
+	resumed := allResumed
+	allResumed = nil
+	pauseMu.Unlock()
+
+	endTimedPauseAll()
+	if resumed == nil {
+		return false
+	}
+	close(resumed)
+	log.Print("All backups resumed")
+	requestStatusUpdate()
+	return true
+}
+
+// allBackupsPaused reports whether all backups are paused from the tray.
+func allBackupsPaused() bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	return allResumed != nil
+}
+
+// awaitAllResumed blocks a scheduled run while all backups are paused.
+//
+// Returns false if ctx was cancelled.
+func awaitAllResumed(ctx context.Context) bool {
+	pauseMu.Lock()
+	resumed := allResumed
+	pauseMu.Unlock()
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-resumed:
+		return true
+	}
+}
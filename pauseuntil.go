@@ -0,0 +1,225 @@
+// Package main - pauseuntil.go pauses backups until a given time.
+//
+// Disabling a config, or pausing all backups from the tray, lasts until
+// someone remembers to undo it. "Pause until" in the tray pauses one config,
+// or all of them, for an hour, until tomorrow morning or until a chosen time,
+// and backups resume by themselves when that time comes.
+//
+// Timed pauses are saved in paused_until.json and survive restarts and
+// reloads of config.json; a pause whose time passed while the application
+// was not running has simply ended. They are checked against the wall clock
+// every pauseCheckInterval rather than with timers, so a machine waking from
+// sleep after the pause ended resumes right away.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pauseCheckInterval is how often timed pauses are checked for their end
+const pauseCheckInterval = 30 * time.Second
+
+// pauseMorningHour is the hour "until tomorrow" pauses end at
+const pauseMorningHour = 9
+
+// TimedPauses are the pauses that end by themselves, as saved in paused_until.json.
+type TimedPauses struct {
+	All     time.Time            `json:"all,omitzero"`     // End of a pause of all backups
+	Configs map[string]time.Time `json:"configs,omitempty"` // Config name -> end of its pause
+}
+
+// timedPauses holds the active timed pauses
+var (
+	timedPausesMu   sync.Mutex
+	timedPauses     = TimedPauses{Configs: make(map[string]time.Time)}
+	timedPausesPath = "paused_until.json"
+)
+
+// loadTimedPauses restores the timed pauses saved before a restart; a missing
+// file is normal. Pauses of all backups take effect at once, those of single
+// configs when the config starts (see restoreTimedPause).
+func loadTimedPauses() error {
+	data, err := os.ReadFile(timedPausesPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var saved TimedPauses
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	timedPausesMu.Lock()
+	timedPauses.Configs = make(map[string]time.Time)
+	for name, until := range saved.Configs {
+		timedPauses.Configs[name] = until
+	}
+	timedPausesMu.Unlock()
+	if saved.All.After(time.Now()) {
+		pauseAllUntil(saved.All)
+	}
+	return nil
+}
+
+// saveTimedPausesLocked writes the timed pauses to disk. Callers must hold timedPausesMu.
+func saveTimedPausesLocked() {
+	data, err := json.MarshalIndent(timedPauses, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode timed pauses: %v", err)
+		return
+	}
+	if err := os.WriteFile(timedPausesPath, data, 0644); err != nil {
+		log.Printf("Failed to save timed pauses: %v", err)
+	}
+}
+
+// pauseConfigUntil pauses a config until the given time, replacing any
+// earlier timed pause of it.
+func pauseConfigUntil(name string, until time.Time) {
+	pauseConfig(name)
+	timedPausesMu.Lock()
+	timedPauses.Configs[name] = until
+	saveTimedPausesLocked()
+	timedPausesMu.Unlock()
+
+	log.Printf("Backups for %s paused until %s", name, formatDisplayTime(until))
+	backupStatus.setAlert(name, "paused until "+formatDisplayTime(until))
+	requestStatusUpdate()
+}
+
+// pauseAllUntil pauses all backups until the given time.
+func pauseAllUntil(until time.Time) {
+	pauseAllBackups()
+	timedPausesMu.Lock()
+	timedPauses.All = until
+	saveTimedPausesLocked()
+	timedPausesMu.Unlock()
+
+	log.Printf("All backups paused until %s", formatDisplayTime(until))
+	requestStatusUpdate()
+}
+
+// restoreTimedPause pauses a starting config again if its saved pause hasn't ended.
+func restoreTimedPause(name string) {
+	timedPausesMu.Lock()
+	until, exists := timedPauses.Configs[name]
+	timedPausesMu.Unlock()
+	if !exists {
+		return
+	}
+	if until.After(time.Now()) {
+		pauseConfigUntil(name, until)
+	} else {
+		endTimedPause(name)
+	}
+}
+
+// endTimedPause forgets the timed pause of a config, after it was resumed.
+func endTimedPause(name string) {
+	timedPausesMu.Lock()
+	defer timedPausesMu.Unlock()
+	if _, exists := timedPauses.Configs[name]; exists {
+		delete(timedPauses.Configs, name)
+		saveTimedPausesLocked()
+	}
+}
+
+// endTimedPauseAll forgets the timed pause of all backups, after they were resumed.
+func endTimedPauseAll() {
+	timedPausesMu.Lock()
+	defer timedPausesMu.Unlock()
+	if !timedPauses.All.IsZero() {
+		timedPauses.All = time.Time{}
+		saveTimedPausesLocked()
+	}
+}
+
+// pausedUntil returns when a config's timed pause ends, zero if it has none.
+func pausedUntil(name string) time.Time {
+	timedPausesMu.Lock()
+	defer timedPausesMu.Unlock()
+	return timedPauses.Configs[name]
+}
+
+// allPausedUntil returns when the timed pause of all backups ends, zero if there is none.
+func allPausedUntil() time.Time {
+	timedPausesMu.Lock()
+	defer timedPausesMu.Unlock()
+	return timedPauses.All
+}
+
+// startTimedPauseWatcher resumes timed pauses once their time has come, until ctx ends.
+func startTimedPauseWatcher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pauseCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				resumeEndedPauses(time.Now())
+			}
+		}
+	}()
+}
+
+// resumeEndedPauses resumes the timed pauses that ended by now.
+func resumeEndedPauses(now time.Time) {
+	timedPausesMu.Lock()
+	var ended []string
+	for name, until := range timedPauses.Configs {
+		if !until.After(now) {
+			ended = append(ended, name)
+		}
+	}
+	all := timedPauses.All
+	timedPausesMu.Unlock()
+
+	for _, name := range ended {
+		// A config removed meanwhile isn't paused; its entry is dropped all the same
+		if resumeConfig(name) {
+			log.Printf("Backups for %s resumed as scheduled", name)
+		}
+		endTimedPause(name)
+	}
+	if !all.IsZero() && !all.After(now) {
+		resumeAllBackups()
+	}
+}
+
+// pauseUntilTomorrow returns pauseMorningHour o'clock of the day after now.
+func pauseUntilTomorrow(now time.Time) time.Time {
+	tomorrow := now.AddDate(0, 0, 1)
+	return time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), pauseMorningHour, 0, 0, 0, now.Location())
+}
+
+// parsePauseUntil reads the end of a pause as typed by the user: a date and
+// time ("2024-05-01 18:00") or a time of day ("18:00"), which means its next
+// occurrence.
+func parsePauseUntil(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if until, err := time.ParseInLocation("2006-01-02 15:04", value, now.Location()); err == nil {
+		if !until.After(now) {
+			return time.Time{}, fmt.Errorf("%s is in the past", value)
+		}
+		return until, nil
+	}
+	minutes, err := parseTimeOfDay(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither YYYY-MM-DD HH:MM nor HH:MM", value)
+	}
+	until := time.Date(now.Year(), now.Month(), now.Day(), 0, minutes, 0, 0, now.Location())
+	if !until.After(now) {
+		until = until.AddDate(0, 0, 1)
+	}
+	return until, nil
+}
@@ -0,0 +1,92 @@
+// Package main - preflight.go summarizes a manual backup before it starts.
+//
+// A backup started by hand is often started with a question in mind: is the
+// new folder huge, is there room for it, when was the last snapshot? With
+// "confirm_manual_backups" set, "Backup now" in the tray first shows the
+// size of the source (after exclusions), the free space at the destination
+// and the age of the last snapshot, and only starts once confirmed.
+//
+// Scheduled runs never ask, since nobody is there to answer. Hotkeys and the
+// command line don't either: a shortcut that opens a dialog defeats its
+// purpose, and scripts can't click.
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// preflightSummary is what a manual backup is about to do.
+type preflightSummary struct {
+	Files        int       // Files in the source after exclusions
+	Bytes        int64     // Their total size
+	SourceErr    error     // Why the source couldn't be measured
+	Free         uint64    // Free space at the destination
+	HasFree      bool      // False if the destination's free space is unknown (e.g. buckets)
+	LastSnapshot time.Time // Zero if the config has no snapshot yet
+}
+
+// buildPreflightSummary measures what a backup of config would copy.
+func buildPreflightSummary(config BackupConfig) preflightSummary {
+	var summary preflightSummary
+	summary.SourceErr = walkTree(config.Source, walkOptionsFor(config), func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			summary.Files++
+			summary.Bytes += info.Size()
+		}
+		return nil
+	})
+	if !isRemoteDestination(config.Destination) {
+		// A new config's destination folder is created by its first backup
+		for _, dir := range []string{config.Destination, filepath.Dir(config.Destination)} {
+			if free, total, err := diskUsage(dir); err == nil && total > 0 {
+				summary.Free, summary.HasFree = free, true
+				break
+			}
+		}
+	}
+	summary.LastSnapshot = backupStatus.findLastBackupTime(config)
+	return summary
+}
+
+// describe renders the summary as the body of the confirmation dialog.
+func (s preflightSummary) describe(config BackupConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Back up %s now?\n\n", config.Name)
+	if s.SourceErr != nil {
+		fmt.Fprintf(&b, "Source: cannot be read (%v)\n", s.SourceErr)
+	} else {
+		fmt.Fprintf(&b, "Source: %s in %s\n", formatSize(s.Bytes), pluralize(s.Files, "file"))
+	}
+	if s.HasFree {
+		fmt.Fprintf(&b, "Destination free: %s", formatSize(int64(s.Free)))
+		if s.SourceErr == nil && uint64(s.Bytes) > s.Free {
+			b.WriteString(" - less than the source")
+		}
+		b.WriteString("\n")
+	}
+	if s.LastSnapshot.IsZero() {
+		b.WriteString("Last snapshot: none yet\n")
+	} else {
+		fmt.Fprintf(&b, "Last snapshot: %s (%s)\n", formatDisplayTime(s.LastSnapshot), formatAge(time.Since(s.LastSnapshot)))
+	}
+	if config.IsHashCheckEnabled() {
+		b.WriteString("\nNothing is copied if the contents are unchanged.")
+	}
+	return b.String()
+}
+
+// confirmManualBackup asks before a manual backup from the tray if
+// confirm_manual_backups is set, and reports whether to go ahead.
+func confirmManualBackup(config BackupConfig) bool {
+	if !currentSettings().ConfirmManualBackups {
+		return true
+	}
+	return askConfirmation("Backup now", buildPreflightSummary(config).describe(config))
+}
@@ -0,0 +1,205 @@
+// Package main - purge.go forecasts when rotation next deletes a snapshot.
+//
+// Old snapshots disappearing without warning surprises users who were
+// counting on a particular version. After every run the forecast of the next
+// deletion is recomputed and saved to purge_forecast.json, so the tray and
+// the report can say which snapshot goes next and roughly when.
+//
+// Rotation only runs after a new snapshot is created, so a deletion can't
+// happen before the next backup; runs skipped as unchanged postpone it. The
+// forecast time is therefore the earliest possible one, assuming every
+// scheduled run creates a snapshot.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// PurgeForecast describes the next snapshot rotation will delete.
+type PurgeForecast struct {
+	Snapshot     string    `json:"snapshot"`           // Directory name of the snapshot
+	Taken        time.Time `json:"taken"`              // When the snapshot was taken
+	AfterBackups int       `json:"afterBackups"`       // New snapshots needed before it is deleted
+	NotBefore    time.Time `json:"notBefore,omitzero"` // When retention exceptions or thinning stop keeping it
+	At           time.Time `json:"at,omitzero"`        // Earliest deletion time; zero while the config isn't scheduled
+	ComputedAt   time.Time `json:"computedAt"`         // When the forecast was made
+}
+
+// forecastPurge predicts the next deletion among snapshots (newest first).
+//
+// A snapshot at position idx leaves the newest rotation_count after
+// rotation_count-idx new snapshots; if a retention exception, thinning or GFS
+// retention keeps it, it is deleted by the first rotation after that ends.
+// Returns false when there are no snapshots.
+func forecastPurge(config BackupConfig, snapshots []Snapshot, now, nextRun time.Time) (PurgeForecast, bool) {
+	if len(snapshots) == 0 {
+		return PurgeForecast{}, false
+	}
+
+	retained := make([]retainedSnapshot, len(snapshots))
+	for i, snapshot := range snapshots {
+		retained[i] = retainedSnapshot{name: snapshot.Name, taken: snapshot.Time}
+	}
+	protected := retentionExceptionKeeps(config.RetentionExceptions, retained, now)
+	mode := config.GetRetentionMode()
+
+	interval := time.Duration(config.ScheduleMinutes) * time.Minute
+	base := nextRun
+	if base.IsZero() {
+		base = now.Add(interval) // Only used to rank candidates
+	}
+
+	var best PurgeForecast
+	var bestAt time.Time
+	for idx, snapshot := range snapshots {
+		candidate := PurgeForecast{
+			Snapshot:     snapshot.Name,
+			Taken:        snapshot.Time,
+			AfterBackups: max(config.RotationCount-idx, 1),
+			ComputedAt:   now,
+		}
+		at := base.Add(time.Duration(candidate.AfterBackups-1) * interval)
+		if protected[snapshot.Name] {
+			candidate.NotBefore = retentionExceptionExpiry(config.RetentionExceptions, snapshot.Time)
+		}
+		var expiry time.Time
+		switch mode {
+		case RetentionThinning:
+			expiry = thinningExpiry(retained, retained[idx], now)
+		case RetentionGFS:
+			expiry = gfsExpiry(config.GFS, retained, retained[idx], base)
+		}
+		if expiry.After(candidate.NotBefore) {
+			candidate.NotBefore = expiry
+		}
+		if candidate.NotBefore.After(at) {
+			at = candidate.NotBefore
+		}
+		if !nextRun.IsZero() {
+			candidate.At = at
+		}
+		// On a tie the older snapshot goes first, as in cleanupOldBackups
+		if bestAt.IsZero() || !at.After(bestAt) {
+			best, bestAt = candidate, at
+		}
+	}
+	return best, true
+}
+
+// describe renders a forecast for the tray, e.g.
+// "Next deletion: 02.01.2026 15:04 snapshot in 3 days".
+func (pf PurgeForecast) describe(now time.Time) string {
+	text := "Next deletion: " + formatDisplayTime(pf.Taken) + " snapshot"
+	switch {
+	case pf.At.IsZero():
+		return text + fmt.Sprintf(" after %d more backup(s)", pf.AfterBackups)
+	case !pf.At.After(now):
+		return text + " at the next backup"
+	default:
+		return text + " in " + formatUntil(pf.At.Sub(now))
+	}
+}
+
+// formatUntil renders a future duration as "25 minutes", "5 hours" or "3 days".
+func formatUntil(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", max(int(d.Minutes()), 1))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
+
+// PurgeForecasts persists the latest forecast per configuration.
+type PurgeForecasts struct {
+	mu        sync.Mutex
+	forecasts map[string]PurgeForecast // Config name -> forecast
+	filePath  string
+}
+
+// Global singleton instance shared by the runner, tray and report
+var purgeForecasts = &PurgeForecasts{
+	forecasts: make(map[string]PurgeForecast),
+	filePath:  "purge_forecast.json",
+}
+
+// load restores forecasts from disk; a missing file is normal on first run.
+func (pf *PurgeForecasts) load() error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	data, err := os.ReadFile(pf.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &pf.forecasts)
+}
+
+// update recomputes and saves the forecast of a configuration.
+//
+// A destination that can't be listed keeps the previous forecast; one without
+// snapshots has none.
+func (pf *PurgeForecasts) update(config BackupConfig) {
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		return
+	}
+	forecast, exists := forecastPurge(config, snapshots, time.Now(), backupStatus.nextRunFor(config.Name))
+
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	if exists {
+		pf.forecasts[config.Name] = forecast
+	} else {
+		delete(pf.forecasts, config.Name)
+	}
+	pf.saveLocked()
+}
+
+// saveLocked writes all forecasts to disk. Callers must hold pf.mu.
+func (pf *PurgeForecasts) saveLocked() {
+	data, err := json.MarshalIndent(pf.forecasts, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode purge forecasts: %v", err)
+		return
+	}
+	if err := os.WriteFile(pf.filePath, data, 0644); err != nil {
+		log.Printf("Failed to save purge forecasts: %v", err)
+	}
+}
+
+// forecastFor returns the saved forecast of a configuration.
+func (pf *PurgeForecasts) forecastFor(name string) (PurgeForecast, bool) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	forecast, exists := pf.forecasts[name]
+	return forecast, exists
+}
+
+// prune removes the forecasts of configs not in known and returns how many.
+func (pf *PurgeForecasts) prune(known map[string]bool) int {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	removed := 0
+	for name := range pf.forecasts {
+		if !known[name] {
+			delete(pf.forecasts, name)
+			removed++
+		}
+	}
+	if removed > 0 {
+		pf.saveLocked()
+	}
+	return removed
+}
@@ -0,0 +1,31 @@
+// Package main - readonly.go implements the read-only mode for shared machines.
+//
+// On kiosk and family machines everyone can see the tray icon, but only an
+// administrator should decide what is backed up and when. With the read_only
+// setting the application keeps running its schedule and still shows status,
+// history, comparisons and exports, but refuses every action that would change
+// backup behavior or folder contents: manual runs, pausing and resuming,
+// restores, first backup confirmations and configuration edits. A pause
+// started before the mode was turned on still ends at its set time.
+//
+// The check is made at each interface that accepts user actions (tray, IPC,
+// hotkeys, CLI) rather than in the engine, so scheduled backups are unaffected.
+// Protecting config.json itself with file permissions is up to the
+// administrator; the mode only governs what the application lets users do.
+package main
+
+import "errors"
+
+// errReadOnly is returned for actions refused in read-only mode
+var errReadOnly = errors.New("backups are in read-only mode on this computer; ask an administrator to make changes")
+
+// ensureWritable refuses an action in read-only mode.
+//
+// Refusals are audited so an administrator can see who tried what.
+func ensureWritable(iface, action, configName string) error {
+	if !currentSettings().ReadOnly {
+		return nil
+	}
+	auditLog.record(iface, "refused", configName, action+" (read-only mode)")
+	return errReadOnly
+}
@@ -0,0 +1,164 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Windows registry root keys and access rights
+const (
+	HKEY_CURRENT_USER  = 0x80000001
+	HKEY_LOCAL_MACHINE = 0x80000002
+
+	KEY_READ  = 0x20019
+	KEY_WRITE = 0x20006
+
+	REG_SZ = 1
+
+	RRF_RT_REG_SZ    = 0x2
+	RRF_RT_REG_DWORD = 0x10
+)
+
+var (
+	advapi32               = syscall.NewLazyDLL("advapi32.dll")
+	procRegOpenKeyExW      = advapi32.NewProc("RegOpenKeyExW")
+	procRegCloseKey        = advapi32.NewProc("RegCloseKey")
+	procRegCreateKeyExW    = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW     = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteTreeW     = advapi32.NewProc("RegDeleteTreeW")
+	procRegDeleteKeyValueW = advapi32.NewProc("RegDeleteKeyValueW")
+	procRegGetValueW       = advapi32.NewProc("RegGetValueW")
+)
+
+// regKeyExists reports whether a registry key exists and is readable.
+func regKeyExists(root uintptr, path string) bool {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+
+	var key syscall.Handle
+	ret, _, _ := procRegOpenKeyExW.Call(root, uintptr(unsafe.Pointer(pathPtr)), 0, KEY_READ, uintptr(unsafe.Pointer(&key)))
+	if ret != 0 {
+		return false
+	}
+	procRegCloseKey.Call(uintptr(key))
+	return true
+}
+
+// regGetString reads a string value; found is false if it doesn't exist.
+func regGetString(root uintptr, path, name string) (string, bool) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", false
+	}
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return "", false
+	}
+	var size uint32
+	ret, _, _ := procRegGetValueW.Call(root, uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(namePtr)), RRF_RT_REG_SZ, 0, 0, uintptr(unsafe.Pointer(&size)))
+	if ret != 0 || size < 2 {
+		return "", false
+	}
+	data := make([]uint16, size/2)
+	ret, _, _ = procRegGetValueW.Call(root, uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(namePtr)), RRF_RT_REG_SZ, 0,
+		uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&size)))
+	if ret != 0 {
+		return "", false
+	}
+	return syscall.UTF16ToString(data), true
+}
+
+// regGetDWORD reads a DWORD value; found is false if it doesn't exist.
+func regGetDWORD(root uintptr, path, name string) (uint32, bool) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, false
+	}
+	var value uint32
+	size := uint32(unsafe.Sizeof(value))
+	ret, _, _ := procRegGetValueW.Call(root, uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(namePtr)), RRF_RT_REG_DWORD, 0,
+		uintptr(unsafe.Pointer(&value)), uintptr(unsafe.Pointer(&size)))
+	return value, ret == 0
+}
+
+// regSetString creates a key if needed and sets one of its string values.
+//
+// An empty name sets the key's default value.
+func regSetString(root uintptr, path, name, value string) error {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	var key syscall.Handle
+	ret, _, _ := procRegCreateKeyExW.Call(root, uintptr(unsafe.Pointer(pathPtr)), 0, 0, 0, KEY_WRITE, 0, uintptr(unsafe.Pointer(&key)), 0)
+	if ret != 0 {
+		return fmt.Errorf("failed to create registry key %s: %v", path, syscall.Errno(ret))
+	}
+	defer procRegCloseKey.Call(uintptr(key))
+
+	var namePtr *uint16
+	if name != "" {
+		if namePtr, err = syscall.UTF16PtrFromString(name); err != nil {
+			return err
+		}
+	}
+	data, err := syscall.UTF16FromString(value)
+	if err != nil {
+		return err
+	}
+	ret, _, _ = procRegSetValueExW.Call(uintptr(key), uintptr(unsafe.Pointer(namePtr)), 0, REG_SZ,
+		uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)*2))
+	if ret != 0 {
+		return fmt.Errorf("failed to set registry value %s: %v", path, syscall.Errno(ret))
+	}
+	return nil
+}
+
+// regDeleteTree removes a key and everything below it; a missing key is not an error.
+func regDeleteTree(root uintptr, path string) error {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	ret, _, _ := procRegDeleteTreeW.Call(root, uintptr(unsafe.Pointer(pathPtr)))
+	if ret != 0 && syscall.Errno(ret) != syscall.ERROR_FILE_NOT_FOUND {
+		return fmt.Errorf("failed to delete registry key %s: %v", path, syscall.Errno(ret))
+	}
+	return nil
+}
+
+// regDeleteValue removes one value of a key; a missing key or value is not an error.
+func regDeleteValue(root uintptr, path, name string) error {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	ret, _, _ := procRegDeleteKeyValueW.Call(root, uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(namePtr)))
+	if ret != 0 && syscall.Errno(ret) != syscall.ERROR_FILE_NOT_FOUND {
+		return fmt.Errorf("failed to delete registry value %s: %v", path, syscall.Errno(ret))
+	}
+	return nil
+}
+
+// registeredDataDirArgs returns dataDirArgs quoted for a command line the
+// registry starts, with a leading space; empty for the default data folder.
+func registeredDataDirArgs() string {
+	args := ""
+	for _, arg := range dataDirArgs() {
+		args += " " + syscall.EscapeArg(arg)
+	}
+	return args
+}
@@ -0,0 +1,244 @@
+// Package main - reload.go applies edits of config.json without a restart.
+//
+// config.json is checked every configReloadInterval. When it changed, it is
+// loaded and validated like at startup and the running configs are brought
+// in line with it: new configs start, removed or disabled configs stop, and
+// changed configs are restarted with their new settings. Global settings
+// take effect immediately, except hotkeys, which are registered once.
+//
+// Key design decisions:
+//
+// 1. Polling, not file notifications: One stat every few seconds is cheap,
+//    needs no extra dependency, and also works when config.json is on a
+//    network share or replaced by an editor that writes a new file.
+//
+// 2. Invalid edits are ignored: A config that doesn't load or validate is
+//    reported once and the running configs keep going, so a half-typed edit
+//    saved from an editor never stops backups.
+//
+// 3. Restart, not patch: A changed config's scheduler is stopped and a new
+//    one started, rather than patching settings into the running one. A
+//    backup already in progress finishes with the old settings; the runner
+//    keeps the new scheduler from overlapping it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configReloadInterval is how often config.json is checked for changes
+const configReloadInterval = 5 * time.Second
+
+// activeConfig is a running backup configuration.
+type activeConfig struct {
+	fingerprint string             // configFingerprint of the config it was started with
+	cancel      context.CancelFunc // Stops its scheduler and tray submenu
+	menu        *configMenu        // nil in headless mode
+}
+
+// configSet tracks the running configurations.
+type configSet struct {
+	ctx   context.Context
+	start func(ctx context.Context, config BackupConfig) (*configMenu, bool) // Starts a scheduler and submenu; false on failure
+
+	mu     sync.Mutex
+	active map[string]activeConfig
+	known  []BackupConfig // All configs of the last loaded config.json, including disabled ones
+}
+
+// newConfigSet returns an empty set whose configs run until ctx ends.
+func newConfigSet(ctx context.Context, start func(ctx context.Context, config BackupConfig) (*configMenu, bool)) *configSet {
+	return &configSet{ctx: ctx, start: start, active: make(map[string]activeConfig)}
+}
+
+// configFingerprint identifies a config's settings for change detection.
+//
+// Optional switches are compared by their effective value, so a config added
+// from Explorer (with defaults left nil) matches the same config read back
+// from config.json.
+func configFingerprint(config BackupConfig) string {
+	enabled, hashCheck := config.IsEnabled(), config.IsHashCheckEnabled()
+	config.Enabled, config.HashCheck = &enabled, &hashCheck
+	data, _ := json.Marshal(config)
+	return string(data)
+}
+
+// activate starts a config unless it is already running with the same settings.
+//
+// Returns false if nothing had to be done.
+func (cs *configSet) activate(config BackupConfig) bool {
+	fingerprint := configFingerprint(config)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if running, exists := cs.active[config.Name]; exists {
+		if running.fingerprint == fingerprint {
+			return false
+		}
+		cs.stopLocked(config.Name)
+	}
+
+	ctx, cancel := context.WithCancel(cs.ctx)
+	menu, ok := cs.start(ctx, config)
+	if !ok {
+		cancel()
+		return false
+	}
+	cs.active[config.Name] = activeConfig{fingerprint: fingerprint, cancel: cancel, menu: menu}
+	return true
+}
+
+// stopLocked stops a running config. Must be called with cs.mu held.
+//
+// The systray library can't remove menu items, so the submenu is hidden;
+// a config started again later gets a new one.
+func (cs *configSet) stopLocked(name string) {
+	running, exists := cs.active[name]
+	if !exists {
+		return
+	}
+	running.cancel()
+	if running.menu != nil {
+		running.menu.root.Hide()
+	}
+	delete(cs.active, name)
+
+	backupRunner.unregister(name)
+	backupStatus.forgetConfig(name)
+	forgetFailures(name)
+	forgetConsecutiveFailures(name)
+	forgetVerifyOnly(name)
+	forgetCriticalFiles(name)
+	forgetReplicaStatus(name)
+}
+
+// apply brings the running configs in line with configs.
+//
+// Returns the names of the configs started, stopped and restarted.
+func (cs *configSet) apply(configs []BackupConfig) (started, stopped, restarted []string) {
+	cs.mu.Lock()
+	cs.known = append([]BackupConfig(nil), configs...)
+	wanted := make(map[string]bool)
+	for _, config := range configs {
+		if config.IsEnabled() {
+			wanted[config.Name] = true
+		}
+	}
+	for name := range cs.active {
+		if !wanted[name] {
+			cs.stopLocked(name)
+			stopped = append(stopped, name)
+		}
+	}
+	previous := make(map[string]bool)
+	for name := range cs.active {
+		previous[name] = true
+	}
+	cs.mu.Unlock()
+
+	for _, config := range configs {
+		if !config.IsEnabled() || !cs.activate(config) {
+			continue
+		}
+		if previous[config.Name] {
+			restarted = append(restarted, config.Name)
+		} else {
+			started = append(started, config.Name)
+		}
+	}
+	sort.Strings(stopped)
+	return started, stopped, restarted
+}
+
+// menus returns the submenus of the running configs.
+func (cs *configSet) menus() []*configMenu {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	menus := make([]*configMenu, 0, len(cs.active))
+	for _, running := range cs.active {
+		if running.menu != nil {
+			menus = append(menus, running.menu)
+		}
+	}
+	return menus
+}
+
+// configs returns all configs of the last loaded config.json.
+func (cs *configSet) configs() []BackupConfig {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return append([]BackupConfig(nil), cs.known...)
+}
+
+// configFileState is what the watcher compares to notice edits
+type configFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// statConfigFile returns the current state of config.json.
+func statConfigFile() configFileState {
+	info, err := os.Stat("config.json")
+	if err != nil {
+		return configFileState{}
+	}
+	return configFileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+// startConfigWatcher reloads config.json into set whenever it changes, until ctx ends.
+func startConfigWatcher(ctx context.Context, set *configSet) {
+	go func() {
+		last := statConfigFile()
+		ticker := time.NewTicker(configReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if current := statConfigFile(); current != last {
+				last = current
+				reloadConfig(set)
+			}
+		}
+	}()
+}
+
+// reloadConfig loads config.json and applies it to the running application.
+func reloadConfig(set *configSet) {
+	config, err := loadConfig()
+	if err == nil {
+		err = validatePaths(config)
+	}
+	if err != nil {
+		log.Printf("config.json changed but was not applied: %v", err)
+		notifyUser("Configuration not applied", "config.json has an error, so the running backups were left unchanged:\n\n"+err.Error())
+		return
+	}
+
+	setActiveSettings(config.Settings)
+	backupRunner.limitChanged()
+	applyAutostart(config.Settings)
+	auditLog.recordLoadedConfig(config)
+	registerLogPaths(config)
+	registerConfigSecrets(config)
+	registerSourceOverlaps(config)
+
+	started, stopped, restarted := set.apply(config.Backups)
+	if len(started)+len(stopped)+len(restarted) > 0 {
+		log.Printf("Reloaded config.json: started [%s], stopped [%s], restarted [%s]",
+			strings.Join(started, ", "), strings.Join(stopped, ", "), strings.Join(restarted, ", "))
+	} else {
+		log.Printf("Reloaded config.json: settings updated, no backup changed")
+	}
+	requestStatusUpdate()
+}
@@ -0,0 +1,319 @@
+// Package main - remote.go backs up to object storage or SFTP servers instead of a folder.
+//
+// A destination such as s3://bucket/backups keeps its snapshots in a bucket
+// (see s3.go), one such as sftp://user@host/backups on an SSH server (see
+// sftp.go). Object storage has no folders to copy a tree into and charges
+// per request, and a tree of small files over SSH would be slow, so each
+// snapshot is uploaded as one zip archive named like a
+// snapshot folder, e.g. 15-01-2024_14-30-00_Documents.zip. The archive is
+// compressed and streamed straight from the source to the upload, so no
+// local copy of the snapshot is ever written.
+//
+// Key design decisions:
+//
+// 1. Same rotation: Archives are named and rotated exactly like snapshot
+//    folders, including retention exceptions and thinning, so a config can
+//    move between a local and a remote destination without surprises.
+//
+// 2. Backup only: Restore, browsing, notes and incremental linking need
+//    snapshots as folders and are not offered for remote destinations; an
+//    archive is restored by downloading and unpacking it. Configs using
+//    options that depend on them are rejected when the config is loaded.
+//
+// 3. Narrow interface: Backups only need to upload, list and delete
+//    objects, so each provider only has to implement remoteStore. Encrypted
+//    snapshots in a local folder (see encryptedsnapshots.go) use the same
+//    path through folderStore.
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// remoteArchiveExt is the extension of snapshot archives in remote destinations
+const remoteArchiveExt = ".zip"
+
+// errRemoteSnapshots is returned where snapshots would have to be folders
+var errRemoteSnapshots = errors.New("snapshots in remote destinations are zip archives; download one to restore it")
+
+// remoteObject is an object stored in a remote destination.
+type remoteObject struct {
+	Name     string // Relative to the destination
+	Size     int64
+	Modified time.Time
+}
+
+// remoteStore is a destination that keeps snapshots as objects rather than folders.
+type remoteStore interface {
+	upload(name string, body io.Reader) error
+	list() ([]remoteObject, error)
+	remove(name string) error
+	describe() string
+	close() error // Ends the connection, if the store keeps one
+}
+
+// isRemoteDestination reports whether a destination is a remote store rather than a path.
+func isRemoteDestination(destination string) bool {
+	return strings.HasPrefix(destination, s3Scheme) || strings.HasPrefix(destination, sftpScheme)
+}
+
+// openRemoteStore connects to a config's remote destination; the caller
+// closes the store.
+func openRemoteStore(config BackupConfig) (remoteStore, error) {
+	if !isRemoteDestination(config.Destination) {
+		return folderStore{dir: config.Destination}, nil
+	}
+	if strings.HasPrefix(config.Destination, sftpScheme) {
+		return newSFTPStore(config)
+	}
+	return newS3Store(config)
+}
+
+// validateRemoteDestination checks a config whose destination is remote.
+func validateRemoteDestination(config BackupConfig) error {
+	var err error
+	if strings.HasPrefix(config.Destination, sftpScheme) {
+		_, err = parseSFTPDestination(config.Destination)
+	} else {
+		_, _, err = parseS3Destination(config.Destination)
+	}
+	if err != nil {
+		return err
+	}
+	if config.LogToDestination {
+		return fmt.Errorf("log_to_destination needs a local destination")
+	}
+	return validateArchiveSnapshots(config)
+}
+
+// validateArchiveSnapshots rejects options that need snapshots as folders.
+func validateArchiveSnapshots(config BackupConfig) error {
+	switch {
+	case config.Incremental:
+		return fmt.Errorf("incremental snapshots need snapshot folders")
+	case config.Replica != nil:
+		return fmt.Errorf("replicas need snapshot folders")
+	case config.GetCopyStrategy() == CopyStrategySQLite:
+		return fmt.Errorf("the sqlite copy strategy needs snapshot folders")
+	}
+	return nil
+}
+
+// performRemoteBackup uploads a snapshot archive of the source to a remote
+// destination, or an encrypted config's folder, and rotates the archives
+// stored there.
+//
+// It follows the steps of performBackup; the new snapshot is complete before
+// any old one is deleted.
+func performRemoteBackup(config BackupConfig, logger *log.Logger) (BackupResult, error) {
+	result := BackupResult{Outcome: ResultBackup}
+	name := generateBackupDirName(config.Source, time.Now()) + remoteArchiveExt
+	var passphrase string
+	if config.IsEncrypted() {
+		var err error
+		if passphrase, err = snapshotPassphrase(config); err != nil {
+			return BackupResult{}, err
+		}
+		name += encryptedExportExtension
+	}
+	progress := newCopyProgress(config, logger)
+	defer progress.finish()
+	if !isRemoteDestination(config.Destination) {
+		// The archive is at most about the size of the source
+		size, err := checkSpaceForBackup(config, nil, logger)
+		if err != nil {
+			return BackupResult{}, err
+		}
+		progress.start(size.Files, size.Copied)
+	}
+	store, err := openRemoteStore(config)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	defer store.close()
+
+	logger.Printf("Writing %s to %s", name, store.describe())
+	uploaded, err := uploadSnapshotArchive(store, name, config, passphrase, newBandwidthLimiter(config), progress)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	result.Bytes = uploaded
+	result.Snapshot = config.Destination + "/" + name
+	if !isRemoteDestination(config.Destination) {
+		result.Snapshot = filepath.Join(config.Destination, name)
+	}
+
+	if err := cleanupOldRemoteBackups(config, store); err != nil {
+		logger.Printf("Failed to cleanup old backups for %s: %v", config.Name, err)
+		result.Outcome = ResultPartial
+		result.Warning = fmt.Sprintf("failed to cleanup old backups: %v", err)
+	}
+
+	backupStatus.updateBackupCompleted(config.Name, config.ScheduleMinutes)
+	requestStatusUpdate()
+
+	if config.IsHashCheckEnabled() {
+		if err := hashManager.recordAction(config, "backup"); err != nil {
+			logger.Printf("Failed to record backup action for %s: %v", config.Name, err)
+		}
+		clearStaleSource(config, logger)
+	}
+	return result, nil
+}
+
+// uploadSnapshotArchive archives the source of config into store as name and
+// returns the size uploaded.
+//
+// The archive is written into a pipe while the upload reads from it, so it is
+// never stored locally.
+func uploadSnapshotArchive(store remoteStore, name string, config BackupConfig, passphrase string, limiter *bandwidthLimiter, progress *copyProgress) (int64, error) {
+	reader, writer := io.Pipe()
+	archived := make(chan error, 1)
+	go func() {
+		err := writeSnapshotStream(writer, config, passphrase, limiter, progress)
+		writer.CloseWithError(err)
+		archived <- err
+	}()
+	uploaded := &countingReader{r: reader}
+	uploadErr := store.upload(name, uploaded)
+	reader.CloseWithError(uploadErr) // Stops the archive if the upload gave up
+	archiveErr := <-archived
+	// Either side failing fails the other; report the one that failed first
+	if uploadErr != nil && (archiveErr == nil || errors.Is(archiveErr, uploadErr)) {
+		return 0, fmt.Errorf("failed to upload snapshot: %v", uploadErr)
+	}
+	if archiveErr != nil {
+		return 0, fmt.Errorf("failed to archive files: %v", archiveErr)
+	}
+	return uploaded.n, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// writeSourceArchive writes a deflate-compressed zip of a config's source to w.
+//
+// The walk honours the config's exclusions, depth and link settings like a
+// local copy. Reads are paced by limiter and large files reported through
+// progress.
+func writeSourceArchive(w io.Writer, config BackupConfig, limiter *bandwidthLimiter, progress *copyProgress) error {
+	zw := zip.NewWriter(w)
+	err := walkTree(config.Source, walkOptionsFor(config), func(path string, d fs.DirEntry) error {
+		relPath, err := filepath.Rel(config.Source, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		defer progress.done()
+		if _, err := io.Copy(entry, progress.reader(limiter.reader(f), path, info.Size())); err != nil {
+			return err
+		}
+		progress.fileDone(info.Size())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// remoteSnapshots returns the snapshot archives of a config in store, oldest first.
+func remoteSnapshots(config BackupConfig, store remoteStore) ([]retainedSnapshot, error) {
+	objects, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+	sourceFolderName := getSourceFolderName(config.Source)
+	var snapshots []retainedSnapshot
+	for _, object := range objects {
+		// Plain and encrypted archives rotate together, so toggling encryption keeps the history
+		base, isArchive := strings.CutSuffix(strings.TrimSuffix(object.Name, encryptedExportExtension), remoteArchiveExt)
+		if !isArchive || !isBackupDirectory(base, sourceFolderName) {
+			continue
+		}
+		taken, err := parseBackupTimestamp(base, sourceFolderName)
+		if err != nil || taken.IsZero() {
+			taken = object.Modified
+		}
+		snapshots = append(snapshots, retainedSnapshot{name: object.Name, taken: taken, size: object.Size})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].taken.Before(snapshots[j].taken)
+	})
+	return snapshots, nil
+}
+
+// cleanupOldRemoteBackups deletes the oldest archives beyond the config's
+// rotation count, sparing those kept by retention exceptions, thinning or GFS
+// retention.
+func cleanupOldRemoteBackups(config BackupConfig, store remoteStore) error {
+	snapshots, err := remoteSnapshots(config, store)
+	if err != nil {
+		return err
+	}
+	for _, name := range rotationDeletions(config, snapshots, systemClock.Now()) {
+		if err := store.remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findLastRemoteBackupTime returns when the newest archive of a config was
+// taken, or zero if there is none or the destination can't be listed.
+func findLastRemoteBackupTime(config BackupConfig) time.Time {
+	store, err := openRemoteStore(config)
+	if err != nil {
+		return time.Time{}
+	}
+	defer store.close()
+	snapshots, err := remoteSnapshots(config, store)
+	if err != nil || len(snapshots) == 0 {
+		return time.Time{}
+	}
+	return snapshots[len(snapshots)-1].taken
+}
@@ -0,0 +1,94 @@
+// Package main - repeatedfailure.go escalates a job that keeps failing.
+//
+// Each failure raises its own failure notification, usually a toast, which is
+// easy to dismiss and missed entirely on an unattended machine. A job that
+// has failed for weeks is the worst case for a backup tool, so once a config
+// has failed alert_after_failures runs in a row a repeated_failure event is
+// raised, which also goes out by e-mail whenever settings.smtp is configured.
+// When the job succeeds again, the same channels hear that it recovered.
+//
+// Unlike pause_after_failures, any failures count, whatever their cause: a
+// job that fails a different way every day is no better off.
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Default number of consecutive failures before a repeated_failure event
+const defaultAlertAfterFailures = 3
+
+// consecutiveFailure counts one config's failed runs since its last success
+type consecutiveFailure struct {
+	count   int
+	since   time.Time // When the first of them failed
+	alerted bool      // A repeated_failure event was raised for this streak
+}
+
+// consecutiveFailures holds the failures of each config since its last success
+var (
+	consecutiveFailuresMu sync.Mutex
+	consecutiveFailures   = make(map[string]*consecutiveFailure)
+)
+
+// countFailure counts a failed run and raises repeated_failure once the
+// config's alert_after_failures is reached.
+func countFailure(config BackupConfig, err error, logger *log.Logger) {
+	limit := config.GetAlertAfterFailures()
+	if limit == 0 {
+		return
+	}
+
+	consecutiveFailuresMu.Lock()
+	failures := consecutiveFailures[config.Name]
+	if failures == nil {
+		failures = &consecutiveFailure{since: time.Now()}
+		consecutiveFailures[config.Name] = failures
+	}
+	failures.count++
+	alerting := failures.count >= limit && !failures.alerted
+	failures.alerted = failures.alerted || alerting
+	count, since := failures.count, failures.since
+	consecutiveFailuresMu.Unlock()
+
+	if !alerting {
+		return
+	}
+	logger.Printf("%s has failed %d times in a row since %s", config.Name, count, formatDisplayTime(since))
+	sendNotification(config, Notification{
+		Config: config.Name,
+		Event:  EventRepeatedFailure,
+		Title:  fmt.Sprintf("Backups keep failing: %s", config.Name),
+		Message: fmt.Sprintf("The last %d backups of %s failed, the first one %s. No new snapshot has been taken since.\n\nLast error: %v",
+			count, config.Source, formatDisplayTime(since), err),
+		Code: errorCodeOf(err),
+		Time: time.Now(),
+	})
+}
+
+// countSuccess ends a config's failure streak, and reports the recovery if
+// the streak had raised repeated_failure.
+func countSuccess(config BackupConfig, logger *log.Logger) {
+	consecutiveFailuresMu.Lock()
+	failures := consecutiveFailures[config.Name]
+	delete(consecutiveFailures, config.Name)
+	consecutiveFailuresMu.Unlock()
+
+	if failures == nil || !failures.alerted {
+		return
+	}
+	logger.Printf("%s succeeded again after %d failed runs", config.Name, failures.count)
+	notifyEvent(config, EventRepeatedFailure, "Backups working again: "+config.Name,
+		fmt.Sprintf("%s was backed up successfully after %d failed runs since %s.",
+			config.Source, failures.count, formatDisplayTime(failures.since)))
+}
+
+// forgetConsecutiveFailures drops the failure streak of a config stopped by a reload.
+func forgetConsecutiveFailures(name string) {
+	consecutiveFailuresMu.Lock()
+	defer consecutiveFailuresMu.Unlock()
+	delete(consecutiveFailures, name)
+}
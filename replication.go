@@ -0,0 +1,372 @@
+// Package main - replication.go copies finished snapshots to a second destination.
+//
+// One backup on one drive protects against a deleted file but not against
+// losing the drive. A replica keeps a second copy of every snapshot somewhere
+// else, e.g. a NAS, on its own schedule: snapshots already written to the
+// primary destination are copied over, so the source is only read once no
+// matter how many copies are kept.
+//
+// Key design decisions:
+//
+// 1. A mirror of the primary: The replica holds the same snapshots as the
+//    primary destination, with their manifests and notes. Snapshots rotation
+//    deleted from the primary are deleted from the replica too, unless the
+//    primary lists no snapshots at all (an unplugged drive is not a reason to
+//    empty the replica).
+//
+// 2. Never half a snapshot: Each snapshot is copied into replicaPartialDir
+//    and renamed into place once complete, so an interrupted run leaves no
+//    incomplete snapshot that looks like a finished one.
+//
+// 3. Checked against the manifest: Files are hashed while they are copied
+//    and compared against the snapshot's manifest, so a primary copy that
+//    went bad is reported instead of spread to the replica.
+//
+// 4. Independent of backups: Replication doesn't take the config's operation
+//    slot, so a long first copy to a slow NAS never delays a backup. A snapshot
+//    rotated away while it is being copied is simply skipped.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultReplicaScheduleMinutes is the replication interval when none is configured
+const defaultReplicaScheduleMinutes = 24 * 60
+
+// replicaStartDelay gives the first backup after startup a head start
+const replicaStartDelay = 5 * time.Minute
+
+// replicaPartialDir holds snapshots still being copied to a replica
+const replicaPartialDir = ".replicating"
+
+// ReplicaSettings configures a second destination for a config's snapshots.
+type ReplicaSettings struct {
+	Destination     string `json:"destination"`                // Folder the snapshots are copied to, e.g. on a NAS
+	ScheduleMinutes int    `json:"schedule_minutes,omitempty"` // 0=daily, minutes between replication runs
+}
+
+// GetScheduleMinutes returns the replication interval in minutes.
+//
+// Returns defaultReplicaScheduleMinutes if not specified or not positive.
+func (rs *ReplicaSettings) GetScheduleMinutes() int {
+	if rs.ScheduleMinutes < 1 {
+		return defaultReplicaScheduleMinutes
+	}
+	return rs.ScheduleMinutes
+}
+
+// ReplicaStatus is the replication state of one config, tracked apart from
+// its backups.
+type ReplicaStatus struct {
+	Destination string    `json:"destination"`
+	Running     bool      `json:"running,omitempty"`
+	LastRun     time.Time `json:"lastRun,omitzero"`    // End of the last replication run
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+	LastError   string    `json:"lastError,omitempty"` // Why the last run failed
+	Copied      int       `json:"copied"`              // Snapshots copied by the last run
+	Pending     int       `json:"pending"`             // Snapshots of the primary not yet on the replica
+	NextRun     time.Time `json:"nextRun,omitzero"`
+}
+
+// replicaStatuses holds the replication state of each config with a replica,
+// by config name
+var (
+	replicaStatusesMu sync.Mutex
+	replicaStatuses   = make(map[string]ReplicaStatus)
+)
+
+// replicaStatusFor returns the replication state of a config.
+func replicaStatusFor(name string) (ReplicaStatus, bool) {
+	replicaStatusesMu.Lock()
+	defer replicaStatusesMu.Unlock()
+	status, exists := replicaStatuses[name]
+	return status, exists
+}
+
+// updateReplicaStatus changes the replication state of a config under the lock.
+func updateReplicaStatus(name string, update func(status *ReplicaStatus)) {
+	replicaStatusesMu.Lock()
+	status := replicaStatuses[name]
+	update(&status)
+	replicaStatuses[name] = status
+	replicaStatusesMu.Unlock()
+	requestStatusUpdate()
+}
+
+// forgetReplicaStatus drops the replication state of a config that was stopped.
+func forgetReplicaStatus(name string) {
+	replicaStatusesMu.Lock()
+	delete(replicaStatuses, name)
+	replicaStatusesMu.Unlock()
+}
+
+// startReplicaScheduler replicates a config's snapshots shortly after start
+// and then on the replica's schedule until ctx ends. Configs without a
+// replica return immediately.
+func startReplicaScheduler(ctx context.Context, config BackupConfig, logger *log.Logger) {
+	if config.Replica == nil {
+		return
+	}
+	interval := time.Duration(config.Replica.GetScheduleMinutes()) * time.Minute
+	logger.Printf("Started replication of %s to %s (every %d minutes)", config.Name, config.Replica.Destination, config.Replica.GetScheduleMinutes())
+	updateReplicaStatus(config.Name, func(status *ReplicaStatus) {
+		status.Destination = config.Replica.Destination
+		status.NextRun = systemClock.Now().Add(replicaStartDelay)
+	})
+
+	timer := systemClock.NewTimer(replicaStartDelay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C():
+	}
+
+	ticker := systemClock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if !awaitAllResumed(ctx) {
+			return
+		}
+		runReplication(ctx, config, logger)
+		updateReplicaStatus(config.Name, func(status *ReplicaStatus) {
+			status.NextRun = systemClock.Now().Add(interval)
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// runReplication performs one replication run and records its outcome.
+func runReplication(ctx context.Context, config BackupConfig, logger *log.Logger) {
+	updateReplicaStatus(config.Name, func(status *ReplicaStatus) { status.Running = true })
+	copied, pending, err := replicateSnapshots(ctx, config, logger)
+
+	updateReplicaStatus(config.Name, func(status *ReplicaStatus) {
+		status.Running = false
+		status.LastRun = systemClock.Now()
+		status.Copied = copied
+		status.Pending = pending
+		if err != nil {
+			status.LastError = err.Error()
+		} else {
+			status.LastError = ""
+			status.LastSuccess = status.LastRun
+		}
+	})
+	if err == nil {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		logger.Printf("Replication of %s stopped: %v", config.Name, err)
+		return
+	}
+	logger.Printf("Replication of %s failed: %v", config.Name, err)
+	notifyEvent(config, EventFailure, "Replication failed: "+config.Name,
+		fmt.Sprintf("Snapshots could not be copied to %s: %v", config.Replica.Destination, err))
+}
+
+// replicateSnapshots brings the replica of a config in line with its primary
+// destination.
+//
+// Returns how many snapshots were copied and how many are still missing from
+// the replica.
+func replicateSnapshots(ctx context.Context, config BackupConfig, logger *log.Logger) (int, int, error) {
+	replica := config.Replica.Destination
+	primary, err := listSnapshots(config)
+	if err != nil {
+		return 0, 0, fmt.Errorf("primary destination unavailable: %v", err)
+	}
+	if err := os.MkdirAll(replica, 0755); err != nil {
+		return 0, len(primary), fmt.Errorf("replica destination unavailable: %v", err)
+	}
+	replicated, err := listSnapshotsIn(replica, config.Source)
+	if err != nil {
+		return 0, len(primary), fmt.Errorf("replica destination unavailable: %v", err)
+	}
+
+	present := make(map[string]bool)
+	for _, snapshot := range replicated {
+		present[snapshot.Name] = true
+	}
+	wanted := make(map[string]bool)
+	for _, snapshot := range primary {
+		wanted[snapshot.Name] = true
+	}
+
+	// Snapshots rotated away on the primary
+	if len(primary) > 0 {
+		for _, snapshot := range replicated {
+			if wanted[snapshot.Name] {
+				continue
+			}
+			if err := removeReplicaSnapshot(replica, snapshot.Name); err != nil {
+				return 0, len(primary), fmt.Errorf("failed to remove %s from the replica: %v", snapshot.Name, err)
+			}
+			logger.Printf("Removed %s from the replica of %s", snapshot.Name, config.Name)
+		}
+	}
+
+	// Missing snapshots, oldest first so each can link from the one before
+	copied, pending := 0, 0
+	var previous string
+	var failure error
+	for i := len(primary) - 1; i >= 0; i-- {
+		snapshot := primary[i]
+		if present[snapshot.Name] {
+			syncReplicaNote(config, replica, snapshot)
+			previous = snapshot.Name
+			continue
+		}
+		if failure != nil {
+			pending++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			failure = err
+			pending++
+			continue
+		}
+
+		start := time.Now()
+		if err := copySnapshotToReplica(config, snapshot, replica, previous); err != nil {
+			if _, statErr := os.Stat(snapshot.Path); os.IsNotExist(statErr) {
+				logger.Printf("Skipped replicating %s: rotation deleted it meanwhile", snapshot.Name)
+				continue
+			}
+			failure = fmt.Errorf("failed to copy %s: %v", snapshot.Name, err)
+			pending++
+			continue
+		}
+		logger.Printf("Replicated %s of %s to %s in %s", snapshot.Name, config.Name, replica, time.Since(start).Round(time.Second))
+		copied++
+		previous = snapshot.Name
+	}
+	return copied, pending, failure
+}
+
+// copySnapshotToReplica copies one snapshot, its manifest and its note to the
+// replica destination.
+//
+// With incremental enabled, files the manifest shows unchanged since the
+// previous replicated snapshot are hardlinked from it, as on the primary.
+func copySnapshotToReplica(config BackupConfig, snapshot Snapshot, replica, previous string) error {
+	manifest, err := loadManifest(config.Destination, snapshot.Name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var base *Manifest
+	if config.Incremental && previous != "" {
+		base, _ = loadManifest(replica, previous)
+	}
+
+	partial := filepath.Join(replica, replicaPartialDir, snapshot.Name)
+	if err := os.RemoveAll(partial); err != nil {
+		return err
+	}
+	limiter := newBandwidthLimiter(config)
+	err = filepath.WalkDir(snapshot.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(snapshot.Path, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(partial, rel)
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		var expected ManifestFile
+		known := false
+		if manifest != nil {
+			expected, known = manifest.Files[filepath.ToSlash(rel)]
+		}
+		if known && base != nil {
+			if previousFile, exists := base.Files[filepath.ToSlash(rel)]; exists && previousFile.SHA256 == expected.SHA256 && previousFile.Size == expected.Size {
+				if os.Link(filepath.Join(replica, previous, rel), dst) == nil {
+					return nil
+				}
+			}
+		}
+
+		hash, size, err := copyFileHashed(path, dst, config.VerifyCopies, limiter, nil)
+		if err != nil {
+			return err
+		}
+		if known && (hash != expected.SHA256 || size != expected.Size) {
+			return fmt.Errorf("%s no longer matches the snapshot's manifest; the primary copy may be damaged", rel)
+		}
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+
+	if err := os.Rename(partial, filepath.Join(replica, snapshot.Name)); err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+	if manifest != nil {
+		if err := copyFile(manifestPath(config.Destination, snapshot.Name), manifestPath(replica, snapshot.Name)); err != nil {
+			return err
+		}
+	}
+	return saveSnapshotNote(replica, snapshot.Name, snapshot.Note)
+}
+
+// syncReplicaNote copies a note added, edited or removed after the snapshot
+// was replicated.
+func syncReplicaNote(config BackupConfig, replica string, snapshot Snapshot) {
+	if loadSnapshotNote(replica, snapshot.Name) == snapshot.Note {
+		return
+	}
+	if err := saveSnapshotNote(replica, snapshot.Name, snapshot.Note); err != nil {
+		log.Printf("Failed to update the note of %s on the replica of %s: %v", snapshot.Name, config.Name, err)
+	}
+}
+
+// removeReplicaSnapshot deletes a snapshot and its manifest and note from a replica.
+func removeReplicaSnapshot(replica, snapshotName string) error {
+	if err := os.RemoveAll(filepath.Join(replica, snapshotName)); err != nil {
+		return err
+	}
+	if err := removeManifest(replica, snapshotName); err != nil {
+		return err
+	}
+	return removeSnapshotNote(replica, snapshotName)
+}
+
+// describeReplicaStatus renders replication state for the tray, e.g.
+// "Replica: up to date, copied 3h ago".
+func describeReplicaStatus(status ReplicaStatus, now time.Time) string {
+	switch {
+	case status.Running:
+		return "Replica: copying snapshots..."
+	case status.LastRun.IsZero():
+		return "Replica: first copy in " + formatUntil(status.NextRun.Sub(now))
+	case status.LastError != "":
+		return "Replica: failed " + formatAge(now.Sub(status.LastRun)) + " - " + status.LastError
+	case status.Pending > 0:
+		return fmt.Sprintf("Replica: %d snapshot(s) behind", status.Pending)
+	default:
+		return "Replica: up to date, copied " + formatAge(now.Sub(status.LastSuccess))
+	}
+}
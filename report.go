@@ -0,0 +1,147 @@
+// Package main - report.go renders the plain-text status report.
+//
+// The tray shows one line per concern; the report is where the longer view
+// lives: per-config success rates, storage use, weekly growth and when the
+// destination is forecast to fill up, and whether the schedule interval fits
+// how often the source changes. It is printed by the "report" command so it can be
+// read over a remote session or mailed from a scheduled task.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// formatReport renders the report for the given configurations.
+func formatReport(configs []BackupConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "SimpleFolderBackup report - %s\n", formatDisplayTime(time.Now()))
+
+	for _, config := range configs {
+		fmt.Fprintf(&b, "\n== %s ==\n", config.Name)
+		fmt.Fprintf(&b, "%s -> %s\n", config.Source, config.Destination)
+		writeReliabilitySection(&b, config)
+		writeScheduleSection(&b, config)
+		writeStorageSection(&b, config)
+		writeRetentionSection(&b, config)
+	}
+	return b.String()
+}
+
+// writeReliabilitySection writes the success rates of one configuration.
+func writeReliabilitySection(b *strings.Builder, config BackupConfig) {
+	b.WriteString("\nRuns\n")
+	for _, days := range []int{1, 7, 30, runStatsDays} {
+		counts := runStats.summary(config.Name, days)
+		fmt.Fprintf(b, "  Last %2d days: %s", days, counts.describe())
+		if counts.Skipped > 0 {
+			fmt.Fprintf(b, " (%d skipped as unchanged)", counts.Skipped)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(b, "  All time:     %s\n", runStats.allTime(config.Name).describe())
+}
+
+// writeScheduleSection writes the schedule interval of one configuration and,
+// if the run history suggests a better one, the suggestion.
+func writeScheduleSection(b *strings.Builder, config BackupConfig) {
+	b.WriteString("\nSchedule\n")
+	fmt.Fprintf(b, "  Every %s\n", formatInterval(config.ScheduleMinutes))
+	if suggestion, suggested := suggestInterval(config); suggested {
+		fmt.Fprintf(b, "  Suggestion: every %s (\"schedule_minutes\": %d) - %s\n",
+			formatInterval(suggestion.Minutes), suggestion.Minutes, suggestion.Reason)
+	}
+}
+
+// writeStorageSection writes the storage growth and forecast of one configuration.
+func writeStorageSection(b *strings.Builder, config BackupConfig) {
+	samples := storageHistory.samplesFor(config.Name)
+	trend := computeStorageTrend(config, samples)
+
+	b.WriteString("\nStorage\n")
+	if health, err := destinationDiskHealth(config); err == nil {
+		fmt.Fprintf(b, "  Destination disk: %s\n", health.describe())
+	}
+	if len(samples) == 0 {
+		b.WriteString("  No storage history yet (recorded after the first backup of each day)\n")
+		return
+	}
+
+	fmt.Fprintf(b, "  Used by snapshots: %s\n", formatSize(trend.UsedSize))
+	if trend.Capacity > 0 {
+		fmt.Fprintf(b, "  Destination free:  %s of %s\n", formatSize(int64(trend.Free)), formatSize(int64(trend.Capacity)))
+	}
+
+	if len(trend.Weekly) > 0 {
+		b.WriteString("  Weekly usage:\n")
+		var previous int64
+		for i, week := range trend.Weekly {
+			line := fmt.Sprintf("    week of %s  %10s", week.WeekStart.Format(storageSampleLayout), formatSize(week.UsedSize))
+			if i > 0 {
+				line += "  " + formatSizeChange(week.UsedSize-previous)
+			}
+			b.WriteString(line + "\n")
+			previous = week.UsedSize
+		}
+	}
+
+	switch {
+	case trend.GrowthPerDay == 0:
+		b.WriteString("  Growth: not enough history for a forecast\n")
+	case trend.GrowthPerDay < 0:
+		fmt.Fprintf(b, "  Growth: shrinking by %s per week\n", formatSize(int64(-trend.GrowthPerDay*7)))
+	default:
+		fmt.Fprintf(b, "  Growth: %s per week\n", formatSize(int64(trend.GrowthPerDay*7)))
+		if !trend.FullAt.IsZero() {
+			fmt.Fprintf(b, "  Forecast: destination full around %s (%d days)\n",
+				trend.FullAt.Format(storageSampleLayout), int(time.Until(trend.FullAt).Hours()/24))
+		}
+	}
+}
+
+// formatSizeChange renders a signed size difference, e.g. "+1.2 GB".
+func formatSizeChange(delta int64) string {
+	if delta < 0 {
+		return "-" + formatSize(-delta)
+	}
+	return "+" + formatSize(delta)
+}
+
+// writeRetentionSection writes which snapshot rotation deletes next.
+//
+// The forecast is the one saved by the tray application after its last run
+// of the config, since only it knows when the next backup is due.
+func writeRetentionSection(b *strings.Builder, config BackupConfig) {
+	b.WriteString("\nRetention\n")
+	switch config.GetRetentionMode() {
+	case RetentionThinning:
+		fmt.Fprintf(b, "  Keeps every snapshot of the last day, one per day for 30 days and one per week for a year, and at least the newest %d", config.RotationCount)
+	case RetentionGFS:
+		fmt.Fprintf(b, "  Keeps one snapshot for each of the last %s with snapshots, and at least the newest %d", config.GFS.describe(), config.RotationCount)
+	default:
+		fmt.Fprintf(b, "  Keeps the newest %d snapshots", config.RotationCount)
+	}
+	if len(config.RetentionExceptions) > 0 {
+		b.WriteString(" plus weekday exceptions")
+	}
+	b.WriteString("\n")
+
+	forecast, exists := purgeForecasts.forecastFor(config.Name)
+	if !exists {
+		b.WriteString("  Next deletion: no forecast yet (made after each run)\n")
+		return
+	}
+	fmt.Fprintf(b, "  Next deletion: snapshot of %s, after %d more backup(s)", formatDisplayTime(forecast.Taken), forecast.AfterBackups)
+	if !forecast.NotBefore.IsZero() {
+		fmt.Fprintf(b, ", not before %s", formatDisplayTime(forecast.NotBefore))
+	}
+	b.WriteString("\n")
+	if !forecast.At.IsZero() {
+		if until := time.Until(forecast.At); until > 0 {
+			fmt.Fprintf(b, "  Earliest: %s (in %s)\n", formatDisplayTime(forecast.At), formatUntil(until))
+		} else {
+			b.WriteString("  Earliest: at the next backup\n")
+		}
+	}
+}
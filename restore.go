@@ -0,0 +1,201 @@
+// Package main - restore.go implements restoring a snapshot back to its source.
+//
+// A backup tool is only as good as its restores. Restoring replaces the source
+// folder's contents with the contents of a snapshot, so the source ends up
+// exactly as it was when the snapshot was taken (files created since then are
+// removed, not merged).
+//
+// Key design decisions:
+//
+// 1. Mirror, not merge: Merging would leave files from the broken state mixed
+//    in with the restored ones, which is rarely what a panic-restore wants.
+//
+// 2. Keep the source root: Only the contents are replaced, so the folder's own
+//    permissions, sharing settings and open Explorer windows stay intact.
+//
+// 3. Serialized with backups: Restores are coordinated by the runner like
+//    backups, so no backup of the folder - by this config or any other whose
+//    source overlaps it - can copy a half-restored folder.
+//
+// 4. Every restore is reversible: Before anything is deleted, the current
+//    source is copied to a safety snapshot in <destination>/.pre-restore. These
+//    are outside rotation and are never deleted automatically, so restoring the
+//    wrong snapshot can itself be undone. If the safety snapshot can't be
+//    written the restore is refused.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// safetySnapshotDir is the destination subfolder holding pre-restore safety snapshots
+const safetySnapshotDir = ".pre-restore"
+
+// restoreSnapshot replaces the contents of the config's source with a snapshot.
+//
+// Returns the path of the safety snapshot holding the previous contents, or
+// an empty string if the source did not exist or was empty.
+func restoreSnapshot(config BackupConfig, snapshot Snapshot, logger *log.Logger) (string, error) {
+	if info, err := os.Stat(snapshot.Path); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("snapshot not found: %s", snapshot.Path)
+	}
+
+	var safetyPath string
+	err := backupRunner.withOperation(config, OperationRestore, func() error {
+		var err error
+		safetyPath, err = createSafetySnapshot(config)
+		if err != nil {
+			return fmt.Errorf("restore aborted, the current contents could not be saved first: %v", err)
+		}
+		if safetyPath != "" {
+			logger.Printf("Saved current contents of %s to %s", config.Name, safetyPath)
+		}
+
+		logger.Printf("Restoring %s from snapshot %s", config.Name, snapshot.Name)
+
+		err = os.MkdirAll(config.Source, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create source directory: %v", err)
+		}
+
+		err = clearDirectory(config.Source, walkOptionsFor(config))
+		if err != nil {
+			return fmt.Errorf("failed to clear source directory: %v", err)
+		}
+
+		// Copied in full: the snapshot is already bounded by the options it was taken with
+		err = copyDir(snapshot.Path, config.Source, config, walkOptions{}, nil, nil, nil, nil)
+		if err != nil {
+			return fmt.Errorf("failed to copy snapshot: %v", err)
+		}
+
+		logger.Printf("Restore of %s from %s completed", config.Name, snapshot.Name)
+		return nil
+	})
+	return safetyPath, err
+}
+
+// restoreSnapshotCopy copies a snapshot into a new folder inside parent.
+//
+// The source is left alone, so this is how older versions are looked at or
+// merged by hand. The copy is named after the snapshot and never replaces an
+// existing folder. Runs as a restore operation so rotation can't delete the
+// snapshot while it is being copied. Returns the path of the copy.
+func restoreSnapshotCopy(config BackupConfig, snapshot Snapshot, parent string, logger *log.Logger) (string, error) {
+	target := filepath.Join(parent, snapshot.Name)
+	if isWithin(target, snapshot.Path) || isWithin(target, config.Destination) {
+		return "", fmt.Errorf("choose a folder outside the backup destination %s", config.Destination)
+	}
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+
+	err := backupRunner.withOperation(config, OperationRestore, func() error {
+		logger.Printf("Restoring snapshot %s of %s to %s", snapshot.Name, config.Name, target)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", target, err)
+		}
+		if err := copyDir(snapshot.Path, target, config, walkOptions{}, nil, nil, nil, nil); err != nil {
+			return fmt.Errorf("failed to copy snapshot: %v", err)
+		}
+		logger.Printf("Restore of %s to %s completed", config.Name, target)
+		return nil
+	})
+	return target, err
+}
+
+// clearDirectory removes everything inside dir while keeping dir itself.
+//
+// Entries the walk options leave out of snapshots are kept, along with the
+// folders containing them: they were never backed up, so the snapshot being
+// restored can't put them back. These are excluded entries, the contents of
+// folders deeper than max_depth, and links that weren't followed.
+func clearDirectory(dir string, opts walkOptions) error {
+	_, err := clearDirectoryBelow(dir, "", 0, opts, make(map[string]bool))
+	return err
+}
+
+// clearDirectoryBelow clears dir for clearDirectory; rel is its slash-separated
+// path below the restored source, at depth. Returns true if anything was kept.
+func clearDirectoryBelow(dir, rel string, depth int, opts walkOptions, ancestors map[string]bool) (bool, error) {
+	leave, err := opts.enter(dir, ancestors)
+	if err != nil {
+		return false, err
+	}
+	defer leave()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	kept := false
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		entryRel := entry.Name()
+		if rel != "" {
+			entryRel = rel + "/" + entryRel
+		}
+		isDir := entry.IsDir()
+		followed := false
+		if !isDir {
+			_, link := opts.classifyLink(entryPath, entry.Type(), ancestors)
+			if link == linkLoop || (link == linkNone && unfollowedLink(entryPath, entry.Type())) {
+				kept = true
+				continue
+			}
+			// A followed link is removed like a file; its target is left alone
+			followed = link == linkFollow
+		}
+		if opts.exclude.excludes(entryRel, isDir || followed) {
+			kept = true
+			continue
+		}
+		if isDir {
+			if !opts.descends(depth + 1) {
+				kept = true // Created empty in the snapshot, so its contents have no backup
+				continue
+			}
+			keptBelow, err := clearDirectoryBelow(entryPath, entryRel, depth+1, opts, ancestors)
+			if err != nil {
+				return false, err
+			}
+			if keptBelow {
+				kept = true
+				continue
+			}
+		}
+		if err := os.RemoveAll(entryPath); err != nil {
+			return false, err
+		}
+	}
+	return kept, nil
+}
+
+// createSafetySnapshot copies the current source aside before it is overwritten.
+//
+// Safety snapshots use the regular snapshot naming inside safetySnapshotDir,
+// so rotation (which only looks at the destination's top level) never sees
+// them. Returns the path of the safety snapshot.
+func createSafetySnapshot(config BackupConfig) (string, error) {
+	entries, err := os.ReadDir(config.Source)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		return "", nil // Nothing to protect
+	}
+
+	safetyDir := filepath.Join(config.Destination, safetySnapshotDir, generateBackupDirName(config.Source, time.Now()))
+	err = os.MkdirAll(safetyDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create safety snapshot directory: %v", err)
+	}
+
+	// Unbounded, since it must hold everything clearDirectory is about to remove
+	err = copyDir(config.Source, safetyDir, config, walkOptions{}, nil, nil, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create safety snapshot: %v", err)
+	}
+	return safetyDir, nil
+}
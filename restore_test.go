@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestFiles creates files below root, keyed by slash-separated path.
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTestFile returns the content of a file below root, failing if it is missing.
+func readTestFile(t *testing.T, root, rel string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		t.Fatalf("%s: %v", rel, err)
+	}
+	return string(data)
+}
+
+func TestRestoreKeepsEntriesBelowMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	maxDepth := 1
+	config := BackupConfig{
+		Name:        "Data",
+		Source:      filepath.Join(dir, "data"),
+		Destination: filepath.Join(dir, "backups"),
+		MaxDepth:    &maxDepth,
+		Exclude:     []string{"*.tmp"},
+	}
+	writeTestFiles(t, config.Source, map[string]string{
+		"notes.txt":             "edited",
+		"added.txt":             "created after the snapshot",
+		"scratch.tmp":           "excluded",
+		"projects/plan.txt":     "below max_depth",
+		"projects/old/todo.txt": "below max_depth",
+	})
+
+	// What a backup with max_depth 1 holds: the top-level files and an empty folder
+	snapshot := Snapshot{Name: generateBackupDirName(config.Source, time.Now())}
+	snapshot.Path = filepath.Join(config.Destination, snapshot.Name)
+	writeTestFiles(t, snapshot.Path, map[string]string{"notes.txt": "original"})
+	if err := os.Mkdir(filepath.Join(snapshot.Path, "projects"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restoreSnapshot(config, snapshot, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTestFile(t, config.Source, "notes.txt"); got != "original" {
+		t.Errorf("notes.txt = %q, want the snapshot's %q", got, "original")
+	}
+	if _, err := os.Stat(filepath.Join(config.Source, "added.txt")); !os.IsNotExist(err) {
+		t.Errorf("added.txt was not removed by the restore (%v)", err)
+	}
+	for _, rel := range []string{"scratch.tmp", "projects/plan.txt", "projects/old/todo.txt"} {
+		readTestFile(t, config.Source, rel)
+	}
+}
+
+func TestRestoreKeepsUnfollowedLinks(t *testing.T) {
+	dir := t.TempDir()
+	config := BackupConfig{
+		Name:        "Data",
+		Source:      filepath.Join(dir, "data"),
+		Destination: filepath.Join(dir, "backups"),
+	}
+	writeTestFiles(t, config.Source, map[string]string{"notes.txt": "edited"})
+	writeTestFiles(t, filepath.Join(dir, "shared"), map[string]string{"list.txt": "outside the source"})
+	if err := os.Symlink(filepath.Join(dir, "shared"), filepath.Join(config.Source, "shared")); err != nil {
+		t.Skipf("can't create symlinks here: %v", err)
+	}
+
+	snapshot := Snapshot{Name: generateBackupDirName(config.Source, time.Now())}
+	snapshot.Path = filepath.Join(config.Destination, snapshot.Name)
+	writeTestFiles(t, snapshot.Path, map[string]string{"notes.txt": "original"})
+
+	if _, err := restoreSnapshot(config, snapshot, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Lstat(filepath.Join(config.Source, "shared")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link to a folder was not kept (%v)", err)
+	}
+	if got := readTestFile(t, config.Source, "notes.txt"); got != "original" {
+		t.Errorf("notes.txt = %q, want the snapshot's %q", got, "original")
+	}
+}
@@ -0,0 +1,106 @@
+// Package main - restructure.go re-links a reorganized source by content.
+//
+// Incremental backups link files by path, so renaming a top-level folder or
+// sorting photos into subfolders makes every moved file look new: the next
+// snapshot copies the whole tree again although not a byte changed. When
+// most of the source's paths are missing from the previous snapshot's
+// manifest, the run is treated as a restructure and files without a path
+// match are looked up by their content hash in the manifest instead, then
+// linked from wherever the previous snapshot had them.
+//
+// Key design decisions:
+//
+// 1. Only after a restructure: Finding a file by content means hashing it
+//    before it is copied. For the few new files of a normal run that read
+//    would be wasted, so content matching starts only once restructureRatio
+//    of the files have new paths.
+//
+// 2. Hashes known first: With warm_cache the change check has already hashed
+//    the moved files, and no file is read twice. Files whose size matches no
+//    file of the previous snapshot are never hashed at all.
+//
+// 3. Automatic: The snapshot is an ordinary complete tree with the new
+//    layout; only the storage is shared. Later runs link by path again.
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Share of source files with new paths, and the minimum number of files,
+// from which a run counts as a restructure
+const (
+	restructureRatio    = 0.5
+	restructureMinFiles = 20
+)
+
+// detectRestructure enables content matching when most source files have
+// paths the previous snapshot doesn't have.
+func (lb *linkBase) detectRestructure(config BackupConfig, logger *log.Logger) {
+	files, moved := 0, 0
+	err := walkTree(config.Source, walkOptionsFor(config), func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(config.Source, path)
+		if err != nil {
+			return nil
+		}
+		files++
+		if _, exists := lb.files[filepath.ToSlash(rel)]; !exists {
+			moved++
+		}
+		return nil
+	})
+	if err != nil || files < restructureMinFiles || float64(moved) < float64(files)*restructureRatio {
+		return
+	}
+
+	lb.byHash = make(map[string]string, len(lb.files))
+	lb.sizes = make(map[int64]bool, len(lb.files))
+	for rel, file := range lb.files {
+		if file.SHA256 != "" {
+			lb.byHash[file.SHA256] = rel
+			lb.sizes[file.Size] = true
+		}
+	}
+	logger.Printf("Source of %s looks restructured: %d of %d files have new paths; matching them by content against snapshot %s",
+		config.Name, moved, files, filepath.Base(lb.dir))
+}
+
+// linkMoved places the previous snapshot's copy of a file that moved to rel
+// at dst, found by its content. Returns false if the content is new.
+func (lb *linkBase) linkMoved(rel string, info os.FileInfo, dst string) (ManifestFile, bool) {
+	if lb.byHash == nil || !lb.sizes[info.Size()] {
+		return ManifestFile{}, false
+	}
+	hash := ""
+	if cached, known := lb.current[filepath.ToSlash(rel)]; known && !cached.Racy &&
+		cached.Size == info.Size() && cached.ModTime == info.ModTime().UnixNano() {
+		hash = cached.Hash
+	} else {
+		var err error
+		if hash, _, err = hashFile(filepath.Join(lb.source, rel)); err != nil {
+			return ManifestFile{}, false
+		}
+	}
+	previousRel, found := lb.byHash[hash]
+	if !found {
+		return ManifestFile{}, false
+	}
+	previous := lb.files[previousRel]
+	if previous.Size != info.Size() || !lb.place(filepath.Join(lb.dir, filepath.FromSlash(previousRel)), dst, info) {
+		return ManifestFile{}, false
+	}
+
+	lb.mu.Lock()
+	lb.linked++
+	lb.moved++
+	lb.bytes += previous.Size
+	lb.mu.Unlock()
+	previous.ModTime = sourceEntry(info).ModTime
+	return previous, true
+}
@@ -0,0 +1,372 @@
+// Package main - retention.go decides which snapshots rotation keeps beyond
+// the newest rotation_count.
+//
+// rotation_count alone keeps the newest snapshots, which with a short
+// schedule covers only a few hours. Three rules keep older ones: weekday
+// exceptions keep the end-of-day state of chosen weekdays for some weeks,
+// thinning keeps snapshots ever more sparsely as they age, and GFS
+// (grandfather-father-son) keeps the newest snapshot of set numbers of hours,
+// days, weeks and months.
+//
+// Key design decisions:
+//
+// 1. Keep sets, not delete lists: Each rule returns the snapshots it keeps,
+//    and rotation deletes only what no rule keeps and isn't among the newest
+//    rotation_count. Rules therefore combine by union, and adding one can
+//    only keep more.
+//
+// 2. Exceptions on top of a mode: Weekday exceptions are not a retention mode
+//    but apply with count, thinning and GFS alike, since "keep Fridays for
+//    eight weeks" is a promise about particular days that thinning's and
+//    GFS's even spacing doesn't make.
+//
+// 3. Time from the name: Ages and periods are read from the time in the
+//    snapshot's name, so copying or touching snapshot folders, or a laptop
+//    that was off for a week, doesn't shift which snapshots survive.
+//
+// 4. Predictable deletions: Each rule can tell when it stops keeping a
+//    snapshot, so the report and the tray can forecast the next deletion.
+//    The size cap (see sizecap.go) still overrides every rule here.
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention modes accepted in the retention config field
+const (
+	RetentionCount    = "count"    // Keep the newest rotation_count snapshots (default)
+	RetentionThinning = "thinning" // Keep snapshots ever more sparsely as they age, see thinningKeeps
+	RetentionGFS      = "gfs"      // Keep one snapshot per hour, day, week and month for set counts, see gfsKeeps
+)
+
+// Tiers of thinning retention by snapshot age
+const (
+	thinningKeepAll = 24 * time.Hour       // Every snapshot this young is kept
+	thinningDaily   = 30 * 24 * time.Hour  // Then one per day up to this age
+	thinningWeekly  = 365 * 24 * time.Hour // Then one per week up to this age; older ones are deleted
+)
+
+// RetentionException keeps snapshots taken on a given weekday for longer than
+// rotation_count alone would, e.g. {"weekday": "friday", "weeks": 8} keeps the
+// last snapshot of each of the past eight Fridays.
+type RetentionException struct {
+	Weekday string `json:"weekday"` // "monday" ... "sunday"
+	Weeks   int    `json:"weeks"`   // How many weeks back matching snapshots are kept
+}
+
+// parseWeekday accepts full or three-letter English weekday names.
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) < 3 {
+		return 0, false
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// exceptionWeeks returns the retention window per weekday.
+//
+// The longest window wins when several rules name the same day.
+func exceptionWeeks(exceptions []RetentionException) map[time.Weekday]int {
+	weeks := make(map[time.Weekday]int)
+	for _, exception := range exceptions {
+		day, ok := parseWeekday(exception.Weekday)
+		if !ok || exception.Weeks < 1 {
+			continue
+		}
+		if exception.Weeks > weeks[day] {
+			weeks[day] = exception.Weeks
+		}
+	}
+	return weeks
+}
+
+// retentionExceptionExpiry returns when a snapshot taken at taken stops being
+// protected by the exceptions: the start of the first day on which
+// retentionExceptionKeeps no longer covers it. Zero if no exception applies.
+func retentionExceptionExpiry(exceptions []RetentionException, taken time.Time) time.Time {
+	weeks, exists := exceptionWeeks(exceptions)[taken.Weekday()]
+	if !exists {
+		return time.Time{}
+	}
+	day := time.Date(taken.Year(), taken.Month(), taken.Day(), 0, 0, 0, 0, taken.Location())
+	return day.AddDate(0, 0, 7*weeks+1)
+}
+
+// GFSRetention sets how many periods of each length keep a snapshot under
+// grandfather-father-son retention, e.g. {"hourly": 24, "daily": 7,
+// "weekly": 4, "monthly": 12}. Zero keeps none for that length.
+type GFSRetention struct {
+	Hourly  int `json:"hourly,omitempty"`
+	Daily   int `json:"daily,omitempty"`
+	Weekly  int `json:"weekly,omitempty"` // ISO weeks, Monday to Sunday
+	Monthly int `json:"monthly,omitempty"`
+}
+
+// gfsTier is one period length of GFS retention.
+type gfsTier struct {
+	name  string
+	keep  int
+	start func(t time.Time) time.Time            // Start of the period containing t
+	after func(start time.Time, n int) time.Time // Start of the nth period after the one beginning at start
+}
+
+// tiers returns the period lengths of the policy, shortest first.
+func (g *GFSRetention) tiers() []gfsTier {
+	return []gfsTier{
+		{"hours", g.Hourly, hourStart, func(s time.Time, n int) time.Time { return s.Add(time.Duration(n) * time.Hour) }},
+		{"days", g.Daily, dayStart, func(s time.Time, n int) time.Time { return s.AddDate(0, 0, n) }},
+		{"weeks", g.Weekly, weekStart, func(s time.Time, n int) time.Time { return s.AddDate(0, 0, 7*n) }},
+		{"months", g.Monthly, monthStart, func(s time.Time, n int) time.Time { return s.AddDate(0, n, 0) }},
+	}
+}
+
+// hourStart, dayStart and monthStart return the start of the period
+// containing t in t's location (weekStart is in storage.go).
+func hourStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// describe renders the policy for the report, e.g. "24 hours, 7 days and 12 months".
+func (g *GFSRetention) describe() string {
+	var parts []string
+	for _, tier := range g.tiers() {
+		if tier.keep > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", tier.keep, tier.name))
+		}
+	}
+	if len(parts) < 2 {
+		return strings.Join(parts, "")
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
+
+// validateGFSRetention checks that a config using GFS retention says what to keep.
+func validateGFSRetention(config BackupConfig) error {
+	if config.GetRetentionMode() != RetentionGFS {
+		return nil
+	}
+	g := config.GFS
+	if g == nil || g.Hourly < 0 || g.Daily < 0 || g.Weekly < 0 || g.Monthly < 0 || g.Hourly+g.Daily+g.Weekly+g.Monthly == 0 {
+		return fmt.Errorf(`"retention": "gfs" needs a gfs section with a positive hourly, daily, weekly or monthly count`)
+	}
+	return nil
+}
+
+// retentionKeeps returns the snapshots that rotation must not delete beyond
+// the newest rotation_count: those protected by retention exceptions and
+// those selected by thinning or GFS retention.
+func retentionKeeps(config BackupConfig, snapshots []retainedSnapshot, now time.Time) map[string]bool {
+	keep := retentionExceptionKeeps(config.RetentionExceptions, snapshots, now)
+	var selected map[string]bool
+	switch config.GetRetentionMode() {
+	case RetentionThinning:
+		selected = thinningKeeps(snapshots, now)
+	case RetentionGFS:
+		selected = gfsKeeps(config.GFS, snapshots)
+	}
+	for name := range selected {
+		keep[name] = true
+	}
+	return keep
+}
+
+// retainedSnapshot is a snapshot considered by the retention exceptions.
+type retainedSnapshot struct {
+	name  string
+	taken time.Time
+	size  int64 // Space the snapshot uses that newer ones don't share; only measured for max_total_size_gb
+}
+
+// retentionExceptionKeeps returns the snapshots protected by the config's
+// retention exceptions.
+//
+// Only the newest snapshot of each matching day is protected: with a
+// 30-minute schedule a Friday produces dozens of snapshots, and it is the
+// end-of-day state that is worth keeping. The window is counted in calendar
+// days from the start of today, so "weeks": 8 covers today and the 8*7 days
+// before it.
+func retentionExceptionKeeps(exceptions []RetentionException, snapshots []retainedSnapshot, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	if len(exceptions) == 0 {
+		return keep
+	}
+
+	weeks := exceptionWeeks(exceptions)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	newestPerDay := make(map[string]retainedSnapshot)
+	for _, snapshot := range snapshots {
+		limit, exists := weeks[snapshot.taken.Weekday()]
+		if !exists {
+			continue
+		}
+		if snapshot.taken.Before(today.AddDate(0, 0, -7*limit)) {
+			continue
+		}
+		day := snapshot.taken.Format("2006-01-02")
+		if current, seen := newestPerDay[day]; !seen || snapshot.taken.After(current.taken) {
+			newestPerDay[day] = snapshot
+		}
+	}
+
+	for _, snapshot := range newestPerDay {
+		keep[snapshot.name] = true
+	}
+	return keep
+}
+
+// thinningKeeps returns the snapshots kept by thinning retention at now.
+//
+// Every snapshot of the last thinningKeepAll is kept, then the newest of each
+// calendar day up to thinningDaily, then the newest of each ISO week up to
+// thinningWeekly. Ages are measured from the time in the snapshot name, not
+// the folder's modification time, so gaps in the schedule (a laptop that was
+// off for a week) don't shift which snapshots survive.
+func thinningKeeps(snapshots []retainedSnapshot, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	newestPerBucket := make(map[string]retainedSnapshot)
+	for _, snapshot := range snapshots {
+		age := now.Sub(snapshot.taken)
+		var bucket string
+		switch {
+		case age < thinningKeepAll:
+			keep[snapshot.name] = true
+			continue
+		case age < thinningDaily:
+			bucket = snapshot.taken.Format("day 2006-01-02")
+		case age < thinningWeekly:
+			year, week := snapshot.taken.ISOWeek()
+			bucket = fmt.Sprintf("week %d-%02d", year, week)
+		default:
+			continue
+		}
+		if current, seen := newestPerBucket[bucket]; !seen || snapshot.taken.After(current.taken) {
+			newestPerBucket[bucket] = snapshot
+		}
+	}
+
+	for _, snapshot := range newestPerBucket {
+		keep[snapshot.name] = true
+	}
+	return keep
+}
+
+// thinningExpiry returns the earliest time thinning retention stops keeping
+// a snapshot it keeps at now. Zero if it isn't kept now.
+//
+// Which snapshots are kept only changes when one of them crosses a tier
+// boundary, so those are the times checked. Only the snapshots kept now are
+// considered; snapshots taken later can only take its place sooner.
+func thinningExpiry(snapshots []retainedSnapshot, snapshot retainedSnapshot, now time.Time) time.Time {
+	kept := thinningKeeps(snapshots, now)
+	if !kept[snapshot.name] {
+		return time.Time{}
+	}
+	var survivors []retainedSnapshot
+	var changes []time.Time
+	for _, other := range snapshots {
+		if !kept[other.name] || other.taken.Before(snapshot.taken) {
+			continue // Deleted at the next rotation, or too old to take its place
+		}
+		survivors = append(survivors, other)
+		for _, boundary := range []time.Duration{thinningKeepAll, thinningDaily, thinningWeekly} {
+			if at := other.taken.Add(boundary); at.After(now) {
+				changes = append(changes, at)
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Before(changes[j]) })
+	for _, at := range changes {
+		if !thinningKeeps(survivors, at)[snapshot.name] {
+			return at
+		}
+	}
+	return snapshot.taken.Add(thinningWeekly)
+}
+
+// gfsPeriods groups snapshots into the periods of a tier and returns the
+// newest snapshot of each period, newest period first.
+func gfsPeriods(tier gfsTier, snapshots []retainedSnapshot) []retainedSnapshot {
+	newestPerPeriod := make(map[int64]retainedSnapshot)
+	for _, snapshot := range snapshots {
+		period := tier.start(snapshot.taken).Unix()
+		if current, seen := newestPerPeriod[period]; !seen || snapshot.taken.After(current.taken) {
+			newestPerPeriod[period] = snapshot
+		}
+	}
+	periods := make([]retainedSnapshot, 0, len(newestPerPeriod))
+	for _, snapshot := range newestPerPeriod {
+		periods = append(periods, snapshot)
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].taken.After(periods[j].taken) })
+	return periods
+}
+
+// gfsKeeps returns the snapshots kept by GFS retention: for each tier, the
+// newest snapshot of each of the newest periods that have one.
+//
+// Periods are counted among those that have snapshots, not back from now, so
+// "daily": 7 keeps seven days of history even when the machine was off for a
+// week in between. Tiers overlap; a snapshot kept by one tier also counts for
+// the others.
+func gfsKeeps(g *GFSRetention, snapshots []retainedSnapshot) map[string]bool {
+	keep := make(map[string]bool)
+	if g == nil {
+		return keep
+	}
+	for _, tier := range g.tiers() {
+		periods := gfsPeriods(tier, snapshots)
+		for _, snapshot := range periods[:min(max(tier.keep, 0), len(periods))] {
+			keep[snapshot.name] = true
+		}
+	}
+	return keep
+}
+
+// gfsExpiry returns the earliest time GFS retention stops keeping a snapshot,
+// assuming every scheduled run from nextRun on takes a snapshot. Zero if it
+// isn't kept now.
+//
+// In each tier that keeps it, the snapshot is replaced at nextRun if that run
+// falls in its own period (which must then be the newest one), and otherwise
+// drops out when enough newer periods have begun to fill the tier.
+func gfsExpiry(g *GFSRetention, snapshots []retainedSnapshot, snapshot retainedSnapshot, nextRun time.Time) time.Time {
+	if g == nil {
+		return time.Time{}
+	}
+	var expiry time.Time
+	for _, tier := range g.tiers() {
+		periods := gfsPeriods(tier, snapshots)
+		rank := slices.IndexFunc(periods, func(kept retainedSnapshot) bool { return kept.name == snapshot.name })
+		if rank < 0 || rank >= tier.keep {
+			continue
+		}
+		start := tier.start(snapshot.taken)
+		at := tier.after(tier.start(periods[0].taken), tier.keep-rank)
+		if rank == 0 && nextRun.Before(tier.after(start, 1)) {
+			at = nextRun
+		}
+		if at.After(expiry) {
+			expiry = at
+		}
+	}
+	return expiry
+}
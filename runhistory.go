@@ -0,0 +1,244 @@
+// Package main - runhistory.go records every backup run of a config.
+//
+// The status keeps only the latest outcome, and run_stats.json only daily
+// counts, so "when did this config last copy anything, and how much?" had
+// no answer once a few skips had happened. Every run is therefore appended as
+// one JSON line to history/<config>.jsonl: when it started and ended, what
+// it decided and why, how much it saved and where.
+//
+// JSON lines rather than a database: appending a line is atomic enough for
+// one writer, a damaged line only loses that run, and the file can be read
+// with any text tool. Records older than runHistoryDays are dropped by the
+// daily maintenance pass.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runHistoryDir holds the run history of each config
+const runHistoryDir = "history"
+
+// runHistoryDays is how long run records are kept
+const runHistoryDays = 365
+
+// RunRecord is one backup run of a config.
+type RunRecord struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Result   string    `json:"result"`             // Result* constant or ResultFailure
+	Reason   string    `json:"reason,omitempty"`   // Why no snapshot was taken, e.g. "contents unchanged"
+	Bytes    int64     `json:"bytes,omitempty"`    // Size of the files saved, or of the uploaded archive
+	Files    int       `json:"files,omitempty"`    // Files in the snapshot; 0 for archives
+	Snapshot string    `json:"snapshot,omitempty"` // Path of the snapshot created
+	Error    string    `json:"error,omitempty"`    // Message of a failure, or warning of a partial run
+	Code     ErrorCode `json:"code,omitempty"`     // Cause of a failure or a wait
+}
+
+// Duration returns how long the run took.
+func (r RunRecord) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// SnapshotFigures are the size and duration of a run that created a snapshot,
+// shown in the tray and the status so it's plain what the last backup holds.
+type SnapshotFigures struct {
+	Time    time.Time `json:"time"`            // When the run ended
+	Files   int       `json:"files,omitempty"` // Files in the snapshot; 0 for archives
+	Bytes   int64     `json:"bytes"`           // Size of the files saved, or of the archive
+	Seconds float64   `json:"seconds"`         // How long the run took
+}
+
+// snapshotFigures returns the figures of record; false if it created no snapshot.
+func snapshotFigures(record RunRecord) (SnapshotFigures, bool) {
+	if record.Snapshot == "" {
+		return SnapshotFigures{}, false
+	}
+	return SnapshotFigures{Time: record.End, Files: record.Files, Bytes: record.Bytes,
+		Seconds: record.Duration().Seconds()}, true
+}
+
+// lastSnapshotFigures returns the figures of the newest recorded run of a
+// config that created a snapshot.
+func lastSnapshotFigures(name string) (SnapshotFigures, bool) {
+	records, err := loadRunHistory(name)
+	if err != nil {
+		return SnapshotFigures{}, false
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if figures, ok := snapshotFigures(records[i]); ok {
+			return figures, true
+		}
+	}
+	return SnapshotFigures{}, false
+}
+
+// describe summarizes the figures, e.g. "4,312 files, 1.2 GB in 38s".
+func (sf SnapshotFigures) describe() string {
+	duration := time.Duration(sf.Seconds * float64(time.Second)).Round(time.Second)
+	if sf.Files == 0 {
+		return fmt.Sprintf("%s in %s", formatSize(sf.Bytes), duration)
+	}
+	files := "files"
+	if sf.Files == 1 {
+		files = "file"
+	}
+	return fmt.Sprintf("%s %s, %s in %s", formatCount(sf.Files), files, formatSize(sf.Bytes), duration)
+}
+
+// formatCount writes n with thousands separators, e.g. "4,312".
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	var out strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 && digits[i-1] != '-' {
+			out.WriteByte(',')
+		}
+		out.WriteRune(digit)
+	}
+	return out.String()
+}
+
+// runHistoryMu serializes appends and compaction of the history files
+var runHistoryMu sync.Mutex
+
+// runHistoryPath returns the history file of a config.
+func runHistoryPath(name string) string {
+	return filepath.Join(runHistoryDir, sanitizeConfigName(name)+".jsonl")
+}
+
+// newRunRecord describes a run that started at start and ended with result and err.
+func newRunRecord(start time.Time, result BackupResult, err error) RunRecord {
+	record := RunRecord{Start: start, End: time.Now(), Result: result.Outcome, Bytes: result.Bytes,
+		Files: result.Files, Snapshot: result.Snapshot, Code: result.Code}
+	switch {
+	case err != nil:
+		record.Result, record.Error, record.Code = ResultFailure, err.Error(), errorCodeOf(err)
+		record.Bytes, record.Files, record.Snapshot = 0, 0, ""
+	case result.Outcome == ResultSkipped:
+		record.Reason = "contents unchanged since the last backup"
+	case result.Outcome == ResultChanged:
+		record.Reason = "contents changed, not backed up while verify_only is on"
+	case result.Outcome == ResultWaiting:
+		record.Reason = "waiting: " + result.Code.describe()
+	case result.Outcome == ResultPartial:
+		record.Error = result.Warning
+	}
+	return record
+}
+
+// recordRun appends a run to the history of a config. Failures are only
+// logged: the history must never fail a backup.
+func recordRun(name string, record RunRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Failed to encode run history of %s: %v", name, err)
+		return
+	}
+
+	runHistoryMu.Lock()
+	defer runHistoryMu.Unlock()
+	if err := os.MkdirAll(runHistoryDir, 0755); err != nil {
+		log.Printf("Failed to save run history of %s: %v", name, err)
+		return
+	}
+	f, err := os.OpenFile(runHistoryPath(name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Failed to save run history of %s: %v", name, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to save run history of %s: %v", name, err)
+	}
+}
+
+// loadRunHistory returns the recorded runs of a config, oldest first. Lines
+// that can't be decoded are skipped.
+func loadRunHistory(name string) ([]RunRecord, error) {
+	runHistoryMu.Lock()
+	data, err := os.ReadFile(runHistoryPath(name))
+	runHistoryMu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []RunRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record RunRecord
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// clearRunHistory deletes the run history of a config and reports whether it had any.
+func clearRunHistory(name string) (bool, error) {
+	runHistoryMu.Lock()
+	defer runHistoryMu.Unlock()
+	err := os.Remove(runHistoryPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// compactRunHistory drops runs older than runHistoryDays from every history
+// file and returns how many were dropped.
+func compactRunHistory(now time.Time) (int, error) {
+	entries, err := os.ReadDir(runHistoryDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	cutoff := now.AddDate(0, 0, -runHistoryDays)
+
+	runHistoryMu.Lock()
+	defer runHistoryMu.Unlock()
+	dropped := 0
+	for _, entry := range entries {
+		path := filepath.Join(runHistoryDir, entry.Name())
+		if entry.IsDir() || filepath.Ext(path) != ".jsonl" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return dropped, err
+		}
+		var kept bytes.Buffer
+		removed := 0
+		for line := range bytes.Lines(data) {
+			var record RunRecord
+			if json.Unmarshal(line, &record) != nil || record.Start.Before(cutoff) {
+				removed++
+				continue
+			}
+			kept.Write(line)
+		}
+		if removed == 0 {
+			continue
+		}
+		if err := os.WriteFile(path, kept.Bytes(), 0644); err != nil {
+			return dropped, err
+		}
+		dropped += removed
+	}
+	return dropped, nil
+}
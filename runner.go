@@ -0,0 +1,377 @@
+// Package main - runner.go coordinates backup executions from multiple triggers.
+//
+// Originally the scheduler loop was the only code path that ran backups. Other
+// triggers (such as the pre-shutdown hook) need to run a configuration on
+// demand, and two triggers firing at once for the same configuration would
+// copy into the destination concurrently and race on hash state.
+//
+// The runner keeps a registry of active configurations and their loggers and
+// serializes executions per configuration: a second request for a config that
+// is already running waits for the first to finish. Different configurations
+// still run independently, preserving the scheduler's fault isolation.
+//
+// Restores take part in the same coordination. A restore rewrites a source
+// folder, so it must not overlap with a backup of that folder - not only of
+// the same config, but of any config whose source contains or is contained in
+// it. Backups of overlapping sources may still run side by side, since they
+// only read. Whoever arrives second waits, and is shown as blocked in status.
+//
+// With settings.max_concurrent, backups of different configurations also
+// wait while that many are running, so a dozen configs on one disk take turns
+// instead of all reading at once. The content hashes the schedulers compute
+// at startup take a turn too (see withHashCheck).
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations coordinated by the runner
+const (
+	OperationBackup      = "backup"
+	OperationRestore     = "restore"
+	OperationMaintenance = "maintenance" // State commands such as rebuild-catalog
+)
+
+// activeOperation is an operation in progress on one configuration.
+type activeOperation struct {
+	operation string // One of the Operation* constants
+	source    string // Source folder of the configuration
+}
+
+// BackupRunner tracks active backup configurations and serializes their executions.
+type BackupRunner struct {
+	mu      sync.Mutex                 // Protects the registry maps
+	changed *sync.Cond                 // Signalled on mu whenever an operation finishes
+	configs map[string]BackupConfig    // Active configurations by name
+	loggers map[string]*log.Logger     // Per-config loggers by name
+	active  map[string]activeOperation // Operations in progress by config name
+	checks  int                        // Hash checks running outside a backup (see withHashCheck)
+	closing bool                       // Set on exit: no new operations start
+}
+
+// errShuttingDown is returned for operations requested while the application exits
+var errShuttingDown = errors.New("the application is exiting")
+
+// Global singleton instance shared by the scheduler and on-demand triggers
+var backupRunner = newBackupRunner()
+
+// newBackupRunner creates an empty runner.
+func newBackupRunner() *BackupRunner {
+	br := &BackupRunner{
+		configs: make(map[string]BackupConfig),
+		loggers: make(map[string]*log.Logger),
+		active:  make(map[string]activeOperation),
+	}
+	br.changed = sync.NewCond(&br.mu)
+	return br
+}
+
+// register makes a configuration available to on-demand triggers.
+func (br *BackupRunner) register(config BackupConfig, logger *log.Logger) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.configs[config.Name] = config
+	br.loggers[config.Name] = logger
+}
+
+// unregister forgets a configuration stopped at runtime.
+//
+// A backup of it that is already running finishes normally.
+func (br *BackupRunner) unregister(name string) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	delete(br.configs, name)
+	delete(br.loggers, name)
+}
+
+// registeredConfigs returns a copy of all registered configurations.
+func (br *BackupRunner) registeredConfigs() []BackupConfig {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	configs := make([]BackupConfig, 0, len(br.configs))
+	for _, config := range br.configs {
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+// run executes a backup for a configuration, waiting if one is already in progress.
+//
+// Success and failure are logged and notified here so every trigger produces
+// the same log output and notifications as a scheduled run.
+func (br *BackupRunner) run(config BackupConfig, logger *log.Logger) error {
+	_, err := br.runWithResult(config, logger)
+	return err
+}
+
+// runWithResult is run for callers that report the outcome themselves, such
+// as the backup command.
+func (br *BackupRunner) runWithResult(config BackupConfig, logger *log.Logger) (BackupResult, error) {
+	var result BackupResult
+	err := br.withOperation(config, OperationBackup, func() error {
+		var err error
+		start := time.Now()
+		publishRunStarted(config.Name)
+		result, err = executeBackup(config, logger)
+		backupStatus.recordResult(config.Name, result, err)
+		defer publishRunEnded(config.Name, result, err)
+		record := newRunRecord(start, result, err)
+		recordRun(config.Name, record)
+		if figures, ok := snapshotFigures(record); ok {
+			backupStatus.recordSnapshot(config.Name, figures)
+		}
+		paused := false
+		if err != nil {
+			runStats.record(config.Name, ResultFailure)
+			if !errors.Is(err, errSourceMissingDisabled) {
+				paused = recordFailure(config, err, logger)
+				countFailure(config, err, logger)
+			}
+		} else if result.Outcome != ResultWaiting {
+			runStats.record(config.Name, result.Outcome)
+			recordSuccess(config, logger)
+			countSuccess(config, logger)
+		}
+		// Every run moves the next backup, and with it the forecast deletion time
+		purgeForecasts.update(config)
+
+		switch {
+		case errors.Is(err, errSourceMissingDisabled):
+			logger.Printf("Backup disabled for %s: %v", config.Name, err)
+		case paused:
+			// recordFailure has already notified about the pause
+			logger.Printf("Backup failed for %s: %s", config.Name, formatCodedError(err))
+			return fmt.Errorf("%w: %v", errConfigPaused, err)
+		case err != nil:
+			logger.Printf("Backup failed for %s: %s", config.Name, formatCodedError(err))
+			// A missing source is notified once per outage by checkSourceAvailable
+			if !errors.Is(err, errSourceMissing) {
+				notifyFailure(config, "Backup failed: "+config.Name, err.Error(), err)
+			}
+		case result.Outcome == ResultChanged:
+			// runVerifyOnly has raised an alert; changes are expected while verify_only is on
+		case result.Outcome == ResultSkipped:
+			notifyEvent(config, EventSkip, "Backup skipped: "+config.Name, "Contents are unchanged since the last backup.")
+		case result.Outcome == ResultPartial:
+			logger.Printf("Backup completed with warnings for %s: %s", config.Name, result.Warning)
+			notifyEvent(config, EventPartial, "Backup incomplete: "+config.Name, "The snapshot was created, but "+result.Warning)
+			afterSnapshot(config, logger)
+		case result.Outcome == ResultBackup:
+			logger.Printf("Backup completed successfully for %s", config.Name)
+			notifyEvent(config, EventSuccess, "Backup completed: "+config.Name, "Saved "+filepath.Base(result.Snapshot))
+			afterSnapshot(config, logger)
+		}
+		return err
+	})
+	return result, err
+}
+
+// afterSnapshot runs the storage checks that follow every new snapshot.
+func afterSnapshot(config BackupConfig, logger *log.Logger) {
+	acceptCriticalFiles(config, logger)
+	if isRemoteDestination(config.Destination) {
+		return // Buckets have neither a volume to fill nor snapshot folders to measure
+	}
+	checkDestinationSpace(config, logger)
+	checkDestinationHealth(config, logger)
+	if config.IsEncrypted() {
+		return // The archives can't be measured without the passphrase
+	}
+	storageHistory.recordSample(config, logger)
+}
+
+// runByName executes a backup for a registered configuration.
+func (br *BackupRunner) runByName(name string) error {
+	_, err := br.runByNameWithResult(name)
+	return err
+}
+
+// runByNameWithResult is runByName for callers that report the outcome
+// themselves, such as a backup command waiting on the running instance.
+func (br *BackupRunner) runByNameWithResult(name string) (BackupResult, error) {
+	br.mu.Lock()
+	config, exists := br.configs[name]
+	logger := br.loggers[name]
+	br.mu.Unlock()
+
+	if !exists {
+		return BackupResult{}, fmt.Errorf("no active backup configuration named %q", name)
+	}
+	return br.runWithResult(config, logger)
+}
+
+// runShutdownBackups runs every registered configuration flagged run_before_shutdown.
+//
+// Backups run sequentially: the time available before a restart is limited,
+// and parallel copies to a shared destination would only slow each other down.
+func (br *BackupRunner) runShutdownBackups() {
+	for _, config := range br.registeredConfigs() {
+		if config.RunBeforeShutdown {
+			br.runByName(config.Name)
+		}
+	}
+}
+
+// withOperation runs fn as an operation on a configuration.
+//
+// Waits while a conflicting operation is in progress (see conflictFor), so
+// operations that must not overlap - a restore and a backup of the same
+// folder - never do. While waiting, the config is shown as blocked.
+func (br *BackupRunner) withOperation(config BackupConfig, operation string, fn func() error) error {
+	br.mu.Lock()
+	for {
+		if br.closing {
+			br.mu.Unlock()
+			backupStatus.markBlocked(config.Name, "")
+			return errShuttingDown
+		}
+		blocker, found := br.conflictFor(config, operation)
+		if !found {
+			break
+		}
+		backupStatus.markBlocked(config.Name, blocker)
+		br.changed.Wait()
+	}
+	br.active[config.Name] = activeOperation{operation: operation, source: config.Source}
+	br.mu.Unlock()
+
+	backupStatus.markBlocked(config.Name, "")
+	backupStatus.markOperation(config.Name, operation)
+	requestStatusUpdate()
+
+	defer func() {
+		br.mu.Lock()
+		delete(br.active, config.Name)
+		br.changed.Broadcast()
+		br.mu.Unlock()
+
+		backupStatus.markOperation(config.Name, "")
+		requestStatusUpdate()
+	}()
+	return fn()
+}
+
+// conflictFor describes the operation that must finish before operation can start.
+//
+// Must be called with br.mu held. Operations on the same config always
+// conflict; operations on different configs conflict when one is a restore and
+// their source folders overlap.
+func (br *BackupRunner) conflictFor(config BackupConfig, operation string) (string, bool) {
+	for name, active := range br.active {
+		if name == config.Name {
+			return fmt.Sprintf("%s of %s", active.operation, name), true
+		}
+		if operation != OperationRestore && active.operation != OperationRestore {
+			continue
+		}
+		if pathsOverlap(active.source, config.Source) {
+			return fmt.Sprintf("%s of %s", active.operation, name), true
+		}
+	}
+	if operation == OperationBackup && !br.slotFree() {
+		return fmt.Sprintf("its turn (max_concurrent %d)", currentSettings().MaxConcurrent), true
+	}
+	return "", false
+}
+
+// slotFree reports whether another backup or hash check may start within
+// settings.max_concurrent. Must be called with br.mu held.
+func (br *BackupRunner) slotFree() bool {
+	settings := currentSettings()
+	limit := settings.GetMaxConcurrent()
+	if limit == 0 {
+		return true
+	}
+	busy := br.checks
+	for _, active := range br.active {
+		if active.operation == OperationBackup {
+			busy++
+		}
+	}
+	return busy < limit
+}
+
+// withHashCheck runs fn, a content hash computed outside a backup such as a
+// scheduler's startup check, once it fits within settings.max_concurrent.
+// Hashing reads the whole source, so at startup it would otherwise hit the
+// disk for every config at once. It never waits while the application exits.
+func (br *BackupRunner) withHashCheck(fn func()) {
+	br.mu.Lock()
+	for !br.closing && !br.slotFree() {
+		br.changed.Wait()
+	}
+	br.checks++
+	br.mu.Unlock()
+
+	defer func() {
+		br.mu.Lock()
+		br.checks--
+		br.changed.Broadcast()
+		br.mu.Unlock()
+	}()
+	fn()
+}
+
+// limitChanged wakes the operations waiting for their turn after
+// settings.max_concurrent may have changed.
+func (br *BackupRunner) limitChanged() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.changed.Broadcast()
+}
+
+// pathsOverlap reports whether one folder is the same as or inside the other.
+func pathsOverlap(a, b string) bool {
+	return isWithin(a, b) || isWithin(b, a)
+}
+
+// isWithin reports whether path is dir or lies below it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// beginShutdown stops new operations from starting, including ones waiting
+// for a conflicting operation; operations in progress continue.
+func (br *BackupRunner) beginShutdown() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	br.closing = true
+	br.changed.Broadcast()
+}
+
+// activeOperations returns the Operation* constants of the operations in progress.
+func (br *BackupRunner) activeOperations() []string {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	operations := make([]string, 0, len(br.active))
+	for _, active := range br.active {
+		operations = append(operations, active.operation)
+	}
+	return operations
+}
+
+// loggerFor returns the logger of a registered configuration, or the system logger.
+func (br *BackupRunner) loggerFor(name string) *log.Logger {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if logger, exists := br.loggers[name]; exists {
+		return logger
+	}
+	return log.Default()
+}
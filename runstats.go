@@ -0,0 +1,273 @@
+// Package main - runstats.go tracks how often each configuration succeeds.
+//
+// The tray shows the outcome of the latest run, which hides a config that
+// fails every third time as long as the last attempt happened to work. Run
+// outcomes are counted per day in run_stats.json so success rates over the
+// last week or month can be shown in the tray and the report.
+//
+// Daily counters rather than individual runs keep the file small regardless of
+// schedule frequency. Days older than runStatsDays are folded into monthly
+// totals in run_stats_monthly.json, which are kept forever.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// runStatsDays is how many days of daily run counters are kept
+const runStatsDays = 90
+
+// runStatsMonthLayout formats the month of a monthly total
+const runStatsMonthLayout = "2006-01"
+
+// RunCounts counts run outcomes.
+type RunCounts struct {
+	Success int `json:"success"`           // Snapshot created
+	Partial int `json:"partial,omitempty"` // Snapshot created with warnings
+	Skipped int `json:"skipped,omitempty"` // Skipped because content was unchanged
+	Failure int `json:"failure,omitempty"` // Run failed
+}
+
+// DailyRunCounts are the run counts of one local calendar day.
+type DailyRunCounts struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	RunCounts
+}
+
+// MonthlyRunCounts are the run counts of one calendar month.
+type MonthlyRunCounts struct {
+	Month string `json:"month"` // YYYY-MM
+	RunCounts
+}
+
+// add adds other's counts to rc.
+func (rc *RunCounts) add(other RunCounts) {
+	rc.Success += other.Success
+	rc.Partial += other.Partial
+	rc.Skipped += other.Skipped
+	rc.Failure += other.Failure
+}
+
+// total returns the number of runs counted.
+func (rc RunCounts) total() int {
+	return rc.Success + rc.Partial + rc.Skipped + rc.Failure
+}
+
+// successRate returns the share of runs that did not fail, from 0 to 1.
+//
+// Skips count as successes: the content was verified and was already backed up.
+func (rc RunCounts) successRate() float64 {
+	if rc.total() == 0 {
+		return 1
+	}
+	return float64(rc.total()-rc.Failure) / float64(rc.total())
+}
+
+// describe renders counts as e.g. "93% ok, 2 failed of 30 runs".
+func (rc RunCounts) describe() string {
+	if rc.total() == 0 {
+		return "no runs"
+	}
+	text := fmt.Sprintf("%.0f%% ok, %d failed of %d runs", rc.successRate()*100, rc.Failure, rc.total())
+	if rc.Partial > 0 {
+		text += fmt.Sprintf(", %d partial", rc.Partial)
+	}
+	return text
+}
+
+// RunStats persists daily run counters per configuration.
+type RunStats struct {
+	mu          sync.Mutex
+	days        map[string][]DailyRunCounts   // Config name -> days, oldest first
+	months      map[string][]MonthlyRunCounts // Config name -> compacted months, oldest first
+	filePath    string
+	monthlyPath string
+}
+
+// Global singleton instance shared by the runner, tray and report
+var runStats = &RunStats{
+	days:        make(map[string][]DailyRunCounts),
+	months:      make(map[string][]MonthlyRunCounts),
+	filePath:    "run_stats.json",
+	monthlyPath: "run_stats_monthly.json",
+}
+
+// load restores counters from disk; a missing file is normal on first run.
+func (rs *RunStats) load() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	data, err := os.ReadFile(rs.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &rs.days); err != nil {
+		return err
+	}
+
+	data, err = os.ReadFile(rs.monthlyPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &rs.months)
+}
+
+// record counts one run outcome: a Result* constant or ResultFailure.
+func (rs *RunStats) record(name, outcome string) {
+	today := time.Now().Format(storageSampleLayout)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	days := rs.days[name]
+	if len(days) == 0 || days[len(days)-1].Date != today {
+		days = append(days, DailyRunCounts{Date: today})
+	}
+	counts := &days[len(days)-1].RunCounts
+	switch outcome {
+	case ResultBackup:
+		counts.Success++
+	case ResultPartial:
+		counts.Partial++
+	case ResultSkipped, ResultChanged:
+		// A verify-only run that found changes checked the source like a skip
+		counts.Skipped++
+	default:
+		counts.Failure++
+	}
+
+	rs.days[name] = days
+
+	rs.compactLocked(name, time.Now())
+	rs.saveLocked()
+}
+
+// compact folds the daily counters older than runStatsDays into monthly totals.
+//
+// record compacts the config it counts; this covers configs that haven't run
+// for a while. Returns the number of days folded.
+func (rs *RunStats) compact(now time.Time) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	folded := 0
+	for name := range rs.days {
+		folded += rs.compactLocked(name, now)
+	}
+	if folded > 0 {
+		rs.saveLocked()
+	}
+	return folded
+}
+
+// compactLocked compacts one config. Callers must hold rs.mu.
+func (rs *RunStats) compactLocked(name string, now time.Time) int {
+	cutoff := now.AddDate(0, 0, -runStatsDays).Format(storageSampleLayout)
+	days := rs.days[name]
+	folded := 0
+	for len(days) > 0 && days[0].Date < cutoff {
+		// The first 7 characters of a YYYY-MM-DD date are its month
+		month := days[0].Date[:len(runStatsMonthLayout)]
+		months := rs.months[name]
+		if len(months) == 0 || months[len(months)-1].Month != month {
+			months = append(months, MonthlyRunCounts{Month: month})
+		}
+		months[len(months)-1].add(days[0].RunCounts)
+		rs.months[name] = months
+		days = days[1:]
+		folded++
+	}
+	if len(days) == 0 {
+		delete(rs.days, name)
+	} else {
+		rs.days[name] = days
+	}
+	return folded
+}
+
+// saveLocked writes the daily and monthly counters. Callers must hold rs.mu.
+func (rs *RunStats) saveLocked() {
+	for path, value := range map[string]any{rs.filePath: rs.days, rs.monthlyPath: rs.months} {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			log.Printf("Failed to encode run statistics: %v", err)
+			continue
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			log.Printf("Failed to save run statistics: %v", err)
+		}
+	}
+}
+
+// clear removes all counters of a configuration and returns whether it had any.
+func (rs *RunStats) clear(name string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	_, hasDays := rs.days[name]
+	_, hasMonths := rs.months[name]
+	if !hasDays && !hasMonths {
+		return false
+	}
+	delete(rs.days, name)
+	delete(rs.months, name)
+	rs.saveLocked()
+	return true
+}
+
+// allTime adds up every run counted for a configuration, including compacted months.
+func (rs *RunStats) allTime(name string) RunCounts {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var total RunCounts
+	for _, month := range rs.months[name] {
+		total.add(month.RunCounts)
+	}
+	for _, day := range rs.days[name] {
+		total.add(day.RunCounts)
+	}
+	return total
+}
+
+// summary adds up the run counts of the last days days, including today.
+func (rs *RunStats) summary(name string, days int) RunCounts {
+	cutoff := time.Now().AddDate(0, 0, -(days - 1)).Format(storageSampleLayout)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var total RunCounts
+	for _, day := range rs.days[name] {
+		if day.Date < cutoff {
+			continue
+		}
+		total.add(day.RunCounts)
+	}
+	return total
+}
+
+// activeDays counts the days with at least one run among the last days days.
+func (rs *RunStats) activeDays(name string, days int) int {
+	cutoff := time.Now().AddDate(0, 0, -(days - 1)).Format(storageSampleLayout)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	active := 0
+	for _, day := range rs.days[name] {
+		if day.Date >= cutoff && day.total() > 0 {
+			active++
+		}
+	}
+	return active
+}
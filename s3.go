@@ -0,0 +1,440 @@
+// Package main - s3.go talks to S3 and S3-compatible object storage (MinIO,
+// Backblaze B2, Wasabi and the like).
+//
+// Only the handful of requests a backup destination needs are implemented:
+// uploading an object, listing and deleting objects. Requests are signed with
+// AWS Signature Version 4 directly over net/http, so the application keeps
+// its small dependency footprint instead of pulling in a full SDK.
+//
+// Key design decisions:
+//
+// 1. Multipart uploads: Archives are streamed while they are being written,
+//    so their size isn't known up front. The upload buffers s3PartSize at a
+//    time; an archive that fits in one part is sent with a single PUT, a
+//    larger one as a multipart upload that is aborted again if a part fails,
+//    so no orphaned parts keep costing storage.
+//
+// 2. Addressing: AWS is addressed virtual-hosted style
+//    (bucket.s3.region.amazonaws.com). A custom endpoint is addressed path
+//    style (endpoint/bucket/key), which every S3-compatible server supports
+//    and which works without wildcard DNS for self-hosted MinIO.
+//
+// 3. Credentials: Keys can be written into the config, taken from the
+//    credential store with the "credential:" prefix also used by hooks, or
+//    left out to use AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Scheme prefixes destinations stored in an S3 bucket, e.g. s3://bucket/backups
+const s3Scheme = "s3://"
+
+// s3PartSize is the size of the parts of a multipart upload; S3 requires at least 5 MB
+const s3PartSize = 16 << 20
+
+// s3DefaultRegion is used when the s3 settings don't name a region
+const s3DefaultRegion = "us-east-1"
+
+// s3RequestTimeout bounds one request, generous enough for a full part on a slow uplink
+const s3RequestTimeout = 30 * time.Minute
+
+// S3Settings configures access to the bucket of an s3:// destination.
+type S3Settings struct {
+	Endpoint        string `json:"endpoint,omitempty"`          // ""=AWS, or the URL of an S3-compatible server, e.g. http://nas:9000
+	Region          string `json:"region,omitempty"`            // ""=us-east-1
+	AccessKeyID     string `json:"access_key_id,omitempty"`     // ""=AWS_ACCESS_KEY_ID
+	SecretAccessKey string `json:"secret_access_key,omitempty"` // ""=AWS_SECRET_ACCESS_KEY, or "credential:<name>"
+}
+
+// GetRegion returns the region requests are signed for.
+//
+// Returns s3DefaultRegion if not specified.
+func (s *S3Settings) GetRegion() string {
+	if s == nil || s.Region == "" {
+		return s3DefaultRegion
+	}
+	return s.Region
+}
+
+// parseS3Destination splits an s3:// destination into its bucket and key prefix.
+//
+// The prefix is returned without slashes at either end; it is empty for
+// backups stored at the top of the bucket.
+func parseS3Destination(destination string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(destination, s3Scheme)
+	if !ok {
+		return "", "", fmt.Errorf("%s is not an s3:// destination", destination)
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%s names no bucket", destination)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// s3Store is a backup destination in an S3 bucket.
+type s3Store struct {
+	client    *http.Client
+	base      *url.URL // Endpoint the bucket is reached at, including the bucket for path style
+	pathStyle bool
+	region    string
+	prefix    string // Key prefix of the destination, "" or ending in "/"
+	accessKey string
+	secretKey string
+}
+
+// newS3Store returns the store of a config's s3:// destination.
+func newS3Store(config BackupConfig) (*s3Store, error) {
+	bucket, prefix, err := parseS3Destination(config.Destination)
+	if err != nil {
+		return nil, err
+	}
+	settings := config.S3
+	if settings == nil {
+		settings = &S3Settings{}
+	}
+
+	store := &s3Store{
+		client: &http.Client{Timeout: s3RequestTimeout},
+		region: settings.GetRegion(),
+	}
+	if prefix != "" {
+		store.prefix = prefix + "/"
+	}
+	if settings.Endpoint == "" {
+		store.base = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, store.region)}
+	} else {
+		endpoint, err := url.Parse(settings.Endpoint)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid s3 endpoint %q", settings.Endpoint)
+		}
+		store.base = &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, Path: strings.TrimSuffix(endpoint.Path, "/") + "/" + bucket}
+		store.pathStyle = true
+	}
+
+	store.accessKey, err = s3Credential(settings.AccessKeyID, "AWS_ACCESS_KEY_ID")
+	if err != nil {
+		return nil, err
+	}
+	store.secretKey, err = s3Credential(settings.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// s3Credential resolves a configured key: a literal value, a credential
+// store entry, or the environment variable envName if not configured.
+//
+// The resolved value is masked in logs, since only literal values are
+// registered when the config is loaded.
+func s3Credential(value, envName string) (string, error) {
+	if target, isCredential := strings.CutPrefix(value, credentialPrefix); isCredential {
+		secret, err := readCredential(target)
+		if err != nil {
+			return "", fmt.Errorf("s3 credential %q: %v", target, err)
+		}
+		registerSecret(secret)
+		return secret, nil
+	}
+	if value == "" {
+		value = os.Getenv(envName)
+	}
+	if value == "" {
+		return "", fmt.Errorf("no s3 credentials: set them in the s3 settings or %s", envName)
+	}
+	registerSecret(value)
+	return value, nil
+}
+
+// describe returns where the store keeps its objects, for log messages.
+func (s *s3Store) describe() string {
+	return s.base.String() + "/" + s.prefix
+}
+
+// close does nothing; every S3 request is a connection of its own.
+func (s *s3Store) close() error {
+	return nil
+}
+
+// upload stores the content of body as the object name, using a multipart
+// upload if it is larger than one part.
+func (s *s3Store) upload(name string, body io.Reader) error {
+	key := s.prefix + name
+	part := make([]byte, s3PartSize)
+	n, err := io.ReadFull(body, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = s.request(http.MethodPut, key, nil, part[:n])
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.requestXML(http.MethodPost, key, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+	if err := s.uploadParts(key, initiated.UploadID, part, body); err != nil {
+		// Parts of an unfinished upload are stored (and billed) until aborted
+		s.request(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil)
+		return err
+	}
+	return nil
+}
+
+// s3CompletedPart identifies an uploaded part when completing a multipart upload.
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// uploadParts sends buffer, which holds the first part, and then the rest of
+// body as the parts of the multipart upload uploadID, and completes it.
+func (s *s3Store) uploadParts(key, uploadID string, buffer []byte, body io.Reader) error {
+	var completed []s3CompletedPart
+	part := buffer
+	for number := 1; ; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := s.request(http.MethodPut, key, query, part)
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %v", number, err)
+		}
+		completed = append(completed, s3CompletedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		n, err := io.ReadFull(body, buffer)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		part = buffer[:n]
+	}
+
+	payload, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: completed})
+	if err != nil {
+		return err
+	}
+	// S3 can report a failed completion with status 200 and an error body
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := s.requestXML(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, payload, &result); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("failed to complete multipart upload: %s: %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// list returns the objects of the destination, with names relative to its prefix.
+func (s *s3Store) list() ([]remoteObject, error) {
+	var objects []remoteObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := s.requestXML(http.MethodGet, "", query, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(object.Key, s.prefix)
+			if name != "" && !strings.Contains(name, "/") {
+				objects = append(objects, remoteObject{Name: name, Size: object.Size, Modified: object.LastModified})
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// remove deletes the object name from the destination.
+func (s *s3Store) remove(name string) error {
+	_, err := s.request(http.MethodDelete, s.prefix+name, nil, nil)
+	return err
+}
+
+// requestXML sends a request and decodes its XML response into result.
+func (s *s3Store) requestXML(method, key string, query url.Values, body []byte, result any) error {
+	resp, err := s.request(method, key, query, body)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(resp.body, result)
+}
+
+// s3Response is a completed response with its body already read.
+type s3Response struct {
+	Header http.Header
+	body   []byte
+}
+
+// request sends a signed request for the object key ("" for the bucket).
+//
+// Responses with an error status are returned as errors carrying S3's error
+// code and message.
+func (s *s3Store) request(method, key string, query url.Values, body []byte) (*s3Response, error) {
+	target := *s.base
+	if key != "" {
+		target.Path += "/" + key
+	} else if !s.pathStyle {
+		target.Path = "/"
+	}
+	target.RawPath = s3EscapePath(target.Path)
+	target.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	signS3Request(req, body, s.region, s.accessKey, s.secretKey, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%s: %s (HTTP %d)", s3Err.Code, s3Err.Message, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return &s3Response{Header: resp.Header, body: data}, nil
+}
+
+// signS3Request adds the AWS Signature Version 4 headers to req, signing
+// its host, every header already set and the SHA-256 of body.
+func signS3Request(req *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// Signature Version 4 requires; slashes are kept if keepSlash is set.
+func s3Escape(value string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3EscapePath encodes an object path for the request line and its signature.
+func s3EscapePath(path string) string {
+	return s3Escape(path, true)
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name, as the request
+// line and its signature must agree on them byte for byte.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
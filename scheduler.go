@@ -25,6 +25,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 )
@@ -34,53 +36,203 @@ import (
 // This is the main scheduling intelligence that determines when backups should occur.
 // The scheduler considers multiple factors:
 // 1. Existing backup folders and their timestamps
-// 2. Hash-based action history (skips vs actual backups)  
+// 2. Hash-based action history (skips vs actual backups)
 // 3. Content changes detected since last action
 // 4. Configured scheduling intervals
 //
 // The complex startup logic is necessary because the scheduler must handle various scenarios:
 // - First run with no backups
-// - Restart after actual backups  
+// - Restart after actual backups
 // - Restart after skipped backups with unchanged content
 // - Restart after skipped backups with changed content
 //
 // Each backup configuration gets its own scheduler goroutine for fault isolation.
 func startBackupScheduler(ctx context.Context, config BackupConfig, logger *log.Logger) {
+	runScheduler(ctx, config, logger, systemClock)
+}
+
+// runScheduler implements startBackupScheduler against the given clock.
+func runScheduler(ctx context.Context, config BackupConfig, logger *log.Logger, clock Clock) {
 	// Initialize status tracking for UI display
 	backupStatus.initializeSchedule(config)
+	purgeForecasts.update(config)
 	logger.Printf("Started backup scheduler for %s (every %d minutes)", config.Name, config.ScheduleMinutes)
-	
-	// Define backup execution wrapper for consistent error handling and logging
-	performBackupTask := func() {
-		err := executeBackup(config, logger)
-		if err != nil {
-			logger.Printf("Backup failed for %s: %v", config.Name, err)
-		} else {
-			logger.Printf("Backup completed successfully for %s", config.Name)
+	checkDestinationWritable(config, logger)
+	if !writesArchiveSnapshots(config) && !config.VerifyOnly {
+		// A backup still running from before a reload may be writing its staging directory
+		backupRunner.withOperation(config, OperationMaintenance, func() error {
+			removeStagedSnapshots(config, logger)
+			return nil
+		})
+	}
+	probeTicker := clock.NewTicker(destinationProbeInterval)
+	defer probeTicker.Stop()
+
+	// Critical files are checked on their own, much shorter interval (see criticalfiles.go)
+	var criticalC <-chan time.Time
+	if len(config.CriticalFiles) > 0 {
+		checkCriticalFiles(config, logger)
+		criticalTicker := clock.NewTicker(criticalCheckInterval)
+		defer criticalTicker.Stop()
+		criticalC = criticalTicker.C()
+	}
+
+	// Define backup execution wrapper - the runner serializes this with on-demand
+	// triggers and handles success/failure logging consistently. Returns false
+	// when the config has been disabled and the scheduler should stop.
+	performBackupTask := func() bool {
+		// Runs that come due while backups are paused start on resume
+		if !awaitAllResumed(ctx) || !awaitResume(ctx, config) {
+			return false
+		}
+		err := backupRunner.run(config, logger)
+		// A paused config stays here until resumed, then retries straight away
+		for errors.Is(err, errConfigPaused) || isPaused(config.Name) {
+			if !awaitResume(ctx, config) {
+				return false
+			}
+			err = backupRunner.run(config, logger)
+		}
+		return !errors.Is(err, errSourceMissingDisabled)
+	}
+
+	// A run deferred because its network destination was offline is retried
+	// before the next regular run (see netdest.go)
+	var retry Timer
+	var retryC <-chan time.Time
+	scheduleRetry := func() {
+		if retry != nil {
+			retry.Stop()
+			retry, retryC = nil, nil
+		}
+		if delay, retrying := destinationRetryDelay(config); retrying {
+			retry = clock.NewTimer(delay)
+			retryC = retry.C()
 		}
 	}
-	
+	defer func() {
+		if retry != nil {
+			retry.Stop()
+		}
+	}()
+
 	// Analyze existing state to determine optimal first backup timing
+	plan := planFirstBackup(config, clock.Now())
+	logger.Print(plan.reason)
+	if plan.awaitConfirmation {
+		if !awaitFirstBackupConfirmation(ctx, config, logger) {
+			logger.Printf("Backup scheduler stopped for %s before first backup", config.Name)
+			return
+		}
+	}
+
+	// Execute first backup after calculated delay
+	firstTimer := clock.NewTimer(plan.delay)
+	defer firstTimer.Stop()
+
+	for waiting := true; waiting; {
+		select {
+		case <-ctx.Done():
+			logger.Printf("Backup scheduler stopped for %s before first backup", config.Name)
+			return
+		case <-probeTicker.C():
+			checkDestinationWritable(config, logger)
+		case <-criticalC:
+			checkCriticalFiles(config, logger)
+		case <-firstTimer.C():
+			if !awaitMaintenanceWindow(ctx, config, logger, clock) {
+				logger.Printf("Backup scheduler stopped for %s before first backup", config.Name)
+				return
+			}
+			if !performBackupTask() {
+				return
+			}
+			scheduleRetry()
+			waiting = false
+		}
+	}
+
+	// Watched sources also back up once their changes settle (see watch.go)
+	watcher := startSourceWatcher(ctx, config, logger, clock)
+
+	// Start regular interval timer for subsequent backups
+	ticker := clock.NewTicker(time.Duration(config.ScheduleMinutes) * time.Minute)
+	defer ticker.Stop()
+
+	// Main scheduling loop - continues until context cancellation
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Printf("Backup scheduler stopped for %s", config.Name)
+			return
+		case <-probeTicker.C():
+			checkDestinationWritable(config, logger)
+		case <-criticalC:
+			checkCriticalFiles(config, logger)
+		case <-ticker.C():
+			if !awaitMaintenanceWindow(ctx, config, logger, clock) {
+				logger.Printf("Backup scheduler stopped for %s", config.Name)
+				return
+			}
+			if !performBackupTask() {
+				logger.Printf("Backup scheduler stopped for %s", config.Name)
+				return
+			}
+			scheduleRetry()
+		case <-watcher.C():
+			if !awaitMaintenanceWindow(ctx, config, logger, clock) {
+				logger.Printf("Backup scheduler stopped for %s", config.Name)
+				return
+			}
+			if !performBackupTask() {
+				logger.Printf("Backup scheduler stopped for %s", config.Name)
+				return
+			}
+			scheduleRetry()
+		case <-retryC:
+			retry, retryC = nil, nil
+			if !performBackupTask() {
+				logger.Printf("Backup scheduler stopped for %s", config.Name)
+				return
+			}
+			scheduleRetry()
+		}
+	}
+}
+
+// firstBackupPlan describes when a scheduler runs its first backup.
+type firstBackupPlan struct {
+	delay             time.Duration // Time from scheduler start to the first backup
+	reason            string        // Explanation for the log
+	awaitConfirmation bool          // First backup waits for tray confirmation (delay then applies after it)
+}
+
+// planFirstBackup decides when the first backup of a scheduler run should happen.
+//
+// Kept free of timers and side effects other than reading hash state, so the
+// same decision serves the live scheduler and the "simulate" command.
+func planFirstBackup(config BackupConfig, now time.Time) firstBackupPlan {
 	lastBackupTime := backupStatus.findLastBackupTime(config)
 	scheduleInterval := time.Duration(config.ScheduleMinutes) * time.Minute
-	
+
 	// Determine the effective "last action" time based on hash awareness
 	var effectiveLastTime time.Time
 	var timeDescription string
-	
+
 	if config.IsHashCheckEnabled() {
 		// Hash checking enabled - use intelligent action-aware scheduling
 		lastActionType := hashManager.getLastActionType(config.Name)
 		lastActionTime := hashManager.getLastActionTime(config.Name)
-		
+
 		if lastActionType == "skipped" && !lastActionTime.IsZero() {
 			// Last action was a skip - check if content has changed since then
-			shouldSkip, err := hashManager.shouldSkipBackup(config.Name, config.Source)
+			var shouldSkip bool
+			var err error
+			backupRunner.withHashCheck(func() { shouldSkip, err = hashManager.shouldSkipBackup(config) })
 			if err != nil {
 				// Hash check failed - fall back to backup folder timing
-				logger.Printf("Hash check failed for %s, using backup folder time: %v", config.Name, err)
 				effectiveLastTime = lastBackupTime
-				timeDescription = "backup folder"
+				timeDescription = "backup folder (hash check failed: " + err.Error() + ")"
 			} else if shouldSkip {
 				// Content still unchanged since last skip - use skip time for scheduling
 				effectiveLastTime = lastActionTime
@@ -100,50 +252,76 @@ func startBackupScheduler(ctx context.Context, config BackupConfig, logger *log.
 		effectiveLastTime = lastBackupTime
 		timeDescription = "backup folder"
 	}
-	
+
+	// New configs may postpone the initial full copy (first_backup option)
+	if isNewConfig(config) {
+		policy := config.GetFirstBackupPolicy()
+		// Confirmation is given from the tray, so headless mode waits for the next slot instead
+		if policy == FirstBackupConfirm && headless {
+			policy = FirstBackupScheduled
+		}
+		switch policy {
+		case FirstBackupScheduled:
+			return firstBackupPlan{
+				delay:  scheduleInterval,
+				reason: fmt.Sprintf("First backup of %s deferred to the next scheduled slot in %v", config.Name, scheduleInterval),
+			}
+		case FirstBackupConfirm:
+			return firstBackupPlan{
+				awaitConfirmation: true,
+				reason:            fmt.Sprintf("First backup of %s requires confirmation", config.Name),
+			}
+		}
+	}
+
 	// Calculate first backup delay based on effective last action time
-	var firstBackupDelay time.Duration
 	if effectiveLastTime.IsZero() {
 		// No previous actions or content changed - run immediately
-		firstBackupDelay = 0
-		logger.Printf("No previous backups found for %s or %s, running immediately", config.Name, timeDescription)
-	} else {
-		timeSinceLastAction := time.Since(effectiveLastTime)
-		if timeSinceLastAction >= scheduleInterval {
-			// Overdue - run immediately
-			firstBackupDelay = 0
-			logger.Printf("Last action for %s (%s) was %v ago (overdue), running immediately", config.Name, timeDescription, timeSinceLastAction)
-		} else {
-			// Calculate remaining time until next scheduled backup
-			firstBackupDelay = scheduleInterval - timeSinceLastAction
-			logger.Printf("Last action for %s (%s) was %v ago, next backup in %v", config.Name, timeDescription, timeSinceLastAction, firstBackupDelay)
+		return firstBackupPlan{
+			reason: fmt.Sprintf("No previous backups found for %s or %s, running immediately", config.Name, timeDescription),
 		}
 	}
-	
-	// Execute first backup after calculated delay
-	firstTimer := time.NewTimer(firstBackupDelay)
-	defer firstTimer.Stop()
-	
-	select {
-	case <-ctx.Done():
-		logger.Printf("Backup scheduler stopped for %s before first backup", config.Name)
-		return
-	case <-firstTimer.C:
-		performBackupTask()
+
+	timeSinceLastAction := now.Sub(effectiveLastTime)
+	if timeSinceLastAction >= scheduleInterval {
+		// Overdue - run immediately
+		return firstBackupPlan{
+			reason: fmt.Sprintf("Last action for %s (%s) was %v ago (overdue), running immediately", config.Name, timeDescription, timeSinceLastAction),
+		}
 	}
-	
-	// Start regular interval timer for subsequent backups
-	ticker := time.NewTicker(scheduleInterval)
+
+	// Calculate remaining time until next scheduled backup
+	delay := scheduleInterval - timeSinceLastAction
+	return firstBackupPlan{
+		delay:  delay,
+		reason: fmt.Sprintf("Last action for %s (%s) was %v ago, next backup in %v", config.Name, timeDescription, timeSinceLastAction, delay),
+	}
+}
+
+// simulateSchedule returns the next runs planned for a configuration.
+//
+// The first timer and the interval ticker are created on a simulated clock
+// the same way runScheduler creates them, and the clock is advanced until
+// they have fired runs times. Runs are assumed to take no time; the live
+// scheduler starts its ticker after the first backup finishes, so later runs
+// shift by that duration. Returns no runs for configs waiting on first
+// backup confirmation.
+func simulateSchedule(config BackupConfig, clock *simulatedClock, runs int) (firstBackupPlan, []time.Time) {
+	plan := planFirstBackup(config, clock.Now())
+	if plan.awaitConfirmation || runs < 1 {
+		return plan, nil
+	}
+
+	firstTimer := clock.NewTimer(plan.delay)
+	clock.advance(plan.delay)
+	planned := []time.Time{<-firstTimer.C()}
+
+	interval := time.Duration(config.ScheduleMinutes) * time.Minute
+	ticker := clock.NewTicker(interval)
 	defer ticker.Stop()
-	
-	// Main scheduling loop - continues until context cancellation
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Printf("Backup scheduler stopped for %s", config.Name)
-			return
-		case <-ticker.C:
-			performBackupTask()
-		}
+	for len(planned) < runs {
+		clock.advance(interval)
+		planned = append(planned, <-ticker.C())
 	}
-}
\ No newline at end of file
+	return plan, planned
+}
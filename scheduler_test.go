@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	_ "time/tzdata" // America/New_York for the daylight saving tests, on any machine
+)
+
+// newScheduleTestConfig returns a config with a source holding one file and
+// an empty destination, with no change-detection state.
+func newScheduleTestConfig(t *testing.T, scheduleMinutes int, hashCheck bool) BackupConfig {
+	t.Helper()
+	dir := t.TempDir()
+	config := BackupConfig{
+		Name:            "Data",
+		Source:          filepath.Join(dir, "data"),
+		Destination:     filepath.Join(dir, "backups"),
+		ScheduleMinutes: scheduleMinutes,
+		HashCheck:       &hashCheck,
+	}
+	for _, folder := range []string{config.Source, config.Destination} {
+		if err := os.Mkdir(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(config.Source, "notes.txt"), []byte("unchanged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := hashManager.hashes
+	hashManager.hashes = make(map[string]HashStatus)
+	t.Cleanup(func() { hashManager.hashes = previous })
+	return config
+}
+
+// addTestSnapshot creates the snapshot folder of a backup taken at taken.
+func addTestSnapshot(t *testing.T, config BackupConfig, taken time.Time) {
+	t.Helper()
+	if err := os.Mkdir(filepath.Join(config.Destination, generateBackupDirName(config.Source, taken)), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordTestAction records a backup or skip at when, with the source's current
+// hash or, if changed, one the source no longer has.
+func recordTestAction(t *testing.T, config BackupConfig, actionType string, when time.Time, changed bool) {
+	t.Helper()
+	hash, err := hashManager.sourceHash(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		hash = "h1:stale"
+	}
+	hashManager.hashes[config.Name] = HashStatus{LastHash: hash, LastActionType: actionType, LastActionTime: when, LastChangeTime: when}
+}
+
+// assertPlannedRuns simulates config's schedule from now and compares the
+// planned runs with want.
+func assertPlannedRuns(t *testing.T, config BackupConfig, now time.Time, want []time.Time) {
+	t.Helper()
+	plan, runs := simulateSchedule(config, newSimulatedClock(now), len(want))
+	if len(runs) != len(want) {
+		t.Fatalf("planned %d runs, want %d (%s)", len(runs), len(want), plan.reason)
+	}
+	for i := range want {
+		if !runs[i].Equal(want[i]) {
+			t.Errorf("run %d at %v, want %v (%s)", i+1, runs[i], want[i], plan.reason)
+		}
+	}
+}
+
+func TestPlanFirstBackupOverdue(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name       string
+		lastBackup time.Duration // Before now; 0 for no snapshot
+		want       []time.Duration
+	}{
+		{"no snapshots", 0, []time.Duration{0, time.Hour, 2 * time.Hour}},
+		{"overdue", 3 * time.Hour, []time.Duration{0, time.Hour, 2 * time.Hour}},
+		{"exactly due", time.Hour, []time.Duration{0, time.Hour}},
+		{"not yet due", 20 * time.Minute, []time.Duration{40 * time.Minute, 100 * time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newScheduleTestConfig(t, 60, false)
+			if tt.lastBackup > 0 {
+				addTestSnapshot(t, config, now.Add(-tt.lastBackup))
+			}
+			var want []time.Time
+			for _, offset := range tt.want {
+				want = append(want, now.Add(offset))
+			}
+			assertPlannedRuns(t, config, now, want)
+		})
+	}
+}
+
+func TestPlanFirstBackupAfterSkip(t *testing.T) {
+	now := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name      string
+		hashCheck bool
+		action    string
+		changed   bool
+		want      time.Duration // First run after now
+	}{
+		{"unchanged since skip", true, "skipped", false, 50 * time.Minute},
+		{"changed since skip", true, "skipped", true, 0},
+		{"last action a backup", true, "backup", false, 0},
+		{"hash check off", false, "skipped", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newScheduleTestConfig(t, 60, tt.hashCheck)
+			addTestSnapshot(t, config, now.Add(-5*time.Hour)) // Overdue going by the snapshot alone
+			recordTestAction(t, config, tt.action, now.Add(-10*time.Minute), tt.changed)
+			first := now.Add(tt.want)
+			assertPlannedRuns(t, config, now, []time.Time{first, first.Add(time.Hour)})
+		})
+	}
+}
+
+func TestSimulateScheduleAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := time.Local
+	time.Local = newYork // Snapshot names are parsed in local time
+	t.Cleanup(func() { time.Local = previous })
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		lastBackup time.Time
+		now        time.Time
+		want       []time.Time
+	}{
+		{
+			// 01:30 EST; clocks jump from 02:00 to 03:00, runs stay an hour apart
+			name:       "spring forward",
+			lastBackup: utc(time.March, 10, 6, 30),
+			now:        utc(time.March, 10, 6, 45),
+			want:       []time.Time{utc(time.March, 10, 7, 30), utc(time.March, 10, 8, 30), utc(time.March, 10, 9, 30)},
+		},
+		{
+			// 00:45 EDT; 01:45 comes twice, once as EDT and once as EST
+			name:       "fall back",
+			lastBackup: utc(time.November, 3, 4, 45),
+			now:        utc(time.November, 3, 5, 0),
+			want:       []time.Time{utc(time.November, 3, 5, 45), utc(time.November, 3, 6, 45), utc(time.November, 3, 7, 45)},
+		},
+		{
+			// 01:30 EST is named like 01:30 EDT and read back as the earlier
+			// of the two, so the snapshot counts as an hour older than it is
+			name:       "fall back, snapshot in the repeated hour",
+			lastBackup: utc(time.November, 3, 6, 30),
+			now:        utc(time.November, 3, 6, 40),
+			want:       []time.Time{utc(time.November, 3, 6, 40), utc(time.November, 3, 7, 40)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newScheduleTestConfig(t, 60, false)
+			addTestSnapshot(t, config, tt.lastBackup.In(newYork))
+			assertPlannedRuns(t, config, tt.now.In(newYork), tt.want)
+		})
+	}
+}
@@ -0,0 +1,739 @@
+// Package main - scripting.go implements the commands for running and checking backups from scripts.
+//
+// Everything the tray offers about the backups themselves - "Backup now",
+// the status lines, the list of jobs - was only reachable through the tray.
+// These commands make the same operations scriptable, e.g. from a scheduled
+// task, a monitoring check or a test:
+//
+// - backup: Back up configs now, or preview what a backup would do
+// - status: The state, last and next run of each config
+// - pause, resume: Pause and resume all backups of the running instance
+// - history: The recorded runs of a config
+// - list: The configs in config.json
+// - validate-config: Check config.json without starting anything
+//
+// Key design decisions:
+//
+// 1. The running instance comes first: backup and status are forwarded to it
+//    when it runs, so a backup started from a script takes the same per-config
+//    lock as a scheduled one and status shows its live schedule. The backup
+//    command waits for the instance to finish, so its summary and exit code
+//    are the same either way. Without an instance, backup runs here and
+//    status is derived from the state files.
+//
+// 2. Exit codes for scripts: 0 on success, 1 when something failed (including
+//    a backup deferred because its source or destination is unavailable),
+//    2 for wrong arguments, and for backup 3 when a snapshot was saved but
+//    its cleanup failed. A backup whose failures all have the same error code
+//    exits with that code's own exit code instead of 1 (see errorcodes.go).
+//
+// 3. A summary to mail: A backup run in the command ends with one plain-text
+//    block covering every config (result, size, duration, error), short
+//    enough for the body of the mail a scheduler sends about the run.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Exit codes of the backup command
+const (
+	exitSuccess = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitPartial = 3 // Saved, but rotation cleanup failed
+)
+
+// backupSummaryRow is one config's line in the summary of the backup command.
+//
+// A running instance sends the rows of the backups it ran for the command.
+type backupSummaryRow struct {
+	Name     string        `json:"name"`
+	Result   string        `json:"result"`             // "saved", "skipped", "changed", "partial", "deferred" or "failed"
+	Snapshot string        `json:"snapshot,omitempty"` // Name of the saved snapshot
+	Bytes    int64         `json:"bytes,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Problem  string        `json:"problem,omitempty"` // Error or warning, "" if none
+	Code     ErrorCode     `json:"code,omitempty"`    // Cause of a failed or deferred run
+}
+
+// newBackupSummaryRow describes the outcome of one config's backup.
+func newBackupSummaryRow(name string, result BackupResult, err error, duration time.Duration) backupSummaryRow {
+	row := backupSummaryRow{Name: name, Bytes: result.Bytes, Duration: duration}
+	switch {
+	case err != nil:
+		row.Result, row.Problem, row.Code = "failed", formatCodedError(err), errorCodeOf(err)
+	case result.Outcome == ResultWaiting:
+		row.Result, row.Problem, row.Code = "deferred", fmt.Sprintf("[%s] %s", result.Code, result.Code.describe()), result.Code
+	case result.Outcome == ResultSkipped:
+		row.Result = "skipped"
+	case result.Outcome == ResultChanged:
+		row.Result = "changed"
+	case result.Outcome == ResultPartial:
+		row.Result, row.Snapshot, row.Problem = "partial", filepath.Base(result.Snapshot), result.Warning
+	default:
+		row.Result, row.Snapshot = "saved", filepath.Base(result.Snapshot)
+	}
+	return row
+}
+
+// printBackupOutcome prints the line the backup command shows as soon as a
+// config's backup ends; failures go to stderr.
+func printBackupOutcome(row backupSummaryRow) {
+	switch row.Result {
+	case "failed":
+		fmt.Fprintf(os.Stderr, "%s: backup failed: %s\n", row.Name, row.Problem)
+	case "deferred":
+		fmt.Fprintf(os.Stderr, "%s: %s, nothing was backed up\n", row.Name, row.Problem)
+	case "skipped":
+		fmt.Printf("%s: skipped, contents are unchanged since the last backup\n", row.Name)
+	case "changed":
+		fmt.Printf("%s: contents changed, but verify_only is on, so nothing was backed up\n", row.Name)
+	case "partial":
+		fmt.Printf("%s: saved %s, but %s\n", row.Name, row.Snapshot, row.Problem)
+	default:
+		fmt.Printf("%s: saved %s\n", row.Name, row.Snapshot)
+	}
+}
+
+// handleBackupRequest starts backups forwarded by the backup command in the
+// tray instance. With req.Wait, the backups run one after another, as in a
+// backup command without an instance, and the answer carries their outcomes.
+func handleBackupRequest(req ipcRequest) ipcResponse {
+	if len(req.Configs) == 0 {
+		return ipcResponse{Message: "No backup config given"}
+	}
+	registered := make(map[string]bool)
+	for _, config := range backupRunner.registeredConfigs() {
+		registered[config.Name] = true
+	}
+	for _, name := range req.Configs {
+		if !registered[name] {
+			return ipcResponse{Message: fmt.Sprintf("No running backup config named %q; it may be disabled", name)}
+		}
+		if err := ensureWritable(requestInterface(req), "backup-now", name); err != nil {
+			return ipcResponse{Message: err.Error()}
+		}
+	}
+
+	if req.Wait {
+		var rows []backupSummaryRow
+		for _, name := range req.Configs {
+			auditLog.record(requestInterface(req), "backup-now", name, "")
+			started := time.Now()
+			result, err := backupRunner.runByNameWithResult(name)
+			rows = append(rows, newBackupSummaryRow(name, result, err, time.Since(started)))
+		}
+		return ipcResponse{OK: true, Message: fmt.Sprintf("Backed up %s in the running instance", joinNames(req.Configs)), Backups: rows}
+	}
+
+	for _, name := range req.Configs {
+		auditLog.record(requestInterface(req), "backup-now", name, "")
+		go backupRunner.runByName(name)
+	}
+	return ipcResponse{OK: true, Message: fmt.Sprintf("Started backup of %s in the running instance; the outcome is shown as a notification and logged", joinNames(req.Configs))}
+}
+
+// handlePauseAllRequest pauses or resumes all backups on request of a tray
+// client or the pause and resume commands.
+func handlePauseAllRequest(req ipcRequest) ipcResponse {
+	if err := ensureWritable(requestInterface(req), req.Action, ""); err != nil {
+		return ipcResponse{Message: err.Error()}
+	}
+	if req.Action == IPCActionPauseAll {
+		auditLog.record(requestInterface(req), "pause-all", "", "")
+		if !pauseAllBackups() {
+			return ipcResponse{OK: true, Message: "Backups were already paused"}
+		}
+		return ipcResponse{OK: true, Message: "All backups paused until resumed"}
+	}
+	auditLog.record(requestInterface(req), "resume-all", "", "")
+	if !resumeAllBackups() {
+		return ipcResponse{OK: true, Message: "Backups were not paused"}
+	}
+	return ipcResponse{OK: true, Message: "All backups resumed"}
+}
+
+// requestInterface is the audit interface an IPC request came from.
+func requestInterface(req ipcRequest) string {
+	if req.Interface == "" {
+		return AuditInterfaceCLI
+	}
+	return req.Interface
+}
+
+// runBackupCommand backs up the named configs once.
+//
+// The backups run in the running instance, or here without one, one after
+// another. Either way the command returns when they are done, printing a
+// summary and exiting with the worst outcome: exitFailure over exitPartial
+// over exitSuccess. With --dry-run, nothing is backed up (see
+// runDryRunCommand).
+func runBackupCommand(args []string) int {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what a backup would copy and delete")
+	list := flags.Bool("list", false, "with --dry-run, also list every file a snapshot would contain")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	args = flags.Args()
+	if len(args) == 0 || (*list && !*dryRun) {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup backup [--dry-run [--list]] <config>...")
+		return exitUsage
+	}
+	if *dryRun {
+		return runDryRunCommand(args, *list)
+	}
+
+	resp, err := sendIPCRequest(ipcRequest{Action: IPCActionBackup, Configs: args, Wait: true})
+	if err == nil {
+		if !resp.OK {
+			fmt.Fprintln(os.Stderr, resp.Message)
+			return exitFailure
+		}
+		fmt.Println(resp.Message)
+		for _, row := range resp.Backups {
+			printBackupOutcome(row)
+		}
+		exitCode := backupExitCode(resp.Backups)
+		fmt.Println()
+		fmt.Print(formatBackupSummary(resp.Backups, exitCode))
+		return exitCode
+	}
+	if !errors.Is(err, errInstanceNotRunning) {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	all, err := loadCLIConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	configs := make([]BackupConfig, 0, len(args))
+	for _, name := range args {
+		config, err := findConfig(all, name)
+		if err == nil && !config.IsEnabled() {
+			err = fmt.Errorf("%s is disabled in config.json", name)
+		}
+		if err == nil {
+			err = ensureWritable(AuditInterfaceCLI, "backup-now", name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		configs = append(configs, config)
+	}
+	if err := loadStateStores(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	var rows []backupSummaryRow
+	for _, config := range configs {
+		logger, err := initBackupLogger(config)
+		if err != nil {
+			row := backupSummaryRow{Name: config.Name, Result: "failed", Problem: fmt.Sprintf("failed to create logger: %v", err)}
+			printBackupOutcome(row)
+			rows = append(rows, row)
+			continue
+		}
+		backupRunner.register(config, logger)
+		auditLog.record(AuditInterfaceCLI, "backup-now", config.Name, "")
+
+		started := time.Now()
+		result, err := backupRunner.runWithResult(config, logger)
+		row := newBackupSummaryRow(config.Name, result, err, time.Since(started))
+		printBackupOutcome(row)
+		rows = append(rows, row)
+	}
+
+	exitCode := backupExitCode(rows)
+	fmt.Println()
+	fmt.Print(formatBackupSummary(rows, exitCode))
+	return exitCode
+}
+
+// runDryRunCommand reports what a backup of each named config would do,
+// without writing anything.
+//
+// It never involves the running instance: nothing is started, and the
+// change-detection state in hashes.json is current enough to read. Disabled
+// configs can be previewed too, typically before enabling a new one.
+func runDryRunCommand(names []string, list bool) int {
+	all, err := loadCLIConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	if err := hashManager.loadFromFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load hash file: %v\n", err)
+	}
+
+	exitCode := exitSuccess
+	for _, name := range names {
+		config, err := findConfig(all, name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = exitFailure
+			continue
+		}
+		var listFile func(rel string, size int64, linked bool)
+		if list {
+			fmt.Printf("Files a snapshot of %s would contain:\n", config.Name)
+			listFile = func(rel string, size int64, linked bool) {
+				action := "copy"
+				if linked {
+					action = "link"
+				}
+				fmt.Printf("  %s  %9s  %s\n", action, formatSize(size), rel)
+			}
+		}
+		report, err := dryRunBackup(config, listFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", config.Name, err)
+			exitCode = exitFailure
+			continue
+		}
+		fmt.Print(formatDryRun(config.Name, report))
+	}
+	return exitCode
+}
+
+// backupExitCode returns the exit code for the worst outcome among rows.
+//
+// Failures that all share an error code exit with that code's exit code, so
+// a script can react to e.g. a full disk without parsing the output.
+func backupExitCode(rows []backupSummaryRow) int {
+	exitCode := exitSuccess
+	var failed []ErrorCode
+	for _, row := range rows {
+		switch row.Result {
+		case "failed", "deferred":
+			failed = append(failed, row.Code)
+		case "partial":
+			exitCode = exitPartial
+		}
+	}
+	if len(failed) == 0 {
+		return exitCode
+	}
+	for _, code := range failed[1:] {
+		if code != failed[0] {
+			return exitFailure
+		}
+	}
+	return failed[0].exitCode()
+}
+
+// formatBackupSummary renders the closing summary of the backup command:
+// one line per config, its error or warning indented below it, and the
+// exit code. Only plain ASCII layout is used, so it reads the same in a
+// terminal, a log file and a plain-text mail.
+func formatBackupSummary(rows []backupSummaryRow, exitCode int) string {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row.Name))
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Summary:\n")
+	for _, row := range rows {
+		size := "-"
+		if row.Result == "saved" || row.Result == "partial" {
+			size = formatSize(row.Bytes)
+		}
+		fmt.Fprintf(&out, "  %-*s  %-8s  %9s  %s\n", width, row.Name, row.Result, size, row.Duration.Round(100*time.Millisecond))
+		if row.Problem != "" {
+			fmt.Fprintf(&out, "    %s\n", row.Problem)
+		}
+	}
+	outcome := "failure"
+	switch exitCode {
+	case exitSuccess:
+		outcome = "success"
+	case exitPartial:
+		outcome = "partial"
+	}
+	fmt.Fprintf(&out, "Result: %s (exit code %d)\n", outcome, exitCode)
+	return out.String()
+}
+
+// statusReport is the output of status --json.
+type statusReport struct {
+	Running bool           `json:"running"` // Whether the statuses come from a running instance
+	Configs []ConfigStatus `json:"configs"`
+}
+
+// pauseAllCommand returns the command name, sending action (IPCActionPauseAll
+// or IPCActionResumeAll) to the running instance; there is nothing to pause
+// without one.
+func pauseAllCommand(name, action string) func(args []string) int {
+	return func(args []string) int {
+		if len(args) != 0 {
+			fmt.Fprintf(os.Stderr, "Usage: SimpleFolderBackup %s\n", name)
+			return exitUsage
+		}
+		resp, err := sendIPCRequest(ipcRequest{Action: action})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		if !resp.OK {
+			fmt.Fprintln(os.Stderr, resp.Message)
+			return exitFailure
+		}
+		fmt.Println(resp.Message)
+		return exitSuccess
+	}
+}
+
+// runStatusCommand prints the status of each config.
+//
+// The running instance knows the live schedule; without one the last run and
+// the next due time are worked out from the snapshots and state files, as the
+// instance would on its next start.
+func runStatusCommand(args []string) int {
+	asJSON := len(args) > 0 && args[0] == "--json"
+	if asJSON {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup status [--json] [config]")
+		return 2
+	}
+
+	var report statusReport
+	configs, err := loadCLIConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := hashManager.loadFromFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load hash file: %v\n", err)
+	}
+	if err := runStats.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load run statistics: %v\n", err)
+	}
+	if err := purgeForecasts.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not load purge forecasts: %v\n", err)
+	}
+
+	resp, err := sendIPCRequest(ipcRequest{Action: IPCActionStatus})
+	switch {
+	case err == nil && resp.OK:
+		report = statusReport{Running: true, Configs: resp.Statuses}
+	case err == nil:
+		fmt.Fprintln(os.Stderr, resp.Message)
+		return 1
+	case !errors.Is(err, errInstanceNotRunning):
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	default:
+		for _, config := range configs {
+			backupStatus.initializeSchedule(config)
+			if !config.IsEnabled() {
+				backupStatus.markDisabled(config.Name)
+			}
+		}
+		report = statusReport{Configs: backupStatus.configStatuses()}
+	}
+
+	if len(args) == 1 {
+		index := slices.IndexFunc(report.Configs, func(status ConfigStatus) bool { return status.Name == args[0] })
+		if index < 0 {
+			fmt.Fprintf(os.Stderr, "no backup config named %q\n", args[0])
+			return 1
+		}
+		report.Configs = report.Configs[index : index+1]
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+
+	if !report.Running {
+		fmt.Println("SimpleFolderBackup is not running; next runs are as of its next start")
+		fmt.Println()
+	}
+	now := time.Now()
+	for _, status := range report.Configs {
+		fmt.Print(formatConfigStatus(status, now))
+	}
+	return 0
+}
+
+// runHistoryCommand prints the most recent recorded runs of a config, newest first.
+func runHistoryCommand(args []string) int {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the runs as JSON")
+	limit := flags.Int("limit", 20, "number of runs to print, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) != 1 || *limit < 0 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup history [--json] [--limit N] <config>")
+		return 2
+	}
+
+	config, err := loadCLIConfig(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	records, err := loadRunHistory(config.Name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read run history: %v\n", err)
+		return 1
+	}
+	slices.Reverse(records)
+	if *limit > 0 {
+		records = records[:min(len(records), *limit)]
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	if len(records) == 0 {
+		fmt.Printf("No runs of %s recorded yet\n", config.Name)
+		return 0
+	}
+	for _, record := range records {
+		fmt.Print(formatRunRecord(record))
+	}
+	return 0
+}
+
+// formatRunRecord renders one recorded run for the history command: its
+// start, result, duration and size, then its snapshot, reason or error
+// indented below.
+func formatRunRecord(record RunRecord) string {
+	var out bytes.Buffer
+	size := "-"
+	if record.Result == ResultBackup || record.Result == ResultPartial {
+		size = formatSize(record.Bytes)
+		if record.Files > 0 {
+			size += fmt.Sprintf(" in %d files", record.Files)
+		}
+	}
+	fmt.Fprintf(&out, "%s  %-8s  %8s  %s\n", formatDisplayTime(record.Start), record.Result, record.Duration().Round(100*time.Millisecond), size)
+	problem := record.Error
+	if record.Result == ResultFailure && record.Code != "" {
+		problem = fmt.Sprintf("[%s] %s", record.Code, problem)
+	}
+	for _, detail := range []string{record.Snapshot, record.Reason, problem} {
+		if detail != "" {
+			fmt.Fprintf(&out, "    %s\n", detail)
+		}
+	}
+	return out.String()
+}
+
+// formatConfigStatus renders one config's status as a block of lines for the status command.
+func formatConfigStatus(status ConfigStatus, now time.Time) string {
+	var out bytes.Buffer
+	if status.PausedUntil.IsZero() {
+		fmt.Fprintf(&out, "%s: %s\n", status.Name, status.State)
+	} else {
+		fmt.Fprintf(&out, "%s: %s until %s\n", status.Name, status.State, formatDisplayTime(status.PausedUntil))
+	}
+	if status.LastRun.IsZero() {
+		fmt.Fprintln(&out, "  Last run: never")
+	} else {
+		fmt.Fprintf(&out, "  Last run: %s (%s), %s\n", formatDisplayTime(status.LastRun), formatAge(now.Sub(status.LastRun)), status.LastResult)
+	}
+	if !status.NextRun.IsZero() {
+		fmt.Fprintf(&out, "  Next run: %s\n", formatDisplayTime(status.NextRun))
+	}
+	if status.LastSnapshot != nil {
+		fmt.Fprintf(&out, "  Last snapshot: %s (%s)\n", status.LastSnapshot.describe(), formatDisplayTime(status.LastSnapshot.Time))
+	}
+	fmt.Fprintf(&out, "  Last 30 days: %s\n", status.Last30Days.describe())
+	if status.Progress != nil {
+		fmt.Fprintf(&out, "  Progress: %s\n", status.Progress.describe())
+	}
+	if status.File != nil {
+		fmt.Fprintf(&out, "  %s\n", status.File.describe(now))
+	}
+	if status.BlockedBy != "" {
+		fmt.Fprintf(&out, "  Waiting for: %s\n", status.BlockedBy)
+	}
+	if status.Alert != "" {
+		fmt.Fprintf(&out, "  Alert: %s\n", status.Alert)
+	}
+	switch status.WatchFallback {
+	case WatchFallbackPolling:
+		fmt.Fprintf(&out, "  Watch: polling every %v, change notifications failed: %s\n", watchPollInterval, status.WatchError)
+	case WatchFallbackSchedule:
+		fmt.Fprintf(&out, "  Watch failed: %s (backing up on schedule, retried every minute)\n", status.WatchError)
+	}
+	if status.LastError != "" {
+		fmt.Fprintf(&out, "  Last error: [%s] %s\n", status.LastErrorCode, status.LastError)
+	}
+	if status.NextPurge != nil {
+		fmt.Fprintf(&out, "  %s\n", status.NextPurge.describe(now))
+	}
+	if status.Replica != nil {
+		fmt.Fprintf(&out, "  %s\n", describeReplicaStatus(*status.Replica, now))
+	}
+	out.WriteString("\n")
+	return out.String()
+}
+
+// runListCommand prints the configs of config.json.
+func runListCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup list")
+		return 2
+	}
+
+	configs, err := loadCLIConfigs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, config := range configs {
+		details := fmt.Sprintf("every %d minutes, keeps %d", config.ScheduleMinutes, config.RotationCount)
+		if !config.IsEnabled() {
+			details = "disabled"
+		}
+		fmt.Printf("%s (%s)\n  %s -> %s\n", config.Name, details, config.Source, config.Destination)
+	}
+	return 0
+}
+
+// runValidateConfigCommand checks config.json and prints the problems found.
+//
+// Errors are settings the application can't work with; warnings are likely
+// mistakes that it works around, such as a misspelled option falling back to
+// its default. Only errors make the command fail.
+func runValidateConfigCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: SimpleFolderBackup validate-config")
+		return 2
+	}
+	// loadConfig would create an example config, which is not what a check should do
+	if _, err := os.Stat("config.json"); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read config.json: %v\n", err)
+		return 1
+	}
+
+	errs, warnings := validateConfigFile()
+	for _, warning := range warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+	for _, err := range errs {
+		fmt.Printf("error: %s\n", err)
+	}
+	if len(errs) > 0 {
+		fmt.Printf("config.json has %d error(s) and %d warning(s)\n", len(errs), len(warnings))
+		return 1
+	}
+	fmt.Printf("config.json is valid (%d warning(s))\n", len(warnings))
+	return 0
+}
+
+// validateConfigFile loads config.json like at startup and checks each config.
+func validateConfigFile() (errs, warnings []string) {
+	config, err := loadConfig()
+	if err != nil {
+		return []string{fmt.Sprintf("cannot load config.json: %v", err)}, nil
+	}
+	// Unknown fields are ignored when loading, so a misspelled option silently does nothing
+	if data, err := os.ReadFile("config.json"); err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&Config{}); err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+	if err := validatePaths(config); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	settings := config.Settings
+	if settings.MaintenanceConflicts != "" && settings.GetMaintenanceConflicts() != settings.MaintenanceConflicts {
+		warnings = append(warnings, fmt.Sprintf("settings: unknown maintenance_conflicts %q, using %q", settings.MaintenanceConflicts, settings.GetMaintenanceConflicts()))
+	}
+
+	seen := make(map[string]bool)
+	for _, backup := range config.Backups {
+		name := backup.Name
+		if name == "" {
+			errs = append(errs, "a backup config has no name")
+			name = "(unnamed)"
+		} else if seen[name] {
+			errs = append(errs, fmt.Sprintf("%s: more than one backup config has this name", name))
+		}
+		seen[name] = true
+
+		if backup.ScheduleMinutes <= 0 {
+			errs = append(errs, fmt.Sprintf("%s: schedule_minutes must be at least 1", name))
+		}
+		if backup.RotationCount <= 0 {
+			errs = append(errs, fmt.Sprintf("%s: rotation_count must be at least 1", name))
+		}
+		if pathsOverlap(backup.Source, backup.Destination) {
+			errs = append(errs, fmt.Sprintf("%s: source and destination must not contain each other", name))
+		}
+
+		// An option the getter doesn't recognize falls back to its default
+		options := []struct{ option, value, used string }{
+			{"copy_strategy", backup.CopyStrategy, backup.GetCopyStrategy()},
+			{"missing_source", backup.MissingSource, backup.GetMissingSourcePolicy()},
+			{"first_backup", backup.FirstBackup, backup.GetFirstBackupPolicy()},
+			{"warm_cache", backup.WarmCache, backup.GetWarmCacheMode()},
+			{"retention", backup.Retention, backup.GetRetentionMode()},
+		}
+		for _, option := range options {
+			if option.value != "" && option.value != option.used {
+				warnings = append(warnings, fmt.Sprintf("%s: unknown %s %q, using %q", name, option.option, option.value, option.used))
+			}
+		}
+
+		if !backup.IsEnabled() {
+			continue
+		}
+		if info, err := os.Stat(backup.Source); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: source %s is not available: %v", name, backup.Source, err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Sprintf("%s: source %s is not a folder", name, backup.Source))
+		}
+		if isRemoteDestination(backup.Destination) {
+			continue // Checked when the bucket is first used
+		}
+		if _, err := os.Stat(backup.Destination); os.IsNotExist(err) {
+			warnings = append(warnings, fmt.Sprintf("%s: destination %s doesn't exist yet and will be created", name, backup.Destination))
+		}
+	}
+
+	for _, overlap := range findSourceOverlaps(config.Backups) {
+		if overlap.rel != "" && overlap.outer.ExcludeNestedSources {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: source is inside the source of %s and is backed up twice", overlap.inner.Name, overlap.outer.Name))
+	}
+	return errs, warnings
+}
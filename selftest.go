@@ -0,0 +1,321 @@
+// Package main - selftest.go checks the backup pipeline on the user's own machine.
+//
+// A build that works on the developer's machine can still misbehave on
+// another file system, under another antivirus or with another locale. The
+// "selftest" command runs the real pipeline against a temporary source and
+// destination, one stage after another, and reports which stage failed:
+//
+//   create  - a first backup copies every file
+//   skip    - a run over unchanged content is skipped
+//   modify  - changed, added and deleted files show up in the next snapshot
+//   rotate  - the oldest snapshot is deleted beyond rotation_count
+//   verify  - every snapshot matches its manifest
+//   restore - a damaged source is put back exactly as snapshotted
+//
+// Key design decisions:
+//
+// 1. The real code paths: Stages call executeBackup and restoreSnapshot, the
+//    functions scheduled runs and the tray use, not simplified copies.
+//
+// 2. No trace in the user's state: The hash state is swapped for one inside
+//    the temporary folder while the test runs, and the folder is deleted
+//    afterwards (unless kept for inspection). config.json is never read.
+//
+// 3. Stages build on each other: Each stage needs the snapshots of the ones
+//    before, so after a failure the remaining stages are reported as not run
+//    instead of failing for the same reason.
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SelfTestResult is the outcome of one self-test stage.
+type SelfTestResult struct {
+	Stage  string
+	Passed bool
+	Ran    bool   // False if an earlier stage failed
+	Detail string // What was checked, or why the stage failed
+}
+
+// selfTest holds the state shared by the stages of a self-test run.
+type selfTest struct {
+	config BackupConfig
+	logger *log.Logger
+}
+
+// selfTestStages lists the stages in the order they run.
+var selfTestStages = []struct {
+	name string
+	run  func(t *selfTest) (string, error)
+}{
+	{"create", (*selfTest).stageCreate},
+	{"skip", (*selfTest).stageSkip},
+	{"modify", (*selfTest).stageModify},
+	{"rotate", (*selfTest).stageRotate},
+	{"verify", (*selfTest).stageVerify},
+	{"restore", (*selfTest).stageRestore},
+}
+
+// runSelfTest runs all stages inside dir, which must be empty, writing the
+// pipeline's log to logOutput.
+func runSelfTest(dir string, logOutput io.Writer) []SelfTestResult {
+	t := &selfTest{
+		config: BackupConfig{
+			Name:            "selftest",
+			Source:          filepath.Join(dir, "source"),
+			Destination:     filepath.Join(dir, "destination"),
+			ScheduleMinutes: 60,
+			RotationCount:   3,
+			VerifyCopies:    true,
+		},
+		logger: log.New(logOutput, "", log.LstdFlags),
+	}
+
+	saved := hashManager
+	hashManager = &HashManager{hashes: make(map[string]HashStatus), filePath: filepath.Join(dir, "hashes.json")}
+	defer func() { hashManager = saved }()
+
+	var results []SelfTestResult
+	failed := false
+	for _, stage := range selfTestStages {
+		result := SelfTestResult{Stage: stage.name}
+		if !failed {
+			result.Ran = true
+			detail, err := stage.run(t)
+			result.Passed = err == nil
+			result.Detail = detail
+			if err != nil {
+				result.Detail = err.Error()
+				failed = true
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// backup runs the config like a scheduled run and checks the outcome.
+//
+// Snapshot names have one-second resolution, so it first waits for the next
+// second to keep the snapshot from landing in the previous one's folder.
+func (t *selfTest) backup(expected string) error {
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	result, err := executeBackup(t.config, t.logger)
+	if err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+	if result.Outcome != expected {
+		return fmt.Errorf("backup outcome was %q, expected %q", result.Outcome, expected)
+	}
+	return nil
+}
+
+// snapshots lists the test config's snapshots, newest first, and checks their number.
+func (t *selfTest) snapshots(expected int) ([]Snapshot, error) {
+	snapshots, err := listSnapshots(t.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != expected {
+		return nil, fmt.Errorf("found %d snapshots, expected %d", len(snapshots), expected)
+	}
+	return snapshots, nil
+}
+
+// matchesSource checks that a snapshot holds exactly the files of the source.
+func (t *selfTest) matchesSource(snapshot Snapshot) error {
+	diff, err := diffSnapshots(Snapshot{Name: "source", Path: t.config.Source}, snapshot)
+	if err != nil {
+		return err
+	}
+	if len(diff.Changes) > 0 {
+		return fmt.Errorf("snapshot %s differs from the source: %s", snapshot.Name, diff.summary())
+	}
+	return nil
+}
+
+// writeFile writes content to a file below the test source.
+func (t *selfTest) writeFile(relPath string, content []byte) error {
+	path := filepath.Join(t.config.Source, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func (t *selfTest) stageCreate() (string, error) {
+	data := make([]byte, 3<<20) // Larger than one copy buffer
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	files := map[string][]byte{
+		"notes.txt":               []byte("first version\n"),
+		"empty.txt":               nil,
+		"docs/report.txt":         []byte("quarterly report\n"),
+		"docs/archive/data.bin":   data,
+		"docs/unicode-äöü-文件.txt": []byte("non-ASCII name\n"),
+	}
+	for relPath, content := range files {
+		if err := t.writeFile(relPath, content); err != nil {
+			return "", fmt.Errorf("failed to create test source: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(t.config.Source, "empty-folder"), 0755); err != nil {
+		return "", fmt.Errorf("failed to create test source: %v", err)
+	}
+
+	if err := t.backup(ResultBackup); err != nil {
+		return "", err
+	}
+	snapshots, err := t.snapshots(1)
+	if err != nil {
+		return "", err
+	}
+	if err := t.matchesSource(snapshots[0]); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(snapshots[0].Path, "empty-folder")); err != nil {
+		return "", fmt.Errorf("empty folder was not copied")
+	}
+	return fmt.Sprintf("first snapshot holds all %d files", len(files)), nil
+}
+
+func (t *selfTest) stageSkip() (string, error) {
+	if err := t.backup(ResultSkipped); err != nil {
+		return "", err
+	}
+	if _, err := t.snapshots(1); err != nil {
+		return "", err
+	}
+	return "unchanged source was skipped", nil
+}
+
+func (t *selfTest) stageModify() (string, error) {
+	if err := t.writeFile("notes.txt", []byte("second version\n")); err != nil {
+		return "", err
+	}
+	if err := t.writeFile("docs/new.txt", []byte("added later\n")); err != nil {
+		return "", err
+	}
+	if err := os.Remove(filepath.Join(t.config.Source, "empty.txt")); err != nil {
+		return "", err
+	}
+
+	if err := t.backup(ResultBackup); err != nil {
+		return "", err
+	}
+	snapshots, err := t.snapshots(2)
+	if err != nil {
+		return "", err
+	}
+	if err := t.matchesSource(snapshots[0]); err != nil {
+		return "", err
+	}
+	diff, err := diffSnapshots(snapshots[1], snapshots[0])
+	if err != nil {
+		return "", err
+	}
+	if added, removed, changed := diff.counts(); added != 1 || removed != 1 || changed != 1 {
+		return "", fmt.Errorf("snapshots differ by %s, expected 1 added, 1 removed, 1 changed", diff.summary())
+	}
+	return "new snapshot has " + diff.summary(), nil
+}
+
+func (t *selfTest) stageRotate() (string, error) {
+	oldest, err := t.snapshots(2)
+	if err != nil {
+		return "", err
+	}
+	t.config.RotationCount = 2
+	if err := t.writeFile("notes.txt", []byte("third version\n")); err != nil {
+		return "", err
+	}
+
+	if err := t.backup(ResultBackup); err != nil {
+		return "", err
+	}
+	snapshots, err := t.snapshots(2)
+	if err != nil {
+		return "", err
+	}
+	removed := oldest[1]
+	for _, snapshot := range snapshots {
+		if snapshot.Name == removed.Name {
+			return "", fmt.Errorf("oldest snapshot %s was kept", removed.Name)
+		}
+	}
+	if _, err := loadManifest(t.config.Destination, removed.Name); !os.IsNotExist(err) {
+		return "", fmt.Errorf("manifest of the rotated snapshot %s was kept", removed.Name)
+	}
+	return "oldest snapshot deleted with its manifest, 2 kept", nil
+}
+
+func (t *selfTest) stageVerify() (string, error) {
+	snapshots, err := t.snapshots(2)
+	if err != nil {
+		return "", err
+	}
+	checked := 0
+	for _, snapshot := range snapshots {
+		manifest, err := loadManifest(t.config.Destination, snapshot.Name)
+		if err != nil {
+			return "", fmt.Errorf("failed to load manifest of %s: %v", snapshot.Name, err)
+		}
+		sizes, err := snapshotFileSizes(snapshot.Path)
+		if err != nil {
+			return "", err
+		}
+		if len(sizes) != len(manifest.Files) {
+			return "", fmt.Errorf("%s holds %d files, its manifest lists %d", snapshot.Name, len(sizes), len(manifest.Files))
+		}
+		for relPath, expected := range manifest.Files {
+			sha, size, err := hashFile(filepath.Join(snapshot.Path, filepath.FromSlash(relPath)))
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", relPath, err)
+			}
+			if sha != expected.SHA256 || size != expected.Size {
+				return "", fmt.Errorf("%s in %s doesn't match its manifest", relPath, snapshot.Name)
+			}
+			checked++
+		}
+	}
+	return fmt.Sprintf("%d files match their manifests", checked), nil
+}
+
+func (t *selfTest) stageRestore() (string, error) {
+	snapshots, err := t.snapshots(2)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(filepath.Join(t.config.Source, "docs", "report.txt")); err != nil {
+		return "", err
+	}
+	if err := t.writeFile("notes.txt", []byte("damaged\n")); err != nil {
+		return "", err
+	}
+	if err := t.writeFile("stray.tmp", []byte("created after the snapshot\n")); err != nil {
+		return "", err
+	}
+
+	safetyPath, err := restoreSnapshot(t.config, snapshots[0], t.logger)
+	if err != nil {
+		return "", fmt.Errorf("restore failed: %v", err)
+	}
+	if err := t.matchesSource(snapshots[0]); err != nil {
+		return "", fmt.Errorf("after the restore, %v", err)
+	}
+	if safetyPath == "" {
+		return "", fmt.Errorf("no safety snapshot of the damaged source was written")
+	}
+	if _, err := os.Stat(filepath.Join(safetyPath, "stray.tmp")); err != nil {
+		return "", fmt.Errorf("safety snapshot is missing the damaged source's files")
+	}
+	return "source restored exactly, previous contents kept in a safety snapshot", nil
+}
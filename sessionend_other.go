@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// startSessionEndWatcher is a no-op outside Windows.
+//
+// There is no portable equivalent of WM_QUERYENDSESSION; on other platforms
+// shutdown arrives as SIGTERM, which is handled as a normal application exit.
+func startSessionEndWatcher(ctx context.Context) {
+	for _, config := range backupRunner.registeredConfigs() {
+		if config.RunBeforeShutdown {
+			log.Printf("run_before_shutdown is only supported on Windows, ignoring for %s", config.Name)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+//go:build windows
+
+// Package main - sessionend_windows.go runs flagged backups before Windows restarts.
+//
+// Automatic restarts after Windows Update are exactly when work in progress is
+// most at risk. Configurations with run_before_shutdown enabled are backed up
+// on two occasions:
+//
+// 1. Pending restart: Windows Update marks a required reboot in the registry.
+//    The watcher polls for that marker and runs flagged backups once as soon as
+//    it appears, usually hours before the restart actually happens.
+//
+// 2. Session end: A hidden window receives WM_QUERYENDSESSION when the user
+//    logs off or the system shuts down. Flagged backups start on a goroutine
+//    and the message is answered at once, so the window keeps pumping
+//    messages; a shutdown block reason is shown until the backups finish.
+//    If the session really ends, WM_ENDSESSION waits for them, since Windows
+//    ends the process as soon as that message returns.
+//
+// Shutdown is never vetoed: the handler always returns TRUE so the tool can't
+// prevent a restart, it only uses the time Windows grants applications.
+//
+// A restart that is already pending when the application starts is treated
+// like one that just appeared, since the backups of an earlier run can't be
+// told apart from none: flagged backups run once shortly after startup.
+package main
+
+import (
+	"context"
+	"log"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rebootRequiredKey is created by Windows Update when a restart is pending
+const rebootRequiredKey = `SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired`
+
+// pendingRestartPollInterval balances timely detection against registry polling cost
+const pendingRestartPollInterval = 15 * time.Minute
+
+// pendingRestartStartupDelay lets schedulers start before flagged backups
+// run for a restart that was already pending at startup
+const pendingRestartStartupDelay = time.Minute
+
+// wmShutdownBackupsDone (WM_APP + 1) is posted to the session end window when
+// its flagged backups have finished
+const wmShutdownBackupsDone = 0x8000 + 1
+
+var (
+	procShutdownBlockReasonCreate  = user32.NewProc("ShutdownBlockReasonCreate")
+	procShutdownBlockReasonDestroy = user32.NewProc("ShutdownBlockReasonDestroy")
+	procPostMessageW               = user32.NewProc("PostMessageW")
+)
+
+// sessionEndBackups is closed when the flagged backups started for the
+// session end have finished; nil if none were started. Only the window's
+// thread uses it.
+var sessionEndBackups chan struct{}
+
+// startSessionEndWatcher registers for session-end notifications and pending restarts.
+func startSessionEndWatcher(ctx context.Context) {
+	err := startMessageWindow("SimpleFolderBackupSessionEnd", handleSessionEndMessage, nil)
+	if err != nil {
+		log.Printf("Failed to register for session end notifications: %v", err)
+	}
+
+	go watchPendingRestart(ctx)
+}
+
+// handleSessionEndMessage runs flagged backups when the session is ending.
+//
+// The block reason can only be created and destroyed on the window's thread,
+// so the backup goroutine posts wmShutdownBackupsDone back to it.
+func handleSessionEndMessage(hwnd syscall.Handle, msg uint32, wParam, lParam uintptr) (uintptr, bool) {
+	switch msg {
+	case WM_QUERYENDSESSION:
+		if sessionEndBackups != nil {
+			return 1, true // Still running from an earlier, cancelled shutdown
+		}
+		// Tell Windows why shutdown is delayed (shown on the shutdown screen)
+		reason, _ := syscall.UTF16PtrFromString("Backing up before shutdown")
+		procShutdownBlockReasonCreate.Call(uintptr(hwnd), uintptr(unsafe.Pointer(reason)))
+
+		log.Printf("Session ending, running pre-shutdown backups")
+		done := make(chan struct{})
+		sessionEndBackups = done
+		go func() {
+			backupRunner.runShutdownBackups()
+			close(done)
+			procPostMessageW.Call(uintptr(hwnd), wmShutdownBackupsDone, 0, 0)
+		}()
+		return 1, true // Always allow the session to end
+
+	case WM_ENDSESSION:
+		if wParam != 0 && sessionEndBackups != nil {
+			<-sessionEndBackups
+			finishSessionEndBackups(hwnd)
+		}
+		return 0, true
+
+	case wmShutdownBackupsDone:
+		finishSessionEndBackups(hwnd)
+		return 0, true
+	}
+	return 0, false
+}
+
+// finishSessionEndBackups removes the block reason once the session end backups are done.
+func finishSessionEndBackups(hwnd syscall.Handle) {
+	if sessionEndBackups == nil {
+		return
+	}
+	select {
+	case <-sessionEndBackups:
+	default:
+		return // A late message of an earlier run; a new one is still going
+	}
+	sessionEndBackups = nil
+	procShutdownBlockReasonDestroy.Call(uintptr(hwnd))
+	log.Printf("Pre-shutdown backups finished")
+}
+
+// watchPendingRestart runs flagged backups once each time a pending restart
+// appears, and once at startup if a restart is already pending.
+func watchPendingRestart(ctx context.Context) {
+	ticker := time.NewTicker(pendingRestartPollInterval)
+	defer ticker.Stop()
+
+	restartPending := regKeyExists(HKEY_LOCAL_MACHINE, rebootRequiredKey)
+	if restartPending {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pendingRestartStartupDelay):
+		}
+		log.Printf("Windows Update restart already pending at startup, running pre-shutdown backups")
+		backupRunner.runShutdownBackups()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pending := regKeyExists(HKEY_LOCAL_MACHINE, rebootRequiredKey)
+			if pending && !restartPending {
+				log.Printf("Windows Update restart pending, running pre-shutdown backups")
+				backupRunner.runShutdownBackups()
+			}
+			restartPending = pending
+		}
+	}
+}
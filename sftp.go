@@ -0,0 +1,606 @@
+// Package main - sftp.go stores snapshot archives on a remote machine over SSH.
+//
+// A destination such as sftp://backup@nas.example.com/srv/backups keeps its
+// snapshot archives (see remote.go) on any machine that accepts SFTP: a Linux
+// server, a NAS or a hosted storage box, without mounting it as a drive.
+//
+// Key design decisions:
+//
+// 1. The system's OpenSSH client: The connection is made by running
+//    "ssh -s host sftp", which ships with Windows 10 and later and every
+//    Linux distribution. Host keys are checked against the user's
+//    known_hosts and keys, agents and ~/.ssh/config work as they do for ssh
+//    itself, so the application needs no SSH implementation or key store of
+//    its own. BatchMode keeps ssh from ever waiting for a password.
+//
+// 2. SFTP spoken directly: The SFTP protocol (version 3, which every server
+//    supports) is a small request/response format over ssh's stdin and
+//    stdout. Speaking it directly lets archives be streamed without a local
+//    temporary file, and works on accounts restricted to SFTP without a
+//    shell.
+//
+// 3. Atomic uploads: Archives are uploaded under a .partial name and renamed
+//    when complete, so an interrupted upload never looks like a snapshot.
+//
+// 4. Pipelined writes: Up to sftpMaxPending writes are in flight at once, so
+//    a distant server's round-trip time doesn't limit the upload speed.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// sftpScheme prefixes destinations on an SFTP server, e.g. sftp://user@host/backups
+const sftpScheme = "sftp://"
+
+// sftpChunkSize is the data size of one write request; every server accepts 32 KB
+const sftpChunkSize = 32 << 10
+
+// sftpMaxPending is how many write requests may await their reply at once
+const sftpMaxPending = 64
+
+// sftpPartialExt marks archives that are still being uploaded
+const sftpPartialExt = ".partial"
+
+// SFTPSettings configures the SSH connection of an sftp:// destination.
+type SFTPSettings struct {
+	IdentityFile string `json:"identity_file,omitempty"` // Private key to log in with, ""=ssh's defaults and agent
+	SSHCommand   string `json:"ssh_command,omitempty"`   // ""=ssh from PATH
+}
+
+// GetSSHCommand returns the ssh client to run.
+//
+// Returns "ssh" if not specified.
+func (s *SFTPSettings) GetSSHCommand() string {
+	if s == nil || s.SSHCommand == "" {
+		return "ssh"
+	}
+	return s.SSHCommand
+}
+
+// sftpTarget is the parsed form of an sftp:// destination.
+type sftpTarget struct {
+	user string // "" for ssh's default
+	host string
+	port string // "" for ssh's default
+	dir  string // Remote folder; relative paths are relative to the login folder
+}
+
+// parseSFTPDestination parses sftp://[user@]host[:port]/path.
+//
+// As with scp, the path after the host is relative to the login folder;
+// sftp://host//srv/backups names the absolute folder /srv/backups.
+func parseSFTPDestination(destination string) (sftpTarget, error) {
+	u, err := url.Parse(destination)
+	if err != nil || u.Scheme+"://" != sftpScheme || u.Hostname() == "" {
+		return sftpTarget{}, fmt.Errorf("%s is not a valid sftp://[user@]host[:port]/folder destination", destination)
+	}
+	target := sftpTarget{host: u.Hostname(), port: u.Port(), dir: strings.TrimPrefix(u.Path, "/")}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return sftpTarget{}, fmt.Errorf("%s contains a password; sftp destinations log in with keys only", u.Redacted())
+		}
+		target.user = u.User.Username()
+	}
+	if target.dir == "" {
+		target.dir = "."
+	}
+	return target, nil
+}
+
+// SFTP packet types and open flags used here (draft-ietf-secsh-filexfer-02)
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpOpen    = 3
+	sftpClose   = 4
+	sftpWrite   = 6
+	sftpOpendir = 11
+	sftpReaddir = 12
+	sftpRemove  = 13
+	sftpMkdir   = 14
+	sftpStat    = 17
+	sftpRename  = 18
+	sftpStatus  = 101
+	sftpHandle  = 102
+	sftpName    = 104
+	sftpAttrs   = 105
+
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpStatusOK   = 0
+	sftpStatusEOF  = 1
+	sftpNoSuchFile = 2
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrACModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+)
+
+// sftpStatusError is an error status returned by the server.
+type sftpStatusError struct {
+	code    uint32
+	message string
+}
+
+func (e *sftpStatusError) Error() string {
+	if e.message != "" {
+		return e.message
+	}
+	return fmt.Sprintf("sftp error %d", e.code)
+}
+
+// sftpStore is a backup destination on an SFTP server.
+type sftpStore struct {
+	target sftpTarget
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+	nextID uint32
+}
+
+// newSFTPStore connects to the server of a config's sftp:// destination.
+func newSFTPStore(config BackupConfig) (*sftpStore, error) {
+	target, err := parseSFTPDestination(config.Destination)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-o", "BatchMode=yes", "-o", "ServerAliveInterval=30"}
+	if config.SFTP != nil && config.SFTP.IdentityFile != "" {
+		args = append(args, "-i", config.SFTP.IdentityFile)
+	}
+	if target.port != "" {
+		args = append(args, "-p", target.port)
+	}
+	if target.user != "" {
+		args = append(args, "-l", target.user)
+	}
+	args = append(args, "-s", target.host, "sftp")
+
+	store := &sftpStore{target: target}
+	store.cmd = exec.Command(config.SFTP.GetSSHCommand(), args...)
+	hideHookWindow(store.cmd)
+	store.cmd.Stderr = &store.stderr
+	if store.stdin, err = store.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := store.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	store.stdout = bufio.NewReader(stdout)
+	if err := store.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run ssh: %v", err)
+	}
+
+	if err := store.handshake(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// handshake agrees on protocol version 3 with the server.
+func (s *sftpStore) handshake() error {
+	var payload sftpBuffer
+	payload.uint32(3)
+	if err := s.send(sftpInit, payload.Bytes()); err != nil {
+		return s.connectionError(err)
+	}
+	kind, _, err := s.receive()
+	if err != nil {
+		return s.connectionError(err)
+	}
+	if kind != sftpVersion {
+		s.close()
+		return fmt.Errorf("unexpected sftp handshake reply %d", kind)
+	}
+	return nil
+}
+
+// connectionError explains a failed connection with what ssh printed, which
+// says why (unknown host key, refused key, unreachable host).
+func (s *sftpStore) connectionError(err error) error {
+	s.stdin.Close()
+	s.cmd.Wait()
+	if message := strings.TrimSpace(s.stderr.String()); message != "" {
+		return fmt.Errorf("ssh connection to %s failed: %s", s.target.host, message)
+	}
+	return fmt.Errorf("ssh connection to %s failed: %v", s.target.host, err)
+}
+
+// close ends the SFTP session and the ssh process.
+func (s *sftpStore) close() error {
+	s.stdin.Close()
+	done := make(chan error, 1)
+	go func() { done <- s.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		s.cmd.Process.Kill()
+		<-done
+	}
+	return nil
+}
+
+// describe returns where the store keeps its objects, for log messages.
+func (s *sftpStore) describe() string {
+	host := s.target.host
+	if s.target.user != "" {
+		host = s.target.user + "@" + host
+	}
+	return sftpScheme + host + "/" + s.target.dir
+}
+
+// upload stores the content of body as the file name in the destination folder.
+func (s *sftpStore) upload(name string, body io.Reader) error {
+	if err := s.mkdirAll(s.target.dir); err != nil {
+		return fmt.Errorf("failed to create %s: %v", s.target.dir, err)
+	}
+	final := path.Join(s.target.dir, name)
+	partial := final + sftpPartialExt
+
+	handle, err := s.openFile(partial)
+	if err != nil {
+		return err
+	}
+	if err := s.writeAll(handle, body); err != nil {
+		s.closeHandle(handle)
+		s.removeFile(partial)
+		return err
+	}
+	if err := s.closeHandle(handle); err != nil {
+		s.removeFile(partial)
+		return err
+	}
+	if err := s.rename(partial, final); err != nil {
+		s.removeFile(partial)
+		return fmt.Errorf("failed to rename %s: %v", partial, err)
+	}
+	return nil
+}
+
+// writeAll writes body to an open file, keeping up to sftpMaxPending
+// writes in flight.
+func (s *sftpStore) writeAll(handle string, body io.Reader) error {
+	chunk := make([]byte, sftpChunkSize)
+	var offset uint64
+	pending := 0
+	for {
+		n, readErr := io.ReadFull(body, chunk)
+		if n > 0 {
+			if pending == sftpMaxPending {
+				if err := s.expectStatus(); err != nil {
+					return err
+				}
+				pending--
+			}
+			var payload sftpBuffer
+			payload.uint32(s.newID())
+			payload.string(handle)
+			payload.uint64(offset)
+			payload.string(string(chunk[:n]))
+			if err := s.send(sftpWrite, payload.Bytes()); err != nil {
+				return err
+			}
+			pending++
+			offset += uint64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		} else if readErr != nil {
+			// Collect the replies so the session stays usable for the cleanup
+			for ; pending > 0; pending-- {
+				s.expectStatus()
+			}
+			return readErr
+		}
+	}
+	for ; pending > 0; pending-- {
+		if err := s.expectStatus(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns the files of the destination folder. Archives still being
+// uploaded are left out.
+func (s *sftpStore) list() ([]remoteObject, error) {
+	handle, err := s.handleRequest(sftpOpendir, func(b *sftpBuffer) { b.string(s.target.dir) })
+	var status *sftpStatusError
+	if errors.As(err, &status) && status.code == sftpNoSuchFile {
+		return nil, nil // The folder is created by the first upload
+	} else if err != nil {
+		return nil, err
+	}
+	defer s.closeHandle(handle)
+
+	var objects []remoteObject
+	for {
+		var payload sftpBuffer
+		payload.uint32(s.newID())
+		payload.string(handle)
+		if err := s.send(sftpReaddir, payload.Bytes()); err != nil {
+			return nil, err
+		}
+		kind, reply, err := s.receive()
+		if err != nil {
+			return nil, err
+		}
+		if kind == sftpStatus {
+			if err := statusError(reply); err != nil {
+				return nil, err
+			}
+			return objects, nil // EOF: the listing is complete
+		}
+		if kind != sftpName {
+			return nil, fmt.Errorf("unexpected sftp reply %d", kind)
+		}
+		r := sftpReader{data: reply}
+		r.uint32() // Request id
+		for count := r.uint32(); count > 0 && r.err == nil; count-- {
+			name := r.string()
+			r.string() // ls -l style line
+			attrs := r.attrs()
+			if name == "." || name == ".." || strings.HasSuffix(name, sftpPartialExt) || attrs.isDir {
+				continue
+			}
+			objects = append(objects, remoteObject{Name: name, Size: attrs.size, Modified: attrs.modified})
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+}
+
+// remove deletes the file name from the destination folder.
+func (s *sftpStore) remove(name string) error {
+	return s.removeFile(path.Join(s.target.dir, name))
+}
+
+// mkdirAll creates dir and its missing parents.
+func (s *sftpStore) mkdirAll(dir string) error {
+	if dir == "." || dir == "/" || dir == "" {
+		return nil
+	}
+	if _, err := s.handleRequest(sftpStat, func(b *sftpBuffer) { b.string(dir) }); err == nil {
+		return nil
+	}
+	if err := s.mkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	return s.statusRequest(sftpMkdir, func(b *sftpBuffer) {
+		b.string(dir)
+		b.uint32(0) // No attributes
+	})
+}
+
+// openFile creates or truncates a file for writing and returns its handle.
+func (s *sftpStore) openFile(name string) (string, error) {
+	return s.handleRequest(sftpOpen, func(b *sftpBuffer) {
+		b.string(name)
+		b.uint32(sftpFlagWrite | sftpFlagCreat | sftpFlagTrunc)
+		b.uint32(0) // No attributes
+	})
+}
+
+func (s *sftpStore) closeHandle(handle string) error {
+	return s.statusRequest(sftpClose, func(b *sftpBuffer) { b.string(handle) })
+}
+
+func (s *sftpStore) removeFile(name string) error {
+	return s.statusRequest(sftpRemove, func(b *sftpBuffer) { b.string(name) })
+}
+
+func (s *sftpStore) rename(from, to string) error {
+	return s.statusRequest(sftpRename, func(b *sftpBuffer) {
+		b.string(from)
+		b.string(to)
+	})
+}
+
+// statusRequest sends a request answered by a status and returns its error.
+func (s *sftpStore) statusRequest(kind byte, fill func(*sftpBuffer)) error {
+	var payload sftpBuffer
+	payload.uint32(s.newID())
+	fill(&payload)
+	if err := s.send(kind, payload.Bytes()); err != nil {
+		return err
+	}
+	return s.expectStatus()
+}
+
+// handleRequest sends a request answered by a handle (or attributes, for
+// stat) and returns the handle.
+func (s *sftpStore) handleRequest(kind byte, fill func(*sftpBuffer)) (string, error) {
+	var payload sftpBuffer
+	payload.uint32(s.newID())
+	fill(&payload)
+	if err := s.send(kind, payload.Bytes()); err != nil {
+		return "", err
+	}
+	reply, data, err := s.receive()
+	if err != nil {
+		return "", err
+	}
+	switch reply {
+	case sftpHandle:
+		r := sftpReader{data: data}
+		r.uint32()
+		handle := r.string()
+		return handle, r.err
+	case sftpAttrs:
+		return "", nil
+	case sftpStatus:
+		if err := statusError(data); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("unexpected sftp reply %d", reply)
+}
+
+// expectStatus reads the next reply, which must be a status, and returns its error.
+func (s *sftpStore) expectStatus() error {
+	kind, data, err := s.receive()
+	if err != nil {
+		return err
+	}
+	if kind != sftpStatus {
+		return fmt.Errorf("unexpected sftp reply %d", kind)
+	}
+	return statusError(data)
+}
+
+// statusError decodes a status reply; OK and EOF are not errors.
+func statusError(data []byte) error {
+	r := sftpReader{data: data}
+	r.uint32() // Request id
+	code := r.uint32()
+	message := r.string()
+	if r.err != nil {
+		return r.err
+	}
+	if code == sftpStatusOK || code == sftpStatusEOF {
+		return nil
+	}
+	return &sftpStatusError{code: code, message: message}
+}
+
+func (s *sftpStore) newID() uint32 {
+	s.nextID++
+	return s.nextID
+}
+
+// send writes one packet.
+//
+// Requests are answered in order, so replies are matched to requests by
+// position rather than by id.
+func (s *sftpStore) send(kind byte, payload []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)+1))
+	header[4] = kind
+	if _, err := s.stdin.Write(append(header, payload...)); err != nil {
+		return fmt.Errorf("sftp connection lost: %v", err)
+	}
+	return nil
+}
+
+// receive reads one packet and returns its type and payload.
+func (s *sftpStore) receive() (byte, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(s.stdout, length[:]); err != nil {
+		return 0, nil, fmt.Errorf("sftp connection lost: %v", err)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size == 0 || size > 1<<24 {
+		return 0, nil, fmt.Errorf("invalid sftp packet of %d bytes", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(s.stdout, packet); err != nil {
+		return 0, nil, fmt.Errorf("sftp connection lost: %v", err)
+	}
+	return packet[0], packet[1:], nil
+}
+
+// sftpBuffer encodes the fields of a request.
+type sftpBuffer struct {
+	bytes.Buffer
+}
+
+func (b *sftpBuffer) uint32(v uint32) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *sftpBuffer) uint64(v uint64) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *sftpBuffer) string(s string) {
+	b.uint32(uint32(len(s)))
+	b.WriteString(s)
+}
+
+// sftpReader decodes the fields of a reply; the first decoding error is kept in err.
+type sftpReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sftpReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = errors.New("truncated sftp reply")
+		return nil
+	}
+	field := r.data[:n]
+	r.data = r.data[n:]
+	return field
+}
+
+func (r *sftpReader) uint32() uint32 {
+	if field := r.take(4); field != nil {
+		return binary.BigEndian.Uint32(field)
+	}
+	return 0
+}
+
+func (r *sftpReader) uint64() uint64 {
+	if field := r.take(8); field != nil {
+		return binary.BigEndian.Uint64(field)
+	}
+	return 0
+}
+
+func (r *sftpReader) string() string {
+	return string(r.take(int(r.uint32())))
+}
+
+// sftpFileAttrs are the attributes of a listed file used here.
+type sftpFileAttrs struct {
+	size     int64
+	modified time.Time
+	isDir    bool
+}
+
+func (r *sftpReader) attrs() sftpFileAttrs {
+	var attrs sftpFileAttrs
+	flags := r.uint32()
+	if flags&sftpAttrSize != 0 {
+		attrs.size = int64(r.uint64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		attrs.isDir = r.uint32()&0170000 == 0040000
+	}
+	if flags&sftpAttrACModTime != 0 {
+		r.uint32() // Access time
+		attrs.modified = time.Unix(int64(r.uint32()), 0)
+	}
+	if flags&sftpAttrExtended != 0 {
+		for count := r.uint32(); count > 0 && r.err == nil; count-- {
+			r.string()
+			r.string()
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// openInFileManager opens a file or folder in the desktop file manager.
+//
+// Files are revealed by opening their containing folder, mirroring the
+// Windows "select in Explorer" behavior as closely as portable tools allow.
+func openInFileManager(path string) error {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		path = filepath.Dir(path)
+	}
+
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	return exec.Command(opener, path).Start()
+}
+
+// openDocument opens a file in the application registered for its type,
+// e.g. a page in the default browser.
+func openDocument(path string) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	return exec.Command(opener, path).Start()
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// openInFileManager opens a file or folder in Windows Explorer.
+//
+// Files are selected inside their parent folder rather than opened, so a
+// diagnostics zip or log file is shown to the user instead of launched.
+func openInFileManager(path string) error {
+	cmd := exec.Command("explorer", path)
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		cmd = exec.Command("explorer", "/select,", path)
+	}
+	// Explorer returns exit code 1 even on success, so only start errors matter
+	return cmd.Start()
+}
+
+// openDocument opens a file in the application registered for its type,
+// e.g. a page in the default browser.
+func openDocument(path string) error {
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", path).Start()
+}
@@ -0,0 +1,87 @@
+// Package main - shutdown.go lets running backups finish when the application exits.
+//
+// Exiting used to end the process at once, abandoning a copy that might have
+// been minutes from completing. Now Exit (or SIGTERM) first stops the
+// schedulers and refuses new runs, then waits up to shutdown_grace_minutes
+// for backups and restores in progress, showing "Finishing 1 backup..." in
+// the tray. Clicking Exit again, or a second signal, exits immediately.
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// waitForOperations blocks until no backup or restore is running, the grace
+// period ends, or force receives.
+//
+// backupRunner.beginShutdown must have been called, so the count can only go down.
+// mQuit is nil in headless mode, where progress is only logged.
+func waitForOperations(grace time.Duration, mQuit *systray.MenuItem, force <-chan struct{}) {
+	operations := backupRunner.activeOperations()
+	if len(operations) == 0 || grace <= 0 {
+		return
+	}
+	log.Printf("Waiting up to %v for %s to finish before exiting", grace, describeOperations(operations))
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// A nil channel never receives, so without a tray only force skips the wait
+	var quitClicked <-chan struct{}
+	if mQuit != nil {
+		quitClicked = mQuit.ClickedCh
+	}
+
+	for {
+		if mQuit != nil {
+			label := "Finishing " + describeOperations(operations) + "..."
+			systray.SetTooltip("SimpleFolderBackup - " + label)
+			mQuit.SetTitle(label + " (click to exit now)")
+		}
+
+		select {
+		case <-deadline.C:
+			log.Printf("Exiting after the %v grace period with %s still running", grace, describeOperations(operations))
+			return
+		case <-force:
+			log.Printf("Exiting without waiting for %s", describeOperations(operations))
+			return
+		case <-quitClicked:
+			log.Printf("Exiting without waiting for %s", describeOperations(operations))
+			return
+		case <-ticker.C:
+			operations = backupRunner.activeOperations()
+			if len(operations) == 0 {
+				log.Printf("Running operations finished, exiting")
+				return
+			}
+		}
+	}
+}
+
+// describeOperations renders operations as e.g. "2 backups and 1 restore".
+func describeOperations(operations []string) string {
+	counts := make(map[string]int)
+	for _, operation := range operations {
+		counts[operation]++
+	}
+
+	var parts []string
+	for _, operation := range []string{OperationBackup, OperationRestore} {
+		switch count := counts[operation]; count {
+		case 0:
+		case 1:
+			parts = append(parts, "1 "+operation)
+		default:
+			parts = append(parts, fmt.Sprintf("%d %ss", count, operation))
+		}
+	}
+	return strings.Join(parts, " and ")
+}
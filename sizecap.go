@@ -0,0 +1,73 @@
+// Package main - sizecap.go caps the space all snapshots of a config use together.
+//
+// rotation_count keeps a number of snapshots, but snapshot sizes vary
+// wildly: a week of small changes and one snapshot after a game install
+// count the same. With max_total_size_gb, rotation also deletes the oldest
+// snapshots while all snapshots of the config together use more than that,
+// so the disk use of a config stays predictable.
+//
+// Key design decisions:
+//
+// 1. Space used, not file sizes: Incremental snapshots hardlink unchanged
+//    files, so each snapshot is measured by what it uses beyond the newer
+//    snapshots, walking from the newest. That is what deleting it, oldest
+//    first, frees; a linked 10 GB file counts once, not in every snapshot.
+//
+// 2. The cap wins: Snapshots are deleted beyond rotation_count and retention
+//    rules until the total fits, except the newest, which is always kept so a
+//    single snapshot larger than the cap is still a backup.
+//
+// 3. Archives by object size: Archives (local, S3 or SFTP) are independent
+//    files, so their listed size is their usage.
+package main
+
+import (
+	"path/filepath"
+)
+
+// measureSnapshotUsage fills in the size of each of the config's snapshot
+// folders (ordered oldest first) as the space it uses beyond newer snapshots.
+func measureSnapshotUsage(config BackupConfig, snapshots []retainedSnapshot) error {
+	seen := make(map[fileID]bool)
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		_, used, err := directoryUsage(filepath.Join(config.Destination, snapshots[i].name), seen)
+		if err != nil {
+			return err
+		}
+		snapshots[i].size = used
+	}
+	return nil
+}
+
+// sizeCapDeletions returns the snapshots max_total_size_gb deletes, oldest
+// first, on top of those rotation already deletes. snapshots are ordered
+// oldest first with their sizes measured; the newest is never deleted.
+func sizeCapDeletions(config BackupConfig, snapshots []retainedSnapshot, deleted []string) []string {
+	limit := config.GetMaxTotalSize()
+	if limit == 0 || len(snapshots) == 0 {
+		return nil
+	}
+	alreadyDeleted := make(map[string]bool)
+	for _, name := range deleted {
+		alreadyDeleted[name] = true
+	}
+	var total int64
+	for _, snapshot := range snapshots {
+		if !alreadyDeleted[snapshot.name] {
+			total += snapshot.size
+		}
+	}
+
+	var capped []string
+	for _, snapshot := range snapshots[:len(snapshots)-1] {
+		if total <= limit {
+			break
+		}
+		if alreadyDeleted[snapshot.name] {
+			continue
+		}
+		capped = append(capped, snapshot.name)
+		total -= snapshot.size
+	}
+	return capped
+}
@@ -0,0 +1,284 @@
+// Package main - snapshotbrowser.go lets the dashboard browse and restore snapshot contents.
+//
+// Getting one file back used to mean finding the right timestamped folder in
+// Explorer and copying the file out by hand, or writing a restore-files
+// pattern. The dashboard's snapshot browser lists the snapshots of a config,
+// walks into any of them folder by folder, and restores a single file or a
+// whole folder into the source with one button, or downloads a file.
+//
+// Restores from the browser are selective restores (see restorefiles.go):
+// only the chosen files are written, replaced files are kept in
+// .pre-restore-files, and each file is checked against the manifest. Like
+// every restore they are refused in read-only mode and recorded in the audit
+// log. Snapshots stored as archives can't be browsed.
+//
+// Browsing hands out backed-up files to whoever asks, so like every
+// dashboard page it is only served to a browser logged in with the current
+// user's key (see dashboard.go). Listings and downloads are also marked not
+// to be cached, so file contents and the action token don't stay behind in
+// the browser's cache.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// browserEntry is one file or folder of a snapshot folder, as the browser lists it.
+type browserEntry struct {
+	Name     string
+	Path     string // Slash-separated path in the snapshot
+	Folder   bool
+	Files    int   // Files in the folder and below; 1 for a file
+	Size     int64 // Their total size
+	Modified time.Time
+}
+
+// browserCrumb is one parent folder in the path above the listing.
+type browserCrumb struct {
+	Name string
+	Path string
+}
+
+// serveBrowse lists the snapshots of a config, or the contents of a folder
+// of one of them.
+func (d *dashboard) serveBrowse(w http.ResponseWriter, r *http.Request) {
+	config, ok := registeredConfig(r.FormValue("config"))
+	if !ok {
+		http.Error(w, "no running backup config with that name", http.StatusNotFound)
+		return
+	}
+	data := map[string]any{
+		"Config":   config.Name,
+		"Token":    d.token,
+		"ReadOnly": currentSettings().ReadOnly,
+		"Message":  r.FormValue("message"),
+		"Failed":   r.FormValue("failed") != "",
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	data["Snapshots"] = snapshots
+
+	if name := r.FormValue("snapshot"); name != "" && err == nil {
+		snapshot, err := findSnapshot(snapshots, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		folder := strings.Trim(r.FormValue("path"), "/")
+		files, err := snapshotFiles(config, snapshot)
+		if err != nil {
+			data["Error"] = fmt.Sprintf("failed to list snapshot %s: %v", snapshot.Name, err)
+		}
+		data["Snapshot"] = snapshot
+		data["Path"] = folder
+		data["Crumbs"] = browserCrumbs(folder)
+		data["Entries"] = browserEntries(files, folder)
+	}
+
+	var page bytes.Buffer
+	if err := browseTemplate.Execute(&page, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(page.Bytes())
+}
+
+// serveFile downloads a file of a snapshot.
+func (d *dashboard) serveFile(w http.ResponseWriter, r *http.Request) {
+	config, snapshot, rel, ok := browsedPath(w, r)
+	if !ok {
+		return
+	}
+	file, err := os.Open(filepath.Join(snapshot.Path, filepath.FromSlash(rel)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.Error(w, "not a file", http.StatusNotFound)
+		return
+	}
+	auditLog.record(AuditInterfaceDashboard, "download", config.Name, fmt.Sprintf("%s: %s", snapshot.Name, rel))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(path.Base(rel))))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, path.Base(rel), info.ModTime(), file)
+}
+
+// serveRestore restores a file or folder of a snapshot into the config's
+// source and returns to the folder it was chosen in.
+func (d *dashboard) serveRestore(w http.ResponseWriter, r *http.Request) {
+	if !d.validToken(w, r) {
+		return
+	}
+	config, snapshot, rel, ok := browsedPath(w, r)
+	if !ok {
+		return
+	}
+	back := url.Values{"config": {config.Name}, "snapshot": {snapshot.Name}, "path": {r.FormValue("from")}}
+	if err := ensureWritable(AuditInterfaceDashboard, "restore-files", config.Name); err != nil {
+		back.Set("message", err.Error())
+		back.Set("failed", "1")
+		http.Redirect(w, r, "/browse?"+back.Encode(), http.StatusSeeOther)
+		return
+	}
+
+	auditLog.record(AuditInterfaceDashboard, "restore-files", config.Name, fmt.Sprintf("%s: %s", snapshot.Name, cmp.Or(rel, "/")))
+	result, err := restorePaths(config, snapshot, []string{rel}, "")
+	if err != nil {
+		back.Set("message", "Restore failed: "+err.Error())
+		back.Set("failed", "1")
+	} else {
+		message := fmt.Sprintf("Restored %d file(s) (%s) into %s", result.Files, formatSize(result.Size), config.Source)
+		if result.SafetyPath != "" {
+			message += "; the files it replaced were saved to " + result.SafetyPath
+		}
+		back.Set("message", message)
+	}
+	http.Redirect(w, r, "/browse?"+back.Encode(), http.StatusSeeOther)
+}
+
+// browsedPath resolves the config, snapshot and path of a browser request,
+// answering it with an error if any of them doesn't exist.
+func browsedPath(w http.ResponseWriter, r *http.Request) (BackupConfig, Snapshot, string, bool) {
+	config, ok := registeredConfig(r.FormValue("config"))
+	if !ok {
+		http.Error(w, "no running backup config with that name", http.StatusNotFound)
+		return config, Snapshot{}, "", false
+	}
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return config, Snapshot{}, "", false
+	}
+	snapshot, err := findSnapshot(snapshots, r.FormValue("snapshot"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return config, snapshot, "", false
+	}
+	rel := strings.Trim(r.FormValue("path"), "/")
+	if rel != "" && !filepath.IsLocal(filepath.FromSlash(rel)) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return config, snapshot, "", false
+	}
+	return config, snapshot, rel, true
+}
+
+// registeredConfig returns the running config with the given name.
+func registeredConfig(name string) (BackupConfig, bool) {
+	for _, config := range backupRunner.registeredConfigs() {
+		if config.Name == name {
+			return config, true
+		}
+	}
+	return BackupConfig{}, false
+}
+
+// browserEntries returns the folders and files directly inside folder,
+// folders first, each sorted by name.
+func browserEntries(files map[string]ManifestFile, folder string) []browserEntry {
+	prefix := ""
+	if folder != "" {
+		prefix = folder + "/"
+	}
+	byName := make(map[string]*browserEntry)
+	for rel, file := range files {
+		rest, inside := strings.CutPrefix(rel, prefix)
+		if !inside {
+			continue
+		}
+		name, _, isFolder := strings.Cut(rest, "/")
+		entry := byName[name]
+		if entry == nil {
+			entry = &browserEntry{Name: name, Path: prefix + name, Folder: isFolder}
+			byName[name] = entry
+		}
+		entry.Files++
+		entry.Size += file.Size
+		if !isFolder && file.ModTime != 0 {
+			entry.Modified = time.Unix(0, file.ModTime)
+		}
+	}
+
+	entries := make([]browserEntry, 0, len(byName))
+	for _, entry := range byName {
+		entries = append(entries, *entry)
+	}
+	slices.SortFunc(entries, func(a, b browserEntry) int {
+		if a.Folder != b.Folder {
+			if a.Folder {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return entries
+}
+
+// browserCrumbs returns the folders leading to folder, outermost first.
+func browserCrumbs(folder string) []browserCrumb {
+	if folder == "" {
+		return nil
+	}
+	var crumbs []browserCrumb
+	names := strings.Split(folder, "/")
+	for i, name := range names {
+		crumbs = append(crumbs, browserCrumb{Name: name, Path: strings.Join(names[:i+1], "/")})
+	}
+	return crumbs
+}
+
+// browseTemplate is the snapshot browser page, sharing the dashboard's style.
+var browseTemplate = template.Must(dashboardTemplate.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Config}} - SimpleFolderBackup</title>
+{{template "style"}}
+</head>
+<body>
+<p><a href="/">&larr; Dashboard</a></p>
+<h1>{{.Config}}</h1>
+{{with .Message}}<p class="{{if $.Failed}}alert{{else}}done{{end}}">{{.}}</p>{{end}}
+{{with .Error}}<p class="alert">{{.}}</p>{{end}}
+{{$config := .Config}}{{$token := .Token}}
+{{if .Snapshot}}{{$snapshot := .Snapshot.Name}}{{$path := .Path}}
+<h2>{{time .Snapshot.Time}}{{with .Snapshot.Note}} - {{.}}{{end}}</h2>
+<p><a href="/browse?config={{$config}}">All snapshots</a> /
+<a href="/browse?config={{$config}}&amp;snapshot={{$snapshot}}">{{$snapshot}}</a>{{range .Crumbs}} / <a href="/browse?config={{$config}}&amp;snapshot={{$snapshot}}&amp;path={{.Path}}">{{.Name}}</a>{{end}}</p>
+<table>
+{{range .Entries}}<tr>
+<td>{{if .Folder}}&#128193; <a href="/browse?config={{$config}}&amp;snapshot={{$snapshot}}&amp;path={{.Path}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+<td>{{if .Folder}}{{.Files}} file(s), {{end}}{{size .Size}}</td>
+<td>{{if not .Modified.IsZero}}{{time .Modified}}{{end}}</td>
+<td>{{if not .Folder}}<a href="/file?config={{$config}}&amp;snapshot={{$snapshot}}&amp;path={{.Path}}">Download</a>{{end}}</td>
+<td>{{if not $.ReadOnly}}<form method="post" action="/restore" onsubmit="return confirm('Restore {{.Name}} into the source folder? Files it replaces are kept in .pre-restore-files.')"><input type="hidden" name="token" value="{{$token}}"><input type="hidden" name="config" value="{{$config}}"><input type="hidden" name="snapshot" value="{{$snapshot}}"><input type="hidden" name="path" value="{{.Path}}"><input type="hidden" name="from" value="{{$path}}"><button>Restore</button></form>{{end}}</td>
+</tr>
+{{else}}<tr><td>This folder is empty.</td></tr>
+{{end}}</table>
+{{else}}
+<table>
+{{range .Snapshots}}<tr><td><a href="/browse?config={{$config}}&amp;snapshot={{.Name}}">{{time .Time}}</a></td><td>{{.Note}}</td></tr>
+{{else}}<tr><td>No snapshots yet.</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
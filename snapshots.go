@@ -0,0 +1,79 @@
+// Package main - snapshots.go provides a single view of the snapshots of a configuration.
+//
+// Several features need "the snapshots of this config, newest first": the tray
+// snapshot browser today, and restore, comparison and export operations. This
+// module centralizes that listing so every consumer identifies snapshots with
+// the same rules as rotation (naming.go) and orders them consistently.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Snapshot describes one backup directory in a configuration's destination.
+type Snapshot struct {
+	Name string    // Directory name, e.g. "02-01-2006_15-04-05_data"
+	Path string    // Absolute path of the snapshot directory
+	Time time.Time // When the snapshot was taken
+	Note string    // User note attached to the snapshot, "" if none
+}
+
+// listSnapshots returns the snapshots of a configuration, newest first.
+//
+// The snapshot time is parsed from the directory name, which records when the
+// backup started. Directories whose name can't be parsed (e.g. renamed by
+// hand) fall back to their modification time so they still sort sensibly.
+func listSnapshots(config BackupConfig) ([]Snapshot, error) {
+	if isRemoteDestination(config.Destination) {
+		return nil, errRemoteSnapshots
+	}
+	if config.IsEncrypted() {
+		return nil, errEncryptedSnapshots
+	}
+	return listSnapshotsIn(config.Destination, config.Source)
+}
+
+// listSafetySnapshots returns the pre-restore safety snapshots of a configuration, newest first.
+func listSafetySnapshots(config BackupConfig) ([]Snapshot, error) {
+	return listSnapshotsIn(filepath.Join(config.Destination, safetySnapshotDir), config.Source)
+}
+
+// listSnapshotsIn lists the snapshot directories of source inside dir.
+func listSnapshotsIn(dir, source string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceFolderName := getSourceFolderName(source)
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() || !isBackupDirectory(entry.Name(), sourceFolderName) {
+			continue
+		}
+
+		snapshotTime, err := parseBackupTimestamp(entry.Name(), sourceFolderName)
+		if err != nil || snapshotTime.IsZero() {
+			info, err := entry.Info()
+			if err != nil {
+				continue // Skip entries we can't stat
+			}
+			snapshotTime = info.ModTime()
+		}
+
+		snapshots = append(snapshots, Snapshot{
+			Name: entry.Name(),
+			Path: filepath.Join(dir, entry.Name()),
+			Time: snapshotTime,
+			Note: loadSnapshotNote(dir, entry.Name()),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
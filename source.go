@@ -0,0 +1,164 @@
+// Package main - source.go implements the policy for missing or unmounted sources.
+//
+// Sources on removable drives, network shares or cloud-synced folders regularly
+// disappear for a while. Treating that as an ordinary backup failure produced an
+// error in the log every cycle with no escalation and no visible indication.
+//
+// Each configuration chooses how to react through missing_source:
+//
+// - "fail" (default): Each cycle fails; the first miss raises a notification
+//    and a tray alert that stays until the source returns.
+// - "wait": Cycles are skipped quietly while the source is absent; the tray
+//    shows that the config is waiting, but no notification is raised.
+// - "disable": Like "fail", but after missing_source_limit consecutive misses
+//    the scheduler for this config stops until the application is restarted.
+//
+// Under every policy, a source that was renamed or moved is reported as such
+// (see sourcemove.go) rather than as missing.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Missing source policies accepted in the missing_source config field
+const (
+	MissingSourceFail    = "fail"
+	MissingSourceWait    = "wait"
+	MissingSourceDisable = "disable"
+)
+
+// errSourceMissingDisabled tells the scheduler to stop after too many misses
+var errSourceMissingDisabled = errors.New("source missing too many times, backup disabled")
+
+// sourceMisses counts consecutive cycles each config's source has been missing
+var (
+	sourceMissesMu sync.Mutex
+	sourceMisses   = make(map[string]int)
+)
+
+// checkSourceAvailable applies the config's missing source policy before a backup.
+//
+// Returns nil when the source exists. Otherwise returns an error for the
+// "fail"/"disable" policies (errSourceMissingDisabled once the limit is hit),
+// or errSourceWaiting for the "wait" policy so the caller can skip quietly.
+func checkSourceAvailable(config BackupConfig, logger *log.Logger) error {
+	info, err := os.Stat(config.Source)
+	if err == nil && info.IsDir() {
+		sourceMissesMu.Lock()
+		misses := sourceMisses[config.Name]
+		delete(sourceMisses, config.Name)
+		sourceMissesMu.Unlock()
+
+		if misses > 0 {
+			logger.Printf("Source for %s is available again after %d missed cycle(s)", config.Name, misses)
+			backupStatus.clearAlert(config.Name)
+			forgetMovedSource(config.Name)
+		}
+		return nil
+	}
+
+	sourceMissesMu.Lock()
+	sourceMisses[config.Name]++
+	misses := sourceMisses[config.Name]
+	sourceMissesMu.Unlock()
+
+	// A renamed or moved source won't come back on its own; report the move instead
+	if misses == 1 {
+		detectMovedSource(config, logger)
+	}
+	_, moved := movedSourceFor(config.Name)
+	if moved && config.GetMissingSourcePolicy() == MissingSourceWait {
+		return errSourceWaiting
+	}
+	if moved && config.GetMissingSourcePolicy() == MissingSourceFail {
+		return fmt.Errorf("%w: %s", errSourceMissing, config.Source)
+	}
+
+	switch config.GetMissingSourcePolicy() {
+	case MissingSourceWait:
+		if misses == 1 {
+			logger.Printf("Source for %s is missing (%s), waiting for it to reappear", config.Name, config.Source)
+		}
+		backupStatus.setAlert(config.Name, "waiting for source")
+		return errSourceWaiting
+
+	case MissingSourceDisable:
+		limit := config.GetMissingSourceLimit()
+		if misses >= limit {
+			backupStatus.setAlert(config.Name, "disabled (source missing)")
+			backupStatus.markDisabled(config.Name)
+			notifyEvent(config, EventFailure, "Backup disabled: "+config.Name,
+				fmt.Sprintf("The source folder has been missing for %d cycles. Restart SimpleFolderBackup after reconnecting it.", misses))
+			auditLog.record(AuditInterfaceSystem, "disable", config.Name, fmt.Sprintf("source missing for %d cycles", misses))
+			return errSourceMissingDisabled
+		}
+		backupStatus.setAlert(config.Name, fmt.Sprintf("source missing (%d/%d)", misses, limit))
+
+	default:
+		backupStatus.setAlert(config.Name, "source missing")
+	}
+
+	// Escalate once per outage rather than every cycle
+	if misses == 1 && !moved {
+		notifyEvent(config, EventFailure, "Backup source missing: "+config.Name, fmt.Sprintf("%s could not be found. Is the drive connected?", config.Source))
+	}
+	return fmt.Errorf("%w: %s", errSourceMissing, config.Source)
+}
+
+// errSourceMissing is returned while a source is missing under the "fail" and "disable" policies
+var errSourceMissing = errors.New("source folder not found")
+
+// errSourceWaiting signals a quietly skipped cycle under the "wait" policy
+var errSourceWaiting = errors.New("waiting for source")
+
+// staleAlerted tracks configs that have already been alerted for stale content,
+// so the warning is raised once per stale period instead of every cycle
+var (
+	staleAlertedMu sync.Mutex
+	staleAlerted   = make(map[string]bool)
+)
+
+// checkStaleSource warns when a source's content hasn't changed for stale_alert_days.
+func checkStaleSource(config BackupConfig, logger *log.Logger) {
+	days := config.GetStaleAlertDays()
+	if days == 0 {
+		return
+	}
+
+	lastChange := hashManager.getLastChangeTime(config.Name)
+	if lastChange.IsZero() || time.Since(lastChange) < time.Duration(days)*24*time.Hour {
+		return
+	}
+
+	staleAlertedMu.Lock()
+	alreadyAlerted := staleAlerted[config.Name]
+	staleAlerted[config.Name] = true
+	staleAlertedMu.Unlock()
+
+	unchangedDays := int(time.Since(lastChange).Hours() / 24)
+	backupStatus.setAlert(config.Name, fmt.Sprintf("no changes for %d days", unchangedDays))
+	if !alreadyAlerted {
+		logger.Printf("Content of %s has not changed since %s (%d days)", config.Name, formatDisplayTime(lastChange), unchangedDays)
+		notifyEvent(config, EventStale, "No new data: "+config.Name,
+			fmt.Sprintf("%s hasn't changed in %d days. Check that whatever writes to it is still working.", config.Source, unchangedDays))
+	}
+}
+
+// clearStaleSource resets the stale warning once content changes again.
+func clearStaleSource(config BackupConfig, logger *log.Logger) {
+	staleAlertedMu.Lock()
+	wasAlerted := staleAlerted[config.Name]
+	delete(staleAlerted, config.Name)
+	staleAlertedMu.Unlock()
+
+	if wasAlerted {
+		logger.Printf("Content of %s is changing again", config.Name)
+		backupStatus.clearAlert(config.Name)
+	}
+}
@@ -0,0 +1,230 @@
+// Package main - sourcemove.go recognizes sources that were renamed or moved.
+//
+// A source folder that was renamed, or relocated by OneDrive's known folder
+// move ("Documents" becoming "OneDrive\Documents"), looks exactly like an
+// unplugged drive: the configured path is simply gone. Waiting for it is
+// pointless, so when a source goes missing its likely new locations are
+// searched, and a folder holding the files of the latest snapshot is offered
+// as the new source in the tray.
+//
+// Key design decisions:
+//
+// 1. Recognized by content: A candidate folder matches when most of a sample
+//    of the latest snapshot's files exist in it with the same size. Folder
+//    identities don't survive OneDrive moves between volumes, and names
+//    change with renames; content survives both.
+//
+// 2. Nearby only: Only OneDrive's known folder locations and folders up to
+//    two levels below the nearest existing parent are searched, so the check
+//    stays quick enough to run when a miss is first noticed.
+//
+// 3. Never switched silently: The match is reported and offered, but
+//    config.json is only changed when the user confirms in the tray.
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Limits of the moved source search
+const (
+	sourceMoveSampleFiles   = 50  // Snapshot files checked per candidate folder
+	sourceMoveMaxCandidates = 500 // Folders examined per search
+	sourceMoveMinMatch      = 0.8 // Share of sampled files a folder must contain
+)
+
+// movedSources holds the likely new location of each config whose source is
+// missing, by config name
+var (
+	movedSourcesMu sync.Mutex
+	movedSources   = make(map[string]string)
+)
+
+// movedSourceFor returns where a config's missing source has probably moved.
+func movedSourceFor(name string) (string, bool) {
+	movedSourcesMu.Lock()
+	defer movedSourcesMu.Unlock()
+	folder, found := movedSources[name]
+	return folder, found
+}
+
+// forgetMovedSource drops a config's moved source once its source is back or replaced.
+func forgetMovedSource(name string) {
+	movedSourcesMu.Lock()
+	delete(movedSources, name)
+	movedSourcesMu.Unlock()
+}
+
+// detectMovedSource searches for a config's missing source and reports a match.
+//
+// Returns whether a likely new location was found.
+func detectMovedSource(config BackupConfig, logger *log.Logger) bool {
+	folder, found := findMovedSource(config)
+	if !found {
+		return false
+	}
+	movedSourcesMu.Lock()
+	movedSources[config.Name] = folder
+	movedSourcesMu.Unlock()
+
+	logger.Printf("Source for %s is missing, but %s contains the files of its latest snapshot", config.Name, folder)
+	backupStatus.setAlert(config.Name, "source moved?")
+	notifyEvent(config, EventFailure, "Backup source moved? "+config.Name,
+		fmt.Sprintf("%s could not be found, but %s looks like the same folder. Use \"Use moved source folder...\" in the tray to back it up from there.", config.Source, folder))
+	return true
+}
+
+// sampleFile is a snapshot file a moved source is expected to contain
+type sampleFile struct {
+	rel  string // Slash-separated path relative to the source
+	size int64
+}
+
+// findMovedSource returns the folder that most likely is config's source
+// under a new path.
+func findMovedSource(config BackupConfig) (string, bool) {
+	sample := sourceSample(config)
+	if len(sample) == 0 {
+		return "", false // Nothing known about the source's content
+	}
+
+	best, bestShare := "", 0.0
+	for _, candidate := range candidateSourceFolders(config.Source) {
+		if isWithin(candidate, config.Destination) || isWithin(config.Destination, candidate) {
+			continue // The snapshots themselves always match
+		}
+		if share := sampleShare(candidate, sample); share > bestShare {
+			best, bestShare = candidate, share
+		}
+	}
+	return best, bestShare >= sourceMoveMinMatch
+}
+
+// sourceSample picks files of the config's latest snapshot, spread evenly over
+// its sorted file list.
+func sourceSample(config BackupConfig) []sampleFile {
+	snapshots, err := listSnapshots(config)
+	if err != nil || len(snapshots) == 0 {
+		return nil
+	}
+	files, err := snapshotFiles(config, snapshots[0])
+	if err != nil || len(files) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(files))
+	for rel := range files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	step := max(len(paths)/sourceMoveSampleFiles, 1)
+	var sample []sampleFile
+	for i := 0; i < len(paths) && len(sample) < sourceMoveSampleFiles; i += step {
+		sample = append(sample, sampleFile{rel: paths[i], size: files[paths[i]].Size})
+	}
+	return sample
+}
+
+// sampleShare returns the share of sample found in dir with the same size.
+func sampleShare(dir string, sample []sampleFile) float64 {
+	matched := 0
+	for _, file := range sample {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file.rel)))
+		if err == nil && !info.IsDir() && info.Size() == file.size {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(sample))
+}
+
+// candidateSourceFolders lists the folders a missing source may have moved to.
+func candidateSourceFolders(source string) []string {
+	source = filepath.Clean(source)
+	var candidates []string
+	seen := map[string]bool{source: true}
+	add := func(dir string) {
+		if !seen[dir] && len(candidates) < sourceMoveMaxCandidates {
+			seen[dir] = true
+			candidates = append(candidates, dir)
+		}
+	}
+
+	// Known folder moves keep the path below the user profile inside OneDrive
+	if home, err := os.UserHomeDir(); err == nil && isWithin(source, home) {
+		rel, _ := filepath.Rel(home, source)
+		for _, variable := range []string{"OneDrive", "OneDriveConsumer", "OneDriveCommercial"} {
+			if root := os.Getenv(variable); root != "" {
+				add(filepath.Join(root, rel))
+				add(filepath.Join(root, filepath.Base(source)))
+			}
+		}
+	}
+
+	// Renamed or moved nearby: folders up to two levels below the nearest existing parent
+	parent := filepath.Dir(source)
+	for {
+		if info, err := os.Stat(parent); err == nil && info.IsDir() {
+			break
+		}
+		next := filepath.Dir(parent)
+		if next == parent {
+			return candidates // Nothing of the path exists, e.g. an unplugged drive
+		}
+		parent = next
+	}
+	filepath.WalkDir(parent, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(parent, path)
+		depth := strings.Count(rel, string(filepath.Separator)) + 1
+		if rel == "." {
+			return nil
+		}
+		if len(candidates) >= sourceMoveMaxCandidates {
+			return filepath.SkipAll
+		}
+		add(path)
+		if depth >= 2 {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return candidates
+}
+
+// useMovedSource switches a config's source to folder in config.json.
+//
+// The running config is restarted with the new source by the config watcher.
+func useMovedSource(name, folder, iface string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config.json: %v", err)
+	}
+	previous := *config
+	previous.Backups = append([]BackupConfig(nil), config.Backups...)
+
+	found := false
+	for i := range config.Backups {
+		if config.Backups[i].Name == name {
+			config.Backups[i].Source = folder
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no backup configuration named %q", name)
+	}
+	if err := saveConfig(config); err != nil {
+		return fmt.Errorf("failed to save config.json: %v", err)
+	}
+	auditLog.recordConfigUpdate(iface, &previous, config)
+	forgetMovedSource(name)
+	return nil
+}
@@ -0,0 +1,263 @@
+// Package main - statetasks.go resets and repairs the application's state per config.
+//
+// The application keeps state about every config outside the snapshots
+// themselves: the change-detection hash in hashes.json, warm caches, run
+// counters, storage samples and the manifests and notes in the destination's
+// .manifests folder (the catalog of what each snapshot contains). After
+// deleting or renaming snapshots by hand, or when change detection is stuck,
+// that state no longer matches reality. These tasks fix it without editing
+// the JSON files by hand:
+//
+// - reset-hash: Forget the change-detection hash and warm cache, so the next
+//   run backs up even if nothing changed
+// - clear-history: Delete the run counters, run records and storage samples
+// - rebuild-catalog: Write manifests for snapshots that have none and delete
+//   manifests and notes of snapshots that no longer exist
+// - rebaseline: Rebuild the catalog and reset the hash, for use after
+//   manual changes to the destination
+//
+// Key design decisions:
+//
+// 1. Run by the tray instance when it is running: It holds this state in
+//    memory and would write its own copy back over an edited file, so the
+//    CLI forwards the task over IPC and only works on the files itself when
+//    no instance is running.
+//
+// 2. Never while the config is busy: Catalog work waits for a backup or
+//    restore of the config to finish, like any other operation.
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// State task names, also used as CLI commands and audit actions
+const (
+	StateTaskResetHash      = "reset-hash"
+	StateTaskClearHistory   = "clear-history"
+	StateTaskRebuildCatalog = "rebuild-catalog"
+	StateTaskRebaseline     = "rebaseline"
+)
+
+// stateTasks maps task names to their implementation
+var stateTasks = map[string]func(config BackupConfig) (string, error){
+	StateTaskResetHash:      resetHashState,
+	StateTaskClearHistory:   clearHistory,
+	StateTaskRebuildCatalog: rebuildCatalog,
+	StateTaskRebaseline:     rebaseline,
+}
+
+// resolveStateTaskConfigs returns the configs of config.json named in names,
+// or all of them if names is empty.
+func resolveStateTaskConfigs(names []string) ([]BackupConfig, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+	if len(names) == 0 {
+		return config.Backups, nil
+	}
+	configs := make([]BackupConfig, 0, len(names))
+	for _, name := range names {
+		backup, err := findConfig(config.Backups, name)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, backup)
+	}
+	return configs, nil
+}
+
+// checkStateTask verifies that task exists and may change each of configs.
+func checkStateTask(task string, configs []BackupConfig, iface string) error {
+	if _, exists := stateTasks[task]; !exists {
+		return fmt.Errorf("unknown task %q", task)
+	}
+	for _, config := range configs {
+		if err := ensureWritable(iface, task, config.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runStateTask runs a checked state task on each of configs, auditing each one.
+//
+// Returns one line per config describing what was done; a failed config
+// doesn't stop the others.
+func runStateTask(task string, configs []BackupConfig, iface string) (string, error) {
+	var lines []string
+	var failed []string
+	for _, config := range configs {
+		summary, err := stateTasks[task](config)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s: failed: %v", config.Name, err))
+			failed = append(failed, config.Name)
+			continue
+		}
+		auditLog.record(iface, task, config.Name, summary)
+		lines = append(lines, fmt.Sprintf("%s: %s", config.Name, summary))
+	}
+	message := strings.Join(lines, "\n")
+	if len(failed) > 0 {
+		return message, fmt.Errorf("%s failed for %s", task, joinNames(failed))
+	}
+	return message, nil
+}
+
+// handleStateTaskRequest starts a state task forwarded by the CLI in the tray
+// instance.
+//
+// Rebuilding a catalog hashes whole snapshots, so like other IPC requests the
+// task is acknowledged once started and its outcome logged and notified.
+func handleStateTaskRequest(req ipcRequest) ipcResponse {
+	configs, err := resolveStateTaskConfigs(req.Configs)
+	if err != nil {
+		return ipcResponse{Message: err.Error()}
+	}
+	if err := checkStateTask(req.Task, configs, AuditInterfaceCLI); err != nil {
+		return ipcResponse{Message: err.Error()}
+	}
+
+	go func() {
+		message, err := runStateTask(req.Task, configs, AuditInterfaceCLI)
+		log.Printf("%s:\n%s", req.Task, message)
+		title := "Finished " + req.Task
+		if err != nil {
+			title = "Failed " + req.Task
+		}
+		notifyUser(title, message)
+		requestStatusUpdate()
+	}()
+	return ipcResponse{OK: true, Message: fmt.Sprintf("Started %s in the running instance; the outcome is shown as a notification and logged", req.Task)}
+}
+
+// resetHashState forgets the change-detection hash and warm cache of a config.
+func resetHashState(config BackupConfig) (string, error) {
+	existed, err := hashManager.reset(config.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to save hashes: %v", err)
+	}
+	if err := dropWarmCache(config); err != nil {
+		return "", fmt.Errorf("failed to delete warm cache: %v", err)
+	}
+	if !existed {
+		return "no change-detection state to reset", nil
+	}
+	return "change-detection state reset; the next run backs up", nil
+}
+
+// clearHistory deletes the run counters, run records and storage samples of a config.
+func clearHistory(config BackupConfig) (string, error) {
+	runs := runStats.clear(config.Name)
+	records, err := clearRunHistory(config.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete run history: %v", err)
+	}
+	samples, err := storageHistory.clear(config.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to save storage history: %v", err)
+	}
+	if !runs && !records && !samples {
+		return "no history to clear", nil
+	}
+	return "run counters, run records and storage samples cleared", nil
+}
+
+// rebuildCatalog brings the manifests and notes in a config's destination in
+// line with the snapshots actually there.
+func rebuildCatalog(config BackupConfig) (string, error) {
+	var written, removed int
+	err := backupRunner.withOperation(config, OperationMaintenance, func() error {
+		snapshots, err := listSnapshots(config)
+		if err != nil {
+			return fmt.Errorf("failed to list snapshots: %v", err)
+		}
+
+		existing := make(map[string]bool)
+		for _, snapshot := range snapshots {
+			existing[snapshot.Name] = true
+			if _, err := loadManifest(config.Destination, snapshot.Name); err == nil {
+				continue
+			}
+			if err := writeSnapshotManifest(config, snapshot); err != nil {
+				return fmt.Errorf("failed to catalog snapshot %s: %v", snapshot.Name, err)
+			}
+			written++
+		}
+
+		removed, err = removeOrphanedCatalogEntries(config, existing)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	purgeForecasts.update(config)
+	return fmt.Sprintf("catalog rebuilt: %d manifest(s) written, %d stale file(s) removed", written, removed), nil
+}
+
+// writeSnapshotManifest hashes every file of a snapshot into a new manifest.
+func writeSnapshotManifest(config BackupConfig, snapshot Snapshot) error {
+	builder := newManifestBuilder(snapshot.Path)
+	err := filepath.WalkDir(snapshot.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return builder.addCopied(path)
+	})
+	if err != nil {
+		return err
+	}
+	return builder.save(config.Destination)
+}
+
+// removeOrphanedCatalogEntries deletes the manifests and notes of this
+// config's snapshots that are no longer in existing.
+//
+// Files belonging to other configs sharing the destination are left alone.
+func removeOrphanedCatalogEntries(config BackupConfig, existing map[string]bool) (int, error) {
+	dir := filepath.Join(config.Destination, manifestDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	sourceFolderName := getSourceFolderName(config.Source)
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		snapshotName := strings.TrimSuffix(strings.TrimSuffix(name, ".note.txt"), ".json")
+		if entry.IsDir() || snapshotName == name || existing[snapshotName] || !isBackupDirectory(snapshotName, sourceFolderName) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// rebaseline rebuilds the catalog and resets change detection, for use after
+// snapshots were deleted, renamed or restored into the destination by hand.
+func rebaseline(config BackupConfig) (string, error) {
+	catalog, err := rebuildCatalog(config)
+	if err != nil {
+		return "", err
+	}
+	hash, err := resetHashState(config)
+	if err != nil {
+		return "", err
+	}
+	return catalog + "; " + hash, nil
+}
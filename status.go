@@ -17,6 +17,10 @@
 // 4. Intelligent scheduling integration: Mirrors the scheduler's hash-aware timing logic
 //    to ensure status display matches actual scheduler behavior for consistency.
 //
+// 5. Data before presentation: configStatuses exposes typed per-config status
+//    (ConfigStatus) and the tray strings are formatted from it, so every
+//    consumer - tray, command line, IPC - reads the same data.
+//
 // The status system is critical for user confidence - without visibility into backup
 // operations, users can't verify the tool is working correctly or troubleshoot issues.
 package main
@@ -25,6 +29,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -36,6 +41,11 @@ import (
 // - nextBackupTimes: When each config is scheduled for next action
 // - scheduleMinutes: Interval configuration for each backup
 // - configNames: Mapping for config name lookups (enables iteration)
+// - alerts: Conditions needing user attention, shown as a separate tray line
+// - operations, blockedBy, lastResults, lastErrors, disabled: Outcome and state of runs in this session
+// - lastSnapshots: Figures of the last snapshot, loaded from the run history at startup
+// - watchFallbacks, watchErrors: How and why watching a "trigger": "watch" source is degraded
+// - clock: Time source, replaceable so status math can run on a simulated clock
 //
 // The RWMutex enables concurrent reads for frequent status display updates while
 // protecting occasional writes when backup operations complete.
@@ -44,11 +54,63 @@ import (
 // map of structs because status display reads are much more frequent than updates,
 // and this structure optimizes for read access patterns.
 type BackupStatus struct {
-	mu                sync.RWMutex          // Protects all status state
-	lastBackupTimes   map[string]time.Time  // When config was last processed
-	nextBackupTimes   map[string]time.Time  // When config is due for next action
-	scheduleMinutes   map[string]int        // Backup interval for each config
-	configNames       map[string]string     // Enables iteration over active configs
+	mu              sync.RWMutex               // Protects all status state
+	lastBackupTimes map[string]time.Time       // When config was last processed
+	nextBackupTimes map[string]time.Time       // When config is due for next action
+	scheduleMinutes map[string]int             // Backup interval for each config
+	configNames     map[string]string          // Enables iteration over active configs
+	alerts          map[string]string          // Config name -> condition needing attention
+	operations      map[string]string          // Config name -> Operation* constant in progress
+	blockedBy       map[string]string          // Config name -> conflicting operation it waits for
+	lastResults     map[string]string          // Outcome of the last run: a Result* constant or ResultFailure
+	lastErrors      map[string]string          // Error message of the last failed run
+	lastErrorCodes  map[string]ErrorCode       // Error code of the last failed run
+	lastSnapshots   map[string]SnapshotFigures // Size and duration of the last run that created a snapshot
+	disabled        map[string]bool            // Configs stopped at runtime
+	paused          map[string]bool            // Configs paused after repeated failures
+	watchFallbacks  map[string]string          // Config name -> WatchFallback* constant while its watch is degraded
+	watchErrors     map[string]string          // Config name -> error that degraded its watch
+	clock           Clock                      // Source of the current time
+}
+
+// Config states reported by ConfigStatus
+const (
+	StateScheduled = "scheduled" // Waiting for the next scheduled run
+	StateRunning   = "running"   // A backup is in progress
+	StateRestoring = "restoring" // A restore is writing to the source
+	StateBlocked   = "blocked"   // A backup or restore waits for a conflicting operation
+	StateWaiting   = "waiting"   // Not scheduled until something happens (e.g. first backup confirmation)
+	StateDisabled  = "disabled"  // Stopped at runtime, e.g. by the missing source policy
+	StatePaused    = "paused"    // Stopped after repeated identical failures, or paused from the tray, until resumed
+)
+
+// ResultFailure is the last result of a config whose last run failed
+const ResultFailure = "failure"
+
+// ConfigStatus is the status of one backup configuration.
+//
+// This is the structured form of everything the tray displays; the tray
+// strings are rendered from it.
+type ConfigStatus struct {
+	Name            string           `json:"name"`
+	State           string           `json:"state"`                   // One of the State* constants
+	LastRun         time.Time        `json:"lastRun,omitzero"`        // Last backup or verified skip
+	LastResult      string           `json:"lastResult,omitempty"`    // Result* constant or ResultFailure
+	LastError       string           `json:"lastError,omitempty"`     // Message of the last failure
+	LastErrorCode   ErrorCode        `json:"lastErrorCode,omitempty"` // Code of the last failure, e.g. "E_DISK_FULL"
+	NextRun         time.Time        `json:"nextRun,omitzero"`        // Zero when not scheduled
+	ScheduleMinutes int              `json:"scheduleMinutes"`
+	Alert           string           `json:"alert,omitempty"`         // Condition needing attention
+	Last30Days      RunCounts        `json:"last30Days"`              // Outcome counts over the last 30 days
+	BlockedBy       string           `json:"blockedBy,omitempty"`     // e.g. "restore of Documents" while blocked
+	NextPurge       *PurgeForecast   `json:"nextPurge,omitempty"`     // Snapshot rotation deletes next
+	Replica         *ReplicaStatus   `json:"replica,omitempty"`       // Copies to the second destination, if configured
+	Progress        *RunProgress     `json:"progress,omitempty"`      // Files and bytes a running backup has copied
+	File            *FileProgress    `json:"file,omitempty"`          // Large file being copied by a running backup
+	PausedUntil     time.Time        `json:"pausedUntil,omitzero"`    // When a pause from "Pause until" ends, for this config or all
+	LastSnapshot    *SnapshotFigures `json:"lastSnapshot,omitempty"`  // Files, bytes and duration of the last run that created a snapshot
+	WatchFallback   string           `json:"watchFallback,omitempty"` // WatchFallback* constant while change notifications for the source fail
+	WatchError      string           `json:"watchError,omitempty"`    // Why watching the source is degraded
 }
 
 // Global singleton instance provides centralized status tracking across all schedulers
@@ -57,6 +119,177 @@ var backupStatus = &BackupStatus{
 	nextBackupTimes: make(map[string]time.Time),
 	scheduleMinutes: make(map[string]int),
 	configNames:     make(map[string]string),
+	alerts:          make(map[string]string),
+	operations:      make(map[string]string),
+	blockedBy:       make(map[string]string),
+	lastResults:     make(map[string]string),
+	lastErrors:      make(map[string]string),
+	lastErrorCodes:  make(map[string]ErrorCode),
+	lastSnapshots:   make(map[string]SnapshotFigures),
+	disabled:        make(map[string]bool),
+	paused:          make(map[string]bool),
+	watchFallbacks:  make(map[string]string),
+	watchErrors:     make(map[string]string),
+	clock:           systemClock,
+}
+
+// markOperation records the operation in progress on a configuration; "" clears it.
+func (bs *BackupStatus) markOperation(configName, operation string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if operation != "" {
+		bs.operations[configName] = operation
+	} else {
+		delete(bs.operations, configName)
+	}
+}
+
+// operationOf returns the operation in progress on a configuration, "" if none.
+func (bs *BackupStatus) operationOf(configName string) string {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return bs.operations[configName]
+}
+
+// markBlocked records the operation a configuration is waiting for; "" clears it.
+func (bs *BackupStatus) markBlocked(configName, blocker string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if blocker != "" {
+		bs.blockedBy[configName] = blocker
+	} else {
+		delete(bs.blockedBy, configName)
+	}
+}
+
+// recordResult stores the outcome of a finished run.
+func (bs *BackupStatus) recordResult(configName string, result BackupResult, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if err != nil {
+		bs.lastResults[configName] = ResultFailure
+		bs.lastErrors[configName] = err.Error()
+		bs.lastErrorCodes[configName] = errorCodeOf(err)
+		return
+	}
+	if result.Outcome != ResultWaiting {
+		bs.lastResults[configName] = result.Outcome
+		delete(bs.lastErrors, configName)
+		delete(bs.lastErrorCodes, configName)
+	}
+}
+
+// recordSnapshot keeps the figures of a run that created a snapshot.
+func (bs *BackupStatus) recordSnapshot(configName string, figures SnapshotFigures) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.lastSnapshots[configName] = figures
+}
+
+// markDisabled records that a configuration was stopped at runtime.
+//
+// The config no longer has a next backup time, so the tray doesn't keep
+// counting down to a backup that will never run.
+func (bs *BackupStatus) markDisabled(configName string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.disabled[configName] = true
+	delete(bs.nextBackupTimes, configName)
+}
+
+// nextRunFor returns when a configuration's next backup is due, zero if not scheduled.
+func (bs *BackupStatus) nextRunFor(configName string) time.Time {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return bs.nextBackupTimes[configName]
+}
+
+// markPaused records that a configuration was paused or resumed.
+//
+// Like a disabled config, a paused one has no next backup time; the next
+// time is set again by the run that follows the resume.
+func (bs *BackupStatus) markPaused(configName string, paused bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if paused {
+		bs.paused[configName] = true
+		delete(bs.nextBackupTimes, configName)
+	} else {
+		delete(bs.paused, configName)
+	}
+}
+
+// configStatuses returns the status of every tracked configuration, sorted by name.
+func (bs *BackupStatus) configStatuses() []ConfigStatus {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	allPaused := allBackupsPaused()
+	statuses := make([]ConfigStatus, 0, len(bs.configNames))
+	for name := range bs.configNames {
+		status := ConfigStatus{
+			Name:            name,
+			LastRun:         bs.lastBackupTimes[name],
+			LastResult:      bs.lastResults[name],
+			LastError:       bs.lastErrors[name],
+			LastErrorCode:   bs.lastErrorCodes[name],
+			NextRun:         bs.nextBackupTimes[name],
+			ScheduleMinutes: bs.scheduleMinutes[name],
+			Alert:           bs.alerts[name],
+			Last30Days:      runStats.summary(name, 30),
+			BlockedBy:       bs.blockedBy[name],
+			WatchFallback:   bs.watchFallbacks[name],
+			WatchError:      bs.watchErrors[name],
+		}
+		if status.Alert == "" && status.WatchFallback == WatchFallbackSchedule {
+			status.Alert = "not watching for changes, backing up on schedule"
+		}
+		if forecast, exists := purgeForecasts.forecastFor(name); exists {
+			status.NextPurge = &forecast
+		}
+		if replica, exists := replicaStatusFor(name); exists {
+			status.Replica = &replica
+		}
+		if progress, exists := runProgressFor(name); exists {
+			status.Progress = &progress
+		}
+		if progress, exists := fileProgressFor(name); exists {
+			status.File = &progress
+		}
+		if figures, exists := bs.lastSnapshots[name]; exists {
+			status.LastSnapshot = &figures
+		}
+		if status.LastResult == "" {
+			// Nothing ran yet this session - the hash state knows the last action
+			status.LastResult = hashManager.getLastActionType(name)
+		}
+
+		switch {
+		case bs.operations[name] == OperationRestore:
+			status.State = StateRestoring
+		case bs.operations[name] == OperationBackup:
+			status.State = StateRunning
+		case status.BlockedBy != "":
+			status.State = StateBlocked
+		case bs.disabled[name]:
+			status.State = StateDisabled
+		case bs.paused[name] || allPaused:
+			status.State = StatePaused
+			if bs.paused[name] {
+				status.PausedUntil = pausedUntil(name)
+			} else {
+				status.PausedUntil = allPausedUntil()
+			}
+		case status.NextRun.IsZero():
+			status.State = StateWaiting
+		default:
+			status.State = StateScheduled
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
 }
 
 // updateBackupCompleted updates status tracking after a backup operation completes.
@@ -74,13 +307,32 @@ func (bs *BackupStatus) updateBackupCompleted(configName string, scheduleMinutes
 	bs.mu.Lock()
 	defer bs.mu.Unlock()
 	
-	now := time.Now()
+	now := bs.clock.Now()
 	bs.lastBackupTimes[configName] = now
 	bs.nextBackupTimes[configName] = now.Add(time.Duration(scheduleMinutes) * time.Minute)
 	bs.scheduleMinutes[configName] = scheduleMinutes
 	bs.configNames[configName] = configName
 }
 
+// updateNextBackup advances the next backup time without recording a completed backup.
+//
+// Used when a cycle is skipped for reasons other than unchanged content (such
+// as waiting for a missing source), so "Last" keeps showing the real last backup.
+func (bs *BackupStatus) updateNextBackup(configName string, scheduleMinutes int) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	
+	bs.nextBackupTimes[configName] = bs.clock.Now().Add(time.Duration(scheduleMinutes) * time.Minute)
+}
+
+// postponeNextBackup moves the next backup time of a configuration to until,
+// for a scheduled run that waits for something to end first.
+func (bs *BackupStatus) postponeNextBackup(configName string, until time.Time) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.nextBackupTimes[configName] = until
+}
+
 // initializeSchedule sets up initial status tracking for a backup configuration.
 //
 // Called during scheduler startup to establish initial status display values.
@@ -94,12 +346,10 @@ func (bs *BackupStatus) updateBackupCompleted(configName string, scheduleMinutes
 // - First run with no previous state
 //
 // Thread safety: Uses write lock since this initializes multiple status fields.
+// The destination scan and content hash happen before it is taken, so the
+// status of other configs stays readable while a large source is hashed or
+// waits for its turn under max_concurrent.
 func (bs *BackupStatus) initializeSchedule(config BackupConfig) {
-	bs.mu.Lock()
-	defer bs.mu.Unlock()
-	
-	now := time.Now()
-	
 	// Mirror scheduler logic: determine effective last action time
 	lastBackupTime := bs.findLastBackupTime(config)
 	var effectiveLastTime time.Time
@@ -111,7 +361,9 @@ func (bs *BackupStatus) initializeSchedule(config BackupConfig) {
 		
 		if lastActionType == "skipped" && !lastActionTime.IsZero() {
 			// Check if content changed since last skip
-			shouldSkip, err := hashManager.shouldSkipBackup(config.Name, config.Source)
+			var shouldSkip bool
+			var err error
+			backupRunner.withHashCheck(func() { shouldSkip, err = hashManager.shouldSkipBackup(config) })
 			if err != nil || !shouldSkip {
 				// Hash check failed or content changed - use backup folder time
 				effectiveLastTime = lastBackupTime
@@ -128,6 +380,16 @@ func (bs *BackupStatus) initializeSchedule(config BackupConfig) {
 		effectiveLastTime = lastBackupTime
 	}
 	
+	// The figures of the last snapshot survive restarts in the run history
+	figures, hasFigures := lastSnapshotFigures(config.Name)
+	
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	now := bs.clock.Now()
+	if hasFigures {
+		bs.lastSnapshots[config.Name] = figures
+	}
+	
 	// Set initial status values based on effective last time
 	if !effectiveLastTime.IsZero() {
 		bs.lastBackupTimes[config.Name] = effectiveLastTime
@@ -156,6 +418,9 @@ func (bs *BackupStatus) initializeSchedule(config BackupConfig) {
 // Returns zero time if no backups exist or directory scan fails, which signals
 // to callers that this is a first-run scenario.
 func (bs *BackupStatus) findLastBackupTime(config BackupConfig) time.Time {
+	if writesArchiveSnapshots(config) {
+		return findLastRemoteBackupTime(config)
+	}
 	entries, err := os.ReadDir(config.Destination)
 	if err != nil {
 		return time.Time{} // Directory doesn't exist or can't be read
@@ -186,55 +451,52 @@ func (bs *BackupStatus) findLastBackupTime(config BackupConfig) time.Time {
 // - Time since last action ("Just now", "N minutes ago")
 // - Configuration name that was processed
 // - Skip indicator [S] if last action was optimized away
+// - Files, size and duration if it created a snapshot
 //
 // The skip indicator helps users understand when backups were intelligently
 // skipped due to unchanged content, providing confidence that the system is
 // working correctly even when no actual file copying occurred.
 //
-// Thread safety: Uses read lock for concurrent access during frequent UI updates.
+// Thread safety: Reads a consistent snapshot through configStatuses.
 func (bs *BackupStatus) getLastBackupStatus() string {
-	bs.mu.RLock()
-	defer bs.mu.RUnlock()
-	
-	if len(bs.lastBackupTimes) == 0 {
+	return describeLastRun(bs.configStatuses(), bs.clock.Now())
+}
+
+// describeLastRun renders the "Last" line for statuses, which may also come
+// from another instance (see trayclient.go).
+func describeLastRun(statuses []ConfigStatus, now time.Time) string {
+	// Find most recent backup action across all configurations
+	var mostRecent ConfigStatus
+	for _, status := range statuses {
+		if status.LastRun.After(mostRecent.LastRun) {
+			mostRecent = status
+		}
+	}
+	if mostRecent.LastRun.IsZero() {
 		return "Last: Never"
 	}
 	
-	// Find most recent backup action across all configurations
-	var mostRecent time.Time
-	var mostRecentConfigName string
-	
-	for configName, lastTime := range bs.lastBackupTimes {
-		if lastTime.After(mostRecent) {
-			mostRecent = lastTime
-			mostRecentConfigName = configName
-		}
+	// [S] indicates the last action was an optimized skip; a backup shows what
+	// its snapshot holds
+	suffix := ""
+	switch {
+	case mostRecent.LastResult == ResultSkipped:
+		suffix = " [S]"
+	case mostRecent.LastSnapshot != nil && (mostRecent.LastResult == ResultBackup || mostRecent.LastResult == ResultPartial):
+		suffix = ", " + mostRecent.LastSnapshot.describe()
 	}
 	
 	// Format time display with proper pluralization
-	minutesAgo := int(math.Round(time.Since(mostRecent).Minutes()))
+	minutesAgo := int(math.Round(now.Sub(mostRecent.LastRun).Minutes()))
 	if minutesAgo == 0 {
-		// Recent action - check if it was skipped for optimization
-		skipIndicator := ""
-		if hashManager.getLastActionType(mostRecentConfigName) == "skipped" {
-			skipIndicator = " [S]" // [S] indicates optimized skip
-		}
-		return fmt.Sprintf("Last: Just now (%s)%s", mostRecentConfigName, skipIndicator)
+		return fmt.Sprintf("Last: Just now (%s)%s", mostRecent.Name, suffix)
 	}
 	
-	// Format with proper singular/plural minutes
 	minuteWord := "minutes"
 	if minutesAgo == 1 {
 		minuteWord = "minute"
 	}
-	
-	// Add skip indicator if applicable
-	skipIndicator := ""
-	if hashManager.getLastActionType(mostRecentConfigName) == "skipped" {
-		skipIndicator = " [S]"
-	}
-	
-	return fmt.Sprintf("Last: %d %s ago (%s)%s", minutesAgo, minuteWord, mostRecentConfigName, skipIndicator)
+	return fmt.Sprintf("Last: %d %s ago (%s)%s", minutesAgo, minuteWord, mostRecent.Name, suffix)
 }
 
 // getNextBackupStatus generates the "Next backup" status string for system tray display.
@@ -250,33 +512,33 @@ func (bs *BackupStatus) getLastBackupStatus() string {
 //
 // Returns "Next: Unknown" if no backup configurations are active, which should
 // only occur during startup before schedulers initialize.
+// While all backups are paused from the tray it returns "Next: Paused".
 //
-// Thread safety: Uses read lock for concurrent access during frequent UI updates.
+// Thread safety: Reads a consistent snapshot through configStatuses.
 func (bs *BackupStatus) getNextBackupStatus() string {
-	bs.mu.RLock()
-	defer bs.mu.RUnlock()
-	
-	if len(bs.nextBackupTimes) == 0 {
-		return "Next: Unknown"
+	return describeNextRun(bs.configStatuses(), bs.clock.Now(), allBackupsPaused(), allPausedUntil())
+}
+
+// describeNextRun renders the "Next" line for statuses; paused and
+// pausedUntil describe a pause of all backups.
+func describeNextRun(statuses []ConfigStatus, now time.Time, paused bool, pausedUntil time.Time) string {
+	if paused {
+		if !pausedUntil.IsZero() {
+			return "Next: Paused until " + formatDisplayTime(pausedUntil)
+		}
+		return "Next: Paused"
 	}
 	
 	// Find earliest next backup time across all configurations
-	var earliest time.Time
-	var earliestConfigName string
-	first := true
-	
-	for configName, nextTime := range bs.nextBackupTimes {
-		if first || nextTime.Before(earliest) {
-			earliest = nextTime
-			earliestConfigName = configName
-			first = false
-		}
+	earliest, found := earliestNextRun(statuses)
+	if !found {
+		return "Next: Unknown"
 	}
 	
 	// Format countdown with proper pluralization
-	minutesUntil := int(math.Round(time.Until(earliest).Minutes()))
+	minutesUntil := int(math.Round(earliest.NextRun.Sub(now).Minutes()))
 	if minutesUntil <= 0 {
-		return fmt.Sprintf("Next: Due now (%s)", earliestConfigName)
+		return fmt.Sprintf("Next: Due now (%s)", earliest.Name)
 	}
 	
 	minuteWord := "minutes"
@@ -284,5 +546,163 @@ func (bs *BackupStatus) getNextBackupStatus() string {
 		minuteWord = "minute"
 	}
 	
-	return fmt.Sprintf("Next: %d %s (%s)", minutesUntil, minuteWord, earliestConfigName)
-}
\ No newline at end of file
+	return fmt.Sprintf("Next: %d %s (%s)", minutesUntil, minuteWord, earliest.Name)
+}
+
+// earliestNextRun returns the scheduled configuration that is due first.
+func earliestNextRun(statuses []ConfigStatus) (ConfigStatus, bool) {
+	var earliest ConfigStatus
+	found := false
+	for _, status := range statuses {
+		if status.NextRun.IsZero() {
+			continue
+		}
+		if !found || status.NextRun.Before(earliest.NextRun) {
+			earliest = status
+			found = true
+		}
+	}
+	return earliest, found
+}
+
+// getTooltipStatus generates the tray icon tooltip with absolute last/next times.
+//
+// The menu lines show relative times ("5 minutes ago"); the tooltip complements
+// them with wall-clock times in the user's configured display format so users
+// can correlate backups with their own activity.
+//
+// Thread safety: Reads a consistent snapshot through configStatuses.
+func (bs *BackupStatus) getTooltipStatus() string {
+	statuses := bs.configStatuses()
+	
+	var mostRecent time.Time
+	for _, status := range statuses {
+		if status.LastRun.After(mostRecent) {
+			mostRecent = status.LastRun
+		}
+	}
+	
+	next := "Unknown"
+	if earliest, found := earliestNextRun(statuses); found {
+		next = formatDisplayTime(earliest.NextRun)
+	}
+	title := "SimpleFolderBackup"
+	if currentSettings().ReadOnly {
+		title += " (read-only)"
+	}
+	if until := allPausedUntil(); !until.IsZero() {
+		title += " (paused until " + formatDisplayTime(until) + ")"
+	} else if allBackupsPaused() {
+		title += " (paused)"
+	}
+	tooltip := fmt.Sprintf("%s\nLast: %s\nNext: %s", title, formatDisplayTime(mostRecent), next)
+	// Tooltips are short, so only the first running backup is shown
+	for _, status := range statuses {
+		if status.Progress != nil {
+			tooltip += "\nBacking up " + status.Name + ": " + status.Progress.describe()
+		}
+		if status.File != nil {
+			tooltip += "\n" + status.File.describe(bs.clock.Now())
+		}
+		if status.Progress != nil || status.File != nil {
+			break
+		}
+	}
+	return tooltip
+}
+
+// setAlert records a condition on a backup configuration that needs user attention.
+//
+// Alerts are shown on their own tray line so they stand out from routine
+// last/next status, and persist until the condition is cleared.
+func (bs *BackupStatus) setAlert(configName, alert string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.alerts[configName] = alert
+}
+
+// clearAlert removes any alert for a backup configuration.
+func (bs *BackupStatus) clearAlert(configName string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	delete(bs.alerts, configName)
+}
+
+// markWatchDegraded records how a config's source is watched while change
+// notifications fail (a WatchFallback* constant) and why; "" clears it.
+//
+// Kept apart from alerts, which other conditions set and clear, so a watch
+// that keeps failing isn't hidden once e.g. a destination alert clears.
+func (bs *BackupStatus) markWatchDegraded(configName, fallback string, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if fallback != "" && err != nil {
+		bs.watchFallbacks[configName] = fallback
+		bs.watchErrors[configName] = err.Error()
+	} else {
+		delete(bs.watchFallbacks, configName)
+		delete(bs.watchErrors, configName)
+	}
+}
+
+// removeSchedule stops showing a configuration in next-backup status.
+//
+// Used while a configuration waits for something other than its schedule,
+// such as the confirmation of its first backup.
+func (bs *BackupStatus) removeSchedule(configName string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	delete(bs.nextBackupTimes, configName)
+}
+
+// forgetConfig removes all status of a configuration that is no longer active.
+//
+// Used when a config is removed, disabled or restarted by a reload of
+// config.json, so it disappears from the tray until its scheduler starts again.
+func (bs *BackupStatus) forgetConfig(configName string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	delete(bs.lastBackupTimes, configName)
+	delete(bs.nextBackupTimes, configName)
+	delete(bs.scheduleMinutes, configName)
+	delete(bs.configNames, configName)
+	delete(bs.alerts, configName)
+	delete(bs.blockedBy, configName)
+	delete(bs.lastResults, configName)
+	delete(bs.lastErrors, configName)
+	delete(bs.lastErrorCodes, configName)
+	delete(bs.lastSnapshots, configName)
+	delete(bs.disabled, configName)
+	delete(bs.paused, configName)
+	delete(bs.watchFallbacks, configName)
+	delete(bs.watchErrors, configName)
+}
+
+// getAlertStatus generates the alert line for system tray display.
+//
+// Returns an empty string when nothing needs attention, which the tray uses to
+// hide the line entirely. A single alert is shown in full; several are
+// summarized with a count since the tray line has limited width.
+//
+// Thread safety: Reads a consistent snapshot through configStatuses.
+func (bs *BackupStatus) getAlertStatus() string {
+	return describeAlerts(bs.configStatuses())
+}
+
+// describeAlerts renders the alert line for statuses; empty if none needs attention.
+func describeAlerts(statuses []ConfigStatus) string {
+	var alerting []ConfigStatus
+	for _, status := range statuses {
+		if status.Alert != "" {
+			alerting = append(alerting, status)
+		}
+	}
+	
+	switch len(alerting) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("⚠ %s: %s", alerting[0].Name, alerting[0].Alert)
+	}
+	return fmt.Sprintf("⚠ %d backups need attention", len(alerting))
+}
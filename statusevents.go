@@ -0,0 +1,115 @@
+// Package main - statusevents.go delivers status changes to whoever displays them.
+//
+// Status changes used to be signalled through a single channel that only the
+// tray read: a second reader would have stolen the tray's updates, and the
+// signal carried no information, so anything beyond the tray had to poll the
+// shared status maps. Changes are now published as typed StatusEvents on
+// statusEvents, and every display subscribes on its own.
+//
+// Key design decisions:
+//
+// 1. One publisher per kind: The runner publishes when a backup starts and
+//    how it ended, since every trigger goes through it; the copy publishes
+//    its progress. Everything else that changes what the tray shows (alerts,
+//    pauses, schedules) publishes StatusChanged through requestStatusUpdate.
+//
+// 2. The maps stay the source of truth: Events say what happened, but a
+//    subscriber that needs the full picture still reads configStatuses, so
+//    a missed event never leaves a display wrong for longer than its next
+//    refresh.
+//
+// 3. Publishing never blocks: A backup must not wait for a slow tray. A
+//    subscriber whose buffer is full misses the event; subscribers that only
+//    redraw, like the tray, use a buffer of one and so coalesce bursts.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// StatusEventKind says what a StatusEvent reports.
+type StatusEventKind string
+
+// Kinds of status events
+const (
+	StatusStarted   StatusEventKind = "started"   // A backup of the config started
+	StatusProgress  StatusEventKind = "progress"  // A running backup copied more files
+	StatusSkipped   StatusEventKind = "skipped"   // A backup ended without a snapshot: unchanged, waiting or verify_only
+	StatusCompleted StatusEventKind = "completed" // A backup created a snapshot, possibly with a warning
+	StatusFailed    StatusEventKind = "failed"    // A backup failed
+	StatusChanged   StatusEventKind = "changed"   // Anything else shown in the status changed
+)
+
+// StatusEvent is one change of the status.
+type StatusEvent struct {
+	Kind     StatusEventKind
+	Config   string       // Name of the config; empty for StatusChanged events that concern all
+	Time     time.Time    // When it happened
+	Result   BackupResult // Outcome of a skipped or completed run
+	Err      error        // Why a run failed
+	Progress RunProgress  // Files and bytes copied, for StatusProgress
+}
+
+// statusBus hands published events to every subscriber.
+type statusBus struct {
+	mu          sync.Mutex
+	subscribers map[chan StatusEvent]struct{}
+}
+
+// statusEvents is the bus of all status events
+var statusEvents = &statusBus{subscribers: make(map[chan StatusEvent]struct{})}
+
+// subscribe returns a channel receiving the events published from now on,
+// holding up to buffer of them, and a function that ends the subscription.
+func (sb *statusBus) subscribe(buffer int) (<-chan StatusEvent, func()) {
+	events := make(chan StatusEvent, max(buffer, 1))
+	sb.mu.Lock()
+	sb.subscribers[events] = struct{}{}
+	sb.mu.Unlock()
+	return events, func() {
+		sb.mu.Lock()
+		delete(sb.subscribers, events)
+		sb.mu.Unlock()
+	}
+}
+
+// publish sends event to every subscriber with room for it, without waiting.
+func (sb *statusBus) publish(event StatusEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for events := range sb.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// requestStatusUpdate announces that something shown in the status changed,
+// so the tray refreshes immediately. It never blocks.
+func requestStatusUpdate() {
+	statusEvents.publish(StatusEvent{Kind: StatusChanged})
+}
+
+// publishRunStarted announces that a backup of a config started.
+func publishRunStarted(name string) {
+	statusEvents.publish(StatusEvent{Kind: StatusStarted, Config: name})
+}
+
+// publishRunEnded announces how a backup of a config ended.
+func publishRunEnded(name string, result BackupResult, err error) {
+	event := StatusEvent{Config: name, Result: result, Err: err}
+	switch {
+	case err != nil:
+		event.Kind = StatusFailed
+	case result.Outcome == ResultBackup || result.Outcome == ResultPartial:
+		event.Kind = StatusCompleted
+	default:
+		event.Kind = StatusSkipped
+	}
+	statusEvents.publish(event)
+}
@@ -0,0 +1,332 @@
+// Package main - storage.go tracks storage growth and forecasts when destinations fill up.
+//
+// Snapshots only cover the rotation window, which is often a few hours, so
+// they can't show how a config grows over weeks. Instead a sample of the
+// snapshot size and the total space used by the config's snapshots is stored
+// once per day in storage_history.json, and trends are computed from those
+// samples.
+//
+// Key design decisions:
+//
+// 1. Daily samples: Sizes are measured by walking snapshot metadata, which
+//    is cheap but not free, so only the first backup of each day records a
+//    sample. Samples are thinned as they age (see compactSamples) rather
+//    than dropped, so the long-term trend stays available.
+//
+// 2. Linear forecast: Growth is the least-squares slope of space used over
+//    the recent samples. Backup growth is rarely smooth, but a straight line
+//    is easy to reason about and errs early rather than late when growth
+//    slows down.
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage history retention and forecast window
+const (
+	storageDailyDays    = 90  // Samples younger than this are kept daily
+	storageWeeklyDays   = 365 // then weekly up to this age, monthly beyond it
+	storageTrendWeeks   = 8   // Weeks of samples used for the growth rate
+	storageSampleLayout = "2006-01-02"
+)
+
+// StorageSample is one day's storage measurement for a configuration.
+type StorageSample struct {
+	Date         string `json:"date"`         // Local date, YYYY-MM-DD
+	SnapshotSize int64  `json:"snapshotSize"` // Size of the newest snapshot in bytes
+	UsedSize     int64  `json:"usedSize"`     // Total size of all retained snapshots in bytes
+}
+
+// StorageHistory persists daily storage samples per configuration.
+type StorageHistory struct {
+	mu       sync.Mutex
+	samples  map[string][]StorageSample // Config name -> samples, oldest first
+	filePath string
+}
+
+// Global singleton instance shared by the runner and reports
+var storageHistory = &StorageHistory{
+	samples:  make(map[string][]StorageSample),
+	filePath: "storage_history.json",
+}
+
+// load restores samples from disk; a missing file is normal on first run.
+func (sh *StorageHistory) load() error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	data, err := os.ReadFile(sh.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &sh.samples)
+}
+
+// save writes all samples to disk. Callers must hold sh.mu.
+func (sh *StorageHistory) save() error {
+	data, err := json.MarshalIndent(sh.samples, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sh.filePath, data, 0644)
+}
+
+// recordSample measures a config's snapshots unless today's sample already exists.
+func (sh *StorageHistory) recordSample(config BackupConfig, logger *log.Logger) {
+	today := time.Now().Format(storageSampleLayout)
+
+	sh.mu.Lock()
+	samples := sh.samples[config.Name]
+	recorded := len(samples) > 0 && samples[len(samples)-1].Date == today
+	sh.mu.Unlock()
+	if recorded {
+		return
+	}
+
+	sample, err := measureStorage(config)
+	if err != nil {
+		logger.Printf("Failed to measure storage for %s: %v", config.Name, err)
+		return
+	}
+	sample.Date = today
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	kept := []StorageSample{}
+	for _, existing := range sh.samples[config.Name] {
+		if existing.Date != today {
+			kept = append(kept, existing)
+		}
+	}
+	sh.samples[config.Name] = compactSamples(append(kept, sample), time.Now())
+
+	if err := sh.save(); err != nil {
+		logger.Printf("Failed to save storage history: %v", err)
+	}
+}
+
+// compact thins the samples of every configuration.
+//
+// recordSample compacts the config it measures; this covers configs that
+// haven't backed up for a while. Returns the number of samples removed.
+func (sh *StorageHistory) compact(now time.Time) (int, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	removed := 0
+	for name, samples := range sh.samples {
+		compacted := compactSamples(samples, now)
+		removed += len(samples) - len(compacted)
+		sh.samples[name] = compacted
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, sh.save()
+}
+
+// clear removes all samples of a configuration and returns whether it had any.
+func (sh *StorageHistory) clear(name string) (bool, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, exists := sh.samples[name]; !exists {
+		return false, nil
+	}
+	delete(sh.samples, name)
+	return true, sh.save()
+}
+
+// compactSamples keeps one sample per day for the last storageDailyDays, one
+// per week up to storageWeeklyDays and one per month beyond that.
+//
+// The last sample of each week or month is kept, matching how weekly usage
+// is reported. samples must be oldest first; so is the result.
+func compactSamples(samples []StorageSample, now time.Time) []StorageSample {
+	// Dates in this layout sort lexically, so string comparison is enough
+	dailyCutoff := now.AddDate(0, 0, -storageDailyDays).Format(storageSampleLayout)
+	weeklyCutoff := now.AddDate(0, 0, -storageWeeklyDays).Format(storageSampleLayout)
+
+	var kept []StorageSample
+	lastPeriod := ""
+	for _, sample := range samples {
+		period := sample.Date
+		if date, err := time.ParseInLocation(storageSampleLayout, sample.Date, time.Local); err == nil {
+			switch {
+			case sample.Date < weeklyCutoff:
+				period = date.Format("2006-01")
+			case sample.Date < dailyCutoff:
+				period = weekStart(date).Format(storageSampleLayout)
+			}
+		}
+		if len(kept) > 0 && period == lastPeriod {
+			kept[len(kept)-1] = sample // A later sample of the same period replaces the earlier one
+			continue
+		}
+		kept = append(kept, sample)
+		lastPeriod = period
+	}
+	return kept
+}
+
+// samplesFor returns a copy of the samples of a configuration, oldest first.
+func (sh *StorageHistory) samplesFor(name string) []StorageSample {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return append([]StorageSample(nil), sh.samples[name]...)
+}
+
+// measureStorage sums the sizes of a config's snapshots.
+//
+// Files hardlinked into several snapshots by incremental backups count toward
+// each snapshot's size but only once toward the space used.
+func measureStorage(config BackupConfig) (StorageSample, error) {
+	snapshots, err := listSnapshots(config)
+	if err != nil {
+		return StorageSample{}, err
+	}
+
+	var sample StorageSample
+	seen := make(map[fileID]bool)
+	for i, snapshot := range snapshots {
+		size, used, err := directoryUsage(snapshot.Path, seen)
+		if err != nil {
+			return StorageSample{}, err
+		}
+		if i == 0 {
+			sample.SnapshotSize = size
+		}
+		sample.UsedSize += used
+	}
+	return sample, nil
+}
+
+// directorySize returns the total size of the regular files below dir.
+func directorySize(dir string) (int64, error) {
+	size, _, err := directoryUsage(dir, nil)
+	return size, err
+}
+
+// directoryUsage returns the total size of the regular files below dir, and
+// the part of it used by files not already in seen. Hardlinked files are
+// added to seen; a nil seen counts every file as used.
+func directoryUsage(dir string, seen map[fileID]bool) (size, used int64, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		if seen != nil {
+			if id, linked := fileIdentity(path, info); linked {
+				if seen[id] {
+					return nil
+				}
+				seen[id] = true
+			}
+		}
+		used += info.Size()
+		return nil
+	})
+	return size, used, err
+}
+
+// WeeklyUsage is the space used by a config at the end of one week.
+type WeeklyUsage struct {
+	WeekStart time.Time // Monday of the week
+	UsedSize  int64     // Space used by the last sample of that week
+}
+
+// StorageTrend summarizes growth and the fill-up forecast of a configuration.
+type StorageTrend struct {
+	Weekly       []WeeklyUsage // Most recent storageTrendWeeks weeks, oldest first
+	UsedSize     int64         // Space used according to the latest sample
+	GrowthPerDay float64       // Bytes per day, 0 when there is too little history
+	Free         uint64        // Free space on the destination volume
+	Capacity     uint64        // Size of the destination volume
+	FullAt       time.Time     // Forecast date the volume fills up, zero if not growing
+}
+
+// computeStorageTrend derives weekly usage and a fill-up forecast from the samples.
+//
+// The forecast assumes this config is the only one growing on its destination
+// volume; configs sharing a volume each see the whole free space.
+func computeStorageTrend(config BackupConfig, samples []StorageSample) StorageTrend {
+	var trend StorageTrend
+	if free, capacity, err := diskUsage(config.Destination); err == nil {
+		trend.Free, trend.Capacity = free, capacity
+	}
+	if len(samples) == 0 {
+		return trend
+	}
+	trend.UsedSize = samples[len(samples)-1].UsedSize
+
+	windowStart := time.Now().AddDate(0, 0, -7*storageTrendWeeks)
+	weeks := make(map[time.Time]int64)
+	var days, sizes []float64
+	for _, sample := range samples {
+		date, err := time.ParseInLocation(storageSampleLayout, sample.Date, time.Local)
+		if err != nil || date.Before(windowStart) {
+			continue
+		}
+		weeks[weekStart(date)] = sample.UsedSize // Samples are oldest first, so the last one wins
+		days = append(days, date.Sub(windowStart).Hours()/24)
+		sizes = append(sizes, float64(sample.UsedSize))
+	}
+
+	for start, used := range weeks {
+		trend.Weekly = append(trend.Weekly, WeeklyUsage{WeekStart: start, UsedSize: used})
+	}
+	sort.Slice(trend.Weekly, func(i, j int) bool {
+		return trend.Weekly[i].WeekStart.Before(trend.Weekly[j].WeekStart)
+	})
+
+	trend.GrowthPerDay = linearSlope(days, sizes)
+	if trend.GrowthPerDay > 0 && trend.Capacity > 0 {
+		daysLeft := float64(trend.Free) / trend.GrowthPerDay
+		if daysLeft < 100*365 { // Beyond that the forecast is meaningless (and overflows time.Duration)
+			trend.FullAt = time.Now().Add(time.Duration(daysLeft * 24 * float64(time.Hour)))
+		}
+	}
+	return trend
+}
+
+// weekStart returns midnight on the Monday of t's week.
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7 // Days since Monday
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+// linearSlope returns the least-squares slope of y over x, or 0 with fewer than two distinct x values.
+func linearSlope(x, y []float64) float64 {
+	n := float64(len(x))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+		sumXY += x[i] * y[i]
+		sumXX += x[i] * x[i]
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
@@ -0,0 +1,153 @@
+// Package main - toastactions.go adds action buttons to failure notifications.
+//
+// Acting on a failure toast used to mean hunting for the config in the tray
+// menu. On Windows a failure toast of a config now offers three buttons:
+//
+// - "Retry now" runs the backup again right away
+// - "Open log" shows today's log of the config
+// - "Pause config" pauses it until resumed from the tray (see pause.go)
+//
+// Key design decisions:
+//
+// 1. Protocol activation: Toasts are raised by PowerShell under its own
+//    AppUserModelID (see notify_windows.go), so an activation callback would
+//    reach PowerShell, not this process. Buttons open simplefolderbackup:
+//    URIs instead, e.g. simplefolderbackup:retry?config=Documents; the URI
+//    scheme is registered for the current user on every start and runs the
+//    "toast-action" command.
+//
+// 2. Forwarded like Explorer requests: That short-lived copy of the
+//    executable only forwards the button over IPC; the running instance,
+//    which owns the schedulers and the logs, carries it out.
+//
+// 3. Buttons only where they work: If the URI scheme can't be registered,
+//    or on other platforms, toasts are shown without buttons.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// toastURIScheme is the URI scheme notification buttons open
+const toastURIScheme = "simplefolderbackup"
+
+// Notification button actions
+const (
+	ToastActionRetry   = "retry"    // Run the config's backup now
+	ToastActionOpenLog = "open-log" // Show today's log of the config
+	ToastActionPause   = "pause"    // Pause the config until resumed
+)
+
+// toastActionsEnabled is set at startup once the URI scheme is registered
+var toastActionsEnabled bool
+
+// toastAction is one button of a notification.
+type toastAction struct {
+	label string // Button text
+	uri   string // URI opened when the button is clicked
+}
+
+// failureToastButtons lists the buttons of failure notifications, in display order
+var failureToastButtons = []struct{ action, label string }{
+	{ToastActionRetry, "Retry now"},
+	{ToastActionOpenLog, "Open log"},
+	{ToastActionPause, "Pause config"},
+}
+
+// failureToastActions returns the buttons of a notification; only failures
+// of a config get any.
+func failureToastActions(n Notification) []toastAction {
+	if !toastActionsEnabled || n.Event != EventFailure || n.Config == "" {
+		return nil
+	}
+	actions := make([]toastAction, 0, len(failureToastButtons))
+	for _, button := range failureToastButtons {
+		uri := fmt.Sprintf("%s:%s?config=%s", toastURIScheme, button.action, url.QueryEscape(n.Config))
+		actions = append(actions, toastAction{label: button.label, uri: uri})
+	}
+	return actions
+}
+
+// parseToastActionURI returns the action and config name of a button URI.
+func parseToastActionURI(raw string) (action, config string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != toastURIScheme || u.Opaque == "" {
+		return "", "", fmt.Errorf("not a notification action: %q", raw)
+	}
+	config = u.Query().Get("config")
+	if config == "" {
+		return "", "", fmt.Errorf("notification action without a backup config: %q", raw)
+	}
+	return u.Opaque, config, nil
+}
+
+// runToastActionCommand forwards a clicked notification button to the tray instance.
+//
+// Windows starts it without a console, so failures are shown in a message box.
+func runToastActionCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: SimpleFolderBackup toast-action %s:<action>?config=<name>\n", toastURIScheme)
+		return 2
+	}
+	action, config, err := parseToastActionURI(args[0])
+	if err == nil {
+		var resp ipcResponse
+		resp, err = sendIPCRequest(ipcRequest{Action: IPCActionToastAction, Button: action, Configs: []string{config}})
+		if err == nil && !resp.OK {
+			err = errors.New(resp.Message)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		showMessageBox("SimpleFolderBackup", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// handleToastActionRequest carries out a notification button in the tray instance.
+func handleToastActionRequest(req ipcRequest) ipcResponse {
+	if len(req.Configs) != 1 {
+		return ipcResponse{Message: "No backup config given"}
+	}
+	name := req.Configs[0]
+	registered := false
+	for _, config := range backupRunner.registeredConfigs() {
+		registered = registered || config.Name == name
+	}
+	if !registered {
+		return ipcResponse{Message: fmt.Sprintf("No running backup config named %q; it may be disabled", name)}
+	}
+
+	switch req.Button {
+	case ToastActionRetry:
+		if err := ensureWritable(AuditInterfaceNotification, "backup-now", name); err != nil {
+			return ipcResponse{Message: err.Error()}
+		}
+		auditLog.record(AuditInterfaceNotification, "backup-now", name, "")
+		notifyUser("Backup started", "Backing up "+name)
+		go backupRunner.runByName(name)
+		return ipcResponse{OK: true, Message: "Backing up " + name}
+	case ToastActionOpenLog:
+		path := configLogToOpen(name)
+		if err := openInFileManager(path); err != nil {
+			return ipcResponse{Message: fmt.Sprintf("Failed to open %s: %v", path, err)}
+		}
+		return ipcResponse{OK: true, Message: "Opened " + path}
+	case ToastActionPause:
+		if err := ensureWritable(AuditInterfaceNotification, "pause", name); err != nil {
+			return ipcResponse{Message: err.Error()}
+		}
+		if !pauseConfig(name) {
+			return ipcResponse{OK: true, Message: fmt.Sprintf("%q is already paused", name)}
+		}
+		auditLog.record(AuditInterfaceNotification, "pause", name, "")
+		backupRunner.loggerFor(name).Printf("Pausing backups for %s from a notification", name)
+		return ipcResponse{OK: true, Message: fmt.Sprintf("Paused %q; resume it from the tray menu", name)}
+	default:
+		return ipcResponse{Message: fmt.Sprintf("Unknown notification action %q", req.Button)}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+// enableToastActions does nothing: notifications have no buttons outside Windows.
+func enableToastActions() {}
+
+// showNotificationWithActions shows a desktop notification; buttons are a
+// Windows feature, so actions are left out.
+func showNotificationWithActions(title, message string, actions []toastAction) error {
+	return showDesktopNotification(title, message)
+}
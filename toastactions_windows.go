@@ -0,0 +1,67 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// toastProtocolKey registers the URI scheme for the current user; HKCU needs no administrator rights
+const toastProtocolKey = `Software\Classes\` + toastURIScheme
+
+// enableToastActions registers the URI scheme opened by notification
+// buttons, pointing it at this executable.
+//
+// Registering on every start keeps the command current when the executable
+// is moved. If it fails, notifications are shown without buttons.
+func enableToastActions() {
+	if err := registerToastProtocol(); err != nil {
+		log.Printf("Failed to register notification buttons, notifications are shown without them: %v", err)
+		return
+	}
+	toastActionsEnabled = true
+}
+
+// registerToastProtocol writes the URI scheme's registry keys.
+func registerToastProtocol() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return err
+	}
+
+	if err := regSetString(HKEY_CURRENT_USER, toastProtocolKey, "", "URL:SimpleFolderBackup notification action"); err != nil {
+		return err
+	}
+	// The empty "URL Protocol" value marks the key as a URI scheme
+	if err := regSetString(HKEY_CURRENT_USER, toastProtocolKey, "URL Protocol", ""); err != nil {
+		return err
+	}
+	// %1 is the URI of the clicked button
+	command := fmt.Sprintf(`"%s"%s toast-action "%%1"`, exe, registeredDataDirArgs())
+	return regSetString(HKEY_CURRENT_USER, toastProtocolKey+`\shell\open\command`, "", command)
+}
+
+// showNotificationWithActions displays a toast with a button per action.
+//
+// Buttons use protocol activation: Windows opens the action's URI, which
+// starts this executable with the toast-action command.
+func showNotificationWithActions(title, message string, actions []toastAction) error {
+	buttons := ""
+	for _, action := range actions {
+		buttons += fmt.Sprintf(`<action content="%s" activationType="protocol" arguments="%s"/>`,
+			escapeXML(action.label), escapeXML(action.uri))
+	}
+	if buttons != "" {
+		buttons = "<actions>" + buttons + "</actions>"
+	}
+	toastXML := fmt.Sprintf(`<toast><visual><binding template="ToastGeneric"><text>%s</text><text>%s</text></binding></visual>%s</toast>`,
+		escapeXML(title), escapeXML(message), buttons)
+	return runToastScript(toastXML)
+}
@@ -0,0 +1,750 @@
+// Package main - tray.go implements the per-configuration tray submenus.
+//
+// The top-level tray menu only has room for aggregate status. Each backup
+// configuration gets its own submenu under "Backups" with quick access to its
+// most recent snapshots, since "the version from two hours ago" is by far the
+// most common thing users go looking for.
+//
+// Design decisions:
+//
+// 1. Fixed slots: The systray library can't remove menu items, so each config
+//    submenu pre-creates traySnapshotSlots items that are retitled, shown and
+//    hidden as snapshots come and go.
+//
+// 2. Refresh with status: Snapshot lists are re-read whenever the status lines
+//    are updated, so new snapshots appear shortly after a backup completes.
+//
+// 3. Actions per snapshot: Each snapshot slot is itself a submenu to open the
+//    snapshot, restore it (over the source or into another folder) or attach
+//    a note, which is then shown in the slot's title.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// traySnapshotSlots is how many recent snapshots each config submenu lists
+const traySnapshotSlots = 10
+
+// configMenu is the tray submenu for one backup configuration.
+type configMenu struct {
+	config        BackupConfig
+	root          *systray.MenuItem
+	stats         *systray.MenuItem
+	lastSnapshot  *systray.MenuItem
+	purge         *systray.MenuItem
+	replica       *systray.MenuItem
+	startFirst    *systray.MenuItem
+	resume        *systray.MenuItem
+	pauseUntil    pauseUntilMenu
+	movedSource   *systray.MenuItem
+	backupNow     *systray.MenuItem
+	openFolder    *systray.MenuItem
+	openLogs      *systray.MenuItem
+	restoreLatest *systray.MenuItem
+	undoRestore   *systray.MenuItem
+	compare       *systray.MenuItem
+	export        *systray.MenuItem
+	snapshotSlots []snapshotSlot
+
+	mu        sync.Mutex // Protects snapshots
+	snapshots []Snapshot // Snapshot shown in each visible slot
+}
+
+// snapshotSlot is the submenu of one snapshot in a config submenu.
+type snapshotSlot struct {
+	item      *systray.MenuItem // Titled with the snapshot time
+	open      *systray.MenuItem
+	restore   *systray.MenuItem
+	restoreTo *systray.MenuItem
+	note      *systray.MenuItem // "Add note..." or "Edit note..."
+	clearNote *systray.MenuItem
+}
+
+// newConfigMenu creates the submenu for a configuration under the given parent.
+func newConfigMenu(parent *systray.MenuItem, config BackupConfig) *configMenu {
+	cm := &configMenu{
+		config: config,
+		root:   parent.AddSubMenuItem(config.Name, fmt.Sprintf("%s -> %s", config.Source, config.Destination)),
+	}
+
+	cm.stats = cm.root.AddSubMenuItem("Last 30 days: no runs", "Share of runs that did not fail")
+	cm.stats.Disable()
+	cm.lastSnapshot = cm.root.AddSubMenuItem("", "Files, size and duration of the last backup that created a snapshot")
+	cm.lastSnapshot.Disable()
+	cm.lastSnapshot.Hide()
+	cm.purge = cm.root.AddSubMenuItem("", "Rotation deletes this snapshot once enough newer ones exist")
+	cm.purge.Disable()
+	cm.purge.Hide()
+	cm.replica = cm.root.AddSubMenuItem("", "Snapshots are also copied to the replica destination on its own schedule")
+	cm.replica.Disable()
+	cm.replica.Hide()
+	cm.startFirst = cm.root.AddSubMenuItem("Start first backup...", "This backup is waiting for confirmation before its first full copy")
+	cm.startFirst.Hide()
+	cm.resume = cm.root.AddSubMenuItem("Resume backups", "Backups are paused, after repeated failures or until a chosen time")
+	cm.resume.Hide()
+	cm.pauseUntil = newPauseUntilMenu(cm.root.AddSubMenuItem("Pause until", "Skip scheduled backups of this job for a while; they resume by themselves"))
+	cm.movedSource = cm.root.AddSubMenuItem("Use moved source folder...", "The source folder is missing, but a folder with its files was found elsewhere")
+	cm.movedSource.Hide()
+	cm.backupNow = cm.root.AddSubMenuItem("Backup now", "Run this backup immediately instead of waiting for the next scheduled run")
+	cm.openFolder = cm.root.AddSubMenuItem("Open backup folder", config.Destination)
+	if isRemoteDestination(config.Destination) {
+		cm.openFolder.Hide() // A bucket can't be opened in the file manager
+	}
+	cm.openLogs = cm.root.AddSubMenuItem("Open logs", "Show today's log of this backup in the file manager")
+	cm.restoreLatest = cm.root.AddSubMenuItem("Restore latest snapshot...", "Replace the source folder with the most recent snapshot")
+	cm.undoRestore = cm.root.AddSubMenuItem("Undo last restore...", "Put back the contents the source had before the last restore")
+	cm.undoRestore.Hide()
+	cm.compare = cm.root.AddSubMenuItem("Changes in latest snapshot", "Compare the two most recent snapshots")
+	cm.export = cm.root.AddSubMenuItem("Export latest snapshot as zip", "Package the most recent snapshot into a single zip file")
+	for i := 0; i < traySnapshotSlots; i++ {
+		slot := snapshotSlot{item: cm.root.AddSubMenuItem("", "Open or restore this snapshot")}
+		slot.open = slot.item.AddSubMenuItem("Open", "Show this snapshot in the file manager")
+		slot.restore = slot.item.AddSubMenuItem("Restore to source...", "Replace the source folder with this snapshot")
+		slot.restoreTo = slot.item.AddSubMenuItem("Restore to another folder...", "Copy this snapshot into a folder of your choice")
+		slot.note = slot.item.AddSubMenuItem("Add note...", "Record why this snapshot matters, e.g. \"before mod install\"")
+		slot.clearNote = slot.item.AddSubMenuItem("Remove note", "Delete the note of this snapshot")
+		slot.item.Hide()
+		cm.snapshotSlots = append(cm.snapshotSlots, slot)
+	}
+	cm.snapshots = make([]Snapshot, traySnapshotSlots)
+	return cm
+}
+
+// refresh updates the snapshot slots from the destination folder.
+func (cm *configMenu) refresh() {
+	snapshots, err := listSnapshots(cm.config)
+	if err != nil {
+		snapshots = nil // Destination unavailable - show no snapshots
+	}
+
+	for _, status := range backupStatus.configStatuses() {
+		if status.Name != cm.config.Name {
+			continue
+		}
+		cm.root.SetTitle(cm.config.Name + stateSuffix(status))
+		if status.LastSnapshot != nil {
+			cm.lastSnapshot.SetTitle("Last snapshot: " + status.LastSnapshot.describe())
+			cm.lastSnapshot.Show()
+		} else {
+			cm.lastSnapshot.Hide()
+		}
+	}
+	cm.stats.SetTitle("Last 30 days: " + runStats.summary(cm.config.Name, 30).describe())
+	if forecast, exists := purgeForecasts.forecastFor(cm.config.Name); exists {
+		cm.purge.SetTitle(forecast.describe(time.Now()))
+		cm.purge.Show()
+	} else {
+		cm.purge.Hide()
+	}
+	if status, exists := replicaStatusFor(cm.config.Name); exists {
+		cm.replica.SetTitle(describeReplicaStatus(status, time.Now()))
+		cm.replica.Show()
+	} else {
+		cm.replica.Hide()
+	}
+	// Actions refused in read-only mode aren't offered at all
+	readOnly := currentSettings().ReadOnly
+	if hasPendingFirstBackup(cm.config.Name) && !readOnly {
+		cm.startFirst.Show()
+	} else {
+		cm.startFirst.Hide()
+	}
+	if isPaused(cm.config.Name) && !readOnly {
+		cm.resume.Show()
+	} else {
+		cm.resume.Hide()
+	}
+	if readOnly {
+		cm.pauseUntil.root.Hide()
+	} else {
+		cm.pauseUntil.root.Show()
+	}
+	if folder, moved := movedSourceFor(cm.config.Name); moved && !readOnly {
+		cm.movedSource.SetTitle("Use moved source folder: " + folder + "...")
+		cm.movedSource.Show()
+	} else {
+		cm.movedSource.Hide()
+	}
+	if readOnly {
+		cm.backupNow.Hide()
+		cm.restoreLatest.Hide()
+	} else {
+		cm.backupNow.Show()
+		cm.restoreLatest.Show()
+	}
+
+	if safety, err := listSafetySnapshots(cm.config); err == nil && len(safety) > 0 && !readOnly {
+		cm.undoRestore.Show()
+	} else {
+		cm.undoRestore.Hide()
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for i, slot := range cm.snapshotSlots {
+		if i >= len(snapshots) {
+			cm.snapshots[i] = Snapshot{}
+			slot.item.Hide()
+			continue
+		}
+		cm.snapshots[i] = snapshots[i]
+		title := fmt.Sprintf("%s (%s)", formatDisplayTime(snapshots[i].Time), formatAge(time.Since(snapshots[i].Time)))
+		if snapshots[i].Note != "" {
+			title += " - " + snapshots[i].Note
+			slot.note.SetTitle("Edit note...")
+			slot.clearNote.Show()
+		} else {
+			slot.note.SetTitle("Add note...")
+			slot.clearNote.Hide()
+		}
+		slot.item.SetTitle(title)
+		if readOnly {
+			slot.restore.Hide()
+			slot.restoreTo.Hide()
+		} else {
+			slot.restore.Show()
+			slot.restoreTo.Show()
+		}
+		slot.item.Show()
+	}
+}
+
+// handleClicks dispatches clicks on this submenu until ctx is cancelled.
+func (cm *configMenu) handleClicks(ctx context.Context) {
+	go cm.pauseUntil.handleClicks(ctx, "Pause "+cm.config.Name, func(until time.Time) {
+		if err := ensureWritable(AuditInterfaceTray, "pause", cm.config.Name); err != nil {
+			showMessageBox("Pause "+cm.config.Name, err.Error())
+			return
+		}
+		auditLog.record(AuditInterfaceTray, "pause", cm.config.Name, "until "+formatDisplayTime(until))
+		pauseConfigUntil(cm.config.Name, until)
+	})
+	for i, slot := range cm.snapshotSlots {
+		go func(index int, slot snapshotSlot) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-slot.open.ClickedCh:
+					if snapshot, ok := cm.snapshotAt(index); ok {
+						openPathOrLog(snapshot.Path)
+					}
+				case <-slot.restore.ClickedCh:
+					if snapshot, ok := cm.snapshotAt(index); ok {
+						go confirmRestoreFromTray(cm.config, snapshot)
+					}
+				case <-slot.restoreTo.ClickedCh:
+					if snapshot, ok := cm.snapshotAt(index); ok {
+						go restoreCopyFromTray(cm.config, snapshot)
+					}
+				case <-slot.note.ClickedCh:
+					if snapshot, ok := cm.snapshotAt(index); ok {
+						go editNoteFromTray(cm.config, snapshot)
+					}
+				case <-slot.clearNote.ClickedCh:
+					if snapshot, ok := cm.snapshotAt(index); ok {
+						go setNoteFromTray(cm.config, snapshot, "")
+					}
+				}
+			}
+		}(i, slot)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cm.openFolder.ClickedCh:
+			openPathOrLog(cm.config.Destination)
+		case <-cm.openLogs.ClickedCh:
+			openPathOrLog(configLogToOpen(cm.config.Name))
+		case <-cm.startFirst.ClickedCh:
+			go startFirstBackupFromTray(cm.config)
+		case <-cm.resume.ClickedCh:
+			if err := ensureWritable(AuditInterfaceTray, "resume", cm.config.Name); err != nil {
+				go showMessageBox("Resume "+cm.config.Name, err.Error())
+			} else if resumeConfig(cm.config.Name) {
+				auditLog.record(AuditInterfaceTray, "resume", cm.config.Name, "")
+			}
+		case <-cm.movedSource.ClickedCh:
+			go useMovedSourceFromTray(cm.config)
+		case <-cm.backupNow.ClickedCh:
+			go backupNowFromTray(cm.config)
+		case <-cm.restoreLatest.ClickedCh:
+			go restoreLatestFromTray(cm.config)
+		case <-cm.undoRestore.ClickedCh:
+			go undoRestoreFromTray(cm.config)
+		case <-cm.compare.ClickedCh:
+			go compareLatestFromTray(cm.config)
+		case <-cm.export.ClickedCh:
+			go exportLatestFromTray(cm.config)
+		}
+	}
+}
+
+// snapshotAt returns the snapshot shown in a slot, if the slot is in use.
+func (cm *configMenu) snapshotAt(index int) (Snapshot, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	snapshot := cm.snapshots[index]
+	return snapshot, snapshot.Path != ""
+}
+
+// enableMenu is the tray submenu that turns configurations on and off.
+//
+// Unlike the config submenus it also lists disabled configs, so they can be
+// turned back on. Items are created as configs appear and hidden when they
+// are removed from config.json.
+type enableMenu struct {
+	ctx    context.Context
+	parent *systray.MenuItem
+
+	mu    sync.Mutex
+	items map[string]*systray.MenuItem // Config name -> checkbox item
+}
+
+// newEnableMenu returns the submenu; its items handle clicks until ctx ends.
+func newEnableMenu(ctx context.Context, parent *systray.MenuItem) *enableMenu {
+	return &enableMenu{ctx: ctx, parent: parent, items: make(map[string]*systray.MenuItem)}
+}
+
+// refresh shows one checkbox per config of config.json, checked if it is enabled.
+func (em *enableMenu) refresh(configs []BackupConfig) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	readOnly := currentSettings().ReadOnly
+	listed := make(map[string]bool)
+	for _, config := range configs {
+		listed[config.Name] = true
+		item, exists := em.items[config.Name]
+		if !exists {
+			item = em.parent.AddSubMenuItemCheckbox(config.Name, "Run scheduled backups of this configuration", config.IsEnabled())
+			em.items[config.Name] = item
+			go em.handleClicks(config.Name, item)
+		}
+		if config.IsEnabled() {
+			item.Check()
+		} else {
+			item.Uncheck()
+		}
+		if readOnly {
+			item.Disable()
+		} else {
+			item.Enable()
+		}
+		item.Show()
+	}
+	for name, item := range em.items {
+		if !listed[name] {
+			item.Hide()
+		}
+	}
+	if len(listed) > 0 {
+		em.parent.Show()
+	} else {
+		em.parent.Hide()
+	}
+}
+
+// handleClicks toggles a config each time its item is clicked.
+func (em *enableMenu) handleClicks(name string, item *systray.MenuItem) {
+	for {
+		select {
+		case <-em.ctx.Done():
+			return
+		case <-item.ClickedCh:
+			go toggleConfigFromTray(name, item)
+		}
+	}
+}
+
+// startFirstBackupFromTray confirms the pending first backup of a new config.
+func startFirstBackupFromTray(config BackupConfig) {
+	if err := ensureWritable(AuditInterfaceTray, "confirm-first-backup", config.Name); err != nil {
+		showMessageBox("Start first backup", err.Error())
+		return
+	}
+	size := "all files"
+	if bytes, err := directorySize(config.Source); err == nil {
+		size = formatSize(bytes)
+	}
+	message := fmt.Sprintf("Start the first backup of %s now?\n\nThis copies %s from\n%s\nto\n%s",
+		config.Name, size, config.Source, config.Destination)
+	if !askConfirmation("Start first backup", message) {
+		return
+	}
+	if confirmFirstBackup(config.Name) {
+		auditLog.record(AuditInterfaceTray, "confirm-first-backup", config.Name, "")
+		requestStatusUpdate()
+	}
+}
+
+// useMovedSourceFromTray switches a config to the folder its missing source
+// was found at, after confirmation.
+func useMovedSourceFromTray(config BackupConfig) {
+	if err := ensureWritable(AuditInterfaceTray, "use-moved-source", config.Name); err != nil {
+		showMessageBox("Use moved source folder", err.Error())
+		return
+	}
+	folder, moved := movedSourceFor(config.Name)
+	if !moved {
+		return
+	}
+	message := fmt.Sprintf("%s can no longer be found, but\n\n%s\n\ncontains the files of its latest snapshot. Back up %s from there from now on?",
+		config.Source, folder, config.Name)
+	if getSourceFolderName(folder) != getSourceFolderName(config.Source) {
+		message += fmt.Sprintf("\n\nThe folder name changed, so snapshots made from now on are named after %q. Existing snapshots keep their names and are no longer listed, rotated or restored by this backup.",
+			getSourceFolderName(folder))
+	}
+	if !askConfirmation("Use moved source folder", message) {
+		return
+	}
+	if err := useMovedSource(config.Name, folder, AuditInterfaceTray); err != nil {
+		showMessageBox("Use moved source folder", err.Error())
+		return
+	}
+	backupRunner.loggerFor(config.Name).Printf("Source of %s changed from %s to %s", config.Name, config.Source, folder)
+	backupStatus.clearAlert(config.Name)
+	requestStatusUpdate()
+}
+
+// toggleConfigFromTray enables or disables a config in config.json; the
+// config watcher then starts or stops it.
+func toggleConfigFromTray(name string, item *systray.MenuItem) {
+	enable := !item.Checked()
+	action := "disable"
+	if enable {
+		action = "enable"
+	}
+	if err := ensureWritable(AuditInterfaceTray, action, name); err != nil {
+		showMessageBox("Enabled backups", err.Error())
+		return
+	}
+	if err := setConfigEnabled(name, enable, AuditInterfaceTray); err != nil {
+		showMessageBox("Enabled backups", fmt.Sprintf("Failed to %s %s:\n\n%v", action, name, err))
+		return
+	}
+	if enable {
+		item.Check()
+		log.Printf("Enabled %s from the tray", name)
+	} else {
+		item.Uncheck()
+		log.Printf("Disabled %s from the tray", name)
+	}
+}
+
+// toggleAutostartFromTray turns start_at_login on or off from its checkbox.
+func toggleAutostartFromTray(item *systray.MenuItem) {
+	enable := !item.Checked()
+	action := "disable-start-at-login"
+	if enable {
+		action = "enable-start-at-login"
+	}
+	if err := ensureWritable(AuditInterfaceTray, action, ""); err != nil {
+		showMessageBox(autostartTitle, err.Error())
+		return
+	}
+	if err := setStartAtLogin(enable, AuditInterfaceTray); err != nil {
+		showMessageBox(autostartTitle, err.Error())
+		return
+	}
+	if enable {
+		item.Check()
+	} else {
+		item.Uncheck()
+	}
+}
+
+// backupNowFromTray runs a backup immediately, outside the config's schedule.
+func backupNowFromTray(config BackupConfig) {
+	if err := ensureWritable(AuditInterfaceTray, "backup-now", config.Name); err != nil {
+		showMessageBox("Backup now", err.Error())
+		return
+	}
+	if !confirmManualBackup(config) {
+		return
+	}
+	auditLog.record(AuditInterfaceTray, "backup-now", config.Name, "")
+	notifyUser("Backup started", "Backing up "+config.Name)
+	if err := backupRunner.runByName(config.Name); err != nil {
+		log.Printf("Backup now %s: %v", config.Name, err)
+	}
+}
+
+// editNoteFromTray asks for a new note for a snapshot and saves it.
+func editNoteFromTray(config BackupConfig, snapshot Snapshot) {
+	prompt := fmt.Sprintf("Note for the snapshot from %s:", formatDisplayTime(snapshot.Time))
+	note, ok := askText("Snapshot note", prompt, snapshot.Note)
+	if !ok {
+		return
+	}
+	setNoteFromTray(config, snapshot, note)
+}
+
+// setNoteFromTray replaces the note of a snapshot; an empty note removes it.
+func setNoteFromTray(config BackupConfig, snapshot Snapshot, note string) {
+	if err := saveSnapshotNote(config.Destination, snapshot.Name, note); err != nil {
+		log.Printf("Failed to save note for %s: %v", snapshot.Name, err)
+		showMessageBox("Snapshot note", fmt.Sprintf("The note could not be saved:\n\n%v", err))
+		return
+	}
+	auditLog.record(AuditInterfaceTray, "note", config.Name, fmt.Sprintf("%s: %q", snapshot.Name, normalizeSnapshotNote(note)))
+	requestStatusUpdate()
+}
+
+// restoreLatestFromTray restores the newest snapshot of a config after confirmation.
+//
+// Panic-restores happen under stress, so the flow is deliberately short:
+// confirm, then restore. restoreSnapshot saves the current source first.
+func restoreLatestFromTray(config BackupConfig) {
+	snapshots, err := listSnapshots(config)
+	if err != nil || len(snapshots) == 0 {
+		showMessageBox("Restore "+config.Name, "No snapshots are available to restore.")
+		return
+	}
+	confirmRestoreFromTray(config, snapshots[0])
+}
+
+// confirmRestoreFromTray asks before restoring a snapshot over the source.
+func confirmRestoreFromTray(config BackupConfig, snapshot Snapshot) {
+	if err := ensureWritable(AuditInterfaceTray, "restore", config.Name); err != nil {
+		showMessageBox("Restore "+config.Name, err.Error())
+		return
+	}
+	label := fmt.Sprintf("%s (%s)", formatDisplayTime(snapshot.Time), formatAge(time.Since(snapshot.Time)))
+	if snapshot.Note != "" {
+		label += fmt.Sprintf(", %q", snapshot.Note)
+	}
+	message := fmt.Sprintf("Replace the contents of\n\n%s\n\nwith the snapshot from %s?\n\nThe current contents will be saved to a safety snapshot first.",
+		config.Source, label)
+	if !askConfirmation("Restore "+config.Name, message) {
+		return
+	}
+	auditLog.record(AuditInterfaceTray, "restore", config.Name, snapshot.Name)
+	restoreFromTray(config, snapshot)
+}
+
+// restoreCopyFromTray copies a snapshot into a folder chosen by the user.
+//
+// The source is not touched, so no confirmation is needed; the copy is
+// revealed in the file manager when it is done.
+func restoreCopyFromTray(config BackupConfig, snapshot Snapshot) {
+	if err := ensureWritable(AuditInterfaceTray, "restore-copy", config.Name); err != nil {
+		showMessageBox("Restore "+config.Name, err.Error())
+		return
+	}
+	parent, ok := chooseFolder(fmt.Sprintf("Restore the snapshot from %s into:", formatDisplayTime(snapshot.Time)))
+	if !ok {
+		return
+	}
+	auditLog.record(AuditInterfaceTray, "restore-copy", config.Name, snapshot.Name+" -> "+parent)
+
+	logger := backupRunner.loggerFor(config.Name)
+	target, err := restoreSnapshotCopy(config, snapshot, parent, logger)
+	if err != nil {
+		logger.Printf("Restore of %s to %s failed: %v", config.Name, parent, err)
+		showMessageBox("Restore "+config.Name, fmt.Sprintf("Restore failed:\n\n%v", err))
+		return
+	}
+	notifyUser("Restore complete: "+config.Name, "Restored to "+target)
+	openPathOrLog(target)
+}
+
+// undoRestoreFromTray puts back the most recent pre-restore safety snapshot.
+//
+// This is an ordinary restore, so it takes a safety snapshot of its own and
+// an accidental undo can be undone again.
+func undoRestoreFromTray(config BackupConfig) {
+	if err := ensureWritable(AuditInterfaceTray, "undo-restore", config.Name); err != nil {
+		showMessageBox("Undo restore "+config.Name, err.Error())
+		return
+	}
+	safety, err := listSafetySnapshots(config)
+	if err != nil || len(safety) == 0 {
+		showMessageBox("Undo restore "+config.Name, "There is no restore to undo.")
+		return
+	}
+	latest := safety[0]
+
+	message := fmt.Sprintf("Put back the contents\n\n%s\n\nhad before the restore at %s?",
+		config.Source, formatDisplayTime(latest.Time))
+	if !askConfirmation("Undo restore "+config.Name, message) {
+		return
+	}
+	auditLog.record(AuditInterfaceTray, "undo-restore", config.Name, latest.Name)
+	restoreFromTray(config, latest)
+}
+
+// restoreFromTray runs a confirmed restore and reports the outcome.
+func restoreFromTray(config BackupConfig, snapshot Snapshot) {
+	logger := backupRunner.loggerFor(config.Name)
+	safetyPath, err := restoreSnapshot(config, snapshot, logger)
+	if err != nil {
+		logger.Printf("Restore of %s failed: %v", config.Name, err)
+		message := fmt.Sprintf("Restore failed:\n\n%v", err)
+		if safetyPath != "" {
+			message += "\n\nThe previous contents are in:\n" + safetyPath
+		}
+		showMessageBox("Restore "+config.Name, message)
+		return
+	}
+	notifyUser("Restore complete: "+config.Name, fmt.Sprintf("Restored the snapshot from %s.", formatDisplayTime(snapshot.Time)))
+}
+
+// compareLatestFromTray shows what changed between the two most recent snapshots.
+//
+// Only the first trayDiffLines changes fit in a message box; the CLI "diff"
+// command prints the full list.
+func compareLatestFromTray(config BackupConfig) {
+	const trayDiffLines = 20
+
+	snapshots, err := listSnapshots(config)
+	if err != nil || len(snapshots) < 2 {
+		showMessageBox("Changes in "+config.Name, "At least two snapshots are needed for a comparison.")
+		return
+	}
+
+	diff, err := diffSnapshots(snapshots[1], snapshots[0])
+	if err != nil {
+		showMessageBox("Changes in "+config.Name, fmt.Sprintf("Comparison failed:\n\n%v", err))
+		return
+	}
+	showMessageBox("Changes in "+config.Name, formatDiff(diff, trayDiffLines))
+}
+
+// exportLatestFromTray zips the most recent snapshot and reveals the archive.
+//
+// The tray has no way to ask for a passphrase, so encrypted exports are only
+// offered by the "export" command.
+func exportLatestFromTray(config BackupConfig) {
+	snapshots, err := listSnapshots(config)
+	if err != nil || len(snapshots) == 0 {
+		showMessageBox("Export "+config.Name, "No snapshots are available to export.")
+		return
+	}
+	latest := snapshots[0]
+
+	written, err := exportSnapshot(latest, defaultExportPath(config, latest), "")
+	if err != nil {
+		log.Printf("Export of %s failed: %v", latest.Path, err)
+		showMessageBox("Export "+config.Name, fmt.Sprintf("Export failed:\n\n%v", err))
+		return
+	}
+	auditLog.record(AuditInterfaceTray, "export", config.Name, latest.Name+" -> "+written)
+	if absPath, err := filepath.Abs(written); err == nil {
+		written = absPath
+	}
+	openPathOrLog(written)
+}
+
+// openPathOrLog opens a folder in the file manager, logging failures.
+func openPathOrLog(path string) {
+	if err := openInFileManager(path); err != nil {
+		log.Printf("Failed to open %s: %v", path, err)
+	}
+}
+
+// stateSuffix describes a busy configuration in its submenu title.
+func stateSuffix(status ConfigStatus) string {
+	switch status.State {
+	case StateRunning:
+		if status.File != nil {
+			return " (" + status.File.describe(time.Now()) + ")"
+		}
+		if status.Progress != nil {
+			return " (backing up: " + status.Progress.describe() + ")"
+		}
+		return " (backing up)"
+	case StateRestoring:
+		return " (restoring)"
+	case StateBlocked:
+		return " (waiting for " + status.BlockedBy + ")"
+	case StatePaused:
+		if !status.PausedUntil.IsZero() {
+			return " (paused until " + formatDisplayTime(status.PausedUntil) + ")"
+		}
+		return " (paused)"
+	default:
+		return ""
+	}
+}
+
+// pauseUntilMenu is a "Pause until" submenu offering when a pause ends.
+type pauseUntilMenu struct {
+	root     *systray.MenuItem
+	hour     *systray.MenuItem
+	tomorrow *systray.MenuItem
+	chosen   *systray.MenuItem
+}
+
+// newPauseUntilMenu adds the choices of a "Pause until" submenu to item.
+func newPauseUntilMenu(item *systray.MenuItem) pauseUntilMenu {
+	return pauseUntilMenu{
+		root:     item,
+		hour:     item.AddSubMenuItem("1 hour", "Resume in one hour"),
+		tomorrow: item.AddSubMenuItem(fmt.Sprintf("Tomorrow %d:00", pauseMorningHour), "Resume tomorrow morning"),
+		chosen:   item.AddSubMenuItem("Choose time...", "Resume at a date and time of your choice"),
+	}
+}
+
+// handleClicks calls pause with the end of the pause chosen, until ctx is cancelled.
+func (pm pauseUntilMenu) handleClicks(ctx context.Context, title string, pause func(until time.Time)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pm.hour.ClickedCh:
+			pause(time.Now().Add(time.Hour))
+		case <-pm.tomorrow.ClickedCh:
+			pause(pauseUntilTomorrow(time.Now()))
+		case <-pm.chosen.ClickedCh:
+			go func() {
+				if until, ok := askPauseUntil(title); ok {
+					pause(until)
+				}
+			}()
+		}
+	}
+}
+
+// askPauseUntil asks for the end of a pause until a valid time is entered or
+// the dialog is cancelled.
+func askPauseUntil(title string) (time.Time, bool) {
+	prompt := "Resume backups at (YYYY-MM-DD HH:MM, or HH:MM for the next time of day):"
+	value := pauseUntilTomorrow(time.Now()).Format("2006-01-02 15:04")
+	for {
+		var ok bool
+		if value, ok = askText(title, prompt, value); !ok {
+			return time.Time{}, false
+		}
+		until, err := parsePauseUntil(value, time.Now())
+		if err == nil {
+			return until, true
+		}
+		showMessageBox(title, err.Error())
+	}
+}
+
+// formatAge renders a duration as a short relative age ("just now", "5m ago", "3h ago", "2d ago").
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
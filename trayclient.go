@@ -0,0 +1,231 @@
+// Package main - trayclient.go shows a tray for a backup engine running headless.
+//
+// The tray application used to be the engine: closing it, a crash of the
+// tray library or logging off stopped every scheduler, and a machine that
+// should back up around the clock had to keep a desktop session open. The
+// engine can now run on its own with --headless, as a service or started at
+// login, and the tray attaches to it as a lightweight client: started while
+// a headless instance runs, the tray shows that instance's status and
+// forwards "Backup now" and "Pause all backups" to it over IPC (see ipc.go)
+// instead of refusing to start.
+//
+// Key design decisions:
+//
+// 1. The engine owns everything: The client reads no state files, takes no
+//    lock and writes no log or audit entry of its own; closing it leaves the
+//    backups running. Anything beyond status, run-now and pause (restores,
+//    snapshots, settings) stays with the full tray, the CLI and config.json.
+//
+// 2. Polling, not subscribing: The engine answers one status request per
+//    connection, so the client asks every few seconds. A status that is a
+//    few seconds old is fine for a menu, and an engine that restarts is
+//    picked up again without reconnect logic.
+//
+// 3. Same rendering as the full tray: The Last, Next and alert lines and the
+//    submenu titles come from the same functions as the tray of a normal
+//    instance, so both read alike.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// trayClientPollInterval is how often the client asks the engine for its status
+const trayClientPollInterval = 5 * time.Second
+
+// clientConfigMenu is the submenu of one config of the engine.
+type clientConfigMenu struct {
+	root      *systray.MenuItem
+	backupNow *systray.MenuItem
+	openLogs  *systray.MenuItem
+}
+
+// engineIsRunning reports whether the running instance is a headless engine
+// a tray client can attach to.
+func engineIsRunning() bool {
+	resp, err := sendIPCRequest(ipcRequest{Action: IPCActionStatus})
+	return err == nil && resp.OK && resp.Engine
+}
+
+// runTrayClient shows the tray of the headless engine until the user closes it.
+func runTrayClient() {
+	// The display format of dates comes from the settings the engine uses too
+	if config, err := loadConfig(); err == nil {
+		setActiveSettings(config.Settings)
+	}
+	systray.Run(trayClientOnReady, func() {})
+}
+
+// trayClientOnReady builds the client menu and refreshes it from the engine.
+func trayClientOnReady() {
+	systray.SetIcon(iconData)
+	systray.SetTitle("SimpleFolderBackup")
+	systray.SetTooltip("SimpleFolderBackup")
+
+	mLastBackup := systray.AddMenuItem("Last backup: Never", "Last backup time")
+	mLastBackup.Disable()
+	mNextBackup := systray.AddMenuItem("Next backup: Unknown", "Next backup time")
+	mNextBackup.Disable()
+	mAlert := systray.AddMenuItem("", "Backups needing attention")
+	mAlert.Disable()
+	mAlert.Hide()
+
+	mBackups := systray.AddMenuItem("Backups", "Backup configurations of the engine")
+	mBackups.Hide()
+	mPauseAll := systray.AddMenuItemCheckbox("Pause all backups", "Stop scheduled backups until resumed", false)
+
+	systray.AddSeparator()
+
+	mDashboard := systray.AddMenuItem("Open dashboard", "Show every backup's status, history and snapshots in the browser")
+	mDashboard.Hide()
+	mLogs := systray.AddMenuItem("Open logs", "Show system.log of the engine, with a folder of logs per backup next to it, in the file manager")
+
+	systray.AddSeparator()
+
+	mQuit := systray.AddMenuItem("Close tray", "Close this tray; the backup engine keeps running")
+
+	// Clicks in config submenus ask for a refresh so their effect shows at once
+	refreshNow := make(chan struct{}, 1)
+	requestRefresh := func() {
+		select {
+		case refreshNow <- struct{}{}:
+		default:
+		}
+	}
+
+	menus := make(map[string]*clientConfigMenu)
+	paused := false
+	refresh := func() {
+		resp, err := sendIPCRequest(ipcRequest{Action: IPCActionStatus})
+		if err == nil && !resp.OK {
+			err = fmt.Errorf("%s", resp.Message)
+		}
+		if err != nil {
+			mLastBackup.SetTitle("Backup engine is not running")
+			mNextBackup.SetTitle("Next: Unknown")
+			mAlert.Hide()
+			mBackups.Hide()
+			systray.SetTooltip("SimpleFolderBackup\nBackup engine is not running")
+			return
+		}
+
+		now := time.Now()
+		last := describeLastRun(resp.Statuses, now)
+		next := describeNextRun(resp.Statuses, now, resp.Paused, resp.PausedUntil)
+		mLastBackup.SetTitle(last)
+		mNextBackup.SetTitle(next)
+		systray.SetTooltip("SimpleFolderBackup\n" + last + "\n" + next)
+		paused = resp.Paused
+		if paused {
+			mPauseAll.Check()
+		} else {
+			mPauseAll.Uncheck()
+		}
+		if alert := describeAlerts(resp.Statuses); alert != "" {
+			mAlert.SetTitle(alert)
+			mAlert.Show()
+		} else {
+			mAlert.Hide()
+		}
+
+		// Menu items can't be removed, so configs the engine no longer runs are hidden
+		shown := make(map[string]bool)
+		for _, status := range resp.Statuses {
+			cm, exists := menus[status.Name]
+			if !exists {
+				cm = newClientConfigMenu(mBackups, status.Name, requestRefresh)
+				menus[status.Name] = cm
+			}
+			cm.root.SetTitle(status.Name + stateSuffix(status))
+			cm.root.Show()
+			shown[status.Name] = true
+		}
+		for name, cm := range menus {
+			if !shown[name] {
+				cm.root.Hide()
+			}
+		}
+		if len(resp.Statuses) > 0 {
+			mBackups.Show()
+		} else {
+			mBackups.Hide()
+		}
+		// The engine writes the login page while its dashboard is running
+		if _, err := os.Stat(dashboardLoginPath); err == nil {
+			mDashboard.Show()
+		} else {
+			mDashboard.Hide()
+		}
+	}
+
+	refresh()
+	ticker := time.NewTicker(trayClientPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			refresh()
+		case <-refreshNow:
+			refresh()
+		case <-mPauseAll.ClickedCh:
+			action := IPCActionPauseAll
+			if paused {
+				action = IPCActionResumeAll
+			}
+			go func() {
+				sendClientRequest(ipcRequest{Action: action})
+				requestRefresh()
+			}()
+		case <-mDashboard.ClickedCh:
+			go openDashboardFromTray()
+		case <-mLogs.ClickedCh:
+			path := systemLogPath
+			if absPath, err := filepath.Abs(path); err == nil {
+				path = absPath
+			}
+			openPathOrLog(path)
+		case <-mQuit.ClickedCh:
+			systray.Quit()
+			return
+		}
+	}
+}
+
+// newClientConfigMenu adds the submenu of a config of the engine and starts
+// handling its clicks.
+func newClientConfigMenu(parent *systray.MenuItem, name string, requestRefresh func()) *clientConfigMenu {
+	cm := &clientConfigMenu{root: parent.AddSubMenuItem(name, "Backup configuration "+name)}
+	cm.backupNow = cm.root.AddSubMenuItem("Backup now", "Run this backup immediately instead of waiting for the next scheduled run")
+	cm.openLogs = cm.root.AddSubMenuItem("Open logs", "Show today's log of this backup in the file manager")
+
+	go func() {
+		for {
+			select {
+			case <-cm.backupNow.ClickedCh:
+				sendClientRequest(ipcRequest{Action: IPCActionBackup, Configs: []string{name}})
+				requestRefresh()
+			case <-cm.openLogs.ClickedCh:
+				openPathOrLog(configLogToOpen(name))
+			}
+		}
+	}()
+	return cm
+}
+
+// sendClientRequest forwards a click to the engine and shows why it failed, if it did.
+func sendClientRequest(req ipcRequest) {
+	req.Interface = AuditInterfaceTray
+	resp, err := sendIPCRequest(req)
+	if err == nil && !resp.OK {
+		err = fmt.Errorf("%s", resp.Message)
+	}
+	if err != nil {
+		showMessageBox("SimpleFolderBackup", err.Error())
+	}
+}
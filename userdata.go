@@ -0,0 +1,343 @@
+// Package main - userdata.go keeps each OS user's configuration and state apart.
+//
+// The application finds config.json, its state files, logs and lock through
+// relative paths. It used to take them from the folder it was started in, so
+// on a shared machine every user starting the same installation shared one
+// config, one hashes.json and one instance lock: the second user was told
+// another instance was running, or both overwrote each other's state.
+//
+// At startup the working directory is now switched to a data folder of the
+// current user (e.g. %AppData%\SimpleFolderBackup or
+// ~/.config/SimpleFolderBackup), and the Windows mutex and named pipe carry
+// the user's identity. The --config-dir option, or the SFB_DATA_DIR
+// environment variable, selects another folder, e.g. for portable use or a
+// service account.
+//
+// Key design decisions:
+//
+// 1. Working directory, not path rewriting: Every path in the application
+//    stays relative, so features keep working unchanged and a folder set by
+//    SFB_DATA_DIR behaves exactly like the old working directory.
+//
+// 2. One-time migration: A user whose data folder has no config.json yet
+//    gets a copy of the config and state files of the folder the application
+//    was started in, so existing installations carry on where they left off.
+//    The originals stay, so each user of a shared installation starts from
+//    the same point. Relative paths in the copied config are rewritten
+//    against the launch folder, since they would otherwise resolve against
+//    the data folder and silently point somewhere else.
+//
+// 3. Arguments stay relative to the shell: Paths given on the command line
+//    are resolved against the folder the command was run in (launchPath),
+//    not against the data folder.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// dataDirEnvVar overrides the folder configuration and state are kept in
+const dataDirEnvVar = "SFB_DATA_DIR"
+
+// configDirFlag overrides the data folder for one start, ahead of dataDirEnvVar
+const configDirFlag = "--config-dir"
+
+// configDirOverride is the folder given with configDirFlag, if any
+var configDirOverride string
+
+// appDataDirName is the application's folder inside the user's config directory
+const appDataDirName = "SimpleFolderBackup"
+
+// launchDir is the working directory the application was started in
+var launchDir string
+
+// migratedFiles lists the files enterUserDataDir copied from launchDir
+var migratedFiles []string
+
+// migratedPaths describes the relative config paths made absolute while migrating
+var migratedPaths []string
+
+// userDataDir returns the folder the current user's configuration and state
+// are kept in.
+func userDataDir() (string, error) {
+	if configDirOverride != "" {
+		return filepath.Abs(configDirOverride)
+	}
+	if dir := os.Getenv(dataDirEnvVar); dir != "" {
+		return filepath.Abs(dir)
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine the user's config folder (set %s): %v", dataDirEnvVar, err)
+	}
+	return filepath.Join(base, appDataDirName), nil
+}
+
+// takeConfigDirFlag removes a leading "--config-dir <folder>" or
+// "--config-dir=<folder>" from args and remembers the folder for
+// userDataDir. It comes before the command or --headless, so it applies to
+// every way of starting the application.
+func takeConfigDirFlag(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if dir, found := strings.CutPrefix(args[0], configDirFlag+"="); found {
+		args = args[1:]
+		configDirOverride = dir
+	} else if args[0] == configDirFlag {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s needs a folder", configDirFlag)
+		}
+		configDirOverride = args[1]
+		args = args[2:]
+	} else {
+		return args, nil
+	}
+	if configDirOverride == "" {
+		return nil, fmt.Errorf("%s needs a folder", configDirFlag)
+	}
+	return args, nil
+}
+
+// dataDirArgs returns the arguments that start the application with the
+// current data folder, for entries that start it later, such as autostart:
+// none for the default folder, --config-dir otherwise, since neither the
+// option nor the environment of this start carries over.
+func dataDirArgs() []string {
+	if configDirOverride == "" && os.Getenv(dataDirEnvVar) == "" {
+		return nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	return []string{configDirFlag, dir}
+}
+
+// enterUserDataDir makes the current user's data folder the working
+// directory, migrating the files of the launch folder into it on first use.
+func enterUserDataDir() error {
+	var err error
+	launchDir, err = os.Getwd()
+	if err != nil {
+		return err
+	}
+	dir, err := userDataDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create data folder: %v", err)
+	}
+
+	if !sameFolder(dir, launchDir) {
+		migratedFiles, err = migrateUserData(launchDir, dir)
+		if err != nil {
+			return fmt.Errorf("failed to copy settings into %s: %v", dir, err)
+		}
+	}
+	return os.Chdir(dir)
+}
+
+// describeMigration reports the files copied into the data folder at this
+// start, or "" if none were.
+func describeMigration() string {
+	if len(migratedFiles) == 0 {
+		return ""
+	}
+	dir, _ := os.Getwd()
+	lines := []string{fmt.Sprintf("Copied %s from %s into the data folder %s", strings.Join(migratedFiles, ", "), launchDir, dir)}
+	lines = append(lines, migratedPaths...)
+	return strings.Join(lines, "\n")
+}
+
+// migratedStateFiles returns the files copied into a new data folder: the
+// state that continues across restarts and, last, the config. Logs and
+// caches start afresh.
+//
+// The config comes last because its presence marks the migration as done;
+// an interrupted one is repeated at the next start.
+func migratedStateFiles() []string {
+	return []string{
+		hashManager.filePath,
+		runStats.filePath,
+		runStats.monthlyPath,
+		storageHistory.filePath,
+		purgeForecasts.filePath,
+		timedPausesPath,
+		auditLog.snapshotPath,
+		"config.json",
+	}
+}
+
+// migrateUserData copies the config and state files from the folder from
+// into the data folder to, unless to already has a config.
+func migrateUserData(from, to string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(to, "config.json")); err == nil {
+		return nil, nil
+	}
+	if _, err := os.Stat(filepath.Join(from, "config.json")); err != nil {
+		return nil, nil // Nothing to migrate; a default config is created as before
+	}
+
+	var migrated []string
+	for _, name := range migratedStateFiles() {
+		var err error
+		if name == "config.json" {
+			err = copyMigratedConfig(filepath.Join(from, name), filepath.Join(to, name), from)
+		} else {
+			err = copyStateFile(filepath.Join(from, name), filepath.Join(to, name))
+		}
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, name)
+	}
+	return migrated, nil
+}
+
+// copyStateFile copies one file readable only by the current user.
+func copyStateFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// copyMigratedConfig copies config.json into the data folder with its
+// relative paths made absolute against the folder from, where they used to
+// be resolved.
+func copyMigratedConfig(src, dst, from string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		// Copied as is; loading reports the error as it would have in the launch folder
+		return copyStateFile(src, dst)
+	}
+	if !absolutizeConfigPaths(&config, from) {
+		return copyStateFile(src, dst)
+	}
+	data, err = json.MarshalIndent(&config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0600)
+}
+
+// absolutizeConfigPaths rewrites the relative folder and file paths of config
+// against base, recording each rewrite in migratedPaths, and reports whether
+// any path changed.
+//
+// Hook commands themselves are left alone: they run in hooks.dir or the
+// source folder, both of which are rewritten here, so relative script paths
+// in them keep resolving as before.
+func absolutizeConfigPaths(config *Config, base string) bool {
+	changed := false
+	rewrite := func(path *string, owner, field string) {
+		abs, ok := absoluteFrom(base, *path)
+		if !ok {
+			return
+		}
+		migratedPaths = append(migratedPaths, fmt.Sprintf("Made %s of %s absolute: %q -> %s", field, owner, *path, abs))
+		*path = abs
+		changed = true
+	}
+
+	for i := range config.Backups {
+		backup := &config.Backups[i]
+		rewrite(&backup.Source, backup.Name, "source")
+		if !isRemoteDestination(backup.Destination) {
+			rewrite(&backup.Destination, backup.Name, "destination")
+		}
+		if backup.Replica != nil {
+			rewrite(&backup.Replica.Destination, backup.Name, "replica.destination")
+		}
+		if backup.Hooks != nil {
+			rewrite(&backup.Hooks.Dir, backup.Name, "hooks.dir")
+		}
+		if backup.SFTP != nil {
+			rewrite(&backup.SFTP.IdentityFile, backup.Name, "sftp.identity_file")
+		}
+	}
+	rewrite(&config.Settings.DefaultDestination, "settings", "default_destination")
+	return changed
+}
+
+// absoluteFrom resolves a relative path against base, the way filepath.Abs
+// resolves it against the working directory. It returns false for empty and
+// already absolute paths.
+func absoluteFrom(base, path string) (string, bool) {
+	if path == "" || filepath.IsAbs(path) {
+		return "", false
+	}
+	if filepath.VolumeName(path) == "" && strings.HasPrefix(path, string(filepath.Separator)) {
+		// Rooted without a drive (Windows): on the drive of base
+		return filepath.Clean(filepath.VolumeName(base) + path), true
+	}
+	if filepath.VolumeName(path) != "" {
+		// Drive-relative such as "D:Backups" (Windows) has no stable meaning; leave it
+		return "", false
+	}
+	return filepath.Join(base, path), true
+}
+
+// launchPath resolves a path given on the command line against the folder
+// the command was run in.
+func launchPath(path string) string {
+	if path == "" || filepath.IsAbs(path) || launchDir == "" {
+		return path
+	}
+	return filepath.Join(launchDir, path)
+}
+
+// displayPath returns path as an absolute path for output, since paths
+// relative to the data folder mean nothing in the user's shell.
+func displayPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// userInstanceKey identifies the current user in names shared across the
+// machine, such as the Windows mutex and named pipe.
+//
+// The user's SID or uid is used, since user names may contain characters
+// those names don't allow.
+func userInstanceKey() string {
+	id := ""
+	if u, err := user.Current(); err == nil {
+		id = u.Uid
+	}
+	if id == "" {
+		id = currentUserName()
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\\' || r == '/' || r == ':' {
+			return '_'
+		}
+		return r
+	}, id)
+}
@@ -0,0 +1,79 @@
+// Package main - verifyonly.go checks a source for changes without writing snapshots.
+//
+// While a destination is being migrated or repaired, nothing should be
+// written to it, but disabling the config would also stop noticing changes.
+// With "verify_only": true a config keeps its schedule and hashes its source
+// on every run without touching the destination:
+//
+// - Unchanged content is recorded like any skipped run
+// - Changed content raises an alert until it is backed up; no notification
+//   is sent, since changes are expected while people keep working
+//
+// The hash state keeps the hash of the last snapshot while changes are
+// pending, so the first run after verify_only is switched off backs them up
+// instead of skipping content it has already seen.
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// verifyOnlyAlert is shown while a verify-only config has changes no snapshot holds
+const verifyOnlyAlert = "changed since the last snapshot, not backed up while verify_only is on"
+
+// verifyOnlyPending tracks the verify-only configs whose alert is shown
+var (
+	verifyOnlyPendingMu sync.Mutex
+	verifyOnlyPending   = make(map[string]bool)
+)
+
+// validateVerifyOnly checks that a verify-only config can detect changes at all.
+func validateVerifyOnly(config BackupConfig) error {
+	if config.VerifyOnly && !config.IsHashCheckEnabled() {
+		return fmt.Errorf("verify_only needs hash_check to detect changes")
+	}
+	return nil
+}
+
+// runVerifyOnly checks a verify-only config's source against its last snapshot.
+//
+// Returns ResultSkipped for unchanged content and ResultChanged otherwise.
+func runVerifyOnly(config BackupConfig, logger *log.Logger) (BackupResult, error) {
+	unchanged, err := hashManager.shouldSkipBackup(config)
+	if err != nil {
+		return BackupResult{}, fmt.Errorf("failed to check the source for changes: %v", err)
+	}
+
+	verifyOnlyPendingMu.Lock()
+	wasPending := verifyOnlyPending[config.Name]
+	verifyOnlyPending[config.Name] = !unchanged
+	verifyOnlyPendingMu.Unlock()
+
+	if unchanged {
+		logger.Printf("Contents identical for %s (verify only)", config.Name)
+		if err := hashManager.recordAction(config, "skipped"); err != nil {
+			logger.Printf("Failed to record skip action for %s: %v", config.Name, err)
+		}
+		if wasPending {
+			backupStatus.clearAlert(config.Name) // Changed back to the snapshotted content
+		}
+		backupStatus.updateBackupCompleted(config.Name, config.ScheduleMinutes)
+		requestStatusUpdate()
+		return BackupResult{Outcome: ResultSkipped}, nil
+	}
+
+	logger.Printf("Contents of %s changed since the last snapshot; verify only, so no snapshot was written", config.Name)
+	backupStatus.setAlert(config.Name, verifyOnlyAlert)
+	backupStatus.updateNextBackup(config.Name, config.ScheduleMinutes)
+	requestStatusUpdate()
+	return BackupResult{Outcome: ResultChanged}, nil
+}
+
+// forgetVerifyOnly drops the pending state of a config stopped by a reload.
+func forgetVerifyOnly(name string) {
+	verifyOnlyPendingMu.Lock()
+	defer verifyOnlyPendingMu.Unlock()
+	delete(verifyOnlyPending, name)
+}
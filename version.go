@@ -0,0 +1,47 @@
+// Package main - version.go exposes build and version information.
+//
+// The release workflow stamps the version at link time with
+// -ldflags "-X main.version=v1.2.3"; local builds report "dev" plus whatever
+// VCS information the Go toolchain embedded, which is enough to identify the
+// exact commit a user is running when they report a bug.
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version is overridden at build time by the release workflow
+var version = "dev"
+
+// buildInfo returns a human-readable multi-line description of this build.
+//
+// Used by the About dialog and included in diagnostics bundles.
+func buildInfo() string {
+	info := fmt.Sprintf("Version: %s\nGo: %s\nPlatform: %s/%s", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		var revision, buildTime, modified string
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.time":
+				buildTime = setting.Value
+			case "vcs.modified":
+				modified = setting.Value
+			}
+		}
+		if revision != "" {
+			if modified == "true" {
+				revision += " (modified)"
+			}
+			info += fmt.Sprintf("\nCommit: %s", revision)
+		}
+		if buildTime != "" {
+			info += fmt.Sprintf("\nBuilt: %s", buildTime)
+		}
+	}
+	return info
+}
@@ -0,0 +1,194 @@
+// Package main - walk.go implements bounded traversal of source trees.
+//
+// Hashing and copying both walk the full source. Normally that is bounded by
+// the tree itself, but a recursive junction or an enormous dependency folder
+// can make a walk effectively endless. Two per-config options bound it:
+//
+// - max_depth limits how many directory levels below the source are walked;
+//    deeper directories are created empty in the snapshot
+// - follow_links descends into symlinked directories and junctions; links
+//    back into a directory already being walked are skipped so a link loop
+//    can't recurse forever. Without it, links are visited like files: a link
+//    to a file is copied as that file, while one to a directory (or to nothing)
+//    can't be copied and is left out of snapshots with a log line
+//
+// Entries matched by the config's exclusions (see exclude.go) are left out of
+// the walk the same way.
+//
+// Key design decisions:
+//
+// 1. One walker for hashing and copying: Both use the same options so the
+//    content hash always describes exactly what a snapshot would contain.
+//
+// 2. Defaults keep the old traversal: With neither option set, entries are
+//    visited in the same order and with the same types as filepath.WalkDir.
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// walkOptions bound a source traversal.
+type walkOptions struct {
+	maxDepth    int             // Directory levels below the root to descend into, 0 = unlimited
+	followLinks bool            // Descend into symlinked directories and junctions
+	exclude     *excludeMatcher // Entries left out, nil = none
+}
+
+// walkOptionsFor returns the traversal bounds configured for a backup.
+//
+// Exclusions were checked by validatePaths when the config was loaded.
+func walkOptionsFor(config BackupConfig) walkOptions {
+	exclude, _ := excludeMatcherFor(config)
+	return walkOptions{
+		maxDepth:    config.GetMaxDepth(),
+		followLinks: config.FollowLinks,
+		exclude:     exclude,
+	}
+}
+
+// bounded reports whether the options change traversal at all.
+func (o walkOptions) bounded() bool {
+	return o.maxDepth > 0 || o.followLinks || o.exclude != nil
+}
+
+// descends reports whether the contents of a directory at depth are walked.
+//
+// The root is depth 0, so max_depth 1 includes only the files directly inside it.
+func (o walkOptions) descends(depth int) bool {
+	return o.maxDepth == 0 || depth < o.maxDepth
+}
+
+// How walks treat a non-directory entry
+const (
+	linkNone   = iota // Not a followed link: visit it as a file
+	linkFollow        // A link to a directory: walk it as a directory
+	linkLoop          // A link back into a directory being walked: leave it out
+)
+
+// classifyLink decides how a non-directory entry is walked.
+//
+// ancestors holds the resolved paths of the directories currently being walked;
+// a link resolving to one of them would recurse forever and is not followed.
+func (o walkOptions) classifyLink(path string, mode fs.FileMode, ancestors map[string]bool) (fs.FileInfo, int) {
+	if !o.followLinks || mode&(fs.ModeSymlink|fs.ModeIrregular) == 0 {
+		return nil, linkNone
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return nil, linkNone
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil || ancestors[resolved] {
+		return nil, linkLoop
+	}
+	return info, linkFollow
+}
+
+// unfollowedLink reports whether a non-directory entry is a link that copies
+// leave out: one to a directory that the walk didn't follow, or one whose
+// target is gone. Restores keep such links in the source, since no snapshot
+// holds them.
+func unfollowedLink(path string, mode fs.FileMode) bool {
+	if mode&(fs.ModeSymlink|fs.ModeIrregular) == 0 {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err != nil || info.IsDir()
+}
+
+// enter records dir as being walked so links back into it aren't followed.
+//
+// Returns a function that removes it again once its contents are done.
+func (o walkOptions) enter(dir string, ancestors map[string]bool) (func(), error) {
+	if !o.followLinks {
+		return func() {}, nil
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	ancestors[resolved] = true
+	return func() { delete(ancestors, resolved) }, nil
+}
+
+// walkTree calls fn for root and every entry below it, honoring the walk options.
+//
+// Like filepath.WalkDir, entries are visited in lexical order and returning
+// filepath.SkipDir from fn for a directory skips its contents. A followed link
+// is passed to fn as a directory.
+func walkTree(root string, opts walkOptions, fn func(path string, d fs.DirEntry) error) error {
+	if !opts.bounded() {
+		return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return fn(path, d)
+		})
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	ancestors := make(map[string]bool)
+	err = walkTreeDir(root, "", fs.FileInfoToDirEntry(info), 0, opts, ancestors, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkTreeDir walks one directory for walkTree; rel is its slash-separated
+// path below the root.
+func walkTreeDir(dir, rel string, d fs.DirEntry, depth int, opts walkOptions, ancestors map[string]bool, fn func(path string, d fs.DirEntry) error) error {
+	if err := fn(dir, d); err != nil {
+		return err
+	}
+	if !opts.descends(depth) {
+		return nil
+	}
+
+	leave, err := opts.enter(dir, ancestors)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		childRel := entry.Name()
+		if rel != "" {
+			childRel = rel + "/" + childRel
+		}
+		if !entry.IsDir() {
+			info, link := opts.classifyLink(path, entry.Type(), ancestors)
+			if link == linkLoop || opts.exclude.excludes(childRel, link == linkFollow) {
+				continue
+			}
+			if link == linkNone {
+				if err := fn(path, entry); err != nil {
+					return err
+				}
+				continue
+			}
+			entry = fs.FileInfoToDirEntry(info)
+		} else if opts.exclude.excludes(childRel, true) {
+			continue
+		}
+
+		if err := walkTreeDir(path, childRel, entry, depth+1, opts, ancestors, fn); err != nil {
+			if err == filepath.SkipDir {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
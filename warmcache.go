@@ -0,0 +1,288 @@
+// Package main - warmcache.go keeps a per-config cache of the source tree between runs.
+//
+// The skip check hashes the whole source with dirhash, which reads every byte
+// of every file each cycle. On large, mostly idle trees that walk dominates
+// skipped cycles. With warm_cache enabled the file list and per-file content
+// hashes of the previous cycle are kept, so the next check:
+//
+// - re-reads a directory listing only when the directory's mtime changed
+// - re-hashes a file only when its size or mtime changed
+//
+// Files are still stat'ed on every run: editing a file in place does not touch
+// its directory's mtime, so skipping those stats would miss changes.
+//
+// Key design decisions:
+//
+// 1. Same hash as dirhash.Hash1: The cached walk produces exactly the "h1:"
+//    value HashDir would, so hashes.json stays valid whether the cache is on,
+//    off, cold or warm.
+//
+// 2. Racy entries are not trusted: A file modified within the mtime
+//    granularity of the scan could change again without its mtime moving, so
+//    files changed just before a scan are re-hashed next time (as git does).
+//
+// 3. Symlinks are never cached: their own mtime says nothing about the target.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Warm cache modes
+const (
+	WarmCacheOff     = "off"     // Hash every file every cycle (default)
+	WarmCacheMemory  = "memory"  // Cache in memory; the first cycle after start is cold
+	WarmCachePersist = "persist" // Also save the cache to disk so restarts start warm
+)
+
+// warmCacheDir holds persisted caches
+const warmCacheDir = "cache"
+
+// racyWindow is how close to the scan a file change must be to distrust its cached hash
+const racyWindow = 2 * time.Second
+
+// cachedDir is the cached listing of one directory.
+type cachedDir struct {
+	ModTime int64    `json:"m"` // Directory mtime (UnixNano) when listed
+	Entries []string `json:"e"` // Names of all entries
+}
+
+// cachedFile is the cached content hash of one file.
+type cachedFile struct {
+	Size    int64  `json:"s"`
+	ModTime int64  `json:"m"` // UnixNano
+	Hash    string `json:"h"` // Hex SHA-256 of the content
+	Racy    bool   `json:"r,omitempty"`
+}
+
+// treeCache is the warm cache of one source tree, keyed by slash-separated relative path.
+type treeCache struct {
+	Dirs  map[string]cachedDir  `json:"dirs"`
+	Files map[string]cachedFile `json:"files"`
+}
+
+// warmCaches holds the caches of all configs, loaded lazily
+var (
+	warmCachesMu sync.Mutex
+	warmCaches   = make(map[string]*treeCache)
+)
+
+// hashSourceWithCache computes the dirhash.Hash1 value of a config's source using its warm cache.
+func hashSourceWithCache(config BackupConfig) (string, error) {
+	warmCachesMu.Lock()
+	cache := warmCaches[config.Name]
+	if cache == nil && config.GetWarmCacheMode() == WarmCachePersist {
+		cache = loadWarmCache(config)
+	}
+	warmCachesMu.Unlock()
+	if cache == nil {
+		cache = &treeCache{}
+	}
+
+	next, hash, err := scanWithCache(config.Source, cache, walkOptionsFor(config))
+	if err != nil {
+		return "", err
+	}
+
+	warmCachesMu.Lock()
+	warmCaches[config.Name] = next
+	warmCachesMu.Unlock()
+
+	if config.GetWarmCacheMode() == WarmCachePersist {
+		saveWarmCache(config, next)
+	}
+	return hash, nil
+}
+
+// scanWithCache walks root, reusing cache where metadata is unchanged.
+//
+// Returns a fresh cache describing the tree as scanned, so entries for deleted
+// files don't accumulate.
+func scanWithCache(root string, cache *treeCache, opts walkOptions) (*treeCache, string, error) {
+	root = filepath.Clean(root)
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, "", err
+	}
+	if !info.IsDir() {
+		return nil, "", fmt.Errorf("%s is not a directory", root)
+	}
+
+	scanStart := time.Now()
+	next := &treeCache{Dirs: make(map[string]cachedDir), Files: make(map[string]cachedFile)}
+	var lines []string
+
+	ancestors := make(map[string]bool)
+
+	var walk func(rel string, depth int) error
+	walk = func(rel string, depth int) error {
+		if !opts.descends(depth) {
+			return nil
+		}
+		dirPath := filepath.Join(root, filepath.FromSlash(rel))
+		dirInfo, err := os.Stat(dirPath)
+		if err != nil {
+			return err
+		}
+		leave, err := opts.enter(dirPath, ancestors)
+		if err != nil {
+			return err
+		}
+		defer leave()
+
+		listing, cached := cache.Dirs[rel]
+		if !cached || listing.ModTime != dirInfo.ModTime().UnixNano() {
+			entries, err := os.ReadDir(dirPath)
+			if err != nil {
+				return err
+			}
+			listing = cachedDir{ModTime: dirInfo.ModTime().UnixNano()}
+			for _, entry := range entries {
+				listing.Entries = append(listing.Entries, entry.Name())
+			}
+		}
+		next.Dirs[rel] = listing
+
+		for _, name := range listing.Entries {
+			childRel := path.Join(rel, name)
+			childPath := filepath.Join(dirPath, name)
+			childInfo, err := os.Lstat(childPath)
+			if err != nil {
+				return err
+			}
+			link := linkNone
+			if !childInfo.IsDir() {
+				_, link = opts.classifyLink(childPath, childInfo.Mode(), ancestors)
+			}
+			isDir := childInfo.IsDir() || link == linkFollow
+			if link == linkLoop || opts.exclude.excludes(childRel, isDir) {
+				continue
+			}
+			if isDir {
+				if err := walk(childRel, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+
+			hash, err := cachedFileHash(childPath, childRel, childInfo, cache, next, scanStart)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(childRel, "\n") {
+				return fmt.Errorf("dirhash: filenames with newlines are not supported")
+			}
+			lines = append(lines, fmt.Sprintf("%s  %s\n", hash, childRel))
+		}
+		return nil
+	}
+
+	if err := walk("", 0); err != nil {
+		return nil, "", err
+	}
+
+	// Same summary as dirhash.Hash1: lines sorted by file name
+	sort.Slice(lines, func(i, j int) bool { return lineName(lines[i]) < lineName(lines[j]) })
+	summary := sha256.New()
+	for _, line := range lines {
+		io.WriteString(summary, line)
+	}
+	return next, "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+// lineName extracts the file name from a "<hash>  <name>\n" summary line.
+func lineName(line string) string {
+	return line[sha256.Size*2+2 : len(line)-1]
+}
+
+// cachedFileHash returns a file's content hash, reading the file only when needed.
+func cachedFileHash(filePath, rel string, info fs.FileInfo, cache, next *treeCache, scanStart time.Time) (string, error) {
+	isSymlink := info.Mode()&fs.ModeSymlink != 0
+	modTime := info.ModTime().UnixNano()
+
+	if entry, ok := cache.Files[rel]; ok && !isSymlink && !entry.Racy && entry.Size == info.Size() && entry.ModTime == modTime {
+		next.Files[rel] = entry
+		return entry.Hash, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+
+	if !isSymlink {
+		next.Files[rel] = cachedFile{
+			Size:    info.Size(),
+			ModTime: modTime,
+			Hash:    hash,
+			Racy:    scanStart.Sub(info.ModTime()) < racyWindow,
+		}
+	}
+	return hash, nil
+}
+
+// warmCachePath returns where a config's persisted cache is stored.
+func warmCachePath(config BackupConfig) string {
+	return filepath.Join(warmCacheDir, sanitizeConfigName(config.Name)+".json")
+}
+
+// loadWarmCache reads a persisted cache, returning nil if there is none or it is unreadable.
+func loadWarmCache(config BackupConfig) *treeCache {
+	data, err := os.ReadFile(warmCachePath(config))
+	if err != nil {
+		return nil
+	}
+	var cache treeCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil // A corrupt cache only costs one cold scan
+	}
+	return &cache
+}
+
+// saveWarmCache persists a config's cache; failures only cost a cold start later.
+func saveWarmCache(config BackupConfig, cache *treeCache) {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(warmCacheDir, 0755); err != nil {
+		return
+	}
+	tempPath := warmCachePath(config) + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return
+	}
+	os.Rename(tempPath, warmCachePath(config))
+}
+
+// dropWarmCache discards a config's cache in memory and on disk, so the next
+// change check reads every file again.
+func dropWarmCache(config BackupConfig) error {
+	warmCachesMu.Lock()
+	delete(warmCaches, config.Name)
+	warmCachesMu.Unlock()
+
+	err := os.Remove(warmCachePath(config))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
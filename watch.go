@@ -0,0 +1,280 @@
+// Package main - watch.go starts backups when the source changes.
+//
+// A schedule hashes the source every schedule_minutes whether anything
+// changed or not, and still leaves up to a whole interval of work without a
+// snapshot. With "trigger": "watch" the source is watched instead, and a
+// backup starts watch_delay_minutes after the last change, once a burst of
+// saves (an editor's temporary files, a build, a camera import) has settled.
+//
+// Key design decisions:
+//
+// 1. No extra dependency: Windows reports changes in the whole tree through
+//    ReadDirectoryChangesW; elsewhere the sizes and modification times of
+//    the tree are compared every watchPollInterval, which costs a walk but no
+//    file reads, and no per-folder watches that a large tree could exhaust.
+//
+// 2. Schedule as backstop: The regular schedule keeps running, so changes a
+//    watcher can't see (network shares don't always report them) are still
+//    backed up. Watched configs usually get a long schedule_minutes, e.g. 1440.
+//
+// 3. Own writes ignored: Changes made while the config's backup runs, such
+//    as a pre-backup hook's database dump, don't start another run.
+//
+// 4. Degrade, don't miss: When change notifications fail (a share that
+//    doesn't support them, too many watches), the source is polled instead;
+//    when even polling fails, it is backed up on schedule only. Either
+//    downgrade is logged and shown in the status, and the watch is tried
+//    again from the start once polling fails.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+)
+
+// What starts a config's backups
+const (
+	TriggerSchedule = "schedule" // Every schedule_minutes
+	TriggerWatch    = "watch"    // After changes in the source settle, and every schedule_minutes
+)
+
+// defaultWatchDelayMinutes is how long a watched source must stay unchanged before its backup starts
+const defaultWatchDelayMinutes = 5
+
+// watchPollInterval is how often a polled source's tree is compared
+const watchPollInterval = 30 * time.Second
+
+// How a watched source is watched while change notifications don't work
+const (
+	WatchFallbackPolling  = "polling"  // The tree is compared every watchPollInterval
+	WatchFallbackSchedule = "schedule" // Not watched; backed up every schedule_minutes only
+)
+
+// watchRetryInterval is how long to wait before watching a source again after the watch failed
+const watchRetryInterval = time.Minute
+
+// GetTrigger returns what starts the config's backups, defaulting to the schedule.
+func (bc *BackupConfig) GetTrigger() string {
+	if bc.Trigger == "" {
+		return TriggerSchedule
+	}
+	return bc.Trigger
+}
+
+// GetWatchDelayMinutes returns how long a watched source must stay unchanged before a backup.
+func (bc *BackupConfig) GetWatchDelayMinutes() int {
+	if bc.WatchDelayMinutes == nil {
+		return defaultWatchDelayMinutes
+	}
+	return *bc.WatchDelayMinutes
+}
+
+// validateTrigger checks the trigger settings of a config.
+func validateTrigger(config BackupConfig) error {
+	switch config.GetTrigger() {
+	case TriggerSchedule, TriggerWatch:
+	default:
+		return fmt.Errorf("unknown trigger %q (use %q or %q)", config.Trigger, TriggerSchedule, TriggerWatch)
+	}
+	if config.GetWatchDelayMinutes() < 1 {
+		return fmt.Errorf("watch_delay_minutes must be at least 1")
+	}
+	return nil
+}
+
+// sourceWatcher signals when changes in a watched source have settled.
+type sourceWatcher struct {
+	due chan struct{}
+}
+
+// C returns the channel that receives a value when a backup is due. A nil
+// watcher's channel never does, so schedulers can select on it unconditionally.
+func (w *sourceWatcher) C() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.due
+}
+
+// startSourceWatcher watches the source of a config with "trigger": "watch"
+// until ctx ends; for other configs it returns nil.
+func startSourceWatcher(ctx context.Context, config BackupConfig, logger *log.Logger, clock Clock) *sourceWatcher {
+	if config.GetTrigger() != TriggerWatch {
+		return nil
+	}
+	w := &sourceWatcher{due: make(chan struct{}, 1)}
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		if backupStatus.operationOf(config.Name) == OperationBackup {
+			return
+		}
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	logger.Printf("Watching %s for changes; backups start %d minutes after the last change", config.Source, config.GetWatchDelayMinutes())
+	go watchSource(ctx, config, logger, changed)
+	go w.debounce(ctx, config, logger, clock, changes)
+	return w
+}
+
+// watchSource keeps the platform watcher running, starting it again when it
+// fails, e.g. because the source drive was disconnected. While change
+// notifications fail the source is polled, and while that fails too it is
+// backed up on schedule only; the status and tray show either downgrade.
+func watchSource(ctx context.Context, config BackupConfig, logger *log.Logger, changed func()) {
+	opts := walkOptionsFor(config)
+	fallback := ""
+	degrade := func(to string, err error) {
+		if to != fallback {
+			switch to {
+			case WatchFallbackPolling:
+				logger.Printf("Change notifications for %s failed, checking it for changes every %v instead: %v", config.Source, watchPollInterval, err)
+			case WatchFallbackSchedule:
+				logger.Printf("Watching %s for changes failed, backing up on schedule until it works again: %v", config.Source, err)
+			default:
+				logger.Printf("Watching %s for changes again", config.Source)
+			}
+			fallback = to
+		}
+		backupStatus.markWatchDegraded(config.Name, to, err)
+		requestStatusUpdate()
+	}
+	ready := func() {
+		if fallback != "" {
+			degrade("", nil)
+		}
+	}
+	defer backupStatus.markWatchDegraded(config.Name, "", nil)
+	for {
+		err := watchSourceTree(ctx, config.Source, opts, ready, changed)
+		if ctx.Err() != nil {
+			return
+		}
+		if !systemWatchPolls {
+			// Shown as polling only once a walk worked, so an unreachable source
+			// goes straight to the schedule
+			notifyErr := err
+			err = pollSourceTree(ctx, config.Source, opts, func() { degrade(WatchFallbackPolling, notifyErr) }, changed)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		degrade(WatchFallbackSchedule, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+// pollSourceTree calls changed whenever an entry below root was added,
+// removed, resized or modified, until ctx ends. It calls ready once the first
+// walk succeeded and changes can be noticed.
+//
+// The tree's sizes and modification times are compared every
+// watchPollInterval. The walk honors the config's exclusions and max_depth,
+// so excluded churn is never noticed.
+func pollSourceTree(ctx context.Context, root string, opts walkOptions, ready, changed func()) error {
+	last, err := treeFingerprint(root, opts)
+	if err != nil {
+		return err
+	}
+	ready()
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		current, err := treeFingerprint(root, opts)
+		if err != nil {
+			return err
+		}
+		if current != last {
+			last = current
+			changed()
+		}
+	}
+}
+
+// treeFingerprint hashes the path, size and modification time of every entry below root.
+func treeFingerprint(root string, opts walkOptions) (string, error) {
+	hash := sha256.New()
+	err := walkTree(root, opts, func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return nil // Deleted since the directory was listed
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// debounce sends on w.due once no change has been reported for the watch delay.
+func (w *sourceWatcher) debounce(ctx context.Context, config BackupConfig, logger *log.Logger, clock Clock, changes <-chan struct{}) {
+	delay := time.Duration(config.GetWatchDelayMinutes()) * time.Minute
+	var timer Timer
+	var timerC <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			// The tray counts down to the triggered run if it comes before the scheduled one
+			due := clock.Now().Add(delay)
+			if next := backupStatus.nextRunFor(config.Name); timer != nil || next.IsZero() || due.Before(next) {
+				backupStatus.postponeNextBackup(config.Name, due)
+				requestStatusUpdate()
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = clock.NewTimer(delay)
+			timerC = timer.C()
+		case <-timerC:
+			timer, timerC = nil, nil
+			logger.Printf("Changes in %s have settled, starting backup of %s", config.Source, config.Name)
+			select {
+			case w.due <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// watchIgnores reports whether a change at rel (slash-separated, relative to
+// the source) is in something the config doesn't back up.
+func watchIgnores(opts walkOptions, rel string) bool {
+	names := strings.Split(rel, "/")
+	if opts.maxDepth > 0 && len(names) > opts.maxDepth {
+		return true
+	}
+	for i := 1; i < len(names); i++ {
+		if opts.exclude.excludes(strings.Join(names[:i], "/"), true) {
+			return true
+		}
+	}
+	return opts.exclude.excludes(rel, false)
+}
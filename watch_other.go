@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import "context"
+
+// systemWatchPolls reports that watchSourceTree already polls, so there is no
+// polling to fall back to when it fails
+const systemWatchPolls = true
+
+// watchSourceTree calls changed whenever an entry below root was added,
+// removed, resized or modified, until ctx ends. It calls ready once the first
+// walk succeeded and changes can be noticed.
+//
+// Without a portable change notification API, the tree is polled (see
+// pollSourceTree); this also means no limit on file watches, such as
+// fs.inotify.max_user_watches, can be exhausted by a large tree.
+func watchSourceTree(ctx context.Context, root string, opts walkOptions, ready, changed func()) error {
+	return pollSourceTree(ctx, root, opts, ready, changed)
+}
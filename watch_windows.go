@@ -0,0 +1,116 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"syscall"
+)
+
+// systemWatchPolls reports that watchSourceTree uses change notifications,
+// so watchSource can fall back to polling when they fail
+const systemWatchPolls = false
+
+// watchBufferSize holds the change records of one ReadDirectoryChangesW call
+const watchBufferSize = 64 * 1024
+
+// watchNotifyFilter selects the changes that can alter a snapshot; access
+// times and security changes are left out
+const watchNotifyFilter = syscall.FILE_NOTIFY_CHANGE_FILE_NAME | syscall.FILE_NOTIFY_CHANGE_DIR_NAME |
+	syscall.FILE_NOTIFY_CHANGE_SIZE | syscall.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+// watchSourceTree calls changed whenever something below root changes that
+// the config backs up, until ctx ends. It calls ready once the first read of
+// changes is pending.
+//
+// ReadDirectoryChangesW watches the whole tree through one handle. It runs
+// overlapped on a completion port, so the wait can check ctx every second
+// and cancel the pending read when the scheduler stops.
+func watchSourceTree(ctx context.Context, root string, opts walkOptions, ready, changed func()) error {
+	path, err := syscall.UTF16PtrFromString(longPath(root))
+	if err != nil {
+		return err
+	}
+	handle, err := syscall.CreateFile(path, syscall.FILE_LIST_DIRECTORY,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS|syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+	port, err := syscall.CreateIoCompletionPort(handle, 0, 0, 1)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(port)
+
+	buf := make([]byte, watchBufferSize)
+	for {
+		var overlapped syscall.Overlapped
+		if err := syscall.ReadDirectoryChanges(handle, &buf[0], uint32(len(buf)), true, watchNotifyFilter, nil, &overlapped, 0); err != nil {
+			return err
+		}
+		if ready != nil {
+			ready()
+			ready = nil
+		}
+
+		var n, key uint32
+		var completed *syscall.Overlapped
+		for {
+			err = syscall.GetQueuedCompletionStatus(port, &n, &key, &completed, 1000)
+			if completed != nil {
+				break
+			}
+			if err != syscall.Errno(syscall.WAIT_TIMEOUT) {
+				return err
+			}
+			if ctx.Err() != nil {
+				// Wait for the cancelled read, so buf isn't written after returning
+				syscall.CancelIoEx(handle, &overlapped)
+				syscall.GetQueuedCompletionStatus(port, &n, &key, &completed, syscall.INFINITE)
+				return nil
+			}
+		}
+		if err != nil {
+			return err // e.g. the source folder was deleted or its drive removed
+		}
+
+		// No records means the buffer overflowed: too many changes to list
+		if n == 0 {
+			changed()
+			continue
+		}
+		for _, rel := range changedPaths(buf[:n]) {
+			if !watchIgnores(opts, rel) {
+				changed()
+				break
+			}
+		}
+	}
+}
+
+// changedPaths returns the slash-separated relative paths of the
+// FILE_NOTIFY_INFORMATION records in buf.
+func changedPaths(buf []byte) []string {
+	var paths []string
+	for offset := 0; offset+12 <= len(buf); {
+		next := int(binary.LittleEndian.Uint32(buf[offset:]))
+		length := int(binary.LittleEndian.Uint32(buf[offset+8:]))
+		if offset+12+length > len(buf) {
+			break
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(buf[offset+12+2*i:])
+		}
+		paths = append(paths, filepath.ToSlash(syscall.UTF16ToString(units)))
+		if next == 0 {
+			break
+		}
+		offset += next
+	}
+	return paths
+}
@@ -0,0 +1,62 @@
+// Package main - webhook.go implements the webhook notification channel.
+//
+// Webhooks connect backup events to chat systems and monitoring tools. Each
+// notification is POSTed as a JSON Notification object to settings.webhook_url;
+// anything that accepts a JSON body (or a small relay in front of it) works.
+//
+// The URL of a Slack, Discord or Teams webhook is its only credential, so it
+// is treated like a password: errors name just the host, and the URL is
+// masked in logs and diagnostics.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookTimeout bounds how long a slow endpoint can hold a delivery goroutine
+const webhookTimeout = 15 * time.Second
+
+// sendWebhookNotification POSTs a notification to the configured webhook URL.
+func sendWebhookNotification(n Notification) error {
+	webhookURL := currentSettings().WebhookURL
+	if webhookURL == "" {
+		return fmt.Errorf("webhook channel used but settings.webhook_url is not set")
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return webhookError(webhookURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookError describes a failed delivery by the webhook's host only, since
+// the *url.Error returned by the client quotes the whole URL.
+func webhookError(webhookURL string, err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	host := "webhook"
+	if parsed, parseErr := url.Parse(webhookURL); parseErr == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	return fmt.Errorf("webhook to %s failed: %v", host, err)
+}